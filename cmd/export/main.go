@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"nyiyui.ca/jts/database"
+	"nyiyui.ca/jts/export"
+)
+
+func main() {
+	var dbPath string
+	var format string
+	var fromRaw, toRaw string
+	var tz string
+	var columns string
+	var outPath string
+	flag.StringVar(&dbPath, "db-path", "", "path to database. if empty, a default path like ~/.config/jts/jts.db is used")
+	flag.StringVar(&format, "format", "csv", "format (csv, json or ical)")
+	flag.StringVar(&fromRaw, "from", "", "first date to export (2006-01-02). defaults to 7 days ago")
+	flag.StringVar(&toRaw, "to", "", "last date to export (2006-01-02, inclusive). defaults to today")
+	flag.StringVar(&tz, "tz", "Local", "time zone of dates and times (IANA name)")
+	flag.StringVar(&columns, "columns", strings.Join(export.DefaultColumns, ","), "CSV columns. available: "+strings.Join(export.Columns, ","))
+	flag.StringVar(&outPath, "o", "", "output file. if empty, stdout is used")
+	flag.Parse()
+
+	if !slices.Contains(export.Formats, export.Format(format)) {
+		log.Fatalf("unknown format %q", format)
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		log.Fatalf("load time zone: %s", err)
+	}
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	opts := export.Options{
+		From:     today.AddDate(0, 0, -6),
+		To:       today.AddDate(0, 0, 1),
+		Location: loc,
+		Columns:  strings.Split(columns, ","),
+		Now:      time.Now(),
+	}
+	if fromRaw != "" {
+		opts.From, err = time.ParseInLocation("2006-01-02", fromRaw, loc)
+		if err != nil {
+			log.Fatalf("parse from: %s", err)
+		}
+	}
+	if toRaw != "" {
+		to, err := time.ParseInLocation("2006-01-02", toRaw, loc)
+		if err != nil {
+			log.Fatalf("parse to: %s", err)
+		}
+		opts.To = to.AddDate(0, 0, 1)
+	}
+	if err := export.ValidateColumns(opts.Columns); err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := database.NewDatabase(dbPath)
+	if err != nil {
+		log.Fatalf("new db: %s", err)
+	}
+	if err := db.Migrate(); err != nil {
+		log.Fatalf("migrate db: %s", err)
+	}
+	entries, err := export.Entries(db, opts)
+	if err != nil {
+		log.Fatalf("export: %s", err)
+	}
+
+	out := os.Stdout
+	if outPath != "" {
+		out, err = os.Create(outPath)
+		if err != nil {
+			log.Fatalf("create output: %s", err)
+		}
+		defer out.Close()
+	}
+	if err := export.Write(out, export.Format(format), entries, opts); err != nil {
+		log.Fatalf("write: %s", err)
+	}
+}
@@ -20,6 +20,10 @@ func mustParseTime(s string) time.Time {
 	return t
 }
 
+func ptr[T any](v T) *T {
+	return &v
+}
+
 func main() {
 	runtime.LockOSThread() // for gtk
 	app := gtk.NewApplication("ca.nyiyui.jts-test", gio.ApplicationFlagsNone)
@@ -39,9 +43,9 @@ func main() {
 			},
 			Timeframes: []sync.MergeConflict[data.Timeframe]{
 				{
-					Original: data.Timeframe{Rowid: 1, ID: "1", SessionID: "1", Start: mustParseTime("2025-04-01T00:00:00Z"), End: mustParseTime("2025-04-02T00:00:00Z")},
-					Local:    data.Timeframe{Rowid: 1, ID: "1", SessionID: "1", Start: mustParseTime("2025-04-01T00:00:00Z"), End: mustParseTime("2025-04-04T00:00:00Z")},
-					Remote:   data.Timeframe{Rowid: 1, ID: "1", SessionID: "1", Start: mustParseTime("2025-04-01T00:00:00Z"), End: mustParseTime("2025-04-03T00:00:00Z")},
+					Original: data.Timeframe{Rowid: 1, ID: "1", SessionID: "1", Start: mustParseTime("2025-04-01T00:00:00Z"), End: ptr(mustParseTime("2025-04-02T00:00:00Z"))},
+					Local:    data.Timeframe{Rowid: 1, ID: "1", SessionID: "1", Start: mustParseTime("2025-04-01T00:00:00Z"), End: ptr(mustParseTime("2025-04-04T00:00:00Z"))},
+					Remote:   data.Timeframe{Rowid: 1, ID: "1", SessionID: "1", Start: mustParseTime("2025-04-01T00:00:00Z"), End: ptr(mustParseTime("2025-04-03T00:00:00Z"))},
 				},
 			},
 		}
@@ -3,55 +3,47 @@ package main
 import (
 	"log"
 	"os"
-	"path/filepath"
 	"runtime"
 
 	_ "embed"
 
 	"github.com/diamondburned/gotk4/pkg/gio/v2"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
-	"github.com/kirsle/configdir"
-	"nyiyui.ca/jts/database"
+	"nyiyui.ca/jts/config"
 	"nyiyui.ca/jts/gtkui"
-	"nyiyui.ca/jts/tokens"
 )
 
 func main() {
 	runtime.LockOSThread() // for gtk
-	path := configdir.LocalConfig("jts")
-	if err := configdir.MakePath(path); err != nil {
-		log.Fatalf("create config dir: %s", err)
-	}
-
-	var token tokens.Token
-	var err error
-	rawToken, err := os.ReadFile(filepath.Join(path, "server-token"))
-	if err != nil && !os.IsNotExist(err) {
-		log.Fatalf("read token: %s", err)
-	}
-	if err == nil {
-		token, err = tokens.ParseToken(string(rawToken))
-		if err != nil {
-			log.Fatalf("parse token: %s", err)
-		}
-	}
-
-	db, err := database.NewDatabase("")
+	configPath := config.DefaultPath()
+	cfg, err := config.Load(configPath)
 	if err != nil {
-		log.Fatalf("new db: %s", err)
-	}
-	if err := db.Migrate(); err != nil {
-		log.Fatalf("migrate db: %s", err)
+		log.Fatalf("load config: %s", err)
 	}
 
 	app := gtk.NewApplication("ca.nyiyui.jts", gio.ApplicationFlagsNone)
 	app.ConnectActivate(func() {
-		mw := gtkui.NewMainWindow(db, token, filepath.Join(path, "original.json"))
-		mw.Window.SetApplication(app)
-		mw.Window.Show()
+		openMainWindow(app, cfg, configPath)
 	})
 
 	if code := app.Run(os.Args); code > 0 {
 		os.Exit(code)
 	}
 }
+
+// openMainWindow opens the current profile's database in a new main window.
+// When the user switches profiles, the window is replaced.
+func openMainWindow(app *gtk.Application, cfg *config.Config, configPath string) {
+	db, err := cfg.CurrentProfile().OpenDatabase()
+	if err != nil {
+		log.Fatalf("open profile %s: %s", cfg.Current, err)
+	}
+	mw := gtkui.NewMainWindow(db, cfg, configPath)
+	mw.OnProfileSwitched = func() {
+		openMainWindow(app, cfg, configPath)
+		// the old database is left open, as a sync of the old window may still be using it
+		mw.Window.Destroy()
+	}
+	mw.Window.SetApplication(app)
+	mw.Window.Show()
+}
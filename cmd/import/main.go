@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"time"
+
+	"nyiyui.ca/jts/database"
+	"nyiyui.ca/jts/importer"
+)
+
+func main() {
+	var dbPath string
+	var format string
+	var tz string
+	var dryRun bool
+	flag.StringVar(&dbPath, "db-path", "", "path to database. if empty, a default path like ~/.config/jts/jts.db is used")
+	flag.StringVar(&format, "format", "", "format (toggl, clockify, timewarrior or watson)")
+	flag.StringVar(&tz, "tz", "Local", "time zone of times without an offset in CSV exports (IANA name)")
+	flag.BoolVar(&dryRun, "dry-run", false, "only show what would be imported")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s -format FORMAT [flags] FILE...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if !slices.Contains(importer.Formats, importer.Format(format)) {
+		log.Fatalf("unknown format %q", format)
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		log.Fatalf("load time zone: %s", err)
+	}
+
+	var records []importer.Record
+	for _, path := range flag.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("open: %s", err)
+		}
+		r, err := importer.Parse(f, importer.Format(format), importer.Options{Location: loc})
+		f.Close()
+		if err != nil {
+			log.Fatalf("parse %s: %s", path, err)
+		}
+		records = append(records, r...)
+	}
+
+	db, err := database.NewDatabase(dbPath)
+	if err != nil {
+		log.Fatalf("new db: %s", err)
+	}
+	if err := db.Migrate(); err != nil {
+		log.Fatalf("migrate db: %s", err)
+	}
+	plan, err := importer.NewPlan(db, records)
+	if err != nil {
+		log.Fatalf("plan: %s", err)
+	}
+	if err := plan.WriteSummary(os.Stdout, loc); err != nil {
+		log.Fatal(err)
+	}
+	if dryRun {
+		return
+	}
+	if err := plan.Apply(db); err != nil {
+		log.Fatalf("import: %s", err)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+)
+
+func runCheck(db *database.Database, args []string) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "ask how to fix each problem")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return errors.New("too many arguments")
+	}
+	if *fix {
+		return fixProblems(db, bufio.NewReader(os.Stdin), os.Stdout)
+	}
+	problems, err := db.CheckTimeframes()
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		fmt.Println("no problems")
+		return nil
+	}
+	for _, p := range problems {
+		if err := printProblem(db, os.Stdout, p); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("%d problems; run jts check -fix to fix them", len(problems))
+}
+
+// fixProblems asks which fix to apply for each problem, checking again after each fix as it can fix or change other problems.
+func fixProblems(db *database.Database, r *bufio.Reader, out io.Writer) error {
+	skipped := map[string]bool{}
+	for {
+		problems, err := db.CheckTimeframes()
+		if err != nil {
+			return err
+		}
+		var p *database.Problem
+		for i := range problems {
+			if !skipped[problems[i].Key()] {
+				p = &problems[i]
+				break
+			}
+		}
+		if p == nil {
+			fmt.Fprintf(out, "%d problems left\n", len(problems))
+			return nil
+		}
+		fmt.Fprintln(out)
+		if err := printProblem(db, out, *p); err != nil {
+			return err
+		}
+		choice, err := askFix(r, out, len(p.Fixes))
+		if err != nil {
+			return err
+		}
+		if choice == 0 {
+			skipped[p.Key()] = true
+			continue
+		}
+		if err := db.ApplyFix(p.Fixes[choice-1]); err != nil {
+			return fmt.Errorf("fix: %w", err)
+		}
+	}
+}
+
+// askFix returns the number of the fix chosen, or 0 to skip.
+func askFix(r *bufio.Reader, out io.Writer, n int) (int, error) {
+	for {
+		fmt.Fprintf(out, "fix [1-%d] or [s]kip? ", n)
+		line, err := r.ReadString('\n')
+		answer := strings.ToLower(strings.TrimSpace(line))
+		if answer == "s" || answer == "skip" {
+			return 0, nil
+		}
+		if choice, err := strconv.Atoi(answer); err == nil && choice >= 1 && choice <= n {
+			return choice, nil
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return 0, errors.New("no answer")
+			}
+			return 0, err
+		}
+	}
+}
+
+func printProblem(db *database.Database, out io.Writer, p database.Problem) error {
+	names := map[string]string{}
+	for _, tf := range p.Timeframes {
+		session, err := db.GetSession(tf.SessionID)
+		if err != nil {
+			return fmt.Errorf("session %s: %w", tf.SessionID, err)
+		}
+		names[tf.SessionID] = session.Description
+	}
+	var timeframes []string
+	for _, tf := range p.Timeframes {
+		timeframes = append(timeframes, fmt.Sprintf("%q %s", names[tf.SessionID], timeRange(tf)))
+	}
+	switch p.Kind {
+	case database.ProblemOverlap:
+		fmt.Fprintf(out, "overlap of %s: %s\n", p.Overlap.Round(time.Second), strings.Join(timeframes, " and "))
+	case database.ProblemZeroLength:
+		fmt.Fprintf(out, "zero length: %s\n", timeframes[0])
+	case database.ProblemNegativeLength:
+		fmt.Fprintf(out, "ends before it starts: %s\n", timeframes[0])
+	}
+	for i, fix := range p.Fixes {
+		fmt.Fprintf(out, "  %d. %s\n", i+1, describeFix(names, fix))
+	}
+	return nil
+}
+
+func describeFix(names map[string]string, fix database.Fix) string {
+	var parts []string
+	for _, tf := range fix.Update {
+		parts = append(parts, fmt.Sprintf("change %q to %s", names[tf.SessionID], timeRange(tf)))
+	}
+	for _, tf := range fix.Add {
+		parts = append(parts, fmt.Sprintf("add %q %s", names[tf.SessionID], timeRange(tf)))
+	}
+	for _, tf := range fix.Delete {
+		parts = append(parts, fmt.Sprintf("delete %q %s", names[tf.SessionID], timeRange(tf)))
+	}
+	return fmt.Sprintf("%s: %s", fix.Kind, strings.Join(parts, ", "))
+}
+
+func timeRange(tf data.Timeframe) string {
+	end := "running"
+	if tf.End != nil {
+		end = tf.End.Local().Format(time.DateTime)
+	}
+	return fmt.Sprintf("%s - %s", tf.Start.Local().Format(time.DateTime), end)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+)
+
+func TestFixProblems(t *testing.T) {
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "jts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+	start := time.Date(2025, 4, 1, 9, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+	later := end.Add(time.Hour)
+	if _, err := db.AddSession(data.Session{Description: "coding", Timeframes: []data.Timeframe{{Start: start, End: &end}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddSession(data.Session{Description: "meeting", Timeframes: []data.Timeframe{{Start: start.Add(time.Hour), End: &later}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddSession(data.Session{Description: "empty", Timeframes: []data.Timeframe{{Start: later, End: &later}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// skip the zero-length timeframe, and trim the end of coding (after an invalid answer)
+	out := new(strings.Builder)
+	if err := fixProblems(db, bufio.NewReader(strings.NewReader("s\n9\n1\n")), out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `overlap of 1h0m0s: "coding"`) || !strings.Contains(out.String(), "1 problems left") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	problems, err := db.CheckTimeframes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 || problems[0].Kind != database.ProblemZeroLength {
+		t.Fatalf("unexpected problems %#v", problems)
+	}
+
+	// running out of answers stops
+	if err := fixProblems(db, bufio.NewReader(strings.NewReader("")), io.Discard); err == nil {
+		t.Error("expected error")
+	}
+}
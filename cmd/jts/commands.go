@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"nyiyui.ca/jts/daemon"
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+)
+
+func runStart(db *database.Database, args []string) error {
+	fs := flag.NewFlagSet("start", flag.ContinueOnError)
+	task := fs.String("task", "", "ID or description of the task to link the session to")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	description := strings.Join(args, " ")
+	if description == "" {
+		return errors.New("description is required")
+	}
+	session := data.Session{
+		Description: description,
+		Timeframes:  []data.Timeframe{{Start: time.Now()}},
+	}
+	if *task != "" {
+		id, err := findTask(db, *task)
+		if err != nil {
+			return err
+		}
+		session.TaskID = &id
+	}
+	running, err := db.GetRunningTimeframes()
+	if err != nil {
+		return err
+	}
+	id, err := db.AddSession(session)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("started %s (%s)\n", description, id)
+	if len(running) > 0 {
+		fmt.Printf("note: %d other timeframes are still running\n", len(running))
+	}
+	return nil
+}
+
+func runStop(db *database.Database, args []string) error {
+	if len(args) > 1 {
+		return errors.New("too many arguments")
+	}
+	sessionIDs := args
+	if len(args) == 0 {
+		running, err := db.GetRunningTimeframes()
+		if err != nil {
+			return err
+		}
+		if len(running) == 0 {
+			return database.ErrTimerNotRunning
+		}
+		for _, tf := range running {
+			sessionIDs = append(sessionIDs, tf.SessionID)
+		}
+	}
+	for _, id := range sessionIDs {
+		session, err := getSession(db, id)
+		if err != nil {
+			return err
+		}
+		if err := db.StopTimer(id); err != nil {
+			if len(args) == 0 && errors.Is(err, database.ErrTimerNotRunning) {
+				// the session had more than one running timeframe, and all of them are stopped already
+				continue
+			}
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		fmt.Printf("stopped %s (%s)\n", session.Description, id)
+	}
+	return nil
+}
+
+func runExtend(db *database.Database, args []string) error {
+	if len(args) > 1 {
+		return errors.New("too many arguments")
+	}
+	var session data.Session
+	if len(args) == 1 {
+		var err error
+		session, err = getSession(db, args[0])
+		if err != nil {
+			return err
+		}
+	} else {
+		sessions, err := db.GetLatestSessions(1, 0)
+		if err != nil {
+			return err
+		}
+		if len(sessions) == 0 {
+			return errors.New("no sessions")
+		}
+		session = sessions[0]
+	}
+	if len(session.Timeframes) == 0 {
+		return fmt.Errorf("%s has no timeframes", session.ID)
+	}
+	for _, tf := range session.Timeframes {
+		if tf.Running() {
+			return fmt.Errorf("%s: %w", session.ID, database.ErrTimerRunning)
+		}
+	}
+	if err := db.ExtendSession(session.ID, time.Now()); err != nil {
+		return err
+	}
+	fmt.Printf("extended %s (%s)\n", session.Description, session.ID)
+	return nil
+}
+
+func runStatus(db *database.Database, args []string) error {
+	if len(args) > 0 {
+		return errors.New("too many arguments")
+	}
+	running, err := db.GetRunningTimeframes()
+	if err != nil {
+		return err
+	}
+	if len(running) == 0 {
+		fmt.Println("not running")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, tf := range running {
+		session, err := db.GetSession(tf.SessionID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%s\tsince %s\t%s\n", session.ID, session.Description, tf.StringStart(), tf.Duration().Round(time.Second))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	printDaemonStatus()
+	return nil
+}
+
+// printDaemonStatus prints how syncing is going, if the daemon is running.
+func printDaemonStatus() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	s, err := daemon.NewClient(profile.SocketPath()).Status(ctx)
+	if err != nil {
+		return
+	}
+	switch {
+	case s.Syncing:
+		fmt.Printf("sync: syncing (%s)\n", s.Stage)
+	case s.Conflict:
+		fmt.Println("sync: conflicts; run jts sync to resolve them")
+	case s.LastError != "":
+		fmt.Printf("sync: failed %d times (%s), retrying at %s\n", s.Failures, s.LastError, s.NextSync.Local().Format("15:04:05"))
+	case s.LastSuccess.IsZero():
+		fmt.Println("sync: not synced yet")
+	default:
+		fmt.Printf("sync: last synced at %s\n", s.LastSuccess.Local().Format("15:04:05"))
+	}
+	if s.Pending {
+		fmt.Println("sync: local changes pending")
+	}
+}
+
+func runLog(db *database.Database, args []string) error {
+	fs := flag.NewFlagSet("log", flag.ContinueOnError)
+	n := fs.Int("n", 10, "number of sessions to show")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return errors.New("too many arguments")
+	}
+	sessions, err := db.GetLatestSessions(*n, 0)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, s := range sessions {
+		var total time.Duration
+		var timeframes []string
+		for _, tf := range s.Timeframes {
+			total += tf.Duration()
+			timeframes = append(timeframes, fmt.Sprintf("%s - %s", tf.StringStart(), tf.StringEnd()))
+		}
+		tags := ""
+		if len(s.Tags) > 0 {
+			tags = "#" + strings.Join(s.Tags, " #")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.ID, s.Description, total.Round(time.Second), strings.Join(timeframes, ", "), tags)
+	}
+	return w.Flush()
+}
+
+func runEdit(db *database.Database, args []string) error {
+	fs := flag.NewFlagSet("edit", flag.ContinueOnError)
+	description := fs.String("description", "", "new description")
+	notes := fs.String("notes", "", "new notes")
+	task := fs.String("task", "", "ID or description of the task to link the session to. empty to unlink")
+	tags := fs.String("tags", "", "comma-separated tags, replacing the current tags")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("exactly one session is required")
+	}
+	session, err := getSession(db, args[0])
+	if err != nil {
+		return err
+	}
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if len(set) == 0 {
+		return errors.New("nothing to edit")
+	}
+	if set["description"] {
+		session.Description = *description
+	}
+	if set["notes"] {
+		session.Notes = *notes
+	}
+	if set["task"] {
+		session.TaskID = nil
+		if *task != "" {
+			id, err := findTask(db, *task)
+			if err != nil {
+				return err
+			}
+			session.TaskID = &id
+		}
+	}
+	if err := db.EditSessionProperties(session); err != nil {
+		return err
+	}
+	if set["tags"] {
+		if err := db.SetSessionTags(session.ID, strings.Split(*tags, ",")); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("edited %s (%s)\n", session.Description, session.ID)
+	return nil
+}
+
+func runTasks(db *database.Database, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "add":
+			return runTasksAdd(db, args[1:])
+		case "done":
+			if len(args) != 2 {
+				return errors.New("exactly one task is required")
+			}
+			id, err := findTask(db, args[1])
+			if err != nil {
+				return err
+			}
+			return db.SetTaskStatus(id, data.TaskStatusDone)
+		}
+	}
+	fs := flag.NewFlagSet("tasks", flag.ContinueOnError)
+	all := fs.Bool("all", false, "also show done and archived tasks")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("unknown subcommand %q", args[0])
+	}
+	var tasks []data.Task
+	if *all {
+		tasks, err = db.GetTasks()
+	} else {
+		tasks, err = db.GetUndoneTasks()
+	}
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, t := range tasks {
+		due := ""
+		if t.Due != nil {
+			due = "due " + t.Due.Local().Format("2006-01-02")
+		}
+		estimate := ""
+		if t.Estimate != nil {
+			estimate = "est. " + t.Estimate.String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", t.ID, t.Description, t.Status, due, estimate)
+	}
+	return w.Flush()
+}
+
+func runTasksAdd(db *database.Database, args []string) error {
+	fs := flag.NewFlagSet("tasks add", flag.ContinueOnError)
+	due := fs.String("due", "", "due date (2006-01-02)")
+	estimate := fs.Duration("estimate", 0, "how long the task is expected to take (e.g. 1h30m)")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	task := data.Task{Description: strings.Join(args, " ")}
+	if task.Description == "" {
+		return errors.New("description is required")
+	}
+	if *due != "" {
+		t, err := time.ParseInLocation("2006-01-02", *due, time.Local)
+		if err != nil {
+			return fmt.Errorf("due: %w", err)
+		}
+		task.Due = &t
+	}
+	if *estimate != 0 {
+		task.Estimate = estimate
+	}
+	id, err := db.AddTask(task)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("added %s (%s)\n", task.Description, id)
+	return nil
+}
+
+func getSession(db *database.Database, id string) (data.Session, error) {
+	session, err := db.GetSession(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return data.Session{}, fmt.Errorf("session %s not found", id)
+	}
+	return session, err
+}
+
+// findTask returns the ID of the task with the ID or description s.
+func findTask(db *database.Database, s string) (string, error) {
+	tasks, err := db.GetTasks()
+	if err != nil {
+		return "", err
+	}
+	var matches []string
+	for _, t := range tasks {
+		if t.ID == s {
+			return t.ID, nil
+		}
+		if t.Description == s {
+			matches = append(matches, t.ID)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("task %s not found", s)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("%d tasks are named %s; use an ID", len(matches), s)
+	}
+}
+
+func runProfiles(db *database.Database, args []string) error {
+	if len(args) == 2 && args[0] == "use" {
+		if _, ok := cfg.Profile(args[1]); !ok {
+			return fmt.Errorf("profile %s does not exist", args[1])
+		}
+		cfg.Current = args[1]
+		return cfg.Save(configPath)
+	}
+	if len(args) > 0 {
+		return errors.New("usage: profiles | profiles use PROFILE")
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, p := range cfg.Profiles {
+		current := ""
+		if p.Name == cfg.Current {
+			current = "*"
+		}
+		remote := p.ServerURL
+		if p.SyncDir != "" {
+			remote = p.SyncDir
+		} else if p.Passphrase != "" {
+			remote += " (encrypted)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", current, p.Name, remote, p.DatabasePath())
+	}
+	return w.Flush()
+}
@@ -0,0 +1,112 @@
+// Command jts records time from the terminal, on the same database as the GTK app.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pressly/goose/v3"
+	"nyiyui.ca/jts/config"
+	"nyiyui.ca/jts/database"
+)
+
+type command struct {
+	usage string
+	run   func(db *database.Database, args []string) error
+}
+
+var commands = map[string]command{
+	"start":    {"start [-task TASK] DESCRIPTION", runStart},
+	"stop":     {"stop [SESSION]", runStop},
+	"extend":   {"extend [SESSION]", runExtend},
+	"status":   {"status", runStatus},
+	"log":      {"log [-n N]", runLog},
+	"edit":     {"edit [-description D] [-notes N] [-task TASK] [-tags T1,T2] SESSION", runEdit},
+	"tasks":    {"tasks [-all] | tasks add [-due DATE] [-estimate DURATION] DESCRIPTION | tasks done TASK", runTasks},
+	"sync":     {"sync [-local] [-dry-run]", runSync},
+	"profiles": {"profiles | profiles use PROFILE", runProfiles},
+	"check":    {"check [-fix]", runCheck},
+}
+
+var (
+	configPath string
+	cfg        *config.Config
+	// profile is the profile in use, from -profile or the config.
+	profile config.Profile
+)
+
+func main() {
+	var profileName string
+	flag.StringVar(&configPath, "config", config.DefaultPath(), "path to the config file")
+	flag.StringVar(&profileName, "profile", "", "profile to use. if empty, the current profile in the config is used")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	name := flag.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	cfg, err = config.Load(configPath)
+	if err != nil {
+		log.Fatalf("load config: %s", err)
+	}
+	if profileName == "" {
+		profileName = cfg.Current
+	}
+	profile, ok = cfg.Profile(profileName)
+	if !ok {
+		log.Fatalf("profile %s does not exist", profileName)
+	}
+	// migration logs would clutter the output of every command
+	goose.SetLogger(goose.NopLogger())
+	db, err := profile.OpenDatabase()
+	if err != nil {
+		log.Fatalf("open profile %s: %s", profile.Name, err)
+	}
+	if err := cmd.run(db, flag.Args()[1:]); err != nil {
+		log.Fatalf("%s: %s", name, err)
+	}
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "usage: %s [-config PATH] [-profile PROFILE] COMMAND [flags] [args]\n\ncommands:\n", filepath.Base(os.Args[0]))
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %s\n", commands[name].usage)
+	}
+	fmt.Fprintln(out, "\nflags:")
+	flag.PrintDefaults()
+}
+
+// parseArgs parses flags that may appear before, between or after positional arguments, and returns the positional arguments.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
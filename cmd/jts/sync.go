@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"nyiyui.ca/jts/daemon"
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+	"nyiyui.ca/jts/database/sync"
+)
+
+func runSync(db *database.Database, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	local := fs.Bool("local", false, "sync in this process even if the daemon is running")
+	dryRun := fs.Bool("dry-run", false, "show what a sync would change, without changing anything")
+	args, err := parseArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return errors.New("too many arguments")
+	}
+	if !*local && !*dryRun {
+		_, err := daemon.NewClient(profile.SocketPath()).Sync(context.Background())
+		var se *daemon.SyncError
+		switch {
+		case err == nil:
+			fmt.Println("synced by daemon")
+			return nil
+		case errors.As(err, &se) && se.Conflict:
+			fmt.Println("the daemon found conflicts; resolving them here")
+		case !errors.Is(err, daemon.ErrNotRunning):
+			return fmt.Errorf("daemon: %w", err)
+		}
+	}
+	syncer, err := profile.Syncer()
+	if err != nil {
+		return fmt.Errorf("profile %s: %w", profile.Name, err)
+	}
+	state, err := sync.ReadSyncState(profile.SyncStatePath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read sync state: %w", err)
+	}
+
+	status := make(chan string)
+	defer close(status)
+	go func() {
+		for s := range status {
+			fmt.Fprintf(os.Stderr, "sync: %s\n", s)
+		}
+	}()
+	if *dryRun {
+		preview, err := syncer.Preview(context.Background(), state, db, status)
+		if err != nil {
+			return err
+		}
+		printPreview(os.Stdout, preview)
+		return nil
+	}
+	resolver := newTerminalResolver(os.Stdin, os.Stdout)
+	changes, newState, err := syncer.SyncDatabase(context.Background(), state, db, resolver, status)
+	if err != nil {
+		return err
+	}
+	if err := sync.WriteSyncState(profile.SyncStatePath(), newState); err != nil {
+		return fmt.Errorf("update sync state: %w", err)
+	}
+	fmt.Printf("synced: sessions=%d, timeframes=%d, tasks=%d\n", len(changes.Sessions), len(changes.Timeframes), len(changes.Tasks))
+	return nil
+}
+
+// newTerminalResolver returns a conflict resolver that asks, for each conflict, whether to keep the local or the remote version.
+func newTerminalResolver(in io.Reader, out io.Writer) func(sync.MergeConflicts) (sync.Changes, error) {
+	r := bufio.NewReader(in)
+	return func(mc sync.MergeConflicts) (sync.Changes, error) {
+		var changes sync.Changes
+		var err error
+		changes.Sessions, err = resolveConflicts(r, out, "session", mc.Sessions, describeSession)
+		if err != nil {
+			return sync.Changes{}, err
+		}
+		changes.Timeframes, err = resolveConflicts(r, out, "timeframe", mc.Timeframes, describeTimeframe)
+		if err != nil {
+			return sync.Changes{}, err
+		}
+		changes.Tasks, err = resolveConflicts(r, out, "task", mc.Tasks, describeTask)
+		if err != nil {
+			return sync.Changes{}, err
+		}
+		return changes, nil
+	}
+}
+
+func resolveConflicts[T any](r *bufio.Reader, out io.Writer, kind string, mcs []sync.MergeConflict[T], describe func(T) string) ([]sync.Change[T], error) {
+	var changes []sync.Change[T]
+	for i, mc := range mcs {
+		fmt.Fprintf(out, "\n%s conflict %d/%d\n", kind, i+1, len(mcs))
+		if len(mc.Fields) > 0 {
+			fmt.Fprintf(out, "  fields:   %s\n", strings.Join(mc.Fields, ", "))
+		}
+		fmt.Fprintf(out, "  original: %s\n", describe(mc.Original))
+		fmt.Fprintf(out, "  local:    %s\n", describeSide(mc.Local, mc.LocalRemoved, describe))
+		fmt.Fprintf(out, "  remote:   %s\n", describeSide(mc.Remote, mc.RemoteRemoved, describe))
+		keepLocal, err := askLocalRemote(r, out)
+		if err != nil {
+			return nil, err
+		}
+		// a Remove change only needs the ID, which the other side has
+		switch {
+		case keepLocal && mc.LocalRemoved:
+			changes = append(changes, sync.Change[T]{Operation: sync.ChangeOperationRemove, Data: mc.Remote})
+		case keepLocal:
+			changes = append(changes, sync.Change[T]{Operation: sync.ChangeOperationExist, Data: mc.Local})
+		case mc.RemoteRemoved:
+			changes = append(changes, sync.Change[T]{Operation: sync.ChangeOperationRemove, Data: mc.Local})
+		default:
+			changes = append(changes, sync.Change[T]{Operation: sync.ChangeOperationExist, Data: mc.Remote})
+		}
+	}
+	return changes, nil
+}
+
+func describeSide[T any](v T, removed bool, describe func(T) string) string {
+	if removed {
+		return "(deleted)"
+	}
+	return describe(v)
+}
+
+func askLocalRemote(r *bufio.Reader, out io.Writer) (keepLocal bool, err error) {
+	for {
+		fmt.Fprint(out, "keep [l]ocal or [r]emote? ")
+		line, err := r.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "l", "local":
+			return true, nil
+		case "r", "remote":
+			return false, nil
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return false, errors.New("no answer")
+			}
+			return false, err
+		}
+	}
+}
+
+func describeSession(s data.Session) string {
+	task := "none"
+	if s.TaskID != nil {
+		task = *s.TaskID
+	}
+	return fmt.Sprintf("%q notes=%q task=%s tags=%s", s.Description, s.Notes, task, strings.Join(s.Tags, ","))
+}
+
+func describeTimeframe(tf data.Timeframe) string {
+	return fmt.Sprintf("%s (session %s)", timeRange(tf), tf.SessionID)
+}
+
+func describeTask(t data.Task) string {
+	s := fmt.Sprintf("%q status=%s", t.Description, t.Status)
+	if t.Due != nil {
+		s += " due=" + t.Due.Local().Format(time.DateOnly)
+	}
+	if t.Estimate != nil {
+		s += " estimate=" + t.Estimate.String()
+	}
+	return s
+}
+
+// printPreview prints the rows a sync would change, with + for added, ~ for changed and - for removed rows.
+func printPreview(out io.Writer, p sync.Preview) {
+	fmt.Fprintf(out, "outgoing: %d\n", p.Outgoing.Len())
+	printDiff(out, p.Outgoing)
+	fmt.Fprintf(out, "incoming: %d\n", p.Incoming.Len())
+	printDiff(out, p.Incoming)
+	fmt.Fprintf(out, "conflicts: %d\n", p.Conflicts.Len())
+	printConflicts(out, "session", p.Conflicts.Sessions, describeSession)
+	printConflicts(out, "timeframe", p.Conflicts.Timeframes, describeTimeframe)
+	printConflicts(out, "task", p.Conflicts.Tasks, describeTask)
+}
+
+func printDiff(out io.Writer, d sync.Diff) {
+	printRowDiffs(out, "session", d.Sessions, describeSession)
+	printRowDiffs(out, "timeframe", d.Timeframes, describeTimeframe)
+	printRowDiffs(out, "task", d.Tasks, describeTask)
+}
+
+func printRowDiffs[T any](out io.Writer, kind string, diffs []sync.RowDiff[T], describe func(T) string) {
+	for _, d := range diffs {
+		switch d.Operation {
+		case sync.DiffOperationAdd:
+			fmt.Fprintf(out, "  + %s %s\n", kind, describe(d.New))
+		case sync.DiffOperationChange:
+			fmt.Fprintf(out, "  ~ %s %s\n      -> %s\n", kind, describe(d.Old), describe(d.New))
+		case sync.DiffOperationRemove:
+			fmt.Fprintf(out, "  - %s %s\n", kind, describe(d.Old))
+		}
+	}
+}
+
+func printConflicts[T any](out io.Writer, kind string, mcs []sync.MergeConflict[T], describe func(T) string) {
+	for _, mc := range mcs {
+		fmt.Fprintf(out, "  ! %s", kind)
+		if len(mc.Fields) > 0 {
+			fmt.Fprintf(out, " (%s)", strings.Join(mc.Fields, ", "))
+		}
+		fmt.Fprintln(out)
+		fmt.Fprintf(out, "      local:  %s\n", describeSide(mc.Local, mc.LocalRemoved, describe))
+		fmt.Fprintf(out, "      remote: %s\n", describeSide(mc.Remote, mc.RemoteRemoved, describe))
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database/sync"
+)
+
+func TestTerminalResolver(t *testing.T) {
+	mc := sync.MergeConflicts{
+		Sessions: []sync.MergeConflict[data.Session]{
+			{
+				Original: data.Session{ID: "s1", Description: "a"},
+				Local:    data.Session{ID: "s1", Description: "local"},
+				Remote:   data.Session{ID: "s1", Description: "remote"},
+			},
+			{
+				Original:      data.Session{ID: "s2", Description: "b"},
+				Local:         data.Session{ID: "s2", Description: "local"},
+				RemoteRemoved: true,
+			},
+		},
+		Tasks: []sync.MergeConflict[data.Task]{
+			{
+				Original:     data.Task{ID: "t1", Description: "c"},
+				LocalRemoved: true,
+				Remote:       data.Task{ID: "t1", Description: "remote"},
+			},
+		},
+	}
+	// an invalid answer is asked again
+	resolver := newTerminalResolver(strings.NewReader("r\nx\nremote\nl"), io.Discard)
+	changes, err := resolver(mc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes.Sessions) != 2 || len(changes.Tasks) != 1 {
+		t.Fatalf("changes: %#v", changes)
+	}
+	if c := changes.Sessions[0]; c.Operation != sync.ChangeOperationExist || c.Data.Description != "remote" {
+		t.Errorf("session 0: %#v", c)
+	}
+	if c := changes.Sessions[1]; c.Operation != sync.ChangeOperationRemove || c.Data.ID != "s2" {
+		t.Errorf("session 1: %#v", c)
+	}
+	if c := changes.Tasks[0]; c.Operation != sync.ChangeOperationRemove || c.Data.ID != "t1" {
+		t.Errorf("task 0: %#v", c)
+	}
+
+	// running out of answers aborts the sync
+	resolver = newTerminalResolver(strings.NewReader("l\n"), io.Discard)
+	if _, err := resolver(mc); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestPrintPreview(t *testing.T) {
+	p := sync.Preview{
+		Outgoing: sync.Diff{
+			Sessions: []sync.RowDiff[data.Session]{{Operation: sync.DiffOperationAdd, New: data.Session{ID: "s1", Description: "lunch"}}},
+		},
+		Incoming: sync.Diff{
+			Tasks: []sync.RowDiff[data.Task]{
+				{Operation: sync.DiffOperationChange, Old: data.Task{ID: "t1", Description: "a", Status: data.TaskStatusOpen}, New: data.Task{ID: "t1", Description: "a", Status: data.TaskStatusDone}},
+				{Operation: sync.DiffOperationRemove, Old: data.Task{ID: "t2", Description: "b", Status: data.TaskStatusOpen}},
+			},
+		},
+		Conflicts: sync.MergeConflicts{
+			Sessions: []sync.MergeConflict[data.Session]{{Local: data.Session{ID: "s2", Description: "local"}, RemoteRemoved: true}},
+		},
+	}
+	var out bytes.Buffer
+	printPreview(&out, p)
+	expected := `outgoing: 1
+  + session "lunch" notes="" task=none tags=
+incoming: 2
+  ~ task "a" status=open
+      -> "a" status=done
+  - task "b" status=open
+conflicts: 1
+  ! session
+      local:  "local" notes="" task=none tags=
+      remote: (deleted)
+`
+	if out.String() != expected {
+		t.Fatalf("unexpected output:\n%s", out.String())
+	}
+}
@@ -0,0 +1,77 @@
+// Command jtsd syncs the local database in the background.
+// The GTK app and the jts CLI ask it to sync over a Unix socket when it is running.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"nyiyui.ca/jts/config"
+	"nyiyui.ca/jts/daemon"
+)
+
+func main() {
+	var configPath, profileName string
+	var cfg daemon.Config
+	flag.StringVar(&configPath, "config", config.DefaultPath(), "path to the config file")
+	flag.StringVar(&profileName, "profile", "", "profile to sync. if empty, the current profile in the config is used")
+	flag.DurationVar(&cfg.Debounce, "debounce", daemon.DefaultDebounce, "time to wait after a local change before syncing")
+	flag.DurationVar(&cfg.Interval, "interval", daemon.DefaultInterval, "time between periodic syncs")
+	flag.DurationVar(&cfg.RetryDelay, "retry-delay", daemon.DefaultRetryDelay, "time before retrying a failed sync. doubles with every failure")
+	flag.DurationVar(&cfg.MaxBackoff, "max-backoff", daemon.DefaultMaxBackoff, "maximum time between retries")
+	flag.DurationVar(&cfg.WatchInterval, "watch-interval", daemon.DefaultWatchInterval, "how often to check for changes made by other processes. negative to disable")
+	flag.Parse()
+
+	c, err := config.Load(configPath)
+	if err != nil {
+		log.Fatalf("load config: %s", err)
+	}
+	if profileName == "" {
+		profileName = c.Current
+	}
+	profile, ok := c.Profile(profileName)
+	if !ok {
+		log.Fatalf("profile %s does not exist", profileName)
+	}
+	syncer, err := profile.Syncer()
+	if err != nil {
+		log.Fatalf("profile %s: %s", profile.Name, err)
+	}
+	db, err := profile.OpenDatabase()
+	if err != nil {
+		log.Fatalf("open profile %s: %s", profile.Name, err)
+	}
+
+	cfg.DB = db
+	cfg.Sync = daemon.SyncWith(syncer, db, profile.SyncStatePath())
+	d := daemon.New(cfg)
+	socketPath := profile.SocketPath()
+
+	l, err := daemon.Listen(socketPath)
+	if err != nil {
+		log.Fatalf("listen: %s", err)
+	}
+	hs := &http.Server{Handler: d.Handler()}
+	go func() {
+		if err := hs.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("serve: %s", err)
+		}
+	}()
+	log.Printf("listening on %s", socketPath)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := d.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		log.Printf("run: %s", err)
+	}
+	// closing the listener removes the socket
+	if err := hs.Close(); err != nil {
+		log.Printf("close: %s", err)
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/gorilla/sessions"
 	"golang.org/x/oauth2"
@@ -24,16 +26,29 @@ func getenvNonEmpty(key string) string {
 	return value
 }
 
+// oidcSecretEnv returns the environment variable with the client secret of the OpenID Connect provider.
+func oidcSecretEnv(name string) string {
+	name = strings.ToUpper(strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name))
+	return "JTS_SERVER_OIDC_" + name + "_CLIENT_SECRET"
+}
+
 func main() {
 	var dbPath string
 	var bindAddress string
 	var tokensPath string
 	var usersPath string
+	var oidcPath string
 	// secrets and their related options are in environment variables, others are in flags
 	flag.StringVar(&bindAddress, "bind", "127.0.0.1:8080", "bind address")
 	flag.StringVar(&dbPath, "db-path", "", "path to database. if empty, a default path like ~/.config/jts/jts.db is used")
-	flag.StringVar(&tokensPath, "tokens-path", "", "path to tokens")
+	flag.StringVar(&tokensPath, "tokens-path", "", "path to tokens, in addition to tokens in the database (see the tokens command)")
 	flag.StringVar(&usersPath, "users-path", "", "path to users")
+	flag.StringVar(&oidcPath, "oidc-path", "", "path to OpenID Connect providers. client secrets are in JTS_SERVER_OIDC_<NAME>_CLIENT_SECRET")
 	flag.Parse()
 
 	tokenMap2 := map[tokens.TokenHash]server.TokenInfo{}
@@ -73,20 +88,56 @@ func main() {
 		log.Fatalf("migrate db: %s", err)
 	}
 	log.Printf("database migrated.")
+	if flag.Arg(0) == "tokens" {
+		if err := runTokens(db, flag.Args()[1:]); err != nil {
+			log.Fatalf("tokens: %s", err)
+		}
+		return
+	}
 
 	authKey, err := hex.DecodeString(getenvNonEmpty("JTS_SERVER_STORE_AUTH_KEY"))
 	if err != nil {
 		log.Fatal(err)
 	}
 	store := sessions.NewFilesystemStore("", authKey)
+	// Lax keeps other sites from posting the web UI's forms with the session cookie (CSRF), while still sending it when an identity provider redirects back after login.
+	store.Options.SameSite = http.SameSiteLaxMode
+	store.Options.HttpOnly = true
+
+	var providers []server.Provider
+	if os.Getenv("JTS_SERVER_OAUTH_CLIENT_ID") != "" {
+		providers = append(providers, server.NewGitHubProvider(&oauth2.Config{
+			ClientID:     getenvNonEmpty("JTS_SERVER_OAUTH_CLIENT_ID"),
+			ClientSecret: getenvNonEmpty("JTS_SERVER_OAUTH_CLIENT_SECRET"),
+			Scopes:       []string{},
+			Endpoint:     github.Endpoint,
+			RedirectURL:  getenvNonEmpty("JTS_SERVER_OAUTH_REDIRECT_URI"),
+		}))
+	}
+	if oidcPath != "" {
+		data, err := os.ReadFile(oidcPath)
+		if err != nil {
+			log.Fatalf("read oidc: %s", err)
+		}
+		var configs []server.OIDCConfig
+		err = json.Unmarshal(data, &configs)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, config := range configs {
+			config.ClientSecret = os.Getenv(oidcSecretEnv(config.Name))
+			p, err := server.NewOIDCProvider(context.Background(), config)
+			if err != nil {
+				log.Fatalf("oidc provider %s: %s", config.Name, err)
+			}
+			providers = append(providers, p)
+		}
+	}
+	if len(providers) == 0 {
+		log.Printf("no identity providers are configured; only tokens can be used.")
+	}
 
-	s, err := server.New(&oauth2.Config{
-		ClientID:     getenvNonEmpty("JTS_SERVER_OAUTH_CLIENT_ID"),
-		ClientSecret: getenvNonEmpty("JTS_SERVER_OAUTH_CLIENT_SECRET"),
-		Scopes:       []string{},
-		Endpoint:     github.Endpoint,
-		RedirectURL:  getenvNonEmpty("JTS_SERVER_OAUTH_REDIRECT_URI"),
-	}, db, tokenMap2, users, store)
+	s, err := server.New(providers, db, tokenMap2, users, store)
 	if err != nil {
 		log.Fatal(err)
 	}
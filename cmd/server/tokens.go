@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"nyiyui.ca/jts/database"
+	"nyiyui.ca/jts/server"
+)
+
+const tokensUsage = `usage:
+  server [flags] tokens issue -name NAME -permissions P1,P2 [-expires DURATION]
+  server [flags] tokens list
+  server [flags] tokens revoke ID
+`
+
+// runTokens manages API tokens in the server database. It can be run while the server is running, and revocation takes effect immediately.
+func runTokens(db *database.Database, args []string) error {
+	if len(args) == 0 {
+		return errors.New(tokensUsage)
+	}
+	switch args[0] {
+	case "issue":
+		fs := flag.NewFlagSet("issue", flag.ContinueOnError)
+		name := fs.String("name", "", "name of the token, e.g. the device using it")
+		permissions := fs.String("permissions", "", "comma-separated permissions")
+		expires := fs.Duration("expires", 0, "how long until the token expires. if zero, it does not expire")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		var ps []server.Permission
+		for _, p := range strings.Split(*permissions, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				ps = append(ps, server.Permission(p))
+			}
+		}
+		var expiresAt *time.Time
+		if *expires != 0 {
+			t := time.Now().Add(*expires)
+			expiresAt = &t
+		}
+		token, apiToken, err := server.IssueToken(db, *name, ps, expiresAt)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("id    = %s\n", apiToken.ID)
+		fmt.Printf("token = %s\n", token)
+		return nil
+	case "list":
+		apiTokens, err := db.GetAPITokens()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "ID\tNAME\tPERMISSIONS\tCREATED\tLAST USED\tEXPIRES\tSTATUS\n")
+		for _, t := range apiTokens {
+			status := "active"
+			switch {
+			case t.RevokedAt != nil:
+				status = "revoked"
+			case !t.Valid(now):
+				status = "expired"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", t.ID, t.Name, strings.Join(t.Permissions, ","), formatTime(&t.CreatedAt), formatTime(t.LastUsedAt), formatTime(t.ExpiresAt), status)
+		}
+		return w.Flush()
+	case "revoke":
+		if len(args) != 2 {
+			return errors.New(tokensUsage)
+		}
+		if err := db.RevokeAPIToken(args[1], time.Now()); err != nil {
+			return fmt.Errorf("revoke %s: %w", args[1], err)
+		}
+		fmt.Printf("revoked %s\n", args[1])
+		return nil
+	default:
+		return errors.New(tokensUsage)
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
@@ -0,0 +1,351 @@
+// Package config is the client configuration: named profiles, each with its own server, token and local database.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kirsle/configdir"
+	"nyiyui.ca/jts/daemon"
+	"nyiyui.ca/jts/database"
+	"nyiyui.ca/jts/database/sync"
+	"nyiyui.ca/jts/tokens"
+)
+
+// DefaultProfileName is the name of the profile created when there is no config file.
+// Its database and sync state are at the paths used before profiles existed.
+const DefaultProfileName = "default"
+
+const (
+	DefaultServerURL = "https://jts.kiyuri.ca"
+	DefaultTimeout   = Duration(5 * time.Second)
+)
+
+// Dir returns the config directory, e.g. ~/.config/jts.
+func Dir() string {
+	return configdir.LocalConfig("jts")
+}
+
+// DefaultPath returns the path of the config file in Dir.
+func DefaultPath() string {
+	return filepath.Join(Dir(), "config.json")
+}
+
+// Duration is a time.Duration written as a string like "5s".
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ErrNoToken is returned by Profile.Transport for a profile that syncs with a server without a token.
+var ErrNoToken = errors.New("no token")
+
+// Profile is a server (or a shared directory) and the local database synced with it.
+type Profile struct {
+	Name      string
+	ServerURL string
+	// Token is the API token, or empty to not sync.
+	Token string
+	// SyncDir is a directory shared between devices (e.g. by Syncthing or on a NAS) to sync through instead of the server.
+	// If set, ServerURL and Token are not used.
+	SyncDir string
+	// Passphrase enables end-to-end encryption: rows are encrypted with a key derived from it before they are sent to the server, which only stores opaque blobs.
+	// Every device syncing with the server must use the same passphrase. Once a device synced with encryption, the server refuses devices without it.
+	Passphrase string
+	// Timeout is the timeout of each request to the server. Zero means DefaultTimeout.
+	Timeout Duration
+	// DBPath is the path to the database. If empty, a path in Dir depending on the name is used.
+	DBPath string
+	// StatePath is the path to the sync state (what the client remembers instead of an original copy).
+	// If empty, a path in Dir depending on the name is used.
+	StatePath string
+	// MergeNotes merges notes edited on two devices line by line, instead of reporting a conflict.
+	MergeNotes bool
+	// ConflictPolicies resolve conflicts of each entity type without asking, where they can (e.g. {"Tasks": "latest"}).
+	ConflictPolicies sync.Policies
+}
+
+// Validate checks that the profile can be used.
+func (p Profile) Validate() error {
+	if p.Name == "" {
+		return errors.New("name is empty")
+	}
+	if strings.ContainsAny(p.Name, `/\`) {
+		return errors.New("name contains a slash")
+	}
+	if p.SyncDir == "" {
+		if _, err := p.BaseURL(); err != nil {
+			return err
+		}
+	} else if p.Passphrase != "" {
+		return errors.New("encryption with a passphrase needs a server, not a shared directory")
+	}
+	if _, err := p.ParsedToken(); err != nil {
+		return err
+	}
+	if p.Timeout < 0 {
+		return errors.New("timeout is negative")
+	}
+	return p.ConflictPolicies.Validate()
+}
+
+// BaseURL returns the parsed server URL.
+func (p Profile) BaseURL() (*url.URL, error) {
+	u, err := url.Parse(p.ServerURL)
+	if err != nil {
+		return nil, fmt.Errorf("server URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("server URL: scheme must be http or https, not %q", u.Scheme)
+	}
+	return u, nil
+}
+
+// ParsedToken returns the parsed token, which is empty if Token is.
+func (p Profile) ParsedToken() (tokens.Token, error) {
+	if p.Token == "" {
+		return tokens.Token{}, nil
+	}
+	token, err := tokens.ParseToken(strings.TrimSpace(p.Token))
+	if err != nil {
+		return tokens.Token{}, fmt.Errorf("token: %w", err)
+	}
+	return token, nil
+}
+
+// Transport returns the transport to sync the profile through: the shared directory if SyncDir is set, and the server otherwise (encrypted if Passphrase is set).
+func (p Profile) Transport() (sync.Transport, error) {
+	if p.SyncDir != "" {
+		return sync.NewDirTransport(p.SyncDir), nil
+	}
+	baseURL, err := p.BaseURL()
+	if err != nil {
+		return nil, err
+	}
+	token, err := p.ParsedToken()
+	if err != nil {
+		return nil, err
+	}
+	if token.Empty() {
+		return nil, ErrNoToken
+	}
+	sc := sync.NewServerClient(p.HTTPClient(), baseURL, token)
+	if p.Passphrase != "" {
+		return sync.NewEncryptedTransport(sc, p.Passphrase), nil
+	}
+	return sc, nil
+}
+
+// Syncer returns a syncer using the profile's transport and merge options.
+func (p Profile) Syncer() (sync.Syncer, error) {
+	t, err := p.Transport()
+	if err != nil {
+		return sync.Syncer{}, err
+	}
+	return sync.Syncer{Transport: t, MergeOptions: p.MergeOptions()}, nil
+}
+
+// HTTPClient returns a client with the profile's timeout.
+func (p Profile) HTTPClient() *http.Client {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	return &http.Client{Timeout: time.Duration(timeout)}
+}
+
+// dir is where the profile's files are kept by default.
+func (p Profile) dir() string {
+	if p.Name == DefaultProfileName {
+		return Dir()
+	}
+	return filepath.Join(Dir(), "profiles", p.Name)
+}
+
+// DatabasePath returns DBPath, or the default path for the profile.
+func (p Profile) DatabasePath() string {
+	if p.DBPath != "" {
+		return p.DBPath
+	}
+	return filepath.Join(p.dir(), "jts.db")
+}
+
+// SyncStatePath returns StatePath, or the default path for the profile.
+func (p Profile) SyncStatePath() string {
+	if p.StatePath != "" {
+		return p.StatePath
+	}
+	return filepath.Join(p.dir(), "sync-state.json")
+}
+
+// MergeOptions returns the options for merging local and remote changes when syncing.
+func (p Profile) MergeOptions() sync.MergeOptions {
+	return sync.MergeOptions{MergeNotes: p.MergeNotes, Policies: p.ConflictPolicies}
+}
+
+// OpenDatabase opens and migrates the profile's database, creating the directories of the profile's files.
+func (p Profile) OpenDatabase() (*database.Database, error) {
+	for _, path := range []string{p.DatabasePath(), p.SyncStatePath()} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	db, err := database.NewDatabase(p.DatabasePath())
+	if err != nil {
+		return nil, fmt.Errorf("new db: %w", err)
+	}
+	if err := db.Migrate(); err != nil {
+		return nil, fmt.Errorf("migrate db: %w", err)
+	}
+	return db, nil
+}
+
+// Config is the client configuration.
+type Config struct {
+	// Current is the name of the profile in use.
+	Current  string
+	Profiles []Profile
+}
+
+// Load reads the config file at path.
+// If it does not exist, a config with only the default profile is returned,
+// using the token in the server-token file next to it if there is one.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return defaultConfig(filepath.Join(filepath.Dir(path), "server-token"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := new(Config)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func defaultConfig(tokenPath string) (*Config, error) {
+	p := Profile{Name: DefaultProfileName, ServerURL: DefaultServerURL, Timeout: DefaultTimeout}
+	token, err := os.ReadFile(tokenPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("read token: %w", err)
+	}
+	p.Token = strings.TrimSpace(string(token))
+	return &Config{Current: p.Name, Profiles: []Profile{p}}, nil
+}
+
+// Save writes the config file at path, readable only by the current user as it contains tokens.
+func (c *Config) Save(path string) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// Validate checks the profiles, and that the current profile exists.
+func (c *Config) Validate() error {
+	seen := map[string]struct{}{}
+	for _, p := range c.Profiles {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("profile %q: %w", p.Name, err)
+		}
+		if _, ok := seen[p.Name]; ok {
+			return fmt.Errorf("profile %q: duplicate name", p.Name)
+		}
+		seen[p.Name] = struct{}{}
+	}
+	if _, ok := seen[c.Current]; !ok {
+		return fmt.Errorf("current profile %q does not exist", c.Current)
+	}
+	return nil
+}
+
+// Profile returns the profile with the name.
+func (c *Config) Profile(name string) (Profile, bool) {
+	i := slices.IndexFunc(c.Profiles, func(p Profile) bool { return p.Name == name })
+	if i == -1 {
+		return Profile{}, false
+	}
+	return c.Profiles[i], true
+}
+
+// CurrentProfile returns the profile in use.
+func (c *Config) CurrentProfile() Profile {
+	p, ok := c.Profile(c.Current)
+	if !ok {
+		panic(fmt.Sprintf("current profile %q does not exist", c.Current))
+	}
+	return p
+}
+
+// SetProfile adds the profile, or replaces the profile with the same name.
+func (c *Config) SetProfile(p Profile) {
+	i := slices.IndexFunc(c.Profiles, func(p2 Profile) bool { return p2.Name == p.Name })
+	if i == -1 {
+		c.Profiles = append(c.Profiles, p)
+	} else {
+		c.Profiles[i] = p
+	}
+}
+
+// DeleteProfile deletes the profile. The current profile cannot be deleted.
+func (c *Config) DeleteProfile(name string) error {
+	if name == c.Current {
+		return errors.New("cannot delete the current profile")
+	}
+	c.Profiles = slices.DeleteFunc(c.Profiles, func(p Profile) bool { return p.Name == name })
+	return nil
+}
+
+// SocketPath returns the path of the socket of the profile's daemon.
+// The default profile uses daemon.DefaultSocketPath.
+func (p Profile) SocketPath() string {
+	path := daemon.DefaultSocketPath()
+	if p.Name == DefaultProfileName {
+		return path
+	}
+	return filepath.Join(filepath.Dir(path), "jtsd-"+p.Name+".sock")
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"nyiyui.ca/jts/database/sync"
+	"nyiyui.ca/jts/tokens"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	token, err := tokens.RandomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "server-token"), []byte(token.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// without a config file, the default profile uses the legacy token
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := c.CurrentProfile()
+	if p.Name != DefaultProfileName || p.ServerURL != DefaultServerURL || p.Token != token.String() {
+		t.Fatalf("default profile: %#v", p)
+	}
+	if p.DatabasePath() != filepath.Join(Dir(), "jts.db") {
+		t.Errorf("default database path: %s", p.DatabasePath())
+	}
+
+	c.SetProfile(Profile{Name: "work", ServerURL: "https://jts.example.com", Timeout: Duration(30 * time.Second)})
+	c.Current = "work"
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("config file mode: %s", info.Mode())
+	}
+	c, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = c.CurrentProfile()
+	if p.Name != "work" || p.ServerURL != "https://jts.example.com" || p.HTTPClient().Timeout != 30*time.Second {
+		t.Fatalf("work profile: %#v", p)
+	}
+	if p.DatabasePath() != filepath.Join(Dir(), "profiles", "work", "jts.db") {
+		t.Errorf("work database path: %s", p.DatabasePath())
+	}
+	if err := c.DeleteProfile("work"); err == nil {
+		t.Error("deleted the current profile")
+	}
+	if err := c.DeleteProfile(DefaultProfileName); err != nil || len(c.Profiles) != 1 {
+		t.Errorf("delete: %v, %d profiles", err, len(c.Profiles))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for name, c := range map[string]Config{
+		"no current":    {Current: "a", Profiles: []Profile{{Name: "b", ServerURL: DefaultServerURL}}},
+		"duplicate":     {Current: "a", Profiles: []Profile{{Name: "a", ServerURL: DefaultServerURL}, {Name: "a", ServerURL: DefaultServerURL}}},
+		"bad scheme":    {Current: "a", Profiles: []Profile{{Name: "a", ServerURL: "ftp://example.com"}}},
+		"bad token":     {Current: "a", Profiles: []Profile{{Name: "a", ServerURL: DefaultServerURL, Token: "nope"}}},
+		"slash in name": {Current: "a/b", Profiles: []Profile{{Name: "a/b", ServerURL: DefaultServerURL}}},
+		"encrypted dir": {Current: "a", Profiles: []Profile{{Name: "a", SyncDir: "/tmp/jts", Passphrase: "a"}}},
+		"bad policy":    {Current: "a", Profiles: []Profile{{Name: "a", ServerURL: DefaultServerURL, ConflictPolicies: sync.Policies{Tasks: sync.PolicyLongest}}}},
+	} {
+		if err := c.Validate(); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	token, err := tokens.RandomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Profile{Name: "a", ServerURL: DefaultServerURL}
+	if _, err := p.Transport(); !errors.Is(err, ErrNoToken) {
+		t.Errorf("without a token: expected %v, got %v", ErrNoToken, err)
+	}
+	p.Token = token.String()
+	if tr, err := p.Transport(); err != nil {
+		t.Errorf("with a token: %s", err)
+	} else if _, ok := tr.(*sync.ServerClient); !ok {
+		t.Errorf("with a token: expected a server client, got %T", tr)
+	}
+	p.Passphrase = "correct horse battery staple"
+	if tr, err := p.Transport(); err != nil {
+		t.Errorf("with a passphrase: %s", err)
+	} else if _, ok := tr.(*sync.EncryptedTransport); !ok {
+		t.Errorf("with a passphrase: expected an encrypted transport, got %T", tr)
+	}
+
+	// a shared directory needs neither a server nor a token
+	p = Profile{Name: "b", SyncDir: t.TempDir()}
+	if err := p.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if tr, err := p.Transport(); err != nil {
+		t.Errorf("with a directory: %s", err)
+	} else if _, ok := tr.(*sync.DirTransport); !ok {
+		t.Errorf("with a directory: expected a directory transport, got %T", tr)
+	}
+}
@@ -0,0 +1,302 @@
+// Package daemon runs syncs in the background: after local changes (debounced), periodically, and on request over a Unix socket.
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	gosync "sync"
+	"time"
+
+	"nyiyui.ca/jts/database"
+	"nyiyui.ca/jts/database/sync"
+)
+
+// SyncFunc runs one sync, reporting progress on status.
+type SyncFunc func(ctx context.Context, status chan<- string) error
+
+// ErrConflictsQueued is returned by a SyncFunc that synced everything but conflicts, which it queued to be resolved interactively (see sync.Syncer.QueueConflicts).
+// The sync counts as successful, but the status reports the conflicts.
+var ErrConflictsQueued = errors.New("conflicts are queued to be resolved interactively")
+
+// Config configures a Daemon. Zero durations are replaced by defaults.
+type Config struct {
+	DB   *database.Database
+	Sync SyncFunc
+	// Debounce is how long to wait after a local change for more changes before syncing.
+	Debounce time.Duration
+	// Interval is the time between periodic syncs.
+	Interval time.Duration
+	// RetryDelay is the time before retrying after the first failed sync. It doubles with every further failure.
+	RetryDelay time.Duration
+	// MaxBackoff caps the time between retries.
+	MaxBackoff time.Duration
+	// WatchInterval is how often to poll the changelog for changes made by other processes,
+	// which Database.Notify does not see. Negative disables polling.
+	WatchInterval time.Duration
+}
+
+const (
+	DefaultDebounce      = 2 * time.Second
+	DefaultInterval      = 5 * time.Minute
+	DefaultRetryDelay    = 30 * time.Second
+	DefaultMaxBackoff    = time.Hour
+	DefaultWatchInterval = 5 * time.Second
+)
+
+func (c *Config) setDefaults() {
+	if c.Debounce == 0 {
+		c.Debounce = DefaultDebounce
+	}
+	if c.Interval == 0 {
+		c.Interval = DefaultInterval
+	}
+	if c.RetryDelay == 0 {
+		c.RetryDelay = DefaultRetryDelay
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = DefaultMaxBackoff
+	}
+	if c.WatchInterval == 0 {
+		c.WatchInterval = DefaultWatchInterval
+	}
+}
+
+// Status is what the daemon is doing and how the last sync went.
+type Status struct {
+	Syncing bool
+	// Stage is the stage of the running sync, as reported by SyncDatabase.
+	Stage string
+	// Pending is whether local changes are waiting to be synced.
+	Pending     bool
+	LastAttempt time.Time
+	LastSuccess time.Time
+	// LastError is the error of the last sync, or empty if it succeeded.
+	LastError string
+	// Conflict is whether the last sync failed because of conflicts, or queued conflicts (ErrConflictsQueued), which have to be resolved interactively.
+	Conflict bool
+	// Failures is the number of consecutive failed syncs.
+	Failures int
+	NextSync time.Time
+}
+
+// Daemon syncs in the background. Use New to create one and Run to start it.
+type Daemon struct {
+	cfg     Config
+	changed chan struct{}
+	trigger chan struct{}
+
+	mu      gosync.Mutex
+	status  Status
+	waiters []chan Status
+}
+
+func New(cfg Config) *Daemon {
+	cfg.setDefaults()
+	return &Daemon{
+		cfg:     cfg,
+		changed: make(chan struct{}, 1),
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+// Status returns the current status.
+func (d *Daemon) Status() Status {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.status
+}
+
+// SyncNow requests a sync, ignoring any backoff, and waits for it to finish.
+// It returns the status after the sync.
+func (d *Daemon) SyncNow(ctx context.Context) (Status, error) {
+	done := make(chan Status, 1)
+	d.mu.Lock()
+	d.waiters = append(d.waiters, done)
+	d.mu.Unlock()
+	select {
+	case d.trigger <- struct{}{}:
+	default:
+		// a sync is already requested
+	}
+	select {
+	case s := <-done:
+		return s, nil
+	case <-ctx.Done():
+		return Status{}, ctx.Err()
+	}
+}
+
+// notify is registered with Database.Notify.
+func (d *Daemon) notify(op int, db string, table string, rowid int64) {
+	switch table {
+	case "sessions", "time_frames", "tasks", "session_tags":
+	default:
+		return
+	}
+	d.markChanged()
+}
+
+func (d *Daemon) markChanged() {
+	d.mu.Lock()
+	if d.status.Syncing {
+		// changes made by the sync itself
+		d.mu.Unlock()
+		return
+	}
+	d.status.Pending = true
+	d.mu.Unlock()
+	select {
+	case d.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run syncs once, then whenever requested, until ctx is done.
+func (d *Daemon) Run(ctx context.Context) error {
+	d.cfg.DB.Notify(d.notify)
+	if d.cfg.WatchInterval > 0 {
+		go d.watch(ctx)
+	}
+	next := time.NewTimer(0)
+	defer next.Stop()
+	debounce := time.NewTimer(0)
+	if !debounce.Stop() {
+		<-debounce.C
+	}
+	defer debounce.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-d.changed:
+			debounce.Reset(d.cfg.Debounce)
+			continue
+		case <-debounce.C:
+			if d.Status().Failures > 0 {
+				// probably offline; wait for the retry instead of failing on every change
+				continue
+			}
+		case <-d.trigger:
+		case <-next.C:
+		}
+		d.syncOnce(ctx)
+		next.Reset(time.Until(d.Status().NextSync))
+	}
+}
+
+// watch polls the changelog for changes made by other processes (e.g. the GTK app or the CLI).
+func (d *Daemon) watch(ctx context.Context) {
+	last, err := d.cfg.DB.LatestSeq()
+	if err != nil {
+		log.Printf("watch: latest seq: %s", err)
+	}
+	ticker := time.NewTicker(d.cfg.WatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		seq, err := d.cfg.DB.LatestSeq()
+		if err != nil {
+			log.Printf("watch: latest seq: %s", err)
+			continue
+		}
+		if d.Status().Syncing {
+			// the sync applies remote changes, which are not local changes to sync
+			last = seq
+			continue
+		}
+		if seq > last {
+			d.markChanged()
+		}
+		last = seq
+	}
+}
+
+func (d *Daemon) syncOnce(ctx context.Context) {
+	d.mu.Lock()
+	waiters := d.waiters
+	d.waiters = nil
+	d.status.Syncing = true
+	d.status.Pending = false
+	d.status.LastAttempt = time.Now()
+	d.mu.Unlock()
+
+	status := make(chan string)
+	stagesDone := make(chan struct{})
+	go func() {
+		defer close(stagesDone)
+		for s := range status {
+			d.mu.Lock()
+			d.status.Stage = s
+			d.mu.Unlock()
+		}
+	}()
+	err := d.cfg.Sync(ctx, status)
+	close(status)
+	<-stagesDone
+
+	d.mu.Lock()
+	d.status.Syncing = false
+	d.status.Stage = ""
+	queued := errors.Is(err, ErrConflictsQueued)
+	if err != nil && !queued {
+		log.Printf("sync: %s", err)
+		d.status.LastError = err.Error()
+		d.status.Conflict = errors.Is(err, sync.ErrConflictNoResolver)
+		d.status.Failures++
+		d.status.NextSync = d.status.LastAttempt.Add(d.backoff(d.status.Failures))
+	} else {
+		d.status.LastError = ""
+		if queued {
+			log.Printf("sync: %s", err)
+			d.status.LastError = err.Error()
+		}
+		d.status.Conflict = queued
+		d.status.Failures = 0
+		d.status.LastSuccess = d.status.LastAttempt
+		d.status.NextSync = d.status.LastAttempt.Add(d.cfg.Interval)
+	}
+	s := d.status
+	d.mu.Unlock()
+	for _, w := range waiters {
+		w <- s
+	}
+}
+
+// backoff returns the time to wait after the given number of consecutive failures.
+func (d *Daemon) backoff(failures int) time.Duration {
+	delay := d.cfg.RetryDelay
+	for i := 1; i < failures && delay < d.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, d.cfg.MaxBackoff)
+}
+
+// SyncWith returns a SyncFunc that syncs db using s, keeping the sync state in the file at statePath.
+// Conflicts that the policies of s do not resolve are queued in the sync state, and the sync returns ErrConflictsQueued.
+func SyncWith(s sync.Syncer, db *database.Database, statePath string) SyncFunc {
+	s.QueueConflicts = true
+	return func(ctx context.Context, status chan<- string) error {
+		state, err := sync.ReadSyncState(statePath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("read sync state: %w", err)
+		}
+		_, newState, err := s.SyncDatabase(ctx, state, db, nil, status)
+		if err != nil {
+			return err
+		}
+		if err := sync.WriteSyncState(statePath, newState); err != nil {
+			return fmt.Errorf("update sync state: %w", err)
+		}
+		if n := newState.Queued.Len(); n > 0 {
+			return fmt.Errorf("%d %w", n, ErrConflictsQueued)
+		}
+		return nil
+	}
+}
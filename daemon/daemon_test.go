@@ -0,0 +1,188 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+	"nyiyui.ca/jts/database/sync"
+)
+
+func newTestDatabase(t *testing.T) *database.Database {
+	t.Helper()
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "jts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestDaemon(t *testing.T) {
+	db := newTestDatabase(t)
+	syncs := make(chan struct{}, 10)
+	var fail atomic.Bool
+	d := New(Config{
+		DB: db,
+		Sync: func(ctx context.Context, status chan<- string) error {
+			syncs <- struct{}{}
+			if fail.Load() {
+				return sync.ErrConflictNoResolver
+			}
+			return nil
+		},
+		Debounce:      10 * time.Millisecond,
+		Interval:      time.Hour,
+		WatchInterval: -1,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+	wait := func(what string) {
+		t.Helper()
+		select {
+		case <-syncs:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no sync %s", what)
+		}
+		// changes during the sync are taken to be made by the sync
+		for d.Status().Syncing {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	wait("on start")
+
+	// changes are debounced into one sync
+	for i := 0; i < 3; i++ {
+		if _, err := db.AddSession(data.Session{Description: "a"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wait("after change")
+	select {
+	case <-syncs:
+		t.Fatal("changes were not debounced")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	fail.Store(true)
+	s, err := d.SyncNow(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-syncs
+	if !s.Conflict || s.Failures != 1 || s.LastError == "" {
+		t.Fatalf("status after failure: %#v", s)
+	}
+	if got := s.NextSync.Sub(s.LastAttempt); got != DefaultRetryDelay {
+		t.Errorf("retry after %s", got)
+	}
+	// failed syncs back off, and changes do not trigger syncs until the retry
+	if _, err := db.AddSession(data.Session{Description: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-syncs:
+		t.Fatal("change triggered sync during backoff")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !d.Status().Pending {
+		t.Error("change is not pending")
+	}
+
+	fail.Store(false)
+	s, err = d.SyncNow(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-syncs
+	if s.Failures != 0 || s.LastError != "" || s.Pending || s.LastSuccess.IsZero() {
+		t.Fatalf("status after success: %#v", s)
+	}
+}
+
+func TestDaemonQueuedConflicts(t *testing.T) {
+	d := New(Config{
+		DB: newTestDatabase(t),
+		Sync: func(ctx context.Context, status chan<- string) error {
+			return fmt.Errorf("1 %w", ErrConflictsQueued)
+		},
+		Interval:      time.Hour,
+		WatchInterval: -1,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+	s, err := d.SyncNow(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rest was synced, so there is no backoff
+	if !s.Conflict || s.Failures != 0 || s.LastError == "" || s.LastSuccess.IsZero() {
+		t.Fatalf("status after queueing conflicts: %#v", s)
+	}
+	if got := s.NextSync.Sub(s.LastAttempt); got != time.Hour {
+		t.Errorf("next sync after %s", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := New(Config{RetryDelay: time.Second, MaxBackoff: 10 * time.Second})
+	for failures, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 100: 10 * time.Second} {
+		if got := d.backoff(failures); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
+
+func TestSocket(t *testing.T) {
+	db := newTestDatabase(t)
+	d := New(Config{
+		DB: db,
+		Sync: func(ctx context.Context, status chan<- string) error {
+			status <- "stage"
+			return errors.New("offline")
+		},
+		WatchInterval: -1,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	path := filepath.Join(t.TempDir(), "jtsd.sock")
+	c := NewClient(path)
+	if _, err := c.Status(ctx); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("status without daemon: %v", err)
+	}
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go (&http.Server{Handler: d.Handler()}).Serve(l)
+
+	_, err = c.Sync(ctx)
+	var se *SyncError
+	if !errors.As(err, &se) || se.Message != "offline" || se.Conflict {
+		t.Fatalf("sync: %v", err)
+	}
+	s, err := c.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.LastError != "offline" || s.Failures == 0 {
+		t.Fatalf("status: %#v", s)
+	}
+	if _, err := Listen(path); err == nil {
+		t.Error("listened on a socket in use")
+	}
+}
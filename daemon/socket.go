@@ -0,0 +1,143 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/kirsle/configdir"
+)
+
+// ErrNotRunning is returned by Client when no daemon is listening on the socket.
+var ErrNotRunning = errors.New("daemon is not running")
+
+// SyncError is returned by Client.Sync when the daemon's sync failed, or queued conflicts.
+type SyncError struct {
+	Message string
+	// Conflict is whether the sync failed because of conflicts, or queued conflicts, which have to be resolved interactively.
+	Conflict bool
+}
+
+func (e *SyncError) Error() string {
+	return e.Message
+}
+
+// DefaultSocketPath returns the path of the socket in $XDG_RUNTIME_DIR, or in the config directory if it is not set.
+func DefaultSocketPath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = configdir.LocalConfig("jts")
+	}
+	return filepath.Join(dir, "jtsd.sock")
+}
+
+// Listen listens on a Unix socket at path, replacing a stale socket left by a daemon that did not exit cleanly.
+// Only the current user can connect.
+func Listen(path string) (net.Listener, error) {
+	if _, err := NewClient(path).Status(context.Background()); err == nil {
+		return nil, fmt.Errorf("another daemon is listening on %s", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Handler serves the daemon's status (GET /status) and runs syncs on request (POST /sync).
+// Both respond with a Status.
+func (d *Daemon) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeStatus(w, d.Status())
+	})
+	mux.HandleFunc("POST /sync", func(w http.ResponseWriter, r *http.Request) {
+		s, err := d.SyncNow(r.Context())
+		if err != nil {
+			// the client went away
+			return
+		}
+		writeStatus(w, s)
+	})
+	return mux
+}
+
+func writeStatus(w http.ResponseWriter, s Status) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Client talks to a daemon over its socket.
+type Client struct {
+	client *http.Client
+}
+
+func NewClient(socketPath string) *Client {
+	dialer := new(net.Dialer)
+	return &Client{client: &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				conn, err := dialer.DialContext(ctx, "unix", socketPath)
+				if err != nil {
+					return nil, fmt.Errorf("%w: %w", ErrNotRunning, err)
+				}
+				return conn, nil
+			},
+		},
+	}}
+}
+
+// Status returns the daemon's status.
+func (c *Client) Status(ctx context.Context) (Status, error) {
+	return c.do(ctx, http.MethodGet, "/status")
+}
+
+// Sync asks the daemon to sync now and waits for it to finish.
+// If the sync fails, the error is a *SyncError.
+func (c *Client) Sync(ctx context.Context) (Status, error) {
+	s, err := c.do(ctx, http.MethodPost, "/sync")
+	if err != nil {
+		return Status{}, err
+	}
+	if s.LastError != "" {
+		return s, &SyncError{Message: s.LastError, Conflict: s.Conflict}
+	}
+	return s, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string) (Status, error) {
+	// the host is ignored, as the transport always dials the socket
+	req, err := http.NewRequestWithContext(ctx, method, "http://jtsd"+path, nil)
+	if err != nil {
+		return Status{}, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return Status{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return Status{}, fmt.Errorf("status code %d: %s", resp.StatusCode, body)
+	}
+	var s Status
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return Status{}, fmt.Errorf("decode status: %w", err)
+	}
+	return s, nil
+}
@@ -2,6 +2,7 @@ package data
 
 import (
 	"slices"
+	"strings"
 	"time"
 )
 
@@ -14,38 +15,92 @@ type Session struct {
 	Notes       string      `db:"notes"`
 	Timeframes  []Timeframe `json:"timeframes,omitempty"`
 	TaskID      *string     `db:"task_id"`
+	// Tags are sorted tag names (see NormalizeTags). They are stored in a separate table.
+	Tags []string `db:"-"`
+	// ModifiedAt is when the session (including its tags) was last edited, or nil if it has not been edited since modification times were recorded.
+	// It shall not be considered for equality.
+	ModifiedAt *time.Time `db:"modified_at"`
 }
 
+// EqualProperties compares the columns of the session. Tags are not compared, as they are merged separately.
 func (s Session) EqualProperties(other Session) bool {
-	return s.ID == other.ID && s.Description == other.Description && s.Notes == other.Notes && s.TaskID == other.TaskID
+	return s.ID == other.ID && s.Description == other.Description && s.Notes == other.Notes && EqualStringPtr(s.TaskID, other.TaskID)
 }
 
 func (s Session) Equal(other Session) bool {
 	return s.EqualProperties(other) && slices.EqualFunc(s.Timeframes, other.Timeframes, Timeframe.Equal)
 }
 
+// NormalizeTags returns the tags trimmed, sorted and without empty or duplicate tags.
+func NormalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			normalized = append(normalized, tag)
+		}
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized)
+}
+
 type Timeframe struct {
 	Rowid     int       `db:"rowid"` // rowid shall not be considered for equality
 	ID        string    `db:"id"`
 	SessionID string    `db:"session_id"`
 	Start     time.Time `db:"start_time"`
-	End       time.Time `db:"end_time"`
-	Done      bool      `db:"done"`
+	// End is nil while the timeframe is running (i.e. the timer has not been stopped yet).
+	End  *time.Time `db:"end_time"`
+	Done bool       `db:"done"`
+	// ModifiedAt is when the timeframe was last edited (see Session.ModifiedAt).
+	ModifiedAt *time.Time `db:"modified_at"`
 }
 
 func (tf Timeframe) Equal(other Timeframe) bool {
-	return tf.ID == other.ID && tf.SessionID == other.SessionID && tf.Start.Equal(other.Start) && tf.End.Equal(other.End) && tf.Done == other.Done
+	return tf.ID == other.ID && tf.SessionID == other.SessionID && tf.Start.Equal(other.Start) && EqualTimePtr(tf.End, other.End) && tf.Done == other.Done
+}
+
+// EqualStringPtr reports whether a and b are both nil, or point to equal strings.
+func EqualStringPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// EqualTimePtr reports whether a and b are both nil, or point to the same instant.
+func EqualTimePtr(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equal(*b)
+}
+
+// Running reports whether the timeframe has not ended yet.
+func (tf Timeframe) Running() bool {
+	return tf.End == nil
+}
+
+// EndOrNow returns the end time, or the current time if the timeframe is running.
+func (tf Timeframe) EndOrNow() time.Time {
+	if tf.End == nil {
+		return time.Now()
+	}
+	return *tf.End
 }
 
 func (tf Timeframe) StringStart() string {
 	format := "2006-01-02 15:04"
-	if tf.End.Local().YearDay() == tf.Start.Local().YearDay() {
+	if tf.EndOrNow().Local().YearDay() == tf.Start.Local().YearDay() {
 		format = "15:04"
 	}
 	return tf.Start.Local().Format(format)
 }
 
 func (tf Timeframe) StringEnd() string {
+	if tf.End == nil {
+		return "実行中"
+	}
 	format := "2006-01-02 15:04"
 	if tf.End.Local().YearDay() == tf.Start.Local().YearDay() {
 		format = "15:04"
@@ -53,16 +108,73 @@ func (tf Timeframe) StringEnd() string {
 	return tf.End.Local().Format(format)
 }
 
+// Duration returns the length of the timeframe.
+// For a running timeframe, this is the time elapsed since Start.
 func (tf Timeframe) Duration() time.Duration {
-	return tf.End.Sub(tf.Start)
+	return tf.EndOrNow().Sub(tf.Start)
 }
 
 type Task struct {
-	Rowid       int    `db:"rowid"` // rowid shall not be considered for equality
-	ID          string `db:"id"`
-	Description string `db:"description"`
+	Rowid       int        `db:"rowid"` // rowid shall not be considered for equality
+	ID          string     `db:"id"`
+	Description string     `db:"description"`
+	Status      TaskStatus `db:"status"`
+	// Due is when the task should be done by, if set.
+	Due *time.Time `db:"due"`
+	// Estimate is how long the task is expected to take, if set.
+	Estimate *time.Duration `db:"estimate"`
+	// ModifiedAt is when the task was last edited (see Session.ModifiedAt).
+	ModifiedAt *time.Time `db:"modified_at"`
 }
 
 func (t Task) Equal(other Task) bool {
-	return t.ID == other.ID && t.Description == other.Description
+	return t.ID == other.ID && t.Description == other.Description && t.Status == other.Status && EqualTimePtr(t.Due, other.Due) && EqualDurationPtr(t.Estimate, other.Estimate)
+}
+
+// EqualDurationPtr reports whether a and b are both nil, or point to equal durations.
+func EqualDurationPtr(a, b *time.Duration) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// TaskStatus is whether a task is still to be worked on.
+// It is independent of the done flags of timeframes.
+type TaskStatus string
+
+const (
+	TaskStatusOpen     TaskStatus = "open"
+	TaskStatusDone     TaskStatus = "done"
+	TaskStatusArchived TaskStatus = "archived"
+)
+
+// Valid reports whether s is one of the known statuses.
+func (s TaskStatus) Valid() bool {
+	switch s {
+	case TaskStatusOpen, TaskStatusDone, TaskStatusArchived:
+		return true
+	}
+	return false
+}
+
+// Label returns the status for display.
+func (s TaskStatus) Label() string {
+	switch s {
+	case TaskStatusOpen:
+		return "未完了"
+	case TaskStatusDone:
+		return "完了"
+	case TaskStatusArchived:
+		return "アーカイブ"
+	}
+	return string(s)
+}
+
+// Tombstone records that a row (e.g. a session) has been deleted.
+type Tombstone struct {
+	Rowid     int       `db:"rowid"` // rowid shall not be considered for equality
+	ID        string    `db:"id"`
+	TableName string    `db:"table_name"`
+	DeletedAt time.Time `db:"deleted_at"`
 }
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"nyiyui.ca/jts/data"
+)
+
+// ChangelogEntry is one change recorded by the changelog triggers (see migration 007).
+type ChangelogEntry struct {
+	Seq       int64  `db:"seq"`
+	TableName string `db:"table_name"`
+	ID        string `db:"id"`
+	// Old is the row before the change as JSON, or nil if the row was inserted.
+	Old *string `db:"old"`
+}
+
+// LatestSeq returns the sequence number of the latest change, or 0 if there are no changes.
+func LatestSeq(q sqlx.Queryer) (int64, error) {
+	var seq int64
+	err := sqlx.Get(q, &seq, "SELECT COALESCE(MAX(seq), 0) FROM changelog")
+	return seq, err
+}
+
+func (d *Database) LatestSeq() (int64, error) {
+	return LatestSeq(d.DB)
+}
+
+// ChangelogSince returns changes with a sequence number greater than seq, oldest first.
+func ChangelogSince(q sqlx.Queryer, seq int64) ([]ChangelogEntry, error) {
+	var entries []ChangelogEntry
+	err := sqlx.Select(q, &entries, "SELECT * FROM changelog WHERE seq > ? ORDER BY seq", seq)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (d *Database) ChangelogSince(seq int64) ([]ChangelogEntry, error) {
+	return ChangelogSince(d.DB, seq)
+}
+
+// PruneChangelog deletes changes with a sequence number up to and including seq.
+// This must not be called on the server, as clients rely on its changelog.
+func (d *Database) PruneChangelog(seq int64) error {
+	_, err := d.DB.Exec("DELETE FROM changelog WHERE seq <= ?", seq)
+	return err
+}
+
+// OldSession decodes Old as a session.
+func (e ChangelogEntry) OldSession() (data.Session, error) {
+	var s data.Session
+	if e.Old == nil {
+		return data.Session{}, fmt.Errorf("change %d: no old row", e.Seq)
+	}
+	err := json.Unmarshal([]byte(*e.Old), &s)
+	return s, err
+}
+
+// OldTimeframe decodes Old as a timeframe.
+func (e ChangelogEntry) OldTimeframe() (data.Timeframe, error) {
+	var raw struct {
+		ID        string
+		SessionID string
+		Start     string
+		End       *string
+		Done      int
+	}
+	if e.Old == nil {
+		return data.Timeframe{}, fmt.Errorf("change %d: no old row", e.Seq)
+	}
+	err := json.Unmarshal([]byte(*e.Old), &raw)
+	if err != nil {
+		return data.Timeframe{}, err
+	}
+	tf := data.Timeframe{ID: raw.ID, SessionID: raw.SessionID, Done: raw.Done != 0}
+	tf.Start, err = parseTimestamp(raw.Start)
+	if err != nil {
+		return data.Timeframe{}, fmt.Errorf("change %d: start: %w", e.Seq, err)
+	}
+	if raw.End != nil {
+		end, err := parseTimestamp(*raw.End)
+		if err != nil {
+			return data.Timeframe{}, fmt.Errorf("change %d: end: %w", e.Seq, err)
+		}
+		tf.End = &end
+	}
+	return tf, nil
+}
+
+// OldTask decodes Old as a task.
+func (e ChangelogEntry) OldTask() (data.Task, error) {
+	var raw struct {
+		ID          string
+		Description string
+		Status      data.TaskStatus
+		Due         *string
+		Estimate    *time.Duration
+	}
+	if e.Old == nil {
+		return data.Task{}, fmt.Errorf("change %d: no old row", e.Seq)
+	}
+	err := json.Unmarshal([]byte(*e.Old), &raw)
+	if err != nil {
+		return data.Task{}, err
+	}
+	t := data.Task{ID: raw.ID, Description: raw.Description, Status: raw.Status, Estimate: raw.Estimate}
+	if raw.Due != nil {
+		due, err := parseTimestamp(*raw.Due)
+		if err != nil {
+			return data.Task{}, fmt.Errorf("change %d: due: %w", e.Seq, err)
+		}
+		t.Due = &due
+	}
+	return t, nil
+}
+
+// parseTimestamp parses a timestamp the same way as go-sqlite3 does for DATETIME columns.
+func parseTimestamp(s string) (time.Time, error) {
+	s = strings.TrimSuffix(s, "Z")
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		t, err := time.ParseInLocation(format, s, time.UTC)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+}
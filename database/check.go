@@ -0,0 +1,234 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"nyiyui.ca/jts/data"
+)
+
+// ProblemKind is what is wrong with one or more timeframes.
+type ProblemKind string
+
+const (
+	// ProblemOverlap is two timeframes covering the same time, in the same session or in different sessions.
+	ProblemOverlap ProblemKind = "overlap"
+	// ProblemZeroLength is a timeframe that ends when it starts.
+	ProblemZeroLength ProblemKind = "zero-length"
+	// ProblemNegativeLength is a timeframe that ends before it starts.
+	ProblemNegativeLength ProblemKind = "negative-length"
+)
+
+// Problem is a timeframe, or a pair of timeframes, that makes totals wrong.
+type Problem struct {
+	Kind ProblemKind
+	// Timeframes are the timeframes with the problem.
+	// For ProblemOverlap, these are two timeframes, the one starting first first.
+	Timeframes []data.Timeframe
+	// Overlap is how long the timeframes overlap, for ProblemOverlap.
+	Overlap time.Duration
+	// Fixes are the proposed fixes, the most likely one first.
+	Fixes []Fix
+}
+
+// Key identifies the problem, so that it can be found again with CheckTimeframes after the timeframes are loaded again.
+func (p Problem) Key() string {
+	ids := make([]string, len(p.Timeframes))
+	for i, tf := range p.Timeframes {
+		ids[i] = tf.ID
+	}
+	return string(p.Kind) + ":" + strings.Join(ids, ",")
+}
+
+// SameSession reports whether all the timeframes are in the same session.
+func (p Problem) SameSession() bool {
+	for _, tf := range p.Timeframes {
+		if tf.SessionID != p.Timeframes[0].SessionID {
+			return false
+		}
+	}
+	return true
+}
+
+// FixKind is how a Fix changes the timeframes.
+type FixKind string
+
+const (
+	// FixTrim moves the start or end of a timeframe so that it no longer overlaps.
+	FixTrim FixKind = "trim"
+	// FixSplit splits a timeframe in two around another timeframe inside it.
+	FixSplit FixKind = "split"
+	// FixMerge merges overlapping timeframes of the same session into one.
+	FixMerge FixKind = "merge"
+	// FixSwap swaps the start and end of a timeframe that ends before it starts.
+	FixSwap FixKind = "swap"
+	// FixDelete deletes a timeframe.
+	FixDelete FixKind = "delete"
+)
+
+// Fix is a proposed change to fix a Problem. Use ApplyFix to apply it.
+type Fix struct {
+	Kind FixKind
+	// Update are timeframes to change, with their new values.
+	Update []data.Timeframe
+	// Add are timeframes to add. Their IDs are ignored.
+	Add []data.Timeframe
+	// Delete are timeframes to delete.
+	Delete []data.Timeframe
+}
+
+// CheckTimeframes finds overlapping timeframes, and timeframes of zero or negative length.
+// Running timeframes are considered to end now.
+func (d *Database) CheckTimeframes() ([]Problem, error) {
+	var tfs []data.Timeframe
+	err := d.DB.Select(&tfs, "SELECT * FROM time_frames")
+	if err != nil {
+		return nil, err
+	}
+	return checkTimeframes(tfs, time.Now()), nil
+}
+
+func checkTimeframes(tfs []data.Timeframe, now time.Time) []Problem {
+	var problems []Problem
+	var valid []data.Timeframe
+	for _, tf := range tfs {
+		switch {
+		case tf.End != nil && tf.End.Before(tf.Start):
+			swapped := tf
+			end := tf.Start
+			swapped.Start, swapped.End = *tf.End, &end
+			problems = append(problems, Problem{
+				Kind:       ProblemNegativeLength,
+				Timeframes: []data.Timeframe{tf},
+				Fixes: []Fix{
+					{Kind: FixSwap, Update: []data.Timeframe{swapped}},
+					{Kind: FixDelete, Delete: []data.Timeframe{tf}},
+				},
+			})
+		case tf.End != nil && tf.End.Equal(tf.Start):
+			problems = append(problems, Problem{
+				Kind:       ProblemZeroLength,
+				Timeframes: []data.Timeframe{tf},
+				Fixes:      []Fix{{Kind: FixDelete, Delete: []data.Timeframe{tf}}},
+			})
+		default:
+			valid = append(valid, tf)
+		}
+	}
+	sort.SliceStable(valid, func(i, j int) bool {
+		return valid[i].Start.Before(valid[j].Start)
+	})
+	// active are the timeframes that have not ended by the start of the current one
+	var active []data.Timeframe
+	for _, b := range valid {
+		kept := active[:0]
+		for _, a := range active {
+			if endAt(a, now).After(b.Start) {
+				kept = append(kept, a)
+			}
+		}
+		active = kept
+		for _, a := range active {
+			overlap := earliest(endAt(a, now), endAt(b, now)).Sub(b.Start)
+			if overlap <= 0 {
+				continue
+			}
+			problems = append(problems, Problem{
+				Kind:       ProblemOverlap,
+				Timeframes: []data.Timeframe{a, b},
+				Overlap:    overlap,
+				Fixes:      overlapFixes(a, b, now),
+			})
+		}
+		active = append(active, b)
+	}
+	return problems
+}
+
+func endAt(tf data.Timeframe, now time.Time) time.Time {
+	if tf.End == nil {
+		return now
+	}
+	return *tf.End
+}
+
+func earliest(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// overlapFixes proposes fixes for a and b overlapping, where a does not start after b.
+func overlapFixes(a, b data.Timeframe, now time.Time) []Fix {
+	if a.SessionID == b.SessionID {
+		merged := a
+		if b.End == nil || (a.End != nil && b.End.After(*a.End)) {
+			merged.End = b.End
+		}
+		merged.Done = a.Done || b.Done
+		return []Fix{{Kind: FixMerge, Update: []data.Timeframe{merged}, Delete: []data.Timeframe{b}}}
+	}
+	var fixes []Fix
+	inside := b.End != nil && !endAt(b, now).After(endAt(a, now))
+	if inside {
+		// a was not stopped while working on b, so split a around b
+		before, after := a, a
+		start := b.Start
+		before.End = &start
+		after.Start = *b.End
+		fixes = append(fixes, Fix{Kind: FixSplit, Update: []data.Timeframe{before}, Add: []data.Timeframe{after}})
+	}
+	if b.Start.After(a.Start) {
+		// end a when b started
+		trimmed := a
+		start := b.Start
+		trimmed.End = &start
+		fixes = append(fixes, Fix{Kind: FixTrim, Update: []data.Timeframe{trimmed}})
+	}
+	if !inside && a.End != nil {
+		// start b when a ended
+		trimmed := b
+		trimmed.Start = *a.End
+		fixes = append(fixes, Fix{Kind: FixTrim, Update: []data.Timeframe{trimmed}})
+	}
+	return fixes
+}
+
+// ApplyFix applies a fix proposed by CheckTimeframes.
+func (d *Database) ApplyFix(fix Fix) error {
+	tx := d.DB.MustBegin()
+	defer tx.Rollback()
+	now := time.Now()
+	for _, tf := range fix.Update {
+		res, err := tx.Exec("UPDATE time_frames SET start_time = ?, end_time = ?, done = ?, modified_at = ? WHERE session_id = ? AND id = ?", tf.Start, tf.End, tf.Done, now, tf.SessionID, tf.ID)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return fmt.Errorf("timeframe %s not found", tf.ID)
+		}
+	}
+	for _, tf := range fix.Add {
+		_, err := tx.Exec("INSERT INTO time_frames (session_id, start_time, end_time, done, modified_at) VALUES (?, ?, ?, ?, ?)", tf.SessionID, tf.Start, tf.End, tf.Done, now)
+		if err != nil {
+			return err
+		}
+	}
+	for _, tf := range fix.Delete {
+		_, err := tx.Exec("DELETE FROM time_frames WHERE session_id = ? AND id = ?", tf.SessionID, tf.ID)
+		if err != nil {
+			return err
+		}
+		if err := Tombstone(tx, "time_frames", tf.ID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -5,8 +5,11 @@ import (
 	"database/sql"
 	"database/sql/driver"
 	"embed"
+	"errors"
 	"fmt"
 	"path/filepath"
+	"slices"
+	"sync/atomic"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -20,9 +23,17 @@ import (
 //go:embed migrations/*.sql
 var migrations embed.FS
 
+var (
+	ErrTimerRunning      = errors.New("session already has a running timeframe")
+	ErrTimerNotRunning   = errors.New("session has no running timeframe")
+	ErrInvalidTaskStatus = errors.New("invalid task status")
+)
+
 type Database struct {
 	DB        *sqlx.DB
 	notifyFns []UpdateHookFn
+	// indexed is set once the search index is rebuilt, after which new connections keep it current (see migrateSearch).
+	indexed atomic.Bool
 }
 
 type connector struct {
@@ -31,14 +42,15 @@ type connector struct {
 	dsn    string
 }
 
-func newConnector(dsn string, hook UpdateHookFn) *connector {
+// newConnector returns a connector that registers hook on every connection, and then calls onConnect.
+func newConnector(dsn string, hook UpdateHookFn, onConnect func(*sqlite3.SQLiteConn) error) *connector {
 	c := new(connector)
 	c.dsn = dsn
 	c.driver = &sqlite3.SQLiteDriver{
 		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
 			c.conns = append(c.conns, conn)
 			conn.RegisterUpdateHook(hook)
-			return nil
+			return onConnect(conn)
 		},
 	}
 	return c
@@ -64,7 +76,7 @@ func NewDatabase(dbPath string) (*Database, error) {
 	}
 
 	db := new(Database)
-	c := newConnector(dbPath, db.updateHook)
+	c := newConnector(dbPath, db.updateHook, db.connectSearch)
 	db_ := sql.OpenDB(c)
 	err := db_.Ping()
 	if err != nil {
@@ -82,15 +94,16 @@ func (d *Database) Migrate() error {
 	if err := goose.Up(d.DB.DB, "migrations"); err != nil {
 		return err
 	}
-	return nil
+	return d.migrateSearch()
 }
 
 func (d *Database) GetLatestSessions(limit, offset int) ([]data.Session, error) {
 	var sessions []data.Session
 	err := d.DB.Select(&sessions, `
 SELECT * FROM sessions
-ORDER BY (SELECT MAX(end_time) FROM time_frames WHERE session_id = sessions.id)
-DESC LIMIT ? OFFSET ?
+ORDER BY EXISTS (SELECT 1 FROM time_frames WHERE session_id = sessions.id AND end_time IS NULL) DESC,
+(SELECT MAX(end_time) FROM time_frames WHERE session_id = sessions.id) DESC
+LIMIT ? OFFSET ?
 `, limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("get sessions: %w", err)
@@ -103,6 +116,55 @@ DESC LIMIT ? OFFSET ?
 		}
 		sessions[i].Timeframes = timeframes
 	}
+	if err := LoadSessionTags(d.DB, sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// GetTimeframesBetween returns timeframes that overlap [from, to), ordered by start time.
+// Running timeframes are considered to end now.
+func (d *Database) GetTimeframesBetween(from, to time.Time) ([]data.Timeframe, error) {
+	// Timestamps are stored as text with the offset they were created with, so comparing them in SQL is only approximate.
+	// Select with a margin larger than any offset, and filter exactly below.
+	margin := 24 * time.Hour
+	var candidates []data.Timeframe
+	err := d.DB.Select(&candidates, "SELECT * FROM time_frames WHERE start_time < ? AND (end_time IS NULL OR end_time > ?) ORDER BY start_time", to.UTC().Add(margin), from.UTC().Add(-margin))
+	if err != nil {
+		return nil, err
+	}
+	timeframes := make([]data.Timeframe, 0, len(candidates))
+	for _, tf := range candidates {
+		if tf.Start.Before(to) && tf.EndOrNow().After(from) {
+			timeframes = append(timeframes, tf)
+		}
+	}
+	slices.SortFunc(timeframes, func(a, b data.Timeframe) int {
+		return a.Start.Compare(b.Start)
+	})
+	return timeframes, nil
+}
+
+// GetSessionsBetween returns sessions that have a timeframe overlapping [from, to), most recent first.
+func (d *Database) GetSessionsBetween(from, to time.Time) ([]data.Session, error) {
+	timeframes, err := d.GetTimeframesBetween(from, to)
+	if err != nil {
+		return nil, err
+	}
+	var sessions []data.Session
+	seen := map[string]struct{}{}
+	for i := len(timeframes) - 1; i >= 0; i-- {
+		id := timeframes[i].SessionID
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		seen[id] = struct{}{}
+		session, err := d.GetSession(id)
+		if err != nil {
+			return nil, fmt.Errorf("get session %s: %w", id, err)
+		}
+		sessions = append(sessions, session)
+	}
 	return sessions, nil
 }
 
@@ -118,12 +180,27 @@ func (d *Database) GetSession(id string) (data.Session, error) {
 		return data.Session{}, err
 	}
 	session.Timeframes = timeframes
-	return session, nil
+	sessions := []data.Session{session}
+	if err := LoadSessionTags(d.DB, sessions); err != nil {
+		return data.Session{}, err
+	}
+	return sessions[0], nil
 }
 
 func (d *Database) AddSession(session data.Session) (string, error) {
 	tx := d.DB.MustBegin()
-	res, err := tx.Exec("INSERT INTO sessions (description, notes, task_id) VALUES (?, ?, ?)", session.Description, session.Notes, session.TaskID)
+	defer tx.Rollback()
+	id, err := AddSession(tx, session)
+	if err != nil {
+		return "", err
+	}
+	return id, tx.Commit()
+}
+
+// AddSession adds a session with its timeframes and tags in tx, and returns its ID.
+func AddSession(tx *sqlx.Tx, session data.Session) (string, error) {
+	now := time.Now()
+	res, err := tx.Exec("INSERT INTO sessions (description, notes, task_id, modified_at) VALUES (?, ?, ?, ?)", session.Description, session.Notes, session.TaskID, now)
 	if err != nil {
 		return "", err
 	}
@@ -137,26 +214,49 @@ func (d *Database) AddSession(session data.Session) (string, error) {
 		return "", err
 	}
 	for _, tf := range session.Timeframes {
-		_, err := tx.Exec("INSERT INTO time_frames (session_id, start_time, end_time) VALUES (?, ?, ?)", id, tf.Start, tf.End)
+		_, err := tx.Exec("INSERT INTO time_frames (session_id, start_time, end_time, modified_at) VALUES (?, ?, ?, ?)", id, tf.Start, tf.End, now)
 		if err != nil {
 			return "", err
 		}
 	}
-	return id, tx.Commit()
+	if err := SetTags(tx, id, session.Tags); err != nil {
+		return "", err
+	}
+	return id, nil
 }
 
-func (d *Database) AddTimeframe(sessionID string, tf data.Timeframe) error {
+func (d *Database) AddTimeframe(sessionID string, tf data.Timeframe) (string, error) {
 	tx := d.DB.MustBegin()
-	_, err := tx.Exec("INSERT INTO time_frames (session_id, start_time, end_time) VALUES (?, ?, ?)", sessionID, tf.Start, tf.End)
+	defer tx.Rollback()
+	id, err := AddTimeframe(tx, sessionID, tf)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return tx.Commit()
+	return id, tx.Commit()
+}
+
+// AddTimeframe adds a timeframe to the session in tx, and returns its ID.
+func AddTimeframe(tx *sqlx.Tx, sessionID string, tf data.Timeframe) (string, error) {
+	res, err := tx.Exec("INSERT INTO time_frames (session_id, start_time, end_time, done, modified_at) VALUES (?, ?, ?, ?, ?)", sessionID, tf.Start, tf.End, tf.Done, time.Now())
+	if err != nil {
+		return "", err
+	}
+	rowid, err := res.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	var id string
+	err = tx.Get(&id, "SELECT id FROM time_frames WHERE rowid = ?", rowid)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
 }
 
 func (d *Database) EditTimeframe(sessionID, timeframeID string, tf data.Timeframe) error {
 	tx := d.DB.MustBegin()
-	_, err := tx.Exec("UPDATE time_frames SET start_time = ?, end_time = ? WHERE session_id = ? AND id = ?", tf.Start, tf.End, sessionID, timeframeID)
+	defer tx.Rollback()
+	_, err := tx.Exec("UPDATE time_frames SET start_time = ?, end_time = ?, done = ?, modified_at = ? WHERE session_id = ? AND id = ?", tf.Start, tf.End, tf.Done, time.Now(), sessionID, timeframeID)
 	if err != nil {
 		return err
 	}
@@ -165,61 +265,277 @@ func (d *Database) EditTimeframe(sessionID, timeframeID string, tf data.Timefram
 
 func (d *Database) DeleteTimeframe(sessionID, timeframeID string) error {
 	tx := d.DB.MustBegin()
+	defer tx.Rollback()
 	_, err := tx.Exec("DELETE FROM time_frames WHERE session_id = ? AND id = ?", sessionID, timeframeID)
 	if err != nil {
 		return err
 	}
+	if err := Tombstone(tx, "time_frames", timeframeID); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
 func (d *Database) ExtendSession(sessionID string, extendTo time.Time) error {
 	tx := d.DB.MustBegin()
-	_, err := tx.Exec("UPDATE time_frames SET end_time = ? WHERE session_id = ? AND end_time = (SELECT MAX(end_time) FROM time_frames WHERE session_id = ?)", extendTo, sessionID, sessionID)
+	_, err := tx.Exec("UPDATE time_frames SET end_time = ?, modified_at = ? WHERE session_id = ? AND end_time = (SELECT MAX(end_time) FROM time_frames WHERE session_id = ?)", extendTo, time.Now(), sessionID, sessionID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// StartTimer adds a running timeframe (i.e. one without an end time) starting now to the session.
+func (d *Database) StartTimer(sessionID string) error {
+	tx := d.DB.MustBegin()
+	defer tx.Rollback()
+	var running int
+	err := tx.Get(&running, "SELECT COUNT(*) FROM time_frames WHERE session_id = ? AND end_time IS NULL", sessionID)
+	if err != nil {
+		return err
+	}
+	if running > 0 {
+		return ErrTimerRunning
+	}
+	now := time.Now()
+	_, err = tx.Exec("INSERT INTO time_frames (session_id, start_time, end_time, modified_at) VALUES (?, ?, NULL, ?)", sessionID, now, now)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// StopTimer ends the running timeframes of the session now.
+func (d *Database) StopTimer(sessionID string) error {
+	tx := d.DB.MustBegin()
+	defer tx.Rollback()
+	now := time.Now()
+	res, err := tx.Exec("UPDATE time_frames SET end_time = ?, modified_at = ? WHERE session_id = ? AND end_time IS NULL", now, now, sessionID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if n == 0 {
+		return ErrTimerNotRunning
+	}
 	return tx.Commit()
 }
 
+// GetRunningTimeframes returns all timeframes that have not ended yet.
+func (d *Database) GetRunningTimeframes() ([]data.Timeframe, error) {
+	var timeframes []data.Timeframe
+	err := d.DB.Select(&timeframes, "SELECT * FROM time_frames WHERE end_time IS NULL ORDER BY start_time")
+	if err != nil {
+		return nil, err
+	}
+	return timeframes, nil
+}
+
 func (d *Database) EditSessionProperties(session data.Session) error {
 	tx := d.DB.MustBegin()
-	_, err := tx.Exec("UPDATE sessions SET description = ?, notes = ? WHERE id = ?", session.Description, session.Notes, session.ID)
+	_, err := tx.Exec("UPDATE sessions SET description = ?, notes = ?, task_id = ?, modified_at = ? WHERE id = ?", session.Description, session.Notes, session.TaskID, time.Now(), session.ID)
 	if err != nil {
 		return err
 	}
 	return tx.Commit()
 }
 
+// DeleteSession deletes the session and its timeframes.
 func (d *Database) DeleteSession(id string) error {
 	tx := d.DB.MustBegin()
-	_, err := tx.Exec("DELETE FROM sessions WHERE id = ?", id)
+	defer tx.Rollback()
+	var timeframeIDs []string
+	err := tx.Select(&timeframeIDs, "SELECT id FROM time_frames WHERE session_id = ?", id)
+	if err != nil {
+		return err
+	}
+	for _, timeframeID := range timeframeIDs {
+		if err := Tombstone(tx, "time_frames", timeframeID); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM time_frames WHERE session_id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM session_tags WHERE session_id = ?", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM sessions WHERE id = ?", id)
 	if err != nil {
 		return err
 	}
+	if err := Tombstone(tx, "sessions", id); err != nil {
+		return err
+	}
 	return tx.Commit()
 }
 
+// touchSession records that the session was edited now, for edits outside the sessions table (i.e. tags).
+func touchSession(tx *sqlx.Tx, id string) error {
+	_, err := tx.Exec("UPDATE sessions SET modified_at = ? WHERE id = ?", time.Now(), id)
+	return err
+}
+
+// Tombstone records that the row with the given id was deleted from table.
+// The row itself must be deleted by the caller.
+func Tombstone(tx *sqlx.Tx, table, id string) error {
+	_, err := tx.Exec("REPLACE INTO tombstones (id, table_name, deleted_at) VALUES (?, ?, ?)", id, table, time.Now())
+	return err
+}
+
+// Untombstone removes the tombstone for the row, e.g. when it is restored.
+func Untombstone(tx *sqlx.Tx, table, id string) error {
+	_, err := tx.Exec("DELETE FROM tombstones WHERE table_name = ? AND id = ?", table, id)
+	return err
+}
+
+func (d *Database) GetTombstones() ([]data.Tombstone, error) {
+	var tombstones []data.Tombstone
+	err := d.DB.Select(&tombstones, "SELECT * FROM tombstones")
+	if err != nil {
+		return nil, err
+	}
+	return tombstones, nil
+}
+
+// GetUndoneTasks returns open tasks, the ones due soonest first.
 func (d *Database) GetUndoneTasks() ([]data.Task, error) {
 	var tasks []data.Task
-	err := d.DB.Select(&tasks, `
-SELECT *
-FROM tasks
-WHERE id IN (SELECT task_id
-             FROM sessions
-						 WHERE id IN (SELECT session_id
-						              FROM time_frames
-													WHERE time_frames.done = FALSE))
-UNION ALL
-SELECT *
-FROM tasks
-WHERE id NOT IN (SELECT task_id FROM sessions WHERE task_id IS NOT NULL)
-`)
+	err := d.DB.Select(&tasks, "SELECT * FROM tasks WHERE status = ? ORDER BY due IS NULL, due, rowid", data.TaskStatusOpen)
 	if err != nil {
 		return nil, err
 	}
 	return tasks, nil
 }
 
+func (d *Database) GetTasks() ([]data.Task, error) {
+	var tasks []data.Task
+	err := d.DB.Select(&tasks, "SELECT * FROM tasks ORDER BY rowid")
+	if err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+func (d *Database) GetTask(id string) (data.Task, error) {
+	var task data.Task
+	err := d.DB.Get(&task, "SELECT * FROM tasks WHERE id = ?", id)
+	if err != nil {
+		return data.Task{}, err
+	}
+	return task, nil
+}
+
+// AddTask adds a task and returns its ID. An empty status means open.
+func (d *Database) AddTask(task data.Task) (string, error) {
+	tx := d.DB.MustBegin()
+	defer tx.Rollback()
+	id, err := AddTask(tx, task)
+	if err != nil {
+		return "", err
+	}
+	return id, tx.Commit()
+}
+
+// AddTask adds a task in tx and returns its ID. An empty status means open.
+func AddTask(tx *sqlx.Tx, task data.Task) (string, error) {
+	if task.Status == "" {
+		task.Status = data.TaskStatusOpen
+	}
+	if !task.Status.Valid() {
+		return "", ErrInvalidTaskStatus
+	}
+	res, err := tx.Exec("INSERT INTO tasks (description, status, due, estimate, modified_at) VALUES (?, ?, ?, ?, ?)", task.Description, task.Status, task.Due, task.Estimate, time.Now())
+	if err != nil {
+		return "", err
+	}
+	rowid, err := res.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	var id string
+	err = tx.Get(&id, "SELECT id FROM tasks WHERE rowid = ?", rowid)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (d *Database) EditTask(task data.Task) error {
+	if !task.Status.Valid() {
+		return ErrInvalidTaskStatus
+	}
+	tx := d.DB.MustBegin()
+	defer tx.Rollback()
+	_, err := tx.Exec("UPDATE tasks SET description = ?, status = ?, due = ?, estimate = ?, modified_at = ? WHERE id = ?", task.Description, task.Status, task.Due, task.Estimate, time.Now(), task.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetTaskStatus changes only the status of the task, e.g. to complete or archive it.
+func (d *Database) SetTaskStatus(id string, status data.TaskStatus) error {
+	if !status.Valid() {
+		return ErrInvalidTaskStatus
+	}
+	tx := d.DB.MustBegin()
+	defer tx.Rollback()
+	res, err := tx.Exec("UPDATE tasks SET status = ?, modified_at = ? WHERE id = ?", status, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// DeleteTask deletes the task. Sessions linked to the task are kept, but unlinked.
+func (d *Database) DeleteTask(id string) error {
+	tx := d.DB.MustBegin()
+	defer tx.Rollback()
+	_, err := tx.Exec("UPDATE sessions SET task_id = NULL, modified_at = ? WHERE task_id = ?", time.Now(), id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM tasks WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if err := Tombstone(tx, "tasks", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetSessionsByTask returns sessions linked to the task, most recently created first.
+func (d *Database) GetSessionsByTask(taskID string) ([]data.Session, error) {
+	var ids []string
+	err := d.DB.Select(&ids, "SELECT id FROM sessions WHERE task_id = ? ORDER BY rowid DESC", taskID)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]data.Session, 0, len(ids))
+	for _, id := range ids {
+		session, err := d.GetSession(id)
+		if err != nil {
+			return nil, fmt.Errorf("get session %s: %w", id, err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
+
 // UpdateHookFn is called when a database update is made.
 // op is one of SQLITE_INSERT, SQLITE_UPDATE, SQLITE_DELETE.
 // cf. sqlite3.SQLiteConn.RegisterUpdateHook
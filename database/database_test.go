@@ -0,0 +1,508 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"nyiyui.ca/jts/data"
+)
+
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+	db, err := NewDatabase(filepath.Join(t.TempDir(), "jts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+	return db
+}
+
+func TestTimer(t *testing.T) {
+	db := newTestDatabase(t)
+	id, err := db.AddSession(data.Session{Description: "learn Go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.StartTimer(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.StartTimer(id); !errors.Is(err, ErrTimerRunning) {
+		t.Fatalf("expected %v, got %v", ErrTimerRunning, err)
+	}
+	running, err := db.GetRunningTimeframes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(running) != 1 || running[0].SessionID != id || !running[0].Running() {
+		t.Fatalf("expected 1 running timeframe for %s, got %#v", id, running)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if d := running[0].Duration(); d <= 0 {
+		t.Fatalf("expected positive live duration, got %s", d)
+	}
+	if err := db.StopTimer(id); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.StopTimer(id); !errors.Is(err, ErrTimerNotRunning) {
+		t.Fatalf("expected %v, got %v", ErrTimerNotRunning, err)
+	}
+	session, err := db.GetSession(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(session.Timeframes) != 1 || session.Timeframes[0].Running() {
+		t.Fatalf("expected 1 stopped timeframe, got %#v", session.Timeframes)
+	}
+}
+
+func TestTaskStatus(t *testing.T) {
+	db := newTestDatabase(t)
+	due := time.Date(2025, 4, 1, 17, 0, 0, 0, time.UTC)
+	estimate := 2 * time.Hour
+	later, err := db.AddTask(data.Task{Description: "write report"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sooner, err := db.AddTask(data.Task{Description: "review PR", Due: &due, Estimate: &estimate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddTask(data.Task{Description: "x", Status: "someday"}); !errors.Is(err, ErrInvalidTaskStatus) {
+		t.Fatalf("expected %v, got %v", ErrInvalidTaskStatus, err)
+	}
+	tasks, err := db.GetUndoneTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 2 || tasks[0].ID != sooner || tasks[1].ID != later {
+		t.Fatalf("expected tasks with due dates first, got %#v", tasks)
+	}
+	if tasks[0].Status != data.TaskStatusOpen || !tasks[0].Due.Equal(due) || *tasks[0].Estimate != estimate {
+		t.Fatalf("unexpected task %#v", tasks[0])
+	}
+
+	seq, err := db.LatestSeq()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTaskStatus(sooner, data.TaskStatusDone); err != nil {
+		t.Fatal(err)
+	}
+	tasks, err = db.GetUndoneTasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != later {
+		t.Fatalf("expected only %s to be undone, got %#v", later, tasks)
+	}
+	entries, err := db.ChangelogSince(seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 change, got %d", len(entries))
+	}
+	old, err := entries[0].OldTask()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old.Status != data.TaskStatusOpen || old.Due == nil || !old.Due.Equal(due) || old.Estimate == nil || *old.Estimate != estimate {
+		t.Fatalf("unexpected old task %#v", old)
+	}
+}
+
+func TestTags(t *testing.T) {
+	db := newTestDatabase(t)
+	id, err := db.AddSession(data.Session{Description: "standup", Tags: []string{"meeting", " billable ", "meeting"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddSession(data.Session{Description: "lunch"}); err != nil {
+		t.Fatal(err)
+	}
+	session, err := db.GetSession(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(session.Tags, []string{"billable", "meeting"}) {
+		t.Fatalf("unexpected tags %v", session.Tags)
+	}
+	if err := db.TagSession(id, " "); !errors.Is(err, ErrEmptyTag) {
+		t.Fatalf("expected %v, got %v", ErrEmptyTag, err)
+	}
+
+	seq, err := db.LatestSeq()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UntagSession(id, "billable"); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := db.ChangelogSince(seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].TableName != "sessions" {
+		t.Fatalf("expected 1 session change, got %#v", entries)
+	}
+	old, err := entries[0].OldSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(old.Tags, []string{"billable", "meeting"}) {
+		t.Fatalf("expected old tags to include billable, got %v", old.Tags)
+	}
+	tagged, err := db.GetSession(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tagged.ModifiedAt == nil || session.ModifiedAt == nil || !tagged.ModifiedAt.After(*session.ModifiedAt) {
+		t.Fatalf("expected untagging to update the modification time, got %v and then %v", session.ModifiedAt, tagged.ModifiedAt)
+	}
+
+	sessions, err := db.GetSessionsByTag("meeting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != id {
+		t.Fatalf("expected only %s, got %#v", id, sessions)
+	}
+	tags, err := db.GetTags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(tags, []string{"meeting"}) {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+}
+
+func TestCheckTimeframes(t *testing.T) {
+	db := newTestDatabase(t)
+	at := func(hour, minute int) time.Time {
+		return time.Date(2025, 4, 1, hour, minute, 0, 0, time.UTC)
+	}
+	ptr := func(t time.Time) *time.Time { return &t }
+	// meeting overlaps coding by 30 minutes; lunch is inside coding; typo ends before it starts
+	coding, err := db.AddSession(data.Session{Description: "coding", Timeframes: []data.Timeframe{{Start: at(9, 0), End: ptr(at(13, 0))}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddSession(data.Session{Description: "meeting", Timeframes: []data.Timeframe{{Start: at(12, 30), End: ptr(at(14, 0))}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddSession(data.Session{Description: "lunch", Timeframes: []data.Timeframe{{Start: at(10, 0), End: ptr(at(11, 0))}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddSession(data.Session{Description: "typo", Timeframes: []data.Timeframe{{Start: at(18, 0), End: ptr(at(17, 0))}}}); err != nil {
+		t.Fatal(err)
+	}
+	problems, err := db.CheckTimeframes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []ProblemKind
+	var overlaps []time.Duration
+	for _, p := range problems {
+		kinds = append(kinds, p.Kind)
+		overlaps = append(overlaps, p.Overlap)
+	}
+	if !slices.Equal(kinds, []ProblemKind{ProblemNegativeLength, ProblemOverlap, ProblemOverlap}) {
+		t.Fatalf("unexpected problems %v", kinds)
+	}
+	if !slices.Equal(overlaps, []time.Duration{0, time.Hour, 30 * time.Minute}) {
+		t.Fatalf("unexpected overlaps %v", overlaps)
+	}
+	lunch := problems[1]
+	if lunch.Fixes[0].Kind != FixSplit || lunch.Timeframes[0].SessionID != coding {
+		t.Fatalf("expected to split coding around lunch, got %#v", lunch.Fixes)
+	}
+	if err := db.ApplyFix(lunch.Fixes[0]); err != nil {
+		t.Fatal(err)
+	}
+	session, err := db.GetSession(coding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(session.Timeframes) != 2 {
+		t.Fatalf("expected coding to be split, got %#v", session.Timeframes)
+	}
+
+	// fix the rest with the first proposed fix
+	for i := 0; i < 5; i++ {
+		problems, err = db.CheckTimeframes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) == 0 {
+			return
+		}
+		if err := db.ApplyFix(problems[0].Fixes[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Fatalf("problems left: %#v", problems)
+}
+
+func TestCheckTimeframesSameSession(t *testing.T) {
+	start := time.Date(2025, 4, 1, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	problems := checkTimeframes([]data.Timeframe{
+		{ID: "a", SessionID: "s", Start: start, End: &end},
+		{ID: "b", SessionID: "s", Start: start.Add(30 * time.Minute)},
+	}, start.Add(2*time.Hour))
+	if len(problems) != 1 || !problems[0].SameSession() || problems[0].Key() != "overlap:a,b" {
+		t.Fatalf("unexpected problems %#v", problems)
+	}
+	fix := problems[0].Fixes[0]
+	if fix.Kind != FixMerge || !fix.Update[0].Running() || fix.Delete[0].ID != "b" {
+		t.Fatalf("expected to merge into a running timeframe, got %#v", fix)
+	}
+}
+
+func TestSearchSessions(t *testing.T) {
+	db := newTestDatabase(t)
+	march := time.Date(2025, 3, 12, 14, 0, 0, 0, time.UTC)
+	marchEnd := march.Add(2 * time.Hour)
+	april := time.Date(2025, 4, 2, 9, 0, 0, 0, time.UTC)
+	aprilEnd := april.Add(time.Hour)
+	task, err := db.AddTask(data.Task{Description: "CI pipeline"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	debugging, err := db.AddSession(data.Session{
+		Description: "debugging",
+		Notes:       "The flaky test was a race in the watcher.\n\nFixed by waiting for the first event.",
+		TaskID:      &task,
+		Timeframes:  []data.Timeframe{{Start: march, End: &marchEnd}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	flaky, err := db.AddSession(data.Session{Description: "flaky test triage", Timeframes: []data.Timeframe{{Start: april, End: &aprilEnd}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(results []SearchResult) []string {
+		var ids []string
+		for _, r := range results {
+			ids = append(ids, r.Session.ID)
+		}
+		return ids
+	}
+	results, err := db.SearchSessions("flaky test", SearchRange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a match in the description ranks higher than one in the notes
+	if !slices.Equal(ids(results), []string{flaky, debugging}) {
+		t.Fatalf("unexpected results %v", ids(results))
+	}
+	if !strings.Contains(results[1].Snippet, HighlightStart+"flaky"+HighlightEnd) {
+		t.Errorf("expected flaky to be highlighted in %q", results[1].Snippet)
+	}
+	if parts := SplitHighlights(results[0].Description); len(parts) != 4 || !parts[0].Match || parts[0].Text != "flaky" {
+		t.Errorf("unexpected description parts %#v", parts)
+	}
+
+	results, err = db.SearchSessions("FLAKY", SearchRange{From: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), To: time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids(results), []string{debugging}) {
+		t.Fatalf("unexpected results in March %v", ids(results))
+	}
+
+	// the task description is searched too, and renaming the task updates the index
+	if err := db.EditTask(data.Task{ID: task, Description: "GitHub Actions", Status: data.TaskStatusOpen}); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{"actions", "CI"} {
+		results, err = db.SearchSessions(query, SearchRange{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{debugging}
+		if query == "CI" {
+			expected = nil
+		}
+		if !slices.Equal(ids(results), expected) {
+			t.Fatalf("%s: unexpected results %v", query, ids(results))
+		}
+	}
+
+	if err := db.DeleteSession(flaky); err != nil {
+		t.Fatal(err)
+	}
+	results, err = db.SearchSessions("triage", SearchRange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("deleted session found: %v", ids(results))
+	}
+}
+
+func TestSearchIndexRebuilt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jts.db")
+	open := func() *Database {
+		db, err := NewDatabase(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.DB.Close() })
+		return db
+	}
+	db := open()
+	if !db.indexed.Load() {
+		t.Skip("built without FTS5")
+	}
+	id, err := db.AddSession(data.Session{Description: "flaky test triage"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as if a build without FTS5 wrote to the database
+	if _, err := db.DB.Exec("DELETE FROM sessions_fts"); err != nil {
+		t.Fatal(err)
+	}
+	db.DB.Close()
+
+	db = open()
+	results, err := db.SearchSessions("triage", SearchRange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Session.ID != id {
+		t.Fatalf("expected the index to be rebuilt on open, got %#v", results)
+	}
+	var triggers int
+	if err := db.DB.Get(&triggers, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'sessions_fts_%'"); err != nil {
+		t.Fatal(err)
+	}
+	if triggers != 0 {
+		t.Fatalf("expected no search triggers in the database, got %d", triggers)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	db := newTestDatabase(t)
+	now := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	expires := now.Add(time.Hour)
+	id, err := db.AddAPIToken(APIToken{Hash: "h", Name: "laptop", Permissions: TokenPermissions{"database:sync", "database:view"}, CreatedAt: now, ExpiresAt: &expires})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := db.GetAPITokenByHash("h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.ID != id || token.Name != "laptop" || !slices.Equal(token.Permissions, TokenPermissions{"database:sync", "database:view"}) || !token.CreatedAt.Equal(now) {
+		t.Fatalf("unexpected token %#v", token)
+	}
+	if !token.Valid(now) || token.Valid(expires) {
+		t.Errorf("expected the token to be valid until it expires")
+	}
+	if err := db.TouchAPIToken(id, now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RevokeAPIToken(id, now.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.RevokeAPIToken(id, now.Add(3*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := db.GetAPITokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].LastUsedAt == nil || !tokens[0].LastUsedAt.Equal(now.Add(time.Minute)) || tokens[0].RevokedAt == nil || !tokens[0].RevokedAt.Equal(now.Add(2*time.Minute)) {
+		t.Fatalf("unexpected tokens %#v", tokens)
+	}
+	if tokens[0].Valid(now) {
+		t.Errorf("expected a revoked token to be invalid")
+	}
+	if err := db.RevokeAPIToken("missing", now); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected %v, got %v", sql.ErrNoRows, err)
+	}
+}
+
+func TestEncryptedRows(t *testing.T) {
+	db := newTestDatabase(t)
+	if _, err := db.EncryptionParams(); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected no params, got %v", err)
+	}
+	// synced in plaintext before encryption was set up
+	if _, err := db.AddSession(data.Session{Description: "plaintext", Tags: []string{"tag"}, Timeframes: []data.Timeframe{{}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddTask(data.Task{Description: "plaintext"}); err != nil {
+		t.Fatal(err)
+	}
+	plaintextSeq, err := db.LatestSeq()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetEncryptionParams([]byte(`{"Salt":"a"}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"sessions", "time_frames", "session_tags", "tasks", "changelog"} {
+		var n int
+		if err := db.DB.Get(&n, "SELECT COUNT(*) FROM "+table); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("expected the plaintext rows in %s to be removed, got %d", table, n)
+		}
+	}
+	if err := db.SetEncryptionParams([]byte(`{"Salt":"b"}`)); !errors.Is(err, ErrEncryptionParamsSet) {
+		t.Fatalf("expected %v, got %v", ErrEncryptionParamsSet, err)
+	}
+	if params, err := db.EncryptionParams(); err != nil || string(params) != `{"Salt":"a"}` {
+		t.Fatalf("expected the first params, got %s, %v", params, err)
+	}
+
+	// sequence numbers continue from the changelog
+	if _, err := db.AddSession(data.Session{Description: "plaintext"}); err != nil {
+		t.Fatal(err)
+	}
+	before, err := db.LatestSeq()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq1, err := db.PutEncryptedRows([]EncryptedRow{{TableName: "sessions", ID: "a", Blob: []byte{1}}, {TableName: "sessions", ID: "b", Blob: []byte{2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq1 <= before || seq1 <= plaintextSeq {
+		t.Fatalf("expected seq after %d and %d, got %d", before, plaintextSeq, seq1)
+	}
+	seq2, err := db.PutEncryptedRows([]EncryptedRow{{TableName: "sessions", ID: "a", Blob: []byte{3}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, latest, err := db.EncryptedRowsSince(seq1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest != seq2 || len(rows) != 1 || rows[0].ID != "a" || !slices.Equal(rows[0].Blob, []byte{3}) {
+		t.Fatalf("expected only the replaced row at seq %d, got %#v (latest %d)", seq2, rows, latest)
+	}
+	rows, _, err = db.EncryptedRowsSince(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("expected 2 rows, got %#v", rows)
+	}
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrEncryptionParamsSet is returned when setting the encryption parameters again.
+var ErrEncryptionParamsSet = errors.New("encryption parameters are already set")
+
+// EncryptedRow is a row synced with end-to-end encryption (see migration 011). Only clients can decrypt Blob.
+type EncryptedRow struct {
+	TableName string `db:"table_name"`
+	ID        string `db:"id"`
+	// Seq is the sequence number of the latest change to the row.
+	Seq  int64  `db:"seq"`
+	Blob []byte `db:"blob"`
+}
+
+// EncryptionParams returns the encryption parameters set by a client, or sql.ErrNoRows if no client synced with encryption yet.
+func (d *Database) EncryptionParams() ([]byte, error) {
+	var params []byte
+	err := d.DB.Get(&params, "SELECT params FROM encryption_params WHERE id = 1")
+	if err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// SetEncryptionParams sets the encryption parameters, which cannot be changed afterwards (ErrEncryptionParamsSet), as the rows are encrypted with a key derived using them.
+// The rows synced in plaintext before, and the changelog (which has old versions of them), are removed, so that they do not stay readable on the server.
+// The sequence number as of then is kept (see EncryptionResetSeq), so that clients that synced in plaintext upload their rows again.
+func (d *Database) SetEncryptionParams(params []byte) error {
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec("INSERT INTO encryption_params (id, params) VALUES (1, ?) ON CONFLICT (id) DO NOTHING", params)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrEncryptionParamsSet
+	}
+	// the changelog goes last, as removing the rows adds to it
+	for _, table := range []string{"session_tags", "time_frames", "sessions", "tasks", "changelog"} {
+		if _, err := tx.Exec("DELETE FROM " + table); err != nil {
+			return fmt.Errorf("remove plaintext %s: %w", table, err)
+		}
+	}
+	_, err = tx.Exec("UPDATE encryption_params SET reset_seq = (SELECT COALESCE(MAX(seq), 0) FROM sqlite_sequence WHERE name = 'changelog') WHERE id = 1")
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EncryptionResetSeq returns the sequence number as of which SetEncryptionParams removed the plaintext rows, or zero if encryption is not set up.
+func (d *Database) EncryptionResetSeq() (int64, error) {
+	var seq int64
+	err := d.DB.Get(&seq, "SELECT COALESCE(MAX(reset_seq), 0) FROM encryption_params")
+	return seq, err
+}
+
+// EncryptedRowsSince returns the encrypted rows changed after seq, and the latest sequence number of all encrypted rows (zero if there are none).
+func (d *Database) EncryptedRowsSince(seq int64) ([]EncryptedRow, int64, error) {
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+	var rows []EncryptedRow
+	err = tx.Select(&rows, "SELECT * FROM encrypted_rows WHERE seq > ? ORDER BY seq", seq)
+	if err != nil {
+		return nil, 0, err
+	}
+	var latest int64
+	err = tx.Get(&latest, "SELECT COALESCE(MAX(seq), 0) FROM encrypted_rows")
+	if err != nil {
+		return nil, 0, err
+	}
+	return rows, latest, nil
+}
+
+// PutEncryptedRows adds or replaces the rows, and returns their new sequence number.
+// Sequence numbers continue from the changelog (even after SetEncryptionParams emptied it), so that a client that synced without encryption before downloads every encrypted row.
+func (d *Database) PutEncryptedRows(rows []EncryptedRow) (int64, error) {
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var seq int64
+	err = tx.Get(&seq, "SELECT MAX((SELECT COALESCE(MAX(seq), 0) FROM encrypted_rows), (SELECT COALESCE(MAX(seq), 0) FROM sqlite_sequence WHERE name = 'changelog')) + 1")
+	if err != nil {
+		return 0, err
+	}
+	for _, row := range rows {
+		_, err = tx.Exec(`INSERT INTO encrypted_rows (table_name, id, seq, blob) VALUES (?, ?, ?, ?)
+ON CONFLICT (table_name, id) DO UPDATE SET seq = excluded.seq, blob = excluded.blob`, row.TableName, row.ID, seq, row.Blob)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return seq, tx.Commit()
+}
@@ -0,0 +1,30 @@
+-- +goose Up
+-- Note: goose already runs this in a transaction for us
+-- make end_time nullable; a NULL end_time means the timeframe is still running
+ALTER TABLE time_frames RENAME TO time_frames_old;
+CREATE TABLE time_frames (
+  rowid INTEGER PRIMARY KEY,
+  id TEXT UNIQUE DEFAULT (lower(hex(randomblob(16)))),
+  session_id TEXT NOT NULL,
+  start_time DATETIME NOT NULL, -- in Unix time
+  end_time DATETIME DEFAULT NULL, -- in Unix time, NULL if running
+  done BOOLEAN DEFAULT FALSE,
+  FOREIGN KEY(session_id) REFERENCES sessions(id)
+);
+INSERT INTO time_frames (id, session_id, start_time, end_time, done) SELECT id, session_id, start_time, end_time, done FROM time_frames_old;
+DROP TABLE time_frames_old;
+
+-- +goose Down
+UPDATE time_frames SET end_time = start_time WHERE end_time IS NULL;
+ALTER TABLE time_frames RENAME TO time_frames_old;
+CREATE TABLE time_frames (
+  rowid INTEGER PRIMARY KEY,
+  id TEXT UNIQUE DEFAULT (lower(hex(randomblob(16)))),
+  session_id TEXT NOT NULL,
+  start_time DATETIME NOT NULL, -- in Unix time
+  end_time DATETIME NOT NULL, -- in Unix time
+  done BOOLEAN DEFAULT FALSE,
+  FOREIGN KEY(session_id) REFERENCES sessions(id)
+);
+INSERT INTO time_frames (id, session_id, start_time, end_time, done) SELECT id, session_id, start_time, end_time, done FROM time_frames_old;
+DROP TABLE time_frames_old;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- tombstones record deleted rows so that deletions can be propagated when syncing
+CREATE TABLE tombstones (
+  rowid INTEGER PRIMARY KEY,
+  id TEXT NOT NULL, -- id of the deleted row
+  table_name TEXT NOT NULL, -- table of the deleted row
+  deleted_at DATETIME NOT NULL, -- in Unix time
+  UNIQUE(table_name, id)
+);
+
+-- +goose Down
+DROP TABLE tombstones;
@@ -0,0 +1,76 @@
+-- +goose Up
+-- changelog records every change to synced tables with a monotonically increasing sequence number.
+-- old is the row before the change as JSON (keys are data.* field names), or NULL if the row was inserted.
+CREATE TABLE changelog (
+  seq INTEGER PRIMARY KEY AUTOINCREMENT,
+  table_name TEXT NOT NULL,
+  id TEXT NOT NULL,
+  old TEXT DEFAULT NULL
+);
+CREATE INDEX changelog_table_name_id ON changelog (table_name, id);
+
+-- existing rows count as inserted
+INSERT INTO changelog (table_name, id) SELECT 'sessions', id FROM sessions;
+INSERT INTO changelog (table_name, id) SELECT 'time_frames', id FROM time_frames;
+INSERT INTO changelog (table_name, id) SELECT 'tasks', id FROM tasks;
+INSERT INTO changelog (table_name, id) SELECT table_name, id FROM tombstones;
+
+-- +goose StatementBegin
+CREATE TRIGGER sessions_changelog_insert AFTER INSERT ON sessions BEGIN
+  INSERT INTO changelog (table_name, id) VALUES ('sessions', NEW.id);
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER sessions_changelog_update AFTER UPDATE ON sessions BEGIN
+  INSERT INTO changelog (table_name, id, old) VALUES ('sessions', NEW.id, json_object('ID', OLD.id, 'Description', OLD.description, 'Notes', OLD.notes, 'TaskID', OLD.task_id));
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER sessions_changelog_delete AFTER DELETE ON sessions BEGIN
+  INSERT INTO changelog (table_name, id, old) VALUES ('sessions', OLD.id, json_object('ID', OLD.id, 'Description', OLD.description, 'Notes', OLD.notes, 'TaskID', OLD.task_id));
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER time_frames_changelog_insert AFTER INSERT ON time_frames BEGIN
+  INSERT INTO changelog (table_name, id) VALUES ('time_frames', NEW.id);
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER time_frames_changelog_update AFTER UPDATE ON time_frames BEGIN
+  INSERT INTO changelog (table_name, id, old) VALUES ('time_frames', NEW.id, json_object('ID', OLD.id, 'SessionID', OLD.session_id, 'Start', OLD.start_time, 'End', OLD.end_time, 'Done', OLD.done));
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER time_frames_changelog_delete AFTER DELETE ON time_frames BEGIN
+  INSERT INTO changelog (table_name, id, old) VALUES ('time_frames', OLD.id, json_object('ID', OLD.id, 'SessionID', OLD.session_id, 'Start', OLD.start_time, 'End', OLD.end_time, 'Done', OLD.done));
+END;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TRIGGER tasks_changelog_insert AFTER INSERT ON tasks BEGIN
+  INSERT INTO changelog (table_name, id) VALUES ('tasks', NEW.id);
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tasks_changelog_update AFTER UPDATE ON tasks BEGIN
+  INSERT INTO changelog (table_name, id, old) VALUES ('tasks', NEW.id, json_object('ID', OLD.id, 'Description', OLD.description));
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tasks_changelog_delete AFTER DELETE ON tasks BEGIN
+  INSERT INTO changelog (table_name, id, old) VALUES ('tasks', OLD.id, json_object('ID', OLD.id, 'Description', OLD.description));
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER sessions_changelog_insert;
+DROP TRIGGER sessions_changelog_update;
+DROP TRIGGER sessions_changelog_delete;
+DROP TRIGGER time_frames_changelog_insert;
+DROP TRIGGER time_frames_changelog_update;
+DROP TRIGGER time_frames_changelog_delete;
+DROP TRIGGER tasks_changelog_insert;
+DROP TRIGGER tasks_changelog_update;
+DROP TRIGGER tasks_changelog_delete;
+DROP TABLE changelog;
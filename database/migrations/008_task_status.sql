@@ -0,0 +1,43 @@
+-- +goose Up
+ALTER TABLE tasks ADD COLUMN status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'done', 'archived'));
+ALTER TABLE tasks ADD COLUMN due DATETIME DEFAULT NULL;
+ALTER TABLE tasks ADD COLUMN estimate INTEGER DEFAULT NULL; -- in nanoseconds (time.Duration)
+
+-- the changelog needs the new columns in old rows
+DROP TRIGGER tasks_changelog_update;
+DROP TRIGGER tasks_changelog_delete;
+-- +goose StatementBegin
+CREATE TRIGGER tasks_changelog_update AFTER UPDATE ON tasks BEGIN
+  INSERT INTO changelog (table_name, id, old) VALUES ('tasks', NEW.id, json_object('ID', OLD.id, 'Description', OLD.description, 'Status', OLD.status, 'Due', OLD.due, 'Estimate', OLD.estimate));
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tasks_changelog_delete AFTER DELETE ON tasks BEGIN
+  INSERT INTO changelog (table_name, id, old) VALUES ('tasks', OLD.id, json_object('ID', OLD.id, 'Description', OLD.description, 'Status', OLD.status, 'Due', OLD.due, 'Estimate', OLD.estimate));
+END;
+-- +goose StatementEnd
+
+-- tasks were considered done when all timeframes of their sessions were done
+UPDATE tasks SET status = 'done'
+WHERE id IN (SELECT task_id FROM sessions WHERE task_id IS NOT NULL)
+  AND id NOT IN (SELECT task_id
+                 FROM sessions
+                 WHERE id IN (SELECT session_id FROM time_frames WHERE done = FALSE)
+                   AND task_id IS NOT NULL);
+
+-- +goose Down
+DROP TRIGGER tasks_changelog_update;
+DROP TRIGGER tasks_changelog_delete;
+-- +goose StatementBegin
+CREATE TRIGGER tasks_changelog_update AFTER UPDATE ON tasks BEGIN
+  INSERT INTO changelog (table_name, id, old) VALUES ('tasks', NEW.id, json_object('ID', OLD.id, 'Description', OLD.description));
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER tasks_changelog_delete AFTER DELETE ON tasks BEGIN
+  INSERT INTO changelog (table_name, id, old) VALUES ('tasks', OLD.id, json_object('ID', OLD.id, 'Description', OLD.description));
+END;
+-- +goose StatementEnd
+ALTER TABLE tasks DROP COLUMN estimate;
+ALTER TABLE tasks DROP COLUMN due;
+ALTER TABLE tasks DROP COLUMN status;
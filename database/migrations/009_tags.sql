@@ -0,0 +1,66 @@
+-- +goose Up
+-- tags lists known tag names
+CREATE TABLE tags (
+  rowid INTEGER PRIMARY KEY,
+  name TEXT NOT NULL UNIQUE
+);
+-- session_tags links sessions and tags by name, so that the same tag created on different clients is the same tag
+CREATE TABLE session_tags (
+  rowid INTEGER PRIMARY KEY,
+  session_id TEXT NOT NULL,
+  tag TEXT NOT NULL,
+  UNIQUE(session_id, tag),
+  FOREIGN KEY(session_id) REFERENCES sessions(id),
+  FOREIGN KEY(tag) REFERENCES tags(name)
+);
+CREATE INDEX session_tags_tag ON session_tags (tag);
+
+-- tags are synced as part of sessions, so the changelog records tag changes as session changes (with Tags in old rows)
+DROP TRIGGER sessions_changelog_update;
+DROP TRIGGER sessions_changelog_delete;
+-- +goose StatementBegin
+CREATE TRIGGER sessions_changelog_update AFTER UPDATE ON sessions BEGIN
+  INSERT INTO changelog (table_name, id, old) VALUES ('sessions', NEW.id, json_object('ID', OLD.id, 'Description', OLD.description, 'Notes', OLD.notes, 'TaskID', OLD.task_id,
+    'Tags', json((SELECT json_group_array(tag) FROM (SELECT tag FROM session_tags WHERE session_id = OLD.id ORDER BY tag)))));
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER sessions_changelog_delete AFTER DELETE ON sessions BEGIN
+  INSERT INTO changelog (table_name, id, old) VALUES ('sessions', OLD.id, json_object('ID', OLD.id, 'Description', OLD.description, 'Notes', OLD.notes, 'TaskID', OLD.task_id,
+    'Tags', json((SELECT json_group_array(tag) FROM (SELECT tag FROM session_tags WHERE session_id = OLD.id ORDER BY tag)))));
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER session_tags_changelog_insert AFTER INSERT ON session_tags BEGIN
+  INSERT INTO changelog (table_name, id, old)
+  SELECT 'sessions', id, json_object('ID', id, 'Description', description, 'Notes', notes, 'TaskID', task_id,
+    'Tags', json((SELECT json_group_array(tag) FROM (SELECT tag FROM session_tags WHERE session_id = NEW.session_id AND tag != NEW.tag ORDER BY tag))))
+  FROM sessions WHERE id = NEW.session_id;
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER session_tags_changelog_delete AFTER DELETE ON session_tags BEGIN
+  INSERT INTO changelog (table_name, id, old)
+  SELECT 'sessions', id, json_object('ID', id, 'Description', description, 'Notes', notes, 'TaskID', task_id,
+    'Tags', json((SELECT json_group_array(tag) FROM (SELECT tag FROM session_tags WHERE session_id = OLD.session_id UNION SELECT OLD.tag ORDER BY tag))))
+  FROM sessions WHERE id = OLD.session_id;
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER session_tags_changelog_insert;
+DROP TRIGGER session_tags_changelog_delete;
+DROP TRIGGER sessions_changelog_update;
+DROP TRIGGER sessions_changelog_delete;
+-- +goose StatementBegin
+CREATE TRIGGER sessions_changelog_update AFTER UPDATE ON sessions BEGIN
+  INSERT INTO changelog (table_name, id, old) VALUES ('sessions', NEW.id, json_object('ID', OLD.id, 'Description', OLD.description, 'Notes', OLD.notes, 'TaskID', OLD.task_id));
+END;
+-- +goose StatementEnd
+-- +goose StatementBegin
+CREATE TRIGGER sessions_changelog_delete AFTER DELETE ON sessions BEGIN
+  INSERT INTO changelog (table_name, id, old) VALUES ('sessions', OLD.id, json_object('ID', OLD.id, 'Description', OLD.description, 'Notes', OLD.notes, 'TaskID', OLD.task_id));
+END;
+-- +goose StatementEnd
+DROP TABLE session_tags;
+DROP TABLE tags;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- api_tokens are API tokens issued by the server. They are not synced, and only the hash of each token is stored.
+CREATE TABLE api_tokens (
+  rowid INTEGER PRIMARY KEY,
+  id TEXT UNIQUE DEFAULT (lower(hex(randomblob(8)))),
+  hash TEXT NOT NULL UNIQUE,
+  name TEXT NOT NULL,
+  permissions TEXT NOT NULL DEFAULT '', -- space-separated
+  created_at DATETIME NOT NULL,
+  last_used_at DATETIME DEFAULT NULL,
+  expires_at DATETIME DEFAULT NULL,
+  revoked_at DATETIME DEFAULT NULL
+);
+
+-- +goose Down
+DROP TABLE api_tokens;
@@ -0,0 +1,22 @@
+-- +goose Up
+-- encrypted_rows are rows synced with end-to-end encryption. The server cannot read them: blob is a change to the row encrypted by a client.
+CREATE TABLE encrypted_rows (
+  table_name TEXT NOT NULL,
+  id TEXT NOT NULL,
+  seq INTEGER NOT NULL, -- sequence number of the latest change to the row, continuing from the changelog
+  blob BLOB NOT NULL,
+  PRIMARY KEY (table_name, id)
+);
+CREATE INDEX encrypted_rows_seq ON encrypted_rows (seq);
+
+-- encryption_params is what clients need to derive the key from their passphrase (e.g. the salt).
+-- It is set by the first client to sync with encryption, and is opaque to the server.
+CREATE TABLE encryption_params (
+  id INTEGER PRIMARY KEY CHECK (id = 1),
+  params BLOB NOT NULL
+);
+
+-- +goose Down
+DROP TABLE encryption_params;
+DROP INDEX encrypted_rows_seq;
+DROP TABLE encrypted_rows;
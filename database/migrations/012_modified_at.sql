@@ -0,0 +1,28 @@
+-- +goose Up
+-- modified_at is when the row was last edited, on whichever device edited it, for latest-edit-wins conflict resolution.
+-- It is set by the database package on local edits, and copied as is when syncing. NULL for rows edited before this migration.
+ALTER TABLE sessions ADD COLUMN modified_at DATETIME DEFAULT NULL;
+ALTER TABLE time_frames ADD COLUMN modified_at DATETIME DEFAULT NULL;
+ALTER TABLE tasks ADD COLUMN modified_at DATETIME DEFAULT NULL;
+
+-- editing tags also sets modified_at of the session, which must not be a second change in the changelog (session_tags triggers record the tag change)
+DROP TRIGGER sessions_changelog_update;
+-- +goose StatementBegin
+CREATE TRIGGER sessions_changelog_update AFTER UPDATE ON sessions
+WHEN NEW.description IS NOT OLD.description OR NEW.notes IS NOT OLD.notes OR NEW.task_id IS NOT OLD.task_id OR NEW.modified_at IS OLD.modified_at BEGIN
+  INSERT INTO changelog (table_name, id, old) VALUES ('sessions', NEW.id, json_object('ID', OLD.id, 'Description', OLD.description, 'Notes', OLD.notes, 'TaskID', OLD.task_id,
+    'Tags', json((SELECT json_group_array(tag) FROM (SELECT tag FROM session_tags WHERE session_id = OLD.id ORDER BY tag)))));
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TRIGGER sessions_changelog_update;
+-- +goose StatementBegin
+CREATE TRIGGER sessions_changelog_update AFTER UPDATE ON sessions BEGIN
+  INSERT INTO changelog (table_name, id, old) VALUES ('sessions', NEW.id, json_object('ID', OLD.id, 'Description', OLD.description, 'Notes', OLD.notes, 'TaskID', OLD.task_id,
+    'Tags', json((SELECT json_group_array(tag) FROM (SELECT tag FROM session_tags WHERE session_id = OLD.id ORDER BY tag)))));
+END;
+-- +goose StatementEnd
+ALTER TABLE tasks DROP COLUMN modified_at;
+ALTER TABLE time_frames DROP COLUMN modified_at;
+ALTER TABLE sessions DROP COLUMN modified_at;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- reset_seq is the sequence number as of which the plaintext rows were removed when encryption was set up.
+-- Clients that last synced at or before it upload the rows that the encrypted rows lack.
+ALTER TABLE encryption_params ADD COLUMN reset_seq INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE encryption_params DROP COLUMN reset_seq;
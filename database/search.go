@@ -0,0 +1,383 @@
+package database
+
+import (
+	"embed"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pressly/goose/v3"
+	"nyiyui.ca/jts/data"
+)
+
+// The search index uses FTS5, which go-sqlite3 only includes when built with the sqlite_fts5 tag.
+// A database can be used by builds with and without FTS5, so the index stays out of the tables both write to:
+// a build with FTS5 rebuilds the index whenever it opens the database (catching up with writes by builds without FTS5),
+// and keeps it current with TEMP triggers on its own connections (see connectSearch), which other builds never see.
+// Its migrations are kept apart (with their own version table).
+//
+//go:embed search_migrations/*.sql
+var searchMigrations embed.FS
+
+const searchVersionTable = "goose_search_version"
+
+// searchTriggers keep the search index current on one connection.
+// REPLACE INTO does not run delete triggers, so inserting also removes any row left for the same session.
+const searchTriggers = `
+CREATE TEMP TRIGGER IF NOT EXISTS sessions_fts_insert AFTER INSERT ON sessions BEGIN
+  DELETE FROM sessions_fts WHERE session_id = NEW.id;
+  INSERT INTO sessions_fts (session_id, description, notes, task)
+  VALUES (NEW.id, NEW.description, COALESCE(NEW.notes, ''), COALESCE((SELECT description FROM tasks WHERE id = NEW.task_id), ''));
+END;
+CREATE TEMP TRIGGER IF NOT EXISTS sessions_fts_update AFTER UPDATE ON sessions BEGIN
+  DELETE FROM sessions_fts WHERE session_id = OLD.id;
+  INSERT INTO sessions_fts (session_id, description, notes, task)
+  VALUES (NEW.id, NEW.description, COALESCE(NEW.notes, ''), COALESCE((SELECT description FROM tasks WHERE id = NEW.task_id), ''));
+END;
+CREATE TEMP TRIGGER IF NOT EXISTS sessions_fts_delete AFTER DELETE ON sessions BEGIN
+  DELETE FROM sessions_fts WHERE session_id = OLD.id;
+END;
+CREATE TEMP TRIGGER IF NOT EXISTS sessions_fts_task_insert AFTER INSERT ON tasks BEGIN
+  UPDATE sessions_fts SET task = NEW.description WHERE session_id IN (SELECT id FROM sessions WHERE task_id = NEW.id);
+END;
+CREATE TEMP TRIGGER IF NOT EXISTS sessions_fts_task_update AFTER UPDATE OF description ON tasks BEGIN
+  UPDATE sessions_fts SET task = NEW.description WHERE session_id IN (SELECT id FROM sessions WHERE task_id = NEW.id);
+END;
+CREATE TEMP TRIGGER IF NOT EXISTS sessions_fts_task_delete AFTER DELETE ON tasks BEGIN
+  UPDATE sessions_fts SET task = '' WHERE session_id IN (SELECT id FROM sessions WHERE task_id = OLD.id);
+END;
+`
+
+// dropSearchTriggers drops the triggers that earlier versions of the index added to the database itself, which made every write fail without FTS5.
+const dropSearchTriggers = `
+DROP TRIGGER IF EXISTS main.sessions_fts_insert;
+DROP TRIGGER IF EXISTS main.sessions_fts_update;
+DROP TRIGGER IF EXISTS main.sessions_fts_delete;
+DROP TRIGGER IF EXISTS main.sessions_fts_task_insert;
+DROP TRIGGER IF EXISTS main.sessions_fts_task_update;
+DROP TRIGGER IF EXISTS main.sessions_fts_task_delete;
+`
+
+// migrateSearch creates or updates the search index and rebuilds it if FTS5 is available.
+func (d *Database) migrateSearch() error {
+	if _, err := d.DB.Exec(dropSearchTriggers); err != nil {
+		return err
+	}
+	available, err := d.fts5Available()
+	if err != nil || !available {
+		return err
+	}
+	goose.SetBaseFS(searchMigrations)
+	goose.SetTableName(searchVersionTable)
+	defer goose.SetTableName(goose.DefaultTablename)
+	if err := goose.Up(d.DB.DB, "search_migrations"); err != nil {
+		return err
+	}
+	if err := d.rebuildSearchIndex(); err != nil {
+		return fmt.Errorf("rebuild search index: %w", err)
+	}
+	d.indexed.Store(true)
+	// close the idle connections, which were opened without the triggers, so that every connection from now on has them
+	d.DB.SetMaxIdleConns(-1)
+	d.DB.SetMaxIdleConns(2)
+	return nil
+}
+
+func (d *Database) rebuildSearchIndex() error {
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec("DELETE FROM sessions_fts")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO sessions_fts (session_id, description, notes, task)
+SELECT id, description, COALESCE(notes, ''), COALESCE((SELECT description FROM tasks WHERE id = task_id), '') FROM sessions`)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// connectSearch adds the triggers that keep the search index current to a new connection, once the index is rebuilt.
+func (d *Database) connectSearch(conn *sqlite3.SQLiteConn) error {
+	if !d.indexed.Load() {
+		return nil
+	}
+	_, err := conn.Exec(searchTriggers, nil)
+	return err
+}
+
+func (d *Database) fts5Available() (bool, error) {
+	var available bool
+	err := d.DB.Get(&available, "SELECT sqlite_compileoption_used('ENABLE_FTS5')")
+	return available, err
+}
+
+// Snippets mark matches with these, as they do not appear in text typed by users.
+const (
+	HighlightStart = "\x02"
+	HighlightEnd   = "\x03"
+)
+
+// SearchResult is a session matching a search.
+type SearchResult struct {
+	Session data.Session
+	// Description is the description of the session with matches highlighted.
+	Description string
+	// Snippet is the part of the description, notes or task description that matches best, with matches highlighted.
+	// Use SplitHighlights to display it.
+	Snippet string
+	// Rank is how well the session matches. Lower is better.
+	Rank float64
+}
+
+// SearchRange limits a search to sessions with a timeframe overlapping [From, To). Zero values are unbounded.
+type SearchRange struct {
+	From, To time.Time
+}
+
+func (r SearchRange) includes(session data.Session) bool {
+	if r.From.IsZero() && r.To.IsZero() {
+		return true
+	}
+	for _, tf := range session.Timeframes {
+		if (r.To.IsZero() || tf.Start.Before(r.To)) && (r.From.IsZero() || tf.EndOrNow().After(r.From)) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchTerms splits a query into terms, which must all match (in any field).
+func searchTerms(query string) []string {
+	return strings.Fields(query)
+}
+
+// SearchSessions returns the sessions whose description, notes or task description contain all words in query, best match first.
+// Without FTS5 (see migrateSearch), or with words shorter than three characters, which the index cannot find,
+// sessions are scanned instead.
+func (d *Database) SearchSessions(query string, r SearchRange) ([]SearchResult, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	indexed := d.indexed.Load()
+	short := slices.ContainsFunc(terms, func(term string) bool {
+		return utf8.RuneCountInString(term) < 3
+	})
+	var results []SearchResult
+	var err error
+	if indexed && !short {
+		results, err = d.searchIndex(terms)
+	} else {
+		results, err = d.searchScan(terms)
+	}
+	if err != nil {
+		return nil, err
+	}
+	filtered := results[:0]
+	for _, result := range results {
+		if r.includes(result.Session) {
+			filtered = append(filtered, result)
+		}
+	}
+	return filtered, nil
+}
+
+func (d *Database) searchIndex(terms []string) ([]SearchResult, error) {
+	// quote each term, so that characters like - and * are not FTS5 syntax
+	phrases := make([]string, len(terms))
+	for i, term := range terms {
+		phrases[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	var rows []struct {
+		SessionID   string  `db:"session_id"`
+		Description string  `db:"description"`
+		Snippet     string  `db:"snippet"`
+		Rank        float64 `db:"rank"`
+	}
+	// with the trigram tokenizer, snippet counts tokens about per character, so use the maximum of 64
+	err := d.DB.Select(&rows, `
+SELECT session_id,
+  highlight(sessions_fts, 1, ?, ?) AS description,
+  snippet(sessions_fts, -1, ?, ?, '…', 64) AS snippet,
+  bm25(sessions_fts, 0, 10, 5, 2) AS rank
+FROM sessions_fts
+WHERE sessions_fts MATCH ?
+ORDER BY rank
+`, HighlightStart, HighlightEnd, HighlightStart, HighlightEnd, strings.Join(phrases, " "))
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	results := make([]SearchResult, len(rows))
+	for i, row := range rows {
+		session, err := d.GetSession(row.SessionID)
+		if err != nil {
+			return nil, fmt.Errorf("get session %s: %w", row.SessionID, err)
+		}
+		results[i] = SearchResult{Session: session, Description: row.Description, Snippet: row.Snippet, Rank: row.Rank}
+	}
+	return results, nil
+}
+
+// searchScan searches without the index, ranking by where and how often the terms appear like searchIndex roughly does.
+func (d *Database) searchScan(terms []string) ([]SearchResult, error) {
+	var rows []struct {
+		ID   string `db:"id"`
+		Task string `db:"task"`
+	}
+	err := d.DB.Select(&rows, "SELECT id, COALESCE((SELECT description FROM tasks WHERE id = task_id), '') AS task FROM sessions")
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	var results []SearchResult
+	for _, row := range rows {
+		session, err := d.GetSession(row.ID)
+		if err != nil {
+			return nil, fmt.Errorf("get session %s: %w", row.ID, err)
+		}
+		fields := []string{session.Description, session.Notes, row.Task}
+		weights := []float64{10, 5, 2}
+		var score float64
+		matchesAll := true
+		for _, term := range terms {
+			found := false
+			for i, field := range fields {
+				n := strings.Count(strings.ToLower(field), strings.ToLower(term))
+				score += weights[i] * float64(n)
+				found = found || n > 0
+			}
+			matchesAll = matchesAll && found
+		}
+		if !matchesAll {
+			continue
+		}
+		snippet := ""
+		for _, field := range fields {
+			if snippet = scanSnippet(field, terms); snippet != "" {
+				break
+			}
+		}
+		results = append(results, SearchResult{
+			Session:     session,
+			Description: highlightTerms(session.Description, terms),
+			Snippet:     snippet,
+			Rank:        -score,
+		})
+	}
+	slices.SortStableFunc(results, func(a, b SearchResult) int {
+		switch {
+		case a.Rank < b.Rank:
+			return -1
+		case a.Rank > b.Rank:
+			return 1
+		}
+		return 0
+	})
+	return results, nil
+}
+
+// highlightTerms marks the terms in s, ignoring case.
+func highlightTerms(s string, terms []string) string {
+	lower := strings.ToLower(s)
+	if len(lower) != len(s) {
+		// byte offsets in lower would not be the same in s
+		return s
+	}
+	// marked[i] is set if the byte at i is part of a match
+	marked := make([]bool, len(s))
+	for _, term := range terms {
+		term = strings.ToLower(term)
+		for i := 0; ; {
+			j := strings.Index(lower[i:], term)
+			if j == -1 {
+				break
+			}
+			for k := i + j; k < i+j+len(term); k++ {
+				marked[k] = true
+			}
+			i += j + len(term)
+		}
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if marked[i] && (i == 0 || !marked[i-1]) {
+			b.WriteString(HighlightStart)
+		}
+		if !marked[i] && i > 0 && marked[i-1] {
+			b.WriteString(HighlightEnd)
+		}
+		b.WriteByte(s[i])
+	}
+	if len(s) > 0 && marked[len(s)-1] {
+		b.WriteString(HighlightEnd)
+	}
+	return b.String()
+}
+
+// scanSnippet returns the text around the first match of a term in s with the terms highlighted, or "" if no term matches.
+func scanSnippet(s string, terms []string) string {
+	lower := strings.ToLower(s)
+	first := -1
+	for _, term := range terms {
+		if i := strings.Index(lower, strings.ToLower(term)); i != -1 && (first == -1 || i < first) {
+			first = i
+		}
+	}
+	if first == -1 || len(lower) != len(s) {
+		return ""
+	}
+	const context = 40
+	start, end := max(first-context, 0), min(first+context*2, len(s))
+	// do not cut runes in half
+	for start > 0 && !utf8.RuneStart(s[start]) {
+		start--
+	}
+	for end < len(s) && !utf8.RuneStart(s[end]) {
+		end++
+	}
+	snippet := highlightTerms(s[start:end], terms)
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if end < len(s) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+// SnippetPart is a part of a highlighted text.
+type SnippetPart struct {
+	Text string
+	// Match is set if the part matches the search.
+	Match bool
+}
+
+// SplitHighlights splits a text highlighted by SearchSessions into parts, so that the matches can be displayed differently.
+func SplitHighlights(s string) []SnippetPart {
+	var parts []SnippetPart
+	for s != "" {
+		start := strings.Index(s, HighlightStart)
+		if start == -1 {
+			parts = append(parts, SnippetPart{Text: s})
+			break
+		}
+		if start > 0 {
+			parts = append(parts, SnippetPart{Text: s[:start]})
+		}
+		s = s[start+len(HighlightStart):]
+		end := strings.Index(s, HighlightEnd)
+		if end == -1 {
+			end = len(s)
+		}
+		parts = append(parts, SnippetPart{Text: s[:end], Match: true})
+		s = strings.TrimPrefix(s[end:], HighlightEnd)
+	}
+	return parts
+}
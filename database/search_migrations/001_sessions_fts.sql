@@ -0,0 +1,10 @@
+-- +goose Up
+-- sessions_fts indexes the description and notes of sessions, and the description of their task.
+-- The trigram tokenizer matches substrings, which also works for text without spaces between words (e.g. Japanese).
+-- It is kept current by TEMP triggers instead of triggers in the database (see searchTriggers).
+CREATE VIRTUAL TABLE sessions_fts USING fts5(session_id UNINDEXED, description, notes, task, tokenize = 'trigram');
+INSERT INTO sessions_fts (session_id, description, notes, task)
+SELECT id, description, COALESCE(notes, ''), COALESCE((SELECT description FROM tasks WHERE id = task_id), '') FROM sessions;
+
+-- +goose Down
+DROP TABLE sessions_fts;
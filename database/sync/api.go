@@ -4,12 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
-	"sync"
+	"time"
 
 	"nyiyui.ca/jts/database"
 	"nyiyui.ca/jts/tokens"
@@ -31,12 +32,17 @@ func (e ErrResolverError) Unwrap() error {
 	return e.err
 }
 
+// ServerClient is a Transport to the jts server.
 type ServerClient struct {
 	client  *http.Client
 	baseURL *url.URL
 	token   tokens.Token
+	// MergeOptions are used to merge local and remote changes in SyncDatabase.
+	MergeOptions MergeOptions
 }
 
+var _ Transport = (*ServerClient)(nil)
+
 func NewServerClient(client *http.Client, baseURL *url.URL, token tokens.Token) *ServerClient {
 	return &ServerClient{
 		client:  client,
@@ -45,7 +51,31 @@ func NewServerClient(client *http.Client, baseURL *url.URL, token tokens.Token)
 	}
 }
 
-func (sc *ServerClient) lock(ctx context.Context) error {
+// Lease is a lock on the server's database that expires unless renewed.
+type Lease struct {
+	ID        string
+	ExpiresAt time.Time
+}
+
+// LeaseRequest is the request body for renewing or releasing a lease.
+type LeaseRequest struct {
+	LeaseID string
+}
+
+// LeaseIDHeader is the header with the ID of the lease held while uploading changes. The server rejects uploads without the current lease.
+const LeaseIDHeader = "X-Lease-ID"
+
+// leaseIDKey is the context key for the ID of the lease held (see Lock).
+type leaseIDKey struct{}
+
+// setLeaseID sets the header with the lease ID in ctx, if any.
+func setLeaseID(ctx context.Context, req *http.Request) {
+	if leaseID, ok := ctx.Value(leaseIDKey{}).(string); ok {
+		req.Header.Set(LeaseIDHeader, leaseID)
+	}
+}
+
+func (sc *ServerClient) lock(ctx context.Context) (Lease, error) {
 	url := sc.baseURL.JoinPath("/lock")
 	req, err := http.NewRequestWithContext(ctx, "POST", url.String(), nil)
 	if err != nil {
@@ -54,26 +84,119 @@ func (sc *ServerClient) lock(ctx context.Context) error {
 	req.Header.Set("X-API-Token", sc.token.String())
 	resp, err := sc.client.Do(req)
 	if err != nil {
-		return err
+		return Lease{}, err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to lock (status code %d): %s", resp.StatusCode, string(body))
+		return Lease{}, fmt.Errorf("failed to lock (status code %d): %s", resp.StatusCode, string(body))
 	}
-	return nil
+	var lease Lease
+	err = json.NewDecoder(resp.Body).Decode(&lease)
+	if err != nil {
+		return Lease{}, fmt.Errorf("decode lease: %w", err)
+	}
+	return lease, nil
 }
 
-func (sc *ServerClient) unlock(ctx context.Context) error {
+func (sc *ServerClient) renew(ctx context.Context, leaseID string) (Lease, error) {
+	url := sc.baseURL.JoinPath("/lock/renew")
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(LeaseRequest{LeaseID: leaseID})
+	if err != nil {
+		return Lease{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url.String(), buf)
+	if err != nil {
+		panic(err)
+	}
+	req.Header.Set("X-API-Token", sc.token.String())
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := sc.client.Do(req)
+	if err != nil {
+		return Lease{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return Lease{}, fmt.Errorf("failed to renew lock (status code %d): %s", resp.StatusCode, string(body))
+	}
+	var lease Lease
+	err = json.NewDecoder(resp.Body).Decode(&lease)
+	if err != nil {
+		return Lease{}, fmt.Errorf("decode lease: %w", err)
+	}
+	return lease, nil
+}
+
+// keepRenewed renews the lease until ctx is done, e.g. while the user is resolving conflicts.
+// It returns an error wrapping ErrLockLost if the lease expired before it could be renewed.
+func (sc *ServerClient) keepRenewed(ctx context.Context, lease Lease) error {
+	for {
+		// renew when a third of the lease is left, to leave room for retries
+		wait := time.Until(lease.ExpiresAt) * 2 / 3
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(wait):
+		}
+		lease2, err := sc.renew(ctx, lease.ID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("renew lease: %s", err)
+			if time.Now().After(lease.ExpiresAt) {
+				return fmt.Errorf("%w: renew lease: %w", ErrLockLost, err)
+			}
+			// retry
+			lease.ExpiresAt = time.Now().Add(time.Until(lease.ExpiresAt) / 2)
+			continue
+		}
+		lease = lease2
+	}
+}
+
+// Lock implements Transport by taking a lease on the server's database, which is renewed until unlock is called.
+// The lease ID is sent with uploads made with the locked context.
+func (sc *ServerClient) Lock(ctx context.Context) (context.Context, func(context.Context) error, error) {
+	lease, err := sc.lock(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	locked, lose := context.WithCancelCause(context.WithValue(ctx, leaseIDKey{}, lease.ID))
+	renewCtx, stopRenew := context.WithCancel(locked)
+	go func() {
+		if err := sc.keepRenewed(renewCtx, lease); err != nil {
+			log.Printf("lease lost: %s", err)
+			lose(err)
+		}
+	}()
+	return locked, func(ctx context.Context) error {
+		stopRenew()
+		lose(nil)
+		return sc.unlock(ctx, lease.ID)
+	}, nil
+}
+
+func (sc *ServerClient) unlock(ctx context.Context, leaseID string) error {
 	url := sc.baseURL.JoinPath("/unlock")
-	req, err := http.NewRequestWithContext(ctx, "POST", url.String(), nil)
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(LeaseRequest{LeaseID: leaseID})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url.String(), buf)
 	if err != nil {
 		panic(err)
 	}
 	req.Header.Set("X-API-Token", sc.token.String())
+	req.Header.Set("Content-Type", "application/json")
 	resp, err := sc.client.Do(req)
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
 		return fmt.Errorf("failed to unlock (status code %d): %s", resp.StatusCode, string(body))
@@ -81,8 +204,10 @@ func (sc *ServerClient) unlock(ctx context.Context) error {
 	return nil
 }
 
-func (sc *ServerClient) download(ctx context.Context) (ExportedDatabase, error) {
-	url := sc.baseURL.JoinPath("/database")
+// DownloadDelta implements Transport.
+func (sc *ServerClient) DownloadDelta(ctx context.Context, since int64) (Delta, error) {
+	url := sc.baseURL.JoinPath("/database/changes")
+	url.RawQuery = fmt.Sprintf("since=%d", since)
 	req, err := http.NewRequestWithContext(ctx, "GET", url.String(), nil)
 	if err != nil {
 		panic(err)
@@ -90,27 +215,38 @@ func (sc *ServerClient) download(ctx context.Context) (ExportedDatabase, error)
 	req.Header.Set("X-API-Token", sc.token.String())
 	resp, err := sc.client.Do(req)
 	if err != nil {
-		return ExportedDatabase{}, err
+		return Delta{}, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return ExportedDatabase{}, fmt.Errorf("failed to download database (status code %d): %s", resp.StatusCode, string(body))
+		return Delta{}, fmt.Errorf("failed to download changes (status code %d): %s", resp.StatusCode, string(body))
 	}
-	var exported ExportedDatabase
-	err = json.NewDecoder(resp.Body).Decode(&exported)
+	var delta Delta
+	err = json.NewDecoder(resp.Body).Decode(&delta)
 	if err != nil {
-		return ExportedDatabase{}, err
+		return Delta{}, err
 	}
-	return exported, nil
+	if err := checkVersion(delta.Version); err != nil {
+		return Delta{}, err
+	}
+	return delta, nil
+}
+
+// UploadChangesResponse is the response to POST /database/changes.
+type UploadChangesResponse struct {
+	// Seq is the server's sequence number after the changes were applied.
+	Seq int64
 }
 
-func (sc *ServerClient) uploadChanges(ctx context.Context, changes Changes) error {
+// ApplyChanges implements Transport by uploading the changes to the server.
+func (sc *ServerClient) ApplyChanges(ctx context.Context, changes Changes) (int64, error) {
 	url := sc.baseURL.JoinPath("/database/changes")
+	changes.Version = SchemaVersion
 	buf := new(bytes.Buffer)
 	err := json.NewEncoder(buf).Encode(changes)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	log.Printf("url = %v", url)
 	req, err := http.NewRequestWithContext(ctx, "POST", url.String(), buf)
@@ -122,118 +258,114 @@ func (sc *ServerClient) uploadChanges(ctx context.Context, changes Changes) erro
 	}
 	req.Header.Set("X-API-Token", sc.token.String())
 	req.Header.Set("Content-Type", "application/json")
+	setLeaseID(ctx, req)
 	resp, err := sc.client.Do(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to upload changes (status code %d): %s", resp.StatusCode, string(body))
+		return 0, fmt.Errorf("failed to upload changes (status code %d): %s", resp.StatusCode, string(body))
 	}
-	return nil
+	var ucr UploadChangesResponse
+	err = json.NewDecoder(resp.Body).Decode(&ucr)
+	if err != nil {
+		return 0, fmt.Errorf("decode response: %w", err)
+	}
+	return ucr.Seq, nil
+}
+
+// SyncDatabase syncs the local database with the server (see Syncer.SyncDatabase).
+func (sc *ServerClient) SyncDatabase(ctx context.Context, state SyncState, db *database.Database, resolver func(MergeConflicts) (Changes, error), status chan<- string) (Changes, SyncState, error) {
+	return Syncer{Transport: sc, MergeOptions: sc.MergeOptions}.SyncDatabase(ctx, state, db, resolver, status)
 }
 
-func (sc *ServerClient) SyncDatabase(ctx context.Context, originalED ExportedDatabase, db *database.Database, resolver func(MergeConflicts) (Changes, error), status chan<- string) (Changes, ExportedDatabase, error) {
-	if status != nil {
-		status <- "施錠"
+// statusError is an unexpected status code in a response from the server.
+type statusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("status code %d: %s", e.StatusCode, e.Body)
+}
+
+// doJSON sends in as JSON unless it is nil, and decodes the response into out unless it is nil.
+func (sc *ServerClient) doJSON(ctx context.Context, method string, u *url.URL, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		buf := new(bytes.Buffer)
+		if err := json.NewEncoder(buf).Encode(in); err != nil {
+			return err
+		}
+		body = buf
 	}
-	err := sc.lock(ctx)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
 	if err != nil {
-		return Changes{}, ExportedDatabase{}, fmt.Errorf("lock: %w", err)
-	}
-	defer func() {
-		if status != nil {
-			status <- "解錠"
-		}
-		err := sc.unlock(ctx)
-		if err != nil {
-			log.Printf("SyncDatabase: unlock: %s", err)
-		}
-	}()
-
-	if status != nil {
-		status <- "取得"
+		panic(err)
 	}
-	var serverED, localED ExportedDatabase
-	var err1, err2 error
-	var wg sync.WaitGroup
-	wg.Add(2)
-	go func() {
-		defer wg.Done()
-		serverED, err1 = sc.download(ctx)
-		log.Printf("downloaded: %#v", serverED)
-	}()
-	go func() {
-		defer wg.Done()
-		localED, err2 = Export(db)
-	}()
-	wg.Wait()
-	if err1 != nil {
-		return Changes{}, ExportedDatabase{}, fmt.Errorf("download: %w", err1)
-	}
-	if err2 != nil {
-		return Changes{}, ExportedDatabase{}, fmt.Errorf("local export: %w", err2)
-	}
-	if status != nil {
-		status <- "マージ"
-	}
-
-	if len(originalED.Sessions) == 0 && len(originalED.Timeframes) == 0 {
-		originalED = serverED
-	}
-	log.Printf("serverED has %d sessions and %d timeframes", len(serverED.Sessions), len(serverED.Timeframes))
-	changes, conflicts := Merge(originalED, localED, serverED)
-	log.Printf("num of conflicts: %d", len(conflicts.Sessions)+len(conflicts.Timeframes))
-	if len(conflicts.Sessions) > 0 || len(conflicts.Timeframes) > 0 {
-		if resolver == nil {
-			return Changes{}, ExportedDatabase{}, ErrConflictNoResolver
-		} else {
-			changes2, err := resolver(conflicts)
-			if err != nil {
-				return Changes{}, ExportedDatabase{}, ErrResolverError{err}
-			}
-			changes.Sessions = append(changes.Sessions, changes2.Sessions...)
-			changes.Timeframes = append(changes.Timeframes, changes2.Timeframes...)
-		}
+	req.Header.Set("X-API-Token", sc.token.String())
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
-
-	for i, s := range changes.Sessions {
-		log.Printf("change %d: session: %v", i, s)
+	setLeaseID(ctx, req)
+	resp, err := sc.client.Do(req)
+	if err != nil {
+		return err
 	}
-	for i, t := range changes.Timeframes {
-		log.Printf("change %d: timeframe: %v", i, t)
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(resp.Body)
+		return statusError{resp.StatusCode, string(body)}
 	}
-	for i, t := range changes.Tasks {
-		log.Printf("change %d: task: %v", i, t)
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("decode response: %w", err)
+		}
 	}
+	return nil
+}
 
-	if status != nil {
-		status <- "更新"
-	}
-	var wg2 sync.WaitGroup
-	wg2.Add(2)
-	go func() {
-		defer wg2.Done()
-		err1 = sc.uploadChanges(ctx, changes)
-	}()
-	go func() {
-		defer wg2.Done()
-		// replace local database with server database
-		err2 = ReplaceAndImport(db, serverED, changes)
-	}()
-	wg2.Wait()
-	if err1 != nil {
-		return Changes{}, ExportedDatabase{}, fmt.Errorf("upload changes: %w", err1)
+var _ SealedTransport = (*ServerClient)(nil)
+
+// KeyParams implements SealedTransport.
+func (sc *ServerClient) KeyParams(ctx context.Context) (KeyParams, error) {
+	var params KeyParams
+	err := sc.doJSON(ctx, "GET", sc.baseURL.JoinPath("/database/encrypted/params"), nil, &params)
+	var se statusError
+	if errors.As(err, &se) && se.StatusCode == http.StatusNotFound {
+		return KeyParams{}, ErrNoKeyParams
 	}
-	if err2 != nil {
-		return Changes{}, ExportedDatabase{}, fmt.Errorf("local replace and import: %w", err2)
+	return params, err
+}
+
+// SetKeyParams implements SealedTransport.
+func (sc *ServerClient) SetKeyParams(ctx context.Context, params KeyParams) error {
+	err := sc.doJSON(ctx, "PUT", sc.baseURL.JoinPath("/database/encrypted/params"), params, nil)
+	var se statusError
+	if errors.As(err, &se) && se.StatusCode == http.StatusConflict {
+		return ErrKeyParamsSet
 	}
+	return err
+}
 
-	newED, err := Export(db)
-	if err != nil {
-		panic(err)
+// DownloadSealed implements SealedTransport.
+func (sc *ServerClient) DownloadSealed(ctx context.Context, since int64) (SealedDelta, error) {
+	u := sc.baseURL.JoinPath("/database/encrypted/changes")
+	u.RawQuery = fmt.Sprintf("since=%d", since)
+	var sd SealedDelta
+	if err := sc.doJSON(ctx, "GET", u, nil, &sd); err != nil {
+		return SealedDelta{}, fmt.Errorf("failed to download encrypted changes: %w", err)
 	}
+	return sd, nil
+}
 
-	return changes, newED, nil
+// ApplySealed implements SealedTransport by uploading the encrypted rows to the server.
+func (sc *ServerClient) ApplySealed(ctx context.Context, changes SealedChanges) (int64, error) {
+	var ucr UploadChangesResponse
+	if err := sc.doJSON(ctx, "POST", sc.baseURL.JoinPath("/database/encrypted/changes"), changes, &ucr); err != nil {
+		return 0, fmt.Errorf("failed to upload encrypted changes: %w", err)
+	}
+	return ucr.Seq, nil
 }
@@ -0,0 +1,317 @@
+package sync
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+)
+
+// Delta is the current state of rows changed after some sequence number.
+// Deleted rows are represented by their tombstones.
+type Delta struct {
+	// Version is the schema version the rows are in (see SchemaVersion).
+	Version int
+	ExportedDatabase
+	// Seq is the sequence number of the latest change included.
+	Seq int64
+	// ResetSeq is the sequence number as of which the canonical database was emptied (e.g. when encryption was set up), or zero if it never was.
+	// A client that last synced at or before it uploads the rows the canonical database lacks, as they were removed.
+	ResetSeq int64
+}
+
+// SyncState is what a client remembers between syncs, instead of a full original copy.
+type SyncState struct {
+	// ServerSeq is the sequence number of the canonical database (e.g. the server's) as of the last sync.
+	ServerSeq int64
+	// LocalSeq is the local sequence number as of the last sync.
+	LocalSeq int64
+	// Queued are the conflicts left unresolved by the last sync, if it had no resolver (see Syncer.QueueConflicts).
+	Queued QueuedConflicts
+}
+
+// touched is the set of changed IDs for each table.
+type touched map[string]map[string]struct{}
+
+func (t touched) add(table, id string) {
+	if t[table] == nil {
+		t[table] = map[string]struct{}{}
+	}
+	t[table][id] = struct{}{}
+}
+
+func (t touched) ids(table string) []string {
+	ids := make([]string, 0, len(t[table]))
+	for id := range t[table] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (d Delta) touched() touched {
+	t := touched{}
+	for _, s := range d.Sessions {
+		t.add("sessions", s.ID)
+	}
+	for _, tf := range d.Timeframes {
+		t.add("time_frames", tf.ID)
+	}
+	for _, task := range d.Tasks {
+		t.add("tasks", task.ID)
+	}
+	for _, ts := range d.Tombstones {
+		t.add(ts.TableName, ts.ID)
+	}
+	return t
+}
+
+// ExportDelta exports rows changed after the sequence number since.
+func ExportDelta(d *database.Database, since int64) (Delta, error) {
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		return Delta{}, err
+	}
+	defer tx.Rollback()
+	delta, _, err := exportDelta(tx, since, false)
+	return delta, err
+}
+
+// exportDelta exports rows changed after since.
+// If withOriginal is set, it also returns the rows as they were at since (rows inserted after since are omitted).
+func exportDelta(tx *sqlx.Tx, since int64, withOriginal bool) (Delta, ExportedDatabase, error) {
+	delta := Delta{Version: SchemaVersion}
+	var original ExportedDatabase
+	var err error
+	delta.Seq, err = database.LatestSeq(tx)
+	if err != nil {
+		return Delta{}, ExportedDatabase{}, fmt.Errorf("latest seq: %w", err)
+	}
+	entries, err := database.ChangelogSince(tx, since)
+	if err != nil {
+		return Delta{}, ExportedDatabase{}, fmt.Errorf("changelog: %w", err)
+	}
+	t := touched{}
+	seen := touched{}
+	for _, e := range entries {
+		t.add(e.TableName, e.ID)
+		if !withOriginal {
+			continue
+		}
+		if _, ok := seen[e.TableName][e.ID]; ok {
+			// only the earliest change has the original row
+			continue
+		}
+		seen.add(e.TableName, e.ID)
+		if e.Old == nil {
+			// inserted after since
+			continue
+		}
+		switch e.TableName {
+		case "sessions":
+			s, err := e.OldSession()
+			if err != nil {
+				return Delta{}, ExportedDatabase{}, err
+			}
+			original.Sessions = append(original.Sessions, s)
+		case "time_frames":
+			tf, err := e.OldTimeframe()
+			if err != nil {
+				return Delta{}, ExportedDatabase{}, err
+			}
+			original.Timeframes = append(original.Timeframes, tf)
+		case "tasks":
+			task, err := e.OldTask()
+			if err != nil {
+				return Delta{}, ExportedDatabase{}, err
+			}
+			original.Tasks = append(original.Tasks, task)
+		}
+	}
+	delta.ExportedDatabase, err = exportIDs(tx, t)
+	if err != nil {
+		return Delta{}, ExportedDatabase{}, err
+	}
+	return delta, original, nil
+}
+
+// exportAll exports every row as a delta, for a sync with an empty canonical database.
+// Unlike exportDelta since zero, it includes rows whose changes were pruned from the changelog.
+func exportAll(tx *sqlx.Tx) (Delta, error) {
+	delta := Delta{Version: SchemaVersion}
+	var err error
+	delta.Seq, err = database.LatestSeq(tx)
+	if err != nil {
+		return Delta{}, fmt.Errorf("latest seq: %w", err)
+	}
+	t := touched{}
+	for _, table := range []string{"sessions", "time_frames", "tasks"} {
+		var ids []string
+		if err := tx.Select(&ids, fmt.Sprintf("SELECT id FROM %s", table)); err != nil {
+			return Delta{}, fmt.Errorf("select %s: %w", table, err)
+		}
+		for _, id := range ids {
+			t.add(table, id)
+		}
+	}
+	delta.ExportedDatabase, err = exportIDs(tx, t)
+	if err != nil {
+		return Delta{}, err
+	}
+	return delta, nil
+}
+
+// exportIDs exports the rows with the given IDs.
+// Rows that do not exist are exported as tombstones.
+func exportIDs(tx *sqlx.Tx, t touched) (ExportedDatabase, error) {
+	var ed ExportedDatabase
+	var err error
+	ed.Sessions, err = selectIDs[data.Session](tx, "sessions", t.ids("sessions"))
+	if err != nil {
+		return ExportedDatabase{}, err
+	}
+	err = database.LoadSessionTags(tx, ed.Sessions)
+	if err != nil {
+		return ExportedDatabase{}, err
+	}
+	ed.Timeframes, err = selectIDs[data.Timeframe](tx, "time_frames", t.ids("time_frames"))
+	if err != nil {
+		return ExportedDatabase{}, err
+	}
+	ed.Tasks, err = selectIDs[data.Task](tx, "tasks", t.ids("tasks"))
+	if err != nil {
+		return ExportedDatabase{}, err
+	}
+	existing := touched{}
+	for _, s := range ed.Sessions {
+		existing.add("sessions", s.ID)
+	}
+	for _, tf := range ed.Timeframes {
+		existing.add("time_frames", tf.ID)
+	}
+	for _, task := range ed.Tasks {
+		existing.add("tasks", task.ID)
+	}
+	for table, ids := range t {
+		for id := range ids {
+			if _, ok := existing[table][id]; ok {
+				continue
+			}
+			var ts data.Tombstone
+			err := tx.Get(&ts, "SELECT * FROM tombstones WHERE table_name = ? AND id = ?", table, id)
+			if err != nil {
+				// deleted without a tombstone (e.g. by hand)
+				ts = data.Tombstone{ID: id, TableName: table}
+			}
+			ed.Tombstones = append(ed.Tombstones, ts)
+		}
+	}
+	return ed, nil
+}
+
+func selectIDs[T any](tx *sqlx.Tx, table string, ids []string) ([]T, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	query, args, err := sqlx.In(fmt.Sprintf("SELECT * FROM %s WHERE id IN (?)", table), ids)
+	if err != nil {
+		return nil, err
+	}
+	var rows []T
+	err = tx.Select(&rows, tx.Rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("select %s: %w", table, err)
+	}
+	return rows, nil
+}
+
+// mergeInputs builds the original, local and remote databases for a 3-way merge of the rows touched by either delta.
+// Rows not changed locally are taken from local for original, and rows not changed remotely are taken from original for remote.
+func mergeInputs(tx *sqlx.Tx, localDelta, remoteDelta Delta, localOriginal ExportedDatabase) (original, local, remote ExportedDatabase, err error) {
+	localTouched := localDelta.touched()
+	remoteTouched := remoteDelta.touched()
+	all := touched{}
+	for _, t := range []touched{localTouched, remoteTouched} {
+		for table, ids := range t {
+			for id := range ids {
+				all.add(table, id)
+			}
+		}
+	}
+	local, err = exportIDs(tx, all)
+	if err != nil {
+		return
+	}
+	original.Sessions, remote.Sessions = deltaSlices(getIDSession, localTouched["sessions"], remoteTouched["sessions"], localOriginal.Sessions, local.Sessions, remoteDelta.Sessions)
+	original.Timeframes, remote.Timeframes = deltaSlices(getIDTimeframe, localTouched["time_frames"], remoteTouched["time_frames"], localOriginal.Timeframes, local.Timeframes, remoteDelta.Timeframes)
+	original.Tasks, remote.Tasks = deltaSlices(getIDTask, localTouched["tasks"], remoteTouched["tasks"], localOriginal.Tasks, local.Tasks, remoteDelta.Tasks)
+	local.Tombstones = localDelta.Tombstones
+	remote.Tombstones = remoteDelta.Tombstones
+	return
+}
+
+// deltaSlices returns the original and remote rows of one table, given the rows as of the last sync (for rows changed locally), the current local rows and the rows changed remotely.
+func deltaSlices[T any](getID func(T) string, localTouched, remoteTouched map[string]struct{}, localOriginal, local, remoteChanged []T) (original, remote []T) {
+	original = append(original, localOriginal...)
+	for _, v := range local {
+		if _, ok := localTouched[getID(v)]; !ok {
+			// unchanged locally since last sync
+			original = append(original, v)
+		}
+	}
+	remote = append(remote, remoteChanged...)
+	for _, v := range original {
+		if _, ok := remoteTouched[getID(v)]; !ok {
+			// unchanged remotely since last sync
+			remote = append(remote, v)
+		}
+	}
+	return original, remote
+}
+
+// applyDelta applies the remote delta and then the changes to the local database.
+// The changes made here are removed from the changelog, as they are already on the server.
+func applyDelta(d *database.Database, remoteDelta Delta, changes Changes) error {
+	tx := d.DB.MustBegin()
+	defer tx.Rollback()
+	before, err := database.LatestSeq(tx)
+	if err != nil {
+		return err
+	}
+	if err := importChanges(tx, deltaChanges(remoteDelta)); err != nil {
+		return fmt.Errorf("remote delta: %w", err)
+	}
+	if err := importChanges(tx, changes); err != nil {
+		return fmt.Errorf("changes: %w", err)
+	}
+	_, err = tx.Exec("DELETE FROM changelog WHERE seq > ?", before)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deltaChanges returns the changes that make a database match the delta.
+func deltaChanges(delta Delta) Changes {
+	c := Changes{Version: delta.Version}
+	for _, s := range delta.Sessions {
+		c.Sessions = append(c.Sessions, Change[data.Session]{ChangeOperationExist, s})
+	}
+	for _, tf := range delta.Timeframes {
+		c.Timeframes = append(c.Timeframes, Change[data.Timeframe]{ChangeOperationExist, tf})
+	}
+	for _, t := range delta.Tasks {
+		c.Tasks = append(c.Tasks, Change[data.Task]{ChangeOperationExist, t})
+	}
+	for _, ts := range delta.Tombstones {
+		switch ts.TableName {
+		case "sessions":
+			c.Sessions = append(c.Sessions, Change[data.Session]{ChangeOperationRemove, data.Session{ID: ts.ID}})
+		case "time_frames":
+			c.Timeframes = append(c.Timeframes, Change[data.Timeframe]{ChangeOperationRemove, data.Timeframe{ID: ts.ID}})
+		case "tasks":
+			c.Tasks = append(c.Tasks, Change[data.Task]{ChangeOperationRemove, data.Task{ID: ts.ID}})
+		}
+	}
+	return c
+}
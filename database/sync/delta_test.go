@@ -0,0 +1,450 @@
+package sync_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+	"nyiyui.ca/jts/database/sync"
+	"nyiyui.ca/jts/server"
+	"nyiyui.ca/jts/tokens"
+)
+
+func newTestDatabase(t *testing.T, name string) *database.Database {
+	t.Helper()
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+	return db
+}
+
+type testClient struct {
+	db     *database.Database
+	syncer sync.Syncer
+	state  sync.SyncState
+}
+
+func (c *testClient) sync(t *testing.T) sync.Changes {
+	t.Helper()
+	changes, state, err := c.syncer.SyncDatabase(context.Background(), c.state, c.db, nil, nil)
+	if err != nil {
+		t.Fatalf("sync: %s", err)
+	}
+	c.state = state
+	return changes
+}
+
+// newTestServer starts a server, and returns a client for it and the server's database.
+func newTestServer(t *testing.T) (*sync.ServerClient, *database.Database) {
+	t.Helper()
+	token, err := tokens.RandomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverDB := newTestDatabase(t, "server.db")
+	s, err := server.New(nil, serverDB, map[tokens.TokenHash]server.TokenInfo{
+		token.Hash(): {Name: "test", Permissions: []server.Permission{server.PermissionSyncDatabase}},
+	}, nil, sessions.NewCookieStore([]byte("test")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(s)
+	t.Cleanup(ts.Close)
+	baseURL, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sync.NewServerClient(ts.Client(), baseURL, token), serverDB
+}
+
+func newTestSetup(t *testing.T) (a, b *testClient) {
+	t.Helper()
+	sc, _ := newTestServer(t)
+	a = &testClient{db: newTestDatabase(t, "a.db"), syncer: sync.Syncer{Transport: sc}}
+	b = &testClient{db: newTestDatabase(t, "b.db"), syncer: sync.Syncer{Transport: sc}}
+	return a, b
+}
+
+func TestDeltaSync(t *testing.T) {
+	forEachTransport(t, testDeltaSync)
+}
+
+func testDeltaSync(t *testing.T, a, b *testClient) {
+	id, err := a.db.AddSession(data.Session{Description: "learn Go", Timeframes: []data.Timeframe{{}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.sync(t)
+	b.sync(t)
+	session, err := b.db.GetSession(id)
+	if err != nil {
+		t.Fatalf("b does not have session: %s", err)
+	}
+	if len(session.Timeframes) != 1 {
+		t.Fatalf("expected 1 timeframe, got %d", len(session.Timeframes))
+	}
+
+	// nothing changed, so nothing should be transferred
+	if changes := a.sync(t); len(changes.Sessions)+len(changes.Timeframes) != 0 {
+		t.Fatalf("expected no changes, got %#v", changes)
+	}
+
+	// edit on b, delete a timeframe on a
+	session.Description = "learn Go generics"
+	if err := b.db.EditSessionProperties(session); err != nil {
+		t.Fatal(err)
+	}
+	b.sync(t)
+	if err := a.db.DeleteTimeframe(id, session.Timeframes[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	changes := a.sync(t)
+	if len(changes.Timeframes) != 1 || changes.Timeframes[0].Operation != sync.ChangeOperationRemove {
+		t.Fatalf("expected timeframe removal, got %#v", changes.Timeframes)
+	}
+	session, err = a.db.GetSession(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Description != "learn Go generics" {
+		t.Fatalf("expected b's edit on a, got %q", session.Description)
+	}
+	b.sync(t)
+	session, err = b.db.GetSession(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(session.Timeframes) != 0 {
+		t.Fatalf("expected deleted timeframe to be gone on b, got %#v", session.Timeframes)
+	}
+
+	// deleting the session must not resurrect it
+	if err := b.db.DeleteSession(id); err != nil {
+		t.Fatal(err)
+	}
+	b.sync(t)
+	a.sync(t)
+	if _, err := a.db.GetSession(id); err == nil {
+		t.Fatal("expected session to be deleted on a")
+	}
+}
+
+func TestDeltaSyncConflict(t *testing.T) {
+	forEachTransport(t, testDeltaSyncConflict)
+}
+
+func testDeltaSyncConflict(t *testing.T, a, b *testClient) {
+	id, err := a.db.AddSession(data.Session{Description: "learn Go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.sync(t)
+	b.sync(t)
+	if err := a.db.EditSessionProperties(data.Session{ID: id, Description: "learn Go (a)"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.db.EditSessionProperties(data.Session{ID: id, Description: "learn Go (b)"}); err != nil {
+		t.Fatal(err)
+	}
+	a.sync(t)
+	_, _, err = b.syncer.SyncDatabase(context.Background(), b.state, b.db, nil, nil)
+	if err != sync.ErrConflictNoResolver {
+		t.Fatalf("expected %v, got %v", sync.ErrConflictNoResolver, err)
+	}
+}
+
+func TestDeltaSyncTags(t *testing.T) {
+	forEachTransport(t, testDeltaSyncTags)
+}
+
+func testDeltaSyncTags(t *testing.T, a, b *testClient) {
+	id, err := a.db.AddSession(data.Session{Description: "standup", Tags: []string{"meeting", "billable"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.sync(t)
+	b.sync(t)
+	if err := a.db.UntagSession(id, "billable"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.db.TagSession(id, "client-acme"); err != nil {
+		t.Fatal(err)
+	}
+	a.sync(t)
+	b.sync(t)
+	a.sync(t)
+	expected := []string{"client-acme", "meeting"}
+	for _, c := range []*testClient{a, b} {
+		session, err := c.db.GetSession(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(session.Tags, expected) {
+			t.Fatalf("expected tags %v, got %v", expected, session.Tags)
+		}
+	}
+}
+
+func TestQueueConflicts(t *testing.T) {
+	forEachTransport(t, testQueueConflicts)
+}
+
+func testQueueConflicts(t *testing.T, a, b *testClient) {
+	session, err := a.db.AddSession(data.Session{Description: "learn Go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := a.db.AddTask(data.Task{Description: "read the spec"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := a.db.AddSession(data.Session{Description: "lunch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.sync(t)
+	b.sync(t)
+	if err := a.db.EditSessionProperties(data.Session{ID: session, Description: "learn Go (a)"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.db.EditTask(data.Task{ID: task, Description: "read the spec (a)", Status: data.TaskStatusOpen}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.db.EditSessionProperties(data.Session{ID: session, Description: "learn Go (b)"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.db.EditTask(data.Task{ID: task, Description: "read the spec (b)", Status: data.TaskStatusOpen}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.db.EditSessionProperties(data.Session{ID: other, Description: "lunch (b)"}); err != nil {
+		t.Fatal(err)
+	}
+	a.sync(t)
+
+	// a background sync syncs the rest, and queues the conflicts
+	b.syncer.QueueConflicts = true
+	b.sync(t)
+	b.sync(t)
+	if n := b.state.Queued.Len(); n != 2 {
+		t.Fatalf("expected 2 queued conflicts, got %d: %#v", n, b.state.Queued)
+	}
+	a.sync(t)
+	if s, err := a.db.GetSession(other); err != nil {
+		t.Fatal(err)
+	} else if s.Description != "lunch (b)" {
+		t.Fatalf("expected the edit without conflicts to be synced, got %q", s.Description)
+	}
+	if s, err := b.db.GetSession(session); err != nil {
+		t.Fatal(err)
+	} else if s.Description != "learn Go (b)" {
+		t.Fatalf("expected the conflicting row to stay as is locally, got %q", s.Description)
+	}
+
+	// b edited the session last, so the policy resolves it, and only the task is left to the resolver
+	b.syncer.MergeOptions.Policies.Sessions = sync.PolicyLatest
+	var resolved sync.MergeConflicts
+	changes, state, err := b.syncer.SyncDatabase(context.Background(), b.state, b.db, func(mc sync.MergeConflicts) (sync.Changes, error) {
+		resolved = mc
+		return sync.Changes{Tasks: []sync.Change[data.Task]{{Operation: sync.ChangeOperationExist, Data: mc.Tasks[0].Remote}}}, nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.state = state
+	if len(resolved.Sessions) != 0 || len(resolved.Tasks) != 1 || resolved.Tasks[0].Local.Description != "read the spec (b)" || resolved.Tasks[0].Remote.Description != "read the spec (a)" {
+		t.Fatalf("expected only the task conflict, got %#v", resolved)
+	}
+	if len(changes.Sessions) != 1 || b.state.Queued.Len() != 0 {
+		t.Fatalf("expected the session to be resolved and nothing queued, got %#v and %#v", changes, b.state.Queued)
+	}
+	a.sync(t)
+	for _, c := range []*testClient{a, b} {
+		s, err := c.db.GetSession(session)
+		if err != nil {
+			t.Fatal(err)
+		}
+		task, err := c.db.GetTask(task)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Description != "learn Go (b)" || task.Description != "read the spec (a)" {
+			t.Fatalf("expected the resolutions on both clients, got %q and %q", s.Description, task.Description)
+		}
+	}
+}
+
+func TestPreview(t *testing.T) {
+	forEachTransport(t, testPreview)
+}
+
+func testPreview(t *testing.T, a, b *testClient) {
+	session, err := a.db.AddSession(data.Session{Description: "learn Go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := a.db.AddTask(data.Task{Description: "read the spec"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conflicting, err := a.db.AddTask(data.Task{Description: "write a parser"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.sync(t)
+	b.sync(t)
+	if err := a.db.EditSessionProperties(data.Session{ID: session, Description: "learn Go generics"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.db.DeleteTask(removed); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.db.EditTask(data.Task{ID: conflicting, Description: "write a parser (a)", Status: data.TaskStatusOpen}); err != nil {
+		t.Fatal(err)
+	}
+	a.sync(t)
+	if err := b.db.EditSessionProperties(data.Session{ID: session, Description: "learn Go", Notes: "chapter 1"}); err != nil {
+		t.Fatal(err)
+	}
+	added, err := b.db.AddSession(data.Session{Description: "lunch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.db.EditTask(data.Task{ID: conflicting, Description: "write a parser (b)", Status: data.TaskStatusOpen}); err != nil {
+		t.Fatal(err)
+	}
+	seq, err := b.db.LatestSeq()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preview, err := b.syncer.Preview(context.Background(), b.state, b.db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := preview.Outgoing
+	if len(out.Sessions) != 2 || out.Timeframes != nil || out.Tasks != nil {
+		t.Fatalf("expected 2 outgoing sessions, got %#v", out)
+	}
+	for _, d := range out.Sessions {
+		switch d.New.ID {
+		case session:
+			if d.Operation != sync.DiffOperationChange || d.Old.Notes != "" || d.New.Description != "learn Go generics" || d.New.Notes != "chapter 1" {
+				t.Errorf("expected the merged session to be uploaded, got %#v", d)
+			}
+		case added:
+			if d.Operation != sync.DiffOperationAdd || d.New.Description != "lunch" {
+				t.Errorf("expected the new session to be uploaded, got %#v", d)
+			}
+		default:
+			t.Errorf("unexpected outgoing session %#v", d)
+		}
+	}
+	in := preview.Incoming
+	if len(in.Sessions) != 1 || in.Sessions[0].Operation != sync.DiffOperationChange || in.Sessions[0].Old.Description != "learn Go" || in.Sessions[0].New.Description != "learn Go generics" {
+		t.Fatalf("expected the merged session to be written locally, got %#v", in.Sessions)
+	}
+	if len(in.Tasks) != 1 || in.Tasks[0].Operation != sync.DiffOperationRemove || in.Tasks[0].Old.ID != removed {
+		t.Fatalf("expected the removed task to be removed locally, got %#v", in.Tasks)
+	}
+	if preview.Conflicts.Len() != 1 || preview.Conflicts.Tasks[0].Local.ID != conflicting {
+		t.Fatalf("expected the task conflict, got %#v", preview.Conflicts)
+	}
+
+	// nothing is written
+	if s, err := b.db.GetSession(session); err != nil {
+		t.Fatal(err)
+	} else if s.Description != "learn Go" {
+		t.Fatalf("expected the local session to be unchanged, got %q", s.Description)
+	}
+	if after, err := b.db.LatestSeq(); err != nil {
+		t.Fatal(err)
+	} else if after != seq {
+		t.Fatalf("expected no local changes, got seq %d after %d", after, seq)
+	}
+	a.sync(t)
+	if _, err := a.db.GetSession(added); err == nil {
+		t.Fatal("expected nothing to be uploaded")
+	}
+
+	// a sync afterwards does what the preview showed
+	b.syncer.QueueConflicts = true
+	changes := b.sync(t)
+	if len(changes.Sessions) != len(out.Sessions) {
+		t.Fatalf("expected the outgoing sessions to be uploaded, got %#v", changes.Sessions)
+	}
+	if s, err := b.db.GetSession(session); err != nil {
+		t.Fatal(err)
+	} else if s.Description != "learn Go generics" || s.Notes != "chapter 1" {
+		t.Fatalf("expected the merged session, got %#v", s)
+	}
+}
+
+func TestPreviewSetsUpNothing(t *testing.T) {
+	t.Run("encrypted", func(t *testing.T) {
+		a, _, serverDB := newEncryptedTestServer(t)
+		if _, err := a.db.AddSession(data.Session{Description: "learn Go"}); err != nil {
+			t.Fatal(err)
+		}
+		preview, err := a.syncer.Preview(context.Background(), a.state, a.db, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(preview.Outgoing.Sessions) != 1 {
+			t.Fatalf("expected the session to be outgoing, got %#v", preview.Outgoing)
+		}
+		if _, err := serverDB.EncryptionParams(); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("expected encryption not to be set up, got %v", err)
+		}
+		// a sync afterwards sets it up
+		a.sync(t)
+		if _, err := serverDB.EncryptionParams(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	t.Run("dir", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "shared")
+		c := &testClient{db: newTestDatabase(t, "a.db"), syncer: sync.Syncer{Transport: sync.NewDirTransport(dir)}}
+		if _, err := c.syncer.Preview(context.Background(), c.state, c.db, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(dir); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("expected the directory not to be created, got %v", err)
+		}
+	})
+}
+
+func TestUploadNeedsLease(t *testing.T) {
+	sc, _ := newTestServer(t)
+	changes := sync.Changes{Sessions: []sync.Change[data.Session]{{Operation: sync.ChangeOperationExist, Data: data.Session{ID: "session1", Tags: []string{}}}}}
+	if _, err := sc.ApplyChanges(context.Background(), changes); err == nil {
+		t.Fatal("expected an upload without a lease to be rejected")
+	}
+	ctx, unlock, err := sc.Lock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sc.ApplyChanges(ctx, changes); err != nil {
+		t.Fatalf("expected an upload with the lease to succeed: %s", err)
+	}
+	if err := unlock(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Fatalf("expected the locked context to be done after unlocking, got %v", ctx.Err())
+	}
+}
@@ -0,0 +1,112 @@
+package sync
+
+import (
+	"slices"
+	"strings"
+)
+
+// maxMergeLines bounds the size of the LCS table used by mergeLines, so that huge notes conflict instead of using a lot of memory.
+const maxMergeLines = 2000 * 2000
+
+// mergeLines merges two edits of a text line by line, like diff3.
+// Lines changed on only one side are taken from that side.
+// ok is false if both sides changed the same (or adjacent) lines differently.
+func mergeLines(original, local, remote string) (merged string, ok bool) {
+	return mergeLinesUnion(original, local, remote, false)
+}
+
+// unionLines merges two edits of a text like mergeLines, but keeps the lines of both sides where they conflict (local first), like the union merge driver of Git.
+// ok is false only if the texts are too long to merge.
+func unionLines(original, local, remote string) (merged string, ok bool) {
+	return mergeLinesUnion(original, local, remote, true)
+}
+
+func mergeLinesUnion(original, local, remote string, union bool) (merged string, ok bool) {
+	o := splitLines(original)
+	a := splitLines(local)
+	b := splitLines(remote)
+	if len(o)*len(a) > maxMergeLines || len(o)*len(b) > maxMergeLines {
+		return "", false
+	}
+	matchA := matchLines(o, a)
+	matchB := matchLines(o, b)
+	var result []string
+	i, ia, ib := 0, 0, 0
+	for i < len(o) || ia < len(a) || ib < len(b) {
+		if i < len(o) && matchA[i] == ia && matchB[i] == ib {
+			// stable line
+			result = append(result, o[i])
+			i++
+			ia++
+			ib++
+			continue
+		}
+		// find the next line of original kept on both sides
+		j := i
+		for j < len(o) && (matchA[j] == -1 || matchB[j] == -1) {
+			j++
+		}
+		ja, jb := len(a), len(b)
+		if j < len(o) {
+			ja, jb = matchA[j], matchB[j]
+		}
+		chunkO, chunkA, chunkB := o[i:j], a[ia:ja], b[ib:jb]
+		switch {
+		case slices.Equal(chunkA, chunkO):
+			result = append(result, chunkB...)
+		case slices.Equal(chunkB, chunkO), slices.Equal(chunkA, chunkB):
+			result = append(result, chunkA...)
+		case union:
+			result = append(result, chunkA...)
+			for _, line := range chunkB {
+				if !slices.Contains(chunkA, line) {
+					result = append(result, line)
+				}
+			}
+		default:
+			return "", false
+		}
+		i, ia, ib = j, ja, jb
+	}
+	return strings.TrimSuffix(strings.Join(result, ""), "\n"), true
+}
+
+// splitLines splits s into lines, keeping the newlines.
+// A newline is added first so that the last line compares equal to the same line followed by more lines.
+func splitLines(s string) []string {
+	return strings.SplitAfter(s+"\n", "\n")
+}
+
+// matchLines returns, for each line of o, the index of the line of v it is matched with in a longest common subsequence, or -1.
+func matchLines(o, v []string) []int {
+	// lcs[i][j] is the length of the LCS of o[i:] and v[j:]
+	lcs := make([][]int, len(o)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(v)+1)
+	}
+	for i := len(o) - 1; i >= 0; i-- {
+		for j := len(v) - 1; j >= 0; j-- {
+			if o[i] == v[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	match := make([]int, len(o))
+	i, j := 0, 0
+	for i < len(o) {
+		switch {
+		case j < len(v) && o[i] == v[j]:
+			match[i] = j
+			i++
+			j++
+		case j < len(v) && lcs[i][j+1] >= lcs[i+1][j]:
+			j++
+		default:
+			match[i] = -1
+			i++
+		}
+	}
+	return match
+}
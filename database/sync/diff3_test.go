@@ -0,0 +1,27 @@
+package sync
+
+import "testing"
+
+func TestMergeLines(t *testing.T) {
+	for _, c := range []struct {
+		name                    string
+		original, local, remote string
+		merged                  string
+		ok                      bool
+	}{
+		{"unchanged", "a\nb", "a\nb", "a\nb", "a\nb", true},
+		{"one side", "a\nb", "a\nB", "a\nb", "a\nB", true},
+		{"separate lines", "a\nb\nc", "A\nb\nc", "a\nb\nC", "A\nb\nC", true},
+		{"both append", "a", "a\nb", "a\nb", "a\nb", true},
+		{"insert and append", "a\nb", "x\na\nb", "a\nb\nc", "x\na\nb\nc", true},
+		{"delete and edit", "a\nb\nc\nd", "a\nc\nd", "a\nb\nc\nD", "a\nc\nD", true},
+		{"same line", "a\nb", "a\nB", "a\nβ", "", false},
+		{"adjacent lines", "a\nb", "A\nb", "a\nB", "", false},
+		{"trailing newline", "a\n", "a\nb\n", "A\n", "", false},
+	} {
+		merged, ok := mergeLines(c.original, c.local, c.remote)
+		if ok != c.ok || merged != c.merged {
+			t.Errorf("%s: got %q, %t; expected %q, %t", c.name, merged, ok, c.merged, c.ok)
+		}
+	}
+}
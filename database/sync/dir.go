@@ -0,0 +1,442 @@
+package sync
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"nyiyui.ca/jts/data"
+)
+
+// DirLockTTL is how long the lock file of a DirTransport is valid unless renewed.
+// It is longer than the server's lease, as file sync tools (e.g. Syncthing) take a while to propagate the lock file.
+const DirLockTTL = 2 * time.Minute
+
+const (
+	dirLockName    = "lock.json"
+	dirChangesName = "changes"
+	// dirChangesExt is the extension of change files, which are named by their sequence number and a random ID (e.g. 00000000000000000042-0123456789abcdef.json).
+	// Files from before the ID was added have no ID (e.g. 00000000000000000042.json).
+	dirChangesExt = ".json"
+)
+
+// DirTransport is a Transport to a directory shared between devices (e.g. by Syncthing or on a NAS), so that they can sync without a server.
+//
+// The canonical database is stored as a log of change files, one per sync that changed something, named by their sequence number and a random ID.
+// The directory is locked by a lock file, which only excludes devices that can see each other's lock file, i.e. that are online at the same time.
+// Devices that cannot (e.g. while offline) can write change files with the same sequence number; thanks to the ID, file sync tools keep both.
+// A device that saw only one of them would miss the other, so deltas are replayed from before such a collision (see DownloadDelta),
+// until the next upload folds the changes since the collision into its file and removes the colliding files (see ApplyChanges).
+type DirTransport struct {
+	dir string
+	// Owner is written to the lock file to show who holds it. If empty, the hostname is used.
+	Owner string
+}
+
+var _ Transport = (*DirTransport)(nil)
+
+// NewDirTransport returns a transport to the directory, which is created on the first sync if it does not exist.
+func NewDirTransport(dir string) *DirTransport {
+	return &DirTransport{dir: dir}
+}
+
+// dirLock is the content of the lock file.
+type dirLock struct {
+	ID        string
+	Owner     string
+	ExpiresAt time.Time
+}
+
+func (t *DirTransport) lockPath() string {
+	return filepath.Join(t.dir, dirLockName)
+}
+
+func (t *DirTransport) readLock() (dirLock, error) {
+	raw, err := os.ReadFile(t.lockPath())
+	if err != nil {
+		return dirLock{}, err
+	}
+	var lock dirLock
+	err = json.Unmarshal(raw, &lock)
+	return lock, err
+}
+
+// Lock implements Transport by creating the lock file, which is renewed until unlock is called.
+// A lock file that expired (e.g. left behind by a crashed client) is removed.
+func (t *DirTransport) Lock(ctx context.Context) (context.Context, func(context.Context) error, error) {
+	if err := os.MkdirAll(filepath.Join(t.dir, dirChangesName), 0o700); err != nil {
+		return nil, nil, err
+	}
+	return t.lock(ctx)
+}
+
+// lockPreview implements previewLocker without creating the directory. If it does not exist, there is nothing to lock.
+func (t *DirTransport) lockPreview(ctx context.Context) (context.Context, func(context.Context) error, error) {
+	if _, err := os.Stat(t.dir); errors.Is(err, fs.ErrNotExist) {
+		return ctx, func(context.Context) error { return nil }, nil
+	}
+	return t.lock(ctx)
+}
+
+// dirLockIDKey is the context key for the ID of the lock file held, so that ApplyChanges can check that it is still held.
+type dirLockIDKey struct{}
+
+func (t *DirTransport) lock(ctx context.Context) (context.Context, func(context.Context) error, error) {
+	id, err := randomHex()
+	if err != nil {
+		return nil, nil, err
+	}
+	lock := dirLock{ID: id, Owner: t.Owner, ExpiresAt: time.Now().Add(DirLockTTL)}
+	if lock.Owner == "" {
+		lock.Owner, _ = os.Hostname()
+	}
+	for attempt := 0; ; attempt++ {
+		file, err := os.OpenFile(t.lockPath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			err = json.NewEncoder(file).Encode(lock)
+			if err2 := file.Close(); err == nil {
+				err = err2
+			}
+			if err != nil {
+				os.Remove(t.lockPath())
+				return nil, nil, err
+			}
+			break
+		}
+		if !errors.Is(err, fs.ErrExist) || attempt > 0 {
+			return nil, nil, err
+		}
+		held, err := t.readLock()
+		if err == nil && time.Now().Before(held.ExpiresAt) {
+			return nil, nil, fmt.Errorf("already locked by %s until %s", held.Owner, held.ExpiresAt.Format(time.DateTime))
+		}
+		if err != nil {
+			info, err2 := os.Stat(t.lockPath())
+			if err2 == nil && time.Since(info.ModTime()) < DirLockTTL {
+				// possibly being written right now
+				return nil, nil, fmt.Errorf("already locked: %w", err)
+			}
+		}
+		log.Printf("DirTransport: removing stale lock %+v", held)
+		if err := os.Remove(t.lockPath()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, err
+		}
+	}
+
+	locked, lose := context.WithCancelCause(context.WithValue(ctx, dirLockIDKey{}, lock.ID))
+	renewCtx, stopRenew := context.WithCancel(locked)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		if err := t.keepRenewed(renewCtx, lock); err != nil {
+			log.Printf("DirTransport: %s", err)
+			lose(err)
+		}
+	}()
+	return locked, func(ctx context.Context) error {
+		stopRenew()
+		<-renewed
+		lose(nil)
+		held, err := t.readLock()
+		if err != nil {
+			return err
+		}
+		if held.ID != lock.ID {
+			return fmt.Errorf("lock was taken over by %s", held.Owner)
+		}
+		return os.Remove(t.lockPath())
+	}, nil
+}
+
+// keepRenewed extends the lock file until ctx is done, or returns an error wrapping ErrLockLost if the lock file was taken over or could not be extended before it expired.
+func (t *DirTransport) keepRenewed(ctx context.Context, lock dirLock) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(DirLockTTL / 3):
+		}
+		if err := t.checkLock(lock.ID); err != nil {
+			return err
+		}
+		expiresAt := lock.ExpiresAt
+		lock.ExpiresAt = time.Now().Add(DirLockTTL)
+		if err := writeJSONFile(t.lockPath(), lock); err != nil {
+			if time.Now().After(expiresAt) {
+				return fmt.Errorf("%w: renew: %w", ErrLockLost, err)
+			}
+			log.Printf("DirTransport: renew lock: %s", err)
+			// retry
+			lock.ExpiresAt = expiresAt
+		}
+	}
+}
+
+// checkLock returns an error wrapping ErrLockLost unless the lock file with the ID is held.
+func (t *DirTransport) checkLock(id string) error {
+	held, err := t.readLock()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrLockLost, err)
+	}
+	if held.ID != id {
+		return fmt.Errorf("%w: taken over by %s", ErrLockLost, held.Owner)
+	}
+	return nil
+}
+
+// changeFile is a file in the changes directory.
+type changeFile struct {
+	seq  int64
+	path string
+}
+
+// changeFiles returns the change files in order of sequence number (and then path).
+// Other files, such as conflicting copies made by file sync tools, are ignored.
+func (t *DirTransport) changeFiles() ([]changeFile, error) {
+	dir := filepath.Join(t.dir, dirChangesName)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []changeFile
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, ".") {
+			// being written
+			continue
+		}
+		base := strings.TrimSuffix(name, dirChangesExt)
+		seqPart, _, _ := strings.Cut(base, "-")
+		seq, err := strconv.ParseInt(seqPart, 10, 64)
+		if err != nil || !strings.HasSuffix(name, dirChangesExt) || strings.Contains(base, ".") || seq <= 0 {
+			log.Printf("DirTransport: ignoring %s", filepath.Join(dir, name))
+			continue
+		}
+		files = append(files, changeFile{seq, filepath.Join(dir, name)})
+	}
+	slices.SortFunc(files, func(a, b changeFile) int {
+		return cmp.Or(cmp.Compare(a.seq, b.seq), cmp.Compare(a.path, b.path))
+	})
+	return files, nil
+}
+
+// firstCollision returns the index of the first change file with the same sequence number as another, or -1 if there is none.
+func firstCollision(files []changeFile) int {
+	for i := 1; i < len(files); i++ {
+		if files[i].seq == files[i-1].seq {
+			return i - 1
+		}
+	}
+	return -1
+}
+
+// replay returns the latest change to each row in the change files.
+func replay(ctx context.Context, files []changeFile) (Changes, error) {
+	sessions := map[string]Change[data.Session]{}
+	timeframes := map[string]Change[data.Timeframe]{}
+	tasks := map[string]Change[data.Task]{}
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return Changes{}, err
+		}
+		raw, err := os.ReadFile(f.path)
+		if err != nil {
+			return Changes{}, err
+		}
+		var changes Changes
+		if err := json.Unmarshal(raw, &changes); err != nil {
+			return Changes{}, fmt.Errorf("%s: %w", f.path, err)
+		}
+		if err := checkVersion(changes.Version); err != nil {
+			return Changes{}, fmt.Errorf("%s: %w", f.path, err)
+		}
+		// later changes to a row replace earlier ones
+		for _, c := range changes.Sessions {
+			sessions[c.Data.ID] = c
+		}
+		for _, c := range changes.Timeframes {
+			timeframes[c.Data.ID] = c
+		}
+		for _, c := range changes.Tasks {
+			tasks[c.Data.ID] = c
+		}
+	}
+	return Changes{
+		Version:    SchemaVersion,
+		Sessions:   sortedChanges(sessions),
+		Timeframes: sortedChanges(timeframes),
+		Tasks:      sortedChanges(tasks),
+	}, nil
+}
+
+func sortedChanges[T any](changes map[string]Change[T]) []Change[T] {
+	ids := make([]string, 0, len(changes))
+	for id := range changes {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	sorted := make([]Change[T], len(ids))
+	for i, id := range ids {
+		sorted[i] = changes[id]
+	}
+	return sorted
+}
+
+// DownloadDelta implements Transport by replaying the change files after since.
+// If change files collided at or before since, they are replayed from before the collision, as the caller may have seen only one of them.
+// Replaying changes the caller already applied does not change anything, as they are the same as the original rows of the merge.
+func (t *DirTransport) DownloadDelta(ctx context.Context, since int64) (Delta, error) {
+	files, err := t.changeFiles()
+	if err != nil {
+		return Delta{}, err
+	}
+	delta := Delta{Version: SchemaVersion}
+	if len(files) > 0 {
+		delta.Seq = files[len(files)-1].seq
+	}
+	if i := firstCollision(files); i != -1 && files[i].seq <= since {
+		log.Printf("DirTransport: change files collided at %d, replaying from there", files[i].seq)
+		since = files[i].seq - 1
+	}
+	start, _ := slices.BinarySearchFunc(files, since+1, func(f changeFile, seq int64) int { return cmp.Compare(f.seq, seq) })
+	changes, err := replay(ctx, files[start:])
+	if err != nil {
+		return Delta{}, err
+	}
+	for _, c := range changes.Sessions {
+		delta.Sessions, delta.Tombstones = foldChange(c, getIDSession, "sessions", delta.Sessions, delta.Tombstones)
+	}
+	for _, c := range changes.Timeframes {
+		delta.Timeframes, delta.Tombstones = foldChange(c, getIDTimeframe, "time_frames", delta.Timeframes, delta.Tombstones)
+	}
+	for _, c := range changes.Tasks {
+		delta.Tasks, delta.Tombstones = foldChange(c, getIDTask, "tasks", delta.Tasks, delta.Tombstones)
+	}
+	return delta, nil
+}
+
+// foldChange appends the row if it exists after the change, and a tombstone if it is removed.
+// The deletion time is not recorded in change files, so the tombstones do not have one.
+func foldChange[T any](c Change[T], getID func(T) string, table string, rows []T, tombstones []data.Tombstone) ([]T, []data.Tombstone) {
+	switch c.Operation {
+	case ChangeOperationExist:
+		rows = append(rows, c.Data)
+	case ChangeOperationRemove:
+		tombstones = append(tombstones, data.Tombstone{ID: getID(c.Data), TableName: table})
+	}
+	return rows, tombstones
+}
+
+// ApplyChanges implements Transport by adding a change file.
+// If change files collided, the file also has the latest change to each row since the collision, and the colliding files are removed,
+// so that later deltas do not have to be replayed from before the collision.
+func (t *DirTransport) ApplyChanges(ctx context.Context, changes Changes) (int64, error) {
+	if err := checkChangeIDs(changes); err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Join(t.dir, dirChangesName), 0o700); err != nil {
+		return 0, err
+	}
+	files, err := t.changeFiles()
+	if err != nil {
+		return 0, err
+	}
+	var seq int64 = 1
+	if len(files) > 0 {
+		seq = files[len(files)-1].seq + 1
+	}
+	if err := context.Cause(ctx); err != nil {
+		return 0, err
+	}
+	if id, ok := ctx.Value(dirLockIDKey{}).(string); !ok {
+		return 0, errors.New("ApplyChanges called without Lock")
+	} else if err := t.checkLock(id); err != nil {
+		return 0, err
+	}
+	changes.Version = SchemaVersion
+	var collided []changeFile
+	if i := firstCollision(files); i != -1 {
+		collided = files[i:]
+		since, err := replay(ctx, collided)
+		if err != nil {
+			return 0, err
+		}
+		changes = Changes{
+			Version:    SchemaVersion,
+			Sessions:   overrideChanges(since.Sessions, changes.Sessions, getIDSession),
+			Timeframes: overrideChanges(since.Timeframes, changes.Timeframes, getIDTimeframe),
+			Tasks:      overrideChanges(since.Tasks, changes.Tasks, getIDTask),
+		}
+	}
+	id, err := randomHex()
+	if err != nil {
+		return 0, err
+	}
+	path := filepath.Join(t.dir, dirChangesName, fmt.Sprintf("%020d-%s%s", seq, id, dirChangesExt))
+	if err := writeJSONFile(path, changes); err != nil {
+		return 0, err
+	}
+	for i, f := range collided {
+		if i > 0 && f.seq == collided[i-1].seq || i+1 < len(collided) && f.seq == collided[i+1].seq {
+			log.Printf("DirTransport: removing %s, which collided and is replayed in %s", f.path, path)
+			if err := os.Remove(f.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return 0, err
+			}
+		}
+	}
+	return seq, nil
+}
+
+// overrideChanges returns the changes in base, with the ones to the same rows replaced by those in override.
+func overrideChanges[T any](base, override []Change[T], getID func(T) string) []Change[T] {
+	overridden := map[string]struct{}{}
+	for _, c := range override {
+		overridden[getID(c.Data)] = struct{}{}
+	}
+	result := slices.DeleteFunc(slices.Clone(base), func(c Change[T]) bool {
+		_, ok := overridden[getID(c.Data)]
+		return ok
+	})
+	return append(result, override...)
+}
+
+// writeJSONFile replaces the file at path with v encoded as JSON atomically.
+// The temporary file starts with a dot, so that it is ignored by changeFiles and most file sync tools.
+func writeJSONFile(path string, v interface{}) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	err = json.NewEncoder(file).Encode(v)
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func randomHex() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,261 @@
+package sync_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database/sync"
+)
+
+// newDirTestSetup returns two clients syncing through a shared directory.
+func newDirTestSetup(t *testing.T) (a, b *testClient) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "shared")
+	newClient := func(name string) *testClient {
+		dt := sync.NewDirTransport(dir)
+		dt.Owner = name
+		return &testClient{db: newTestDatabase(t, name+".db"), syncer: sync.Syncer{Transport: dt}}
+	}
+	return newClient("a"), newClient("b")
+}
+
+// forEachTransport runs test with clients syncing through the server, through a shared directory, and through the server with encryption.
+func forEachTransport(t *testing.T, test func(t *testing.T, a, b *testClient)) {
+	t.Run("server", func(t *testing.T) {
+		a, b := newTestSetup(t)
+		test(t, a, b)
+	})
+	t.Run("dir", func(t *testing.T) {
+		a, b := newDirTestSetup(t)
+		test(t, a, b)
+	})
+	t.Run("encrypted", func(t *testing.T) {
+		a, b := newEncryptedTestSetup(t)
+		test(t, a, b)
+	})
+}
+
+func TestDirLock(t *testing.T) {
+	dir := t.TempDir()
+	a, b := sync.NewDirTransport(dir), sync.NewDirTransport(dir)
+	a.Owner, b.Owner = "a", "b"
+	ctx := context.Background()
+
+	_, unlock, err := a.Lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.Lock(ctx); err == nil {
+		t.Fatal("expected b to fail to lock while a holds the lock")
+	}
+	if err := unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	_, unlock, err = b.Lock(ctx)
+	if err != nil {
+		t.Fatalf("expected b to lock after a unlocked: %s", err)
+	}
+	if err := unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirLockStale(t *testing.T) {
+	dir := t.TempDir()
+	// left behind by a client that crashed
+	stale, err := json.Marshal(map[string]interface{}{"ID": "crashed", "Owner": "c", "ExpiresAt": time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "lock.json"), stale, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	_, unlock, err := sync.NewDirTransport(dir).Lock(ctx)
+	if err != nil {
+		t.Fatalf("expected the expired lock to be removed: %s", err)
+	}
+	if err := unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "lock.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the lock file to be removed, got %v", err)
+	}
+}
+
+// lockDir locks dt until the test ends, and returns the locked context.
+func lockDir(t *testing.T, dt *sync.DirTransport) context.Context {
+	t.Helper()
+	ctx, unlock, err := dt.Lock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := unlock(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+	return ctx
+}
+
+func TestDirLockLost(t *testing.T) {
+	dir := t.TempDir()
+	dt := sync.NewDirTransport(dir)
+	ctx, unlock, err := dt.Lock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// e.g. another device removed the lock as stale, as this one could not renew it, and locked
+	takenOver, err := json.Marshal(map[string]interface{}{"ID": "other", "Owner": "b", "ExpiresAt": time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "lock.json"), takenOver, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = dt.ApplyChanges(ctx, sync.Changes{Sessions: []sync.Change[data.Session]{{Operation: sync.ChangeOperationExist, Data: data.Session{ID: "session1", Tags: []string{}}}}})
+	if !errors.Is(err, sync.ErrLockLost) {
+		t.Fatalf("expected %v, got %v", sync.ErrLockLost, err)
+	}
+	if err := unlock(context.Background()); err == nil {
+		t.Fatal("expected unlock to fail after the lock was taken over")
+	}
+}
+
+func TestDirDownloadDelta(t *testing.T) {
+	dir := t.TempDir()
+	dt := sync.NewDirTransport(dir)
+	ctx := lockDir(t, dt)
+	session := data.Session{ID: "session1", Description: "learn Go", Tags: []string{}}
+	seq1, err := dt.ApplyChanges(ctx, sync.Changes{Sessions: []sync.Change[data.Session]{{Operation: sync.ChangeOperationExist, Data: session}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session.Description = "learn Go generics"
+	seq2, err := dt.ApplyChanges(ctx, sync.Changes{
+		Sessions: []sync.Change[data.Session]{{Operation: sync.ChangeOperationExist, Data: session}},
+		Tasks:    []sync.Change[data.Task]{{Operation: sync.ChangeOperationRemove, Data: data.Task{ID: "task1"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq1 != 1 || seq2 != 2 {
+		t.Fatalf("expected sequence numbers 1 and 2, got %d and %d", seq1, seq2)
+	}
+	// e.g. a conflicting copy made by Syncthing
+	if err := os.WriteFile(filepath.Join(dir, "changes", "00000000000000000002.sync-conflict-20250401-090000-ABCDEFG.json"), []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	delta, err := dt.DownloadDelta(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delta.Seq != 2 || len(delta.Sessions) != 1 || delta.Sessions[0].Description != "learn Go generics" {
+		t.Fatalf("expected the latest session at seq 2, got %#v", delta)
+	}
+	if len(delta.Tombstones) != 1 || delta.Tombstones[0].ID != "task1" || delta.Tombstones[0].TableName != "tasks" {
+		t.Fatalf("expected a tombstone for the task, got %#v", delta.Tombstones)
+	}
+
+	delta, err = dt.DownloadDelta(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delta.Seq != 2 || len(delta.Sessions)+len(delta.Tombstones) != 0 {
+		t.Fatalf("expected no changes after seq 2, got %#v", delta)
+	}
+
+	if _, err := dt.ApplyChanges(ctx, sync.Changes{Sessions: []sync.Change[data.Session]{{Operation: sync.ChangeOperationExist}}}); !errors.Is(err, sync.ErrNoID) {
+		t.Fatalf("expected %v, got %v", sync.ErrNoID, err)
+	}
+}
+
+func TestDirCollision(t *testing.T) {
+	dirA, dirB := t.TempDir(), t.TempDir()
+	a, b := sync.NewDirTransport(dirA), sync.NewDirTransport(dirB)
+	ctxA, ctxB := lockDir(t, a), lockDir(t, b)
+	applySession := func(ctx context.Context, dt *sync.DirTransport, id string) int64 {
+		t.Helper()
+		seq, err := dt.ApplyChanges(ctx, sync.Changes{Sessions: []sync.Change[data.Session]{{Operation: sync.ChangeOperationExist, Data: data.Session{ID: id, Tags: []string{}}}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return seq
+	}
+	sessionIDs := func(delta sync.Delta) []string {
+		ids := make([]string, len(delta.Sessions))
+		for i, s := range delta.Sessions {
+			ids[i] = s.ID
+		}
+		return ids
+	}
+	applySession(ctxA, a, "session1")
+	copyChanges := func(from, to string) {
+		t.Helper()
+		entries, err := os.ReadDir(filepath.Join(from, "changes"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(to, "changes"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			raw, err := os.ReadFile(filepath.Join(from, "changes", e.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(to, "changes", e.Name()), raw, 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	copyChanges(dirA, dirB)
+
+	// a and b are offline, so both add seq 2
+	if seqA, seqB := applySession(ctxA, a, "sessionA"), applySession(ctxB, b, "sessionB"); seqA != 2 || seqB != 2 {
+		t.Fatalf("expected both at seq 2, got %d and %d", seqA, seqB)
+	}
+	// a comes back online and gets b's file, having already seen its own
+	copyChanges(dirB, dirA)
+	delta, err := a.DownloadDelta(ctxA, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := sessionIDs(delta); delta.Seq != 2 || !slices.Contains(ids, "sessionB") {
+		t.Fatalf("expected sessionB to be replayed after the collision, got %v at seq %d", ids, delta.Seq)
+	}
+
+	// the next upload folds the colliding files into its own
+	if seq := applySession(ctxA, a, "session3"); seq != 3 {
+		t.Fatalf("expected seq 3, got %d", seq)
+	}
+	entries, err := os.ReadDir(filepath.Join(dirA, "changes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected the colliding files to be removed, got %d files", len(entries))
+	}
+	delta, err = a.DownloadDelta(ctxA, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := sessionIDs(delta); delta.Seq != 3 || !slices.Equal(ids, []string{"session3", "sessionA", "sessionB"}) {
+		t.Fatalf("expected all sessions after seq 1, got %v at seq %d", ids, delta.Seq)
+	}
+	delta, err = a.DownloadDelta(ctxA, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delta.Sessions) != 0 {
+		t.Fatalf("expected no changes after seq 3, got %v", sessionIDs(delta))
+	}
+}
@@ -0,0 +1,370 @@
+package sync
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"nyiyui.ca/jts/data"
+)
+
+var (
+	// ErrNoKeyParams is returned by SealedTransport.KeyParams if no client synced with encryption yet.
+	ErrNoKeyParams = errors.New("encryption is not set up")
+	// ErrKeyParamsSet is returned by SealedTransport.SetKeyParams if another client set them first.
+	ErrKeyParamsSet = errors.New("encryption is already set up")
+	// ErrWrongPassphrase is returned when the passphrase does not match the one the rows were encrypted with.
+	ErrWrongPassphrase = errors.New("wrong passphrase")
+)
+
+// SealedRow is a change to a row encrypted by a client. Only the table and ID are visible to the server.
+type SealedRow struct {
+	Table string
+	ID    string
+	// Blob is the nonce followed by the encrypted change.
+	Blob []byte
+}
+
+// SealedChanges are Changes encrypted by a client, uploaded to POST /database/encrypted/changes.
+type SealedChanges struct {
+	// Version is the schema version of the changes (see SchemaVersion), so that the server can refuse changes from newer clients without decrypting them.
+	Version int
+	Rows    []SealedRow
+}
+
+// SealedDelta is the latest encrypted change to each row changed after some sequence number, downloaded from GET /database/encrypted/changes.
+type SealedDelta struct {
+	Version int
+	Rows    []SealedRow
+	// Seq is the sequence number of the latest change, or zero if there are no encrypted rows.
+	Seq int64
+	// ResetSeq is the sequence number as of which the plaintext rows were removed (see Delta.ResetSeq).
+	ResetSeq int64
+}
+
+// KeyParams is what clients need to derive the key from the passphrase. The server stores it as is.
+type KeyParams struct {
+	// KDF is the key derivation function; only "argon2id" is supported.
+	KDF     string
+	Salt    []byte
+	Time    uint32
+	Memory  uint32 // in KiB
+	Threads uint8
+	// Check is keyCheckPlaintext encrypted with the key, to tell a wrong passphrase apart from corrupted rows.
+	Check []byte
+}
+
+const (
+	kdfArgon2id       = "argon2id"
+	keyCheckPlaintext = "jts"
+	keyCheckAAD       = "jts key check"
+)
+
+// SealedTransport is a transport that stores rows encrypted by clients (see EncryptedTransport).
+type SealedTransport interface {
+	// Lock is like Transport.Lock.
+	Lock(ctx context.Context) (locked context.Context, unlock func(context.Context) error, err error)
+	// KeyParams returns the key parameters, or ErrNoKeyParams.
+	KeyParams(ctx context.Context) (KeyParams, error)
+	// SetKeyParams sets the key parameters, unless they are already set (ErrKeyParamsSet).
+	SetKeyParams(ctx context.Context, params KeyParams) error
+	// DownloadSealed returns the encrypted rows changed after the sequence number since.
+	DownloadSealed(ctx context.Context, since int64) (SealedDelta, error)
+	// ApplySealed stores the encrypted rows, and returns the sequence number afterwards.
+	ApplySealed(ctx context.Context, changes SealedChanges) (int64, error)
+}
+
+// EncryptedTransport is a Transport that encrypts every change with a key derived from a passphrase before it leaves the device, so that the canonical database only has opaque blobs.
+// Merging is done entirely on the client, as with any other transport.
+type EncryptedTransport struct {
+	inner      SealedTransport
+	passphrase string
+	// key is derived from passphrase with params, and kept as deriving it is slow on purpose.
+	key    []byte
+	params KeyParams
+	// settingUp is set if no client set up encryption yet when locking, so that this sync does (see setUp).
+	settingUp bool
+}
+
+var _ Transport = (*EncryptedTransport)(nil)
+
+// NewEncryptedTransport returns a transport that encrypts rows with a key derived from passphrase, and stores them through inner.
+// The first client to sync sets up the key parameters; every other client must use the same passphrase.
+func NewEncryptedTransport(inner SealedTransport, passphrase string) *EncryptedTransport {
+	return &EncryptedTransport{inner: inner, passphrase: passphrase}
+}
+
+// Lock implements Transport, and also derives the key.
+// If no client set up encryption yet, SyncDatabase sets it up after syncing in plaintext (see setUpper).
+func (t *EncryptedTransport) Lock(ctx context.Context) (context.Context, func(context.Context) error, error) {
+	return t.lock(ctx, true)
+}
+
+// lockPreview implements previewLocker: if no client set up encryption yet, the key is derived with new parameters that are not stored.
+// There are no encrypted rows to decrypt with it then.
+func (t *EncryptedTransport) lockPreview(ctx context.Context) (context.Context, func(context.Context) error, error) {
+	return t.lock(ctx, false)
+}
+
+func (t *EncryptedTransport) lock(ctx context.Context, setUp bool) (context.Context, func(context.Context) error, error) {
+	locked, unlock, err := t.inner.Lock(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := t.loadKey(locked, setUp); err != nil {
+		if err2 := unlock(ctx); err2 != nil {
+			return nil, nil, fmt.Errorf("%w (and unlock: %s)", err, err2)
+		}
+		return nil, nil, err
+	}
+	return locked, unlock, nil
+}
+
+// loadKey derives the key with the stored key parameters.
+// If there are none, it derives the key with new ones, which setUp stores if setUp is set.
+func (t *EncryptedTransport) loadKey(ctx context.Context, setUp bool) error {
+	t.settingUp = false
+	params, err := t.inner.KeyParams(ctx)
+	if errors.Is(err, ErrNoKeyParams) {
+		// we hold the lock, so no other client sets them up at the same time
+		params, t.key, err = newKeyParams(t.passphrase)
+		if err != nil {
+			return err
+		}
+		t.params = params
+		t.settingUp = setUp
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("key params: %w", err)
+	}
+	if t.key != nil && subtle.ConstantTimeCompare(params.Salt, t.params.Salt) == 1 && params.Time == t.params.Time && params.Memory == t.params.Memory && params.Threads == t.params.Threads {
+		return nil
+	}
+	key, err := deriveKey(t.passphrase, params)
+	if err != nil {
+		return err
+	}
+	if check, err := open(key, keyCheckAAD, params.Check); err != nil || string(check) != keyCheckPlaintext {
+		return ErrWrongPassphrase
+	}
+	t.key, t.params = key, params
+	return nil
+}
+
+var _ setUpper = (*EncryptedTransport)(nil)
+
+// beforeSetUp implements setUpper: the rows synced in plaintext through inner (if it can sync in plaintext) are removed when encryption is set up.
+func (t *EncryptedTransport) beforeSetUp() Transport {
+	if !t.settingUp {
+		return nil
+	}
+	plaintext, _ := t.inner.(Transport)
+	return plaintext
+}
+
+// setUp implements setUpper by storing the key parameters, if no client set up encryption yet when locking.
+func (t *EncryptedTransport) setUp(ctx context.Context) error {
+	if !t.settingUp {
+		return nil
+	}
+	t.settingUp = false
+	if err := t.inner.SetKeyParams(ctx, t.params); err != nil {
+		t.key = nil
+		return fmt.Errorf("set key params: %w", err)
+	}
+	return nil
+}
+
+// newKeyParams returns new key parameters with a random salt, and the key derived with them.
+func newKeyParams(passphrase string) (KeyParams, []byte, error) {
+	if passphrase == "" {
+		return KeyParams{}, nil, errors.New("passphrase is empty")
+	}
+	params := KeyParams{KDF: kdfArgon2id, Salt: make([]byte, 16), Time: 3, Memory: 64 * 1024, Threads: 4}
+	if _, err := rand.Read(params.Salt); err != nil {
+		return KeyParams{}, nil, err
+	}
+	key, err := deriveKey(passphrase, params)
+	if err != nil {
+		return KeyParams{}, nil, err
+	}
+	params.Check, err = seal(key, keyCheckAAD, []byte(keyCheckPlaintext))
+	if err != nil {
+		return KeyParams{}, nil, err
+	}
+	return params, key, nil
+}
+
+func deriveKey(passphrase string, params KeyParams) ([]byte, error) {
+	if params.KDF != kdfArgon2id {
+		return nil, fmt.Errorf("unsupported key derivation function %q", params.KDF)
+	}
+	if len(params.Salt) < 16 || params.Time == 0 || params.Memory == 0 || params.Threads == 0 {
+		return nil, errors.New("invalid key params")
+	}
+	return argon2.IDKey([]byte(passphrase), params.Salt, params.Time, params.Memory, params.Threads, 32), nil
+}
+
+// seal encrypts plaintext with AES-256-GCM. aad binds the ciphertext to where it is stored, so that the server cannot swap rows.
+func seal(key []byte, aad string, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, []byte(aad)), nil
+}
+
+// open decrypts a blob made by seal.
+func open(key []byte, aad string, blob []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(blob) < aead.NonceSize() {
+		return nil, errors.New("blob is too short")
+	}
+	return aead.Open(nil, blob[:aead.NonceSize()], blob[aead.NonceSize():], []byte(aad))
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func rowAAD(table, id string) string {
+	return "jts row " + table + " " + id
+}
+
+// sealedChange is the plaintext of a SealedRow.
+type sealedChange[T any] struct {
+	// Version is the schema version of Data (see SchemaVersion).
+	Version   int
+	Operation ChangeOperation
+	Data      T
+}
+
+func sealChanges[T any](key []byte, table string, getID func(T) string, changes []Change[T], rows []SealedRow) ([]SealedRow, error) {
+	for _, c := range changes {
+		plaintext, err := json.Marshal(sealedChange[T]{Version: SchemaVersion, Operation: c.Operation, Data: c.Data})
+		if err != nil {
+			return nil, err
+		}
+		id := getID(c.Data)
+		blob, err := seal(key, rowAAD(table, id), plaintext)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, SealedRow{Table: table, ID: id, Blob: blob})
+	}
+	return rows, nil
+}
+
+func openRow[T any](key []byte, row SealedRow, getID func(T) string) (Change[T], error) {
+	plaintext, err := open(key, rowAAD(row.Table, row.ID), row.Blob)
+	if err != nil {
+		return Change[T]{}, fmt.Errorf("decrypt %s %s: %w", row.Table, row.ID, err)
+	}
+	var sc sealedChange[T]
+	if err := json.Unmarshal(plaintext, &sc); err != nil {
+		return Change[T]{}, fmt.Errorf("decode %s %s: %w", row.Table, row.ID, err)
+	}
+	if err := checkVersion(sc.Version); err != nil {
+		return Change[T]{}, err
+	}
+	if getID(sc.Data) != row.ID {
+		return Change[T]{}, fmt.Errorf("%s %s: decrypted row has ID %s", row.Table, row.ID, getID(sc.Data))
+	}
+	return Change[T]{Operation: sc.Operation, Data: sc.Data}, nil
+}
+
+// DownloadDelta implements Transport by decrypting the rows changed after since.
+func (t *EncryptedTransport) DownloadDelta(ctx context.Context, since int64) (Delta, error) {
+	if t.key == nil {
+		return Delta{}, errors.New("not locked")
+	}
+	sd, err := t.inner.DownloadSealed(ctx, since)
+	if err != nil {
+		return Delta{}, err
+	}
+	if err := checkVersion(sd.Version); err != nil {
+		return Delta{}, err
+	}
+	delta := Delta{Version: SchemaVersion, Seq: sd.Seq, ResetSeq: sd.ResetSeq}
+	for _, row := range sd.Rows {
+		var op ChangeOperation
+		switch row.Table {
+		case "sessions":
+			c, err := openRow(t.key, row, getIDSession)
+			if err != nil {
+				return Delta{}, err
+			}
+			op = c.Operation
+			if op == ChangeOperationExist {
+				delta.Sessions = append(delta.Sessions, c.Data)
+			}
+		case "time_frames":
+			c, err := openRow(t.key, row, getIDTimeframe)
+			if err != nil {
+				return Delta{}, err
+			}
+			op = c.Operation
+			if op == ChangeOperationExist {
+				delta.Timeframes = append(delta.Timeframes, c.Data)
+			}
+		case "tasks":
+			c, err := openRow(t.key, row, getIDTask)
+			if err != nil {
+				return Delta{}, err
+			}
+			op = c.Operation
+			if op == ChangeOperationExist {
+				delta.Tasks = append(delta.Tasks, c.Data)
+			}
+		default:
+			return Delta{}, fmt.Errorf("unknown table %q", row.Table)
+		}
+		if op == ChangeOperationRemove {
+			delta.Tombstones = append(delta.Tombstones, data.Tombstone{ID: row.ID, TableName: row.Table})
+		}
+	}
+	return delta, nil
+}
+
+// ApplyChanges implements Transport by encrypting the changes and storing them.
+func (t *EncryptedTransport) ApplyChanges(ctx context.Context, changes Changes) (int64, error) {
+	if t.key == nil {
+		return 0, errors.New("not locked")
+	}
+	if err := checkChangeIDs(changes); err != nil {
+		return 0, err
+	}
+	sc := SealedChanges{Version: SchemaVersion}
+	var err error
+	sc.Rows, err = sealChanges(t.key, "sessions", getIDSession, changes.Sessions, sc.Rows)
+	if err != nil {
+		return 0, err
+	}
+	sc.Rows, err = sealChanges(t.key, "time_frames", getIDTimeframe, changes.Timeframes, sc.Rows)
+	if err != nil {
+		return 0, err
+	}
+	sc.Rows, err = sealChanges(t.key, "tasks", getIDTask, changes.Tasks, sc.Rows)
+	if err != nil {
+		return 0, err
+	}
+	return t.inner.ApplySealed(ctx, sc)
+}
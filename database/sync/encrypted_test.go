@@ -0,0 +1,191 @@
+package sync_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+	"nyiyui.ca/jts/database/sync"
+)
+
+const testPassphrase = "correct horse battery staple"
+
+// newEncryptedTestSetup returns two clients syncing through the server with encryption.
+func newEncryptedTestSetup(t *testing.T) (a, b *testClient) {
+	t.Helper()
+	a, b, _ = newEncryptedTestServer(t)
+	return a, b
+}
+
+func newEncryptedTestServer(t *testing.T) (a, b *testClient, serverDB *database.Database) {
+	t.Helper()
+	sc, serverDB := newTestServer(t)
+	a = &testClient{db: newTestDatabase(t, "a.db"), syncer: sync.Syncer{Transport: sync.NewEncryptedTransport(sc, testPassphrase)}}
+	b = &testClient{db: newTestDatabase(t, "b.db"), syncer: sync.Syncer{Transport: sync.NewEncryptedTransport(sc, testPassphrase)}}
+	return a, b, serverDB
+}
+
+func TestEncryptedServerHasNoPlaintext(t *testing.T) {
+	a, b, serverDB := newEncryptedTestServer(t)
+	id, err := a.db.AddSession(data.Session{Description: "meeting with Acme", Notes: "Acme is moving to the new office", Tags: []string{"acme"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.sync(t)
+	b.sync(t)
+	session, err := b.db.GetSession(id)
+	if err != nil || session.Notes != "Acme is moving to the new office" {
+		t.Fatalf("expected b to have the session, got %#v, %v", session, err)
+	}
+
+	var n int
+	if err := serverDB.DB.Get(&n, "SELECT COUNT(*) FROM sessions"); err != nil || n != 0 {
+		t.Fatalf("expected no plaintext sessions on the server, got %d, %v", n, err)
+	}
+	rows, _, err := serverDB.EncryptedRowsSince(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) == 0 {
+		t.Fatal("expected encrypted rows on the server")
+	}
+	for _, row := range rows {
+		if bytes.Contains(bytes.ToLower(row.Blob), []byte("acme")) {
+			t.Errorf("%s %s has plaintext", row.TableName, row.ID)
+		}
+	}
+}
+
+func TestEncryptedWrongPassphrase(t *testing.T) {
+	sc, _ := newTestServer(t)
+	a := &testClient{db: newTestDatabase(t, "a.db"), syncer: sync.Syncer{Transport: sync.NewEncryptedTransport(sc, testPassphrase)}}
+	a.sync(t)
+	c := &testClient{db: newTestDatabase(t, "c.db"), syncer: sync.Syncer{Transport: sync.NewEncryptedTransport(sc, "wrong")}}
+	if _, _, err := c.syncer.SyncDatabase(context.Background(), c.state, c.db, nil, nil); !errors.Is(err, sync.ErrWrongPassphrase) {
+		t.Fatalf("expected %v, got %v", sync.ErrWrongPassphrase, err)
+	}
+	// the lock is released
+	a.sync(t)
+
+	// a client without the passphrase must not upload plaintext
+	if _, err := c.db.AddSession(data.Session{Description: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	c.syncer = sync.Syncer{Transport: sc}
+	if _, _, err := c.syncer.SyncDatabase(context.Background(), c.state, c.db, nil, nil); err == nil {
+		t.Fatal("expected a client without encryption to be rejected")
+	}
+}
+
+func TestEnableEncryption(t *testing.T) {
+	sc, serverDB := newTestServer(t)
+	a := &testClient{db: newTestDatabase(t, "a.db"), syncer: sync.Syncer{Transport: sc}}
+	b := &testClient{db: newTestDatabase(t, "b.db"), syncer: sync.Syncer{Transport: sc}}
+	id, err := a.db.AddSession(data.Session{Description: "learn Go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.sync(t)
+	b.sync(t)
+	// the changelog is pruned, so a only has the row itself
+	if err := a.db.PruneChangelog(1 << 62); err != nil {
+		t.Fatal(err)
+	}
+
+	// both switch to encryption, keeping their sync state
+	a.syncer = sync.Syncer{Transport: sync.NewEncryptedTransport(sc, testPassphrase)}
+	b.syncer = sync.Syncer{Transport: sync.NewEncryptedTransport(sc, testPassphrase)}
+	a.sync(t)
+	if _, err := serverDB.GetSession(id); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected the plaintext row to be removed from the server, got %v", err)
+	}
+	c := &testClient{db: newTestDatabase(t, "c.db"), syncer: sync.Syncer{Transport: sync.NewEncryptedTransport(sc, testPassphrase)}}
+	c.sync(t)
+	if _, err := c.db.GetSession(id); err != nil {
+		t.Fatalf("expected a new client to get the rows uploaded when encryption was enabled: %s", err)
+	}
+
+	if err := b.db.EditSessionProperties(data.Session{ID: id, Description: "learn Go generics"}); err != nil {
+		t.Fatal(err)
+	}
+	b.sync(t)
+	a.sync(t)
+	session, err := a.db.GetSession(id)
+	if err != nil || session.Description != "learn Go generics" {
+		t.Fatalf("expected b's edit on a, got %#v, %v", session, err)
+	}
+}
+
+// sealedOnly hides that a SealedTransport can also sync in plaintext.
+type sealedOnly struct {
+	sync.SealedTransport
+}
+
+// addSession adds a session to db, and returns its ID.
+func addSession(t *testing.T, db *database.Database, description string) string {
+	t.Helper()
+	id, err := db.AddSession(data.Session{Description: description})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestEnableEncryptionKeepsRows(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		// sealed wraps the transport of the client setting up encryption.
+		sealed func(sc *sync.ServerClient) sync.SealedTransport
+	}{
+		// rows only on the server are synced in plaintext before they are removed
+		{"plaintext first", func(sc *sync.ServerClient) sync.SealedTransport { return sc }},
+		// rows removed before the first client got them are uploaded again by the others
+		{"reset", func(sc *sync.ServerClient) sync.SealedTransport { return sealedOnly{sc} }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			sc, serverDB := newTestServer(t)
+			a := &testClient{db: newTestDatabase(t, "a.db"), syncer: sync.Syncer{Transport: sc}}
+			b := &testClient{db: newTestDatabase(t, "b.db"), syncer: sync.Syncer{Transport: sc}}
+			ids := []string{addSession(t, a.db, "on a")}
+			a.sync(t)
+			ids = append(ids, addSession(t, b.db, "on b"))
+			b.sync(t)
+			if test.name == "plaintext first" {
+				// e.g. made in the web UI
+				ids = append(ids, addSession(t, serverDB, "on the server"))
+			}
+
+			a.syncer = sync.Syncer{Transport: sync.NewEncryptedTransport(test.sealed(sc), testPassphrase)}
+			a.sync(t)
+			b.syncer = sync.Syncer{Transport: sync.NewEncryptedTransport(sc, testPassphrase)}
+			b.sync(t)
+			a.sync(t)
+			for _, c := range []*testClient{a, b} {
+				for _, id := range ids {
+					if _, err := c.db.GetSession(id); err != nil {
+						t.Errorf("session %s: %s", id, err)
+					}
+				}
+			}
+			for _, id := range ids {
+				if _, err := serverDB.GetSession(id); !errors.Is(err, sql.ErrNoRows) {
+					t.Errorf("expected session %s to be removed from the server in plaintext, got %v", id, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSetKeyParamsNeedsLease(t *testing.T) {
+	sc, serverDB := newTestServer(t)
+	if err := sc.SetKeyParams(context.Background(), sync.KeyParams{KDF: "argon2id"}); err == nil {
+		t.Fatal("expected setting up encryption without a lease to be rejected")
+	}
+	if _, err := serverDB.EncryptionParams(); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected encryption not to be set up, got %v", err)
+	}
+}
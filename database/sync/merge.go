@@ -1,10 +1,12 @@
 package sync
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"slices"
 	"sort"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"nyiyui.ca/jts/data"
@@ -15,6 +17,7 @@ type ExportedDatabase struct {
 	Sessions   []data.Session
 	Timeframes []data.Timeframe
 	Tasks      []data.Task
+	Tombstones []data.Tombstone
 }
 
 func Export(d *database.Database) (ExportedDatabase, error) {
@@ -23,6 +26,10 @@ func Export(d *database.Database) (ExportedDatabase, error) {
 	if err != nil {
 		return ExportedDatabase{}, err
 	}
+	err = database.LoadSessionTags(d.DB, ed.Sessions)
+	if err != nil {
+		return ExportedDatabase{}, err
+	}
 	err = d.DB.Select(&ed.Timeframes, "SELECT * FROM time_frames")
 	if err != nil {
 		return ExportedDatabase{}, err
@@ -31,6 +38,10 @@ func Export(d *database.Database) (ExportedDatabase, error) {
 	if err != nil {
 		return ExportedDatabase{}, err
 	}
+	err = d.DB.Select(&ed.Tombstones, "SELECT * FROM tombstones")
+	if err != nil {
+		return ExportedDatabase{}, err
+	}
 	return ed, nil
 }
 
@@ -61,21 +72,39 @@ func replace(tx *sqlx.Tx, ed ExportedDatabase) error {
 	if err != nil {
 		return err
 	}
+	_, err = tx.Exec("DELETE FROM tombstones")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM session_tags")
+	if err != nil {
+		return err
+	}
 
 	for _, s := range ed.Sessions {
-		_, err = tx.Exec("INSERT INTO sessions (id, description, notes, task_id) VALUES (?, ?, ?, ?)", s.ID, s.Description, s.Notes, s.TaskID)
+		_, err = tx.Exec("INSERT INTO sessions (id, description, notes, task_id, modified_at) VALUES (?, ?, ?, ?, ?)", s.ID, s.Description, s.Notes, s.TaskID, s.ModifiedAt)
+		if err != nil {
+			return err
+		}
+		err = database.SetTags(tx, s.ID, s.Tags)
 		if err != nil {
 			return err
 		}
 	}
 	for _, tf := range ed.Timeframes {
-		_, err = tx.Exec("INSERT INTO time_frames (id, session_id, start_time, end_time, done) VALUES (?, ?, ?, ?, ?)", tf.ID, tf.SessionID, tf.Start, tf.End, tf.Done)
+		_, err = tx.Exec("INSERT INTO time_frames (id, session_id, start_time, end_time, done, modified_at) VALUES (?, ?, ?, ?, ?, ?)", tf.ID, tf.SessionID, tf.Start, tf.End, tf.Done, tf.ModifiedAt)
 		if err != nil {
 			return err
 		}
 	}
 	for _, tf := range ed.Tasks {
-		_, err = tx.Exec("INSERT INTO tasks (id, description) VALUES (?, ?)", tf.ID, tf.Description)
+		_, err = tx.Exec("INSERT INTO tasks (id, description, status, due, estimate, modified_at) VALUES (?, ?, ?, ?, ?, ?)", tf.ID, tf.Description, taskStatus(tf.Status), tf.Due, tf.Estimate, tf.ModifiedAt)
+		if err != nil {
+			return err
+		}
+	}
+	for _, t := range ed.Tombstones {
+		_, err = tx.Exec("INSERT INTO tombstones (id, table_name, deleted_at) VALUES (?, ?, ?)", t.ID, t.TableName, t.DeletedAt)
 		if err != nil {
 			return err
 		}
@@ -83,6 +112,8 @@ func replace(tx *sqlx.Tx, ed ExportedDatabase) error {
 	return nil
 }
 
+// ImportChanges applies the changes to the database.
+// Changes in a newer schema version than SchemaVersion are rejected, as their fields could not all be stored.
 func ImportChanges(d *database.Database, c Changes) error {
 	log.Printf("importing changes %#v", c)
 	tx := d.DB.MustBegin()
@@ -93,39 +124,84 @@ func ImportChanges(d *database.Database, c Changes) error {
 	return tx.Commit()
 }
 
+// importChanges writes every column of each changed row, so that applying a change never clears a column it does not mention.
+// Existing rows are updated in place (instead of REPLACE, which deletes and reinserts them), so that columns outside the synced types are kept.
 func importChanges(tx *sqlx.Tx, c Changes) error {
+	if err := checkVersion(c.Version); err != nil {
+		return err
+	}
 	var err error
-	for i, ch := range c.Sessions {
-		log.Printf("importing session change %d: %#v", i, ch)
+	// tasks first, as sessions refer to them
+	for i, ch := range c.Tasks {
+		log.Printf("importing task change %d: %#v", i, ch)
+		if ch.Data.ID == "" {
+			return fmt.Errorf("task change %d: %w", i, ErrNoID)
+		}
 		switch ch.Operation {
 		case ChangeOperationExist:
-			_, err = tx.Exec("REPLACE INTO sessions (id, description, notes) VALUES (?, ?, ?)", ch.Data.ID, ch.Data.Description, ch.Data.Notes)
+			_, err = tx.Exec(`INSERT INTO tasks (id, description, status, due, estimate, modified_at) VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET description = excluded.description, status = excluded.status, due = excluded.due, estimate = excluded.estimate, modified_at = excluded.modified_at`,
+				ch.Data.ID, ch.Data.Description, taskStatus(ch.Data.Status), ch.Data.Due, ch.Data.Estimate, ch.Data.ModifiedAt)
+			if err == nil {
+				err = database.Untombstone(tx, "tasks", ch.Data.ID)
+			}
 		case ChangeOperationRemove:
-			_, err = tx.Exec("DELETE FROM sessions WHERE id = ?", ch.Data.ID)
+			_, err = tx.Exec("DELETE FROM tasks WHERE id = ?", ch.Data.ID)
+			if err == nil {
+				err = database.Tombstone(tx, "tasks", ch.Data.ID)
+			}
 		}
 		if err != nil {
 			return fmt.Errorf("change %d (%#v): %w", i, ch, err)
 		}
 	}
-	for i, ch := range c.Timeframes {
-		log.Printf("importing timeframe change %d: %#v", i, ch)
+	for i, ch := range c.Sessions {
+		log.Printf("importing session change %d: %#v", i, ch)
+		if ch.Data.ID == "" {
+			return fmt.Errorf("session change %d: %w", i, ErrNoID)
+		}
 		switch ch.Operation {
 		case ChangeOperationExist:
-			_, err = tx.Exec("REPLACE INTO time_frames (id, session_id, start_time, end_time) VALUES (?, ?, ?, ?)", ch.Data.ID, ch.Data.SessionID, ch.Data.Start, ch.Data.End)
+			_, err = tx.Exec(`INSERT INTO sessions (id, description, notes, task_id, modified_at) VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET description = excluded.description, notes = excluded.notes, task_id = excluded.task_id, modified_at = excluded.modified_at`,
+				ch.Data.ID, ch.Data.Description, ch.Data.Notes, ch.Data.TaskID, ch.Data.ModifiedAt)
+			if err == nil {
+				err = database.SetTags(tx, ch.Data.ID, ch.Data.Tags)
+			}
+			if err == nil {
+				err = database.Untombstone(tx, "sessions", ch.Data.ID)
+			}
 		case ChangeOperationRemove:
-			_, err = tx.Exec("DELETE FROM time_frames WHERE id = ?", ch.Data.ID)
+			_, err = tx.Exec("DELETE FROM session_tags WHERE session_id = ?", ch.Data.ID)
+			if err == nil {
+				_, err = tx.Exec("DELETE FROM sessions WHERE id = ?", ch.Data.ID)
+			}
+			if err == nil {
+				err = database.Tombstone(tx, "sessions", ch.Data.ID)
+			}
 		}
 		if err != nil {
 			return fmt.Errorf("change %d (%#v): %w", i, ch, err)
 		}
 	}
-	for i, ch := range c.Tasks {
-		log.Printf("importing task change %d: %#v", i, ch)
+	for i, ch := range c.Timeframes {
+		log.Printf("importing timeframe change %d: %#v", i, ch)
+		if ch.Data.ID == "" {
+			return fmt.Errorf("timeframe change %d: %w", i, ErrNoID)
+		}
 		switch ch.Operation {
 		case ChangeOperationExist:
-			_, err = tx.Exec("REPLACE INTO tasks (id, description) VALUES (?, ?)", ch.Data.ID, ch.Data.Description)
+			_, err = tx.Exec(`INSERT INTO time_frames (id, session_id, start_time, end_time, done, modified_at) VALUES (?, ?, ?, ?, ?, ?)
+ON CONFLICT (id) DO UPDATE SET session_id = excluded.session_id, start_time = excluded.start_time, end_time = excluded.end_time, done = excluded.done, modified_at = excluded.modified_at`,
+				ch.Data.ID, ch.Data.SessionID, ch.Data.Start, ch.Data.End, ch.Data.Done, ch.Data.ModifiedAt)
+			if err == nil {
+				err = database.Untombstone(tx, "time_frames", ch.Data.ID)
+			}
 		case ChangeOperationRemove:
-			_, err = tx.Exec("DELETE FROM tasks WHERE id = ?", ch.Data.ID)
+			_, err = tx.Exec("DELETE FROM time_frames WHERE id = ?", ch.Data.ID)
+			if err == nil {
+				err = database.Tombstone(tx, "time_frames", ch.Data.ID)
+			}
 		}
 		if err != nil {
 			return fmt.Errorf("change %d (%#v): %w", i, ch, err)
@@ -142,9 +218,58 @@ type MergeConflicts struct {
 
 type MergeConflict[T any] struct {
 	Original, Local, Remote T
+	// LocalRemoved and RemoteRemoved are set when the row was deleted on that side (delete-vs-edit conflict).
+	// The corresponding Local or Remote is then the zero value.
+	LocalRemoved, RemoteRemoved bool
+	// Fields are the names of the fields that both sides changed differently.
+	// The other fields are already merged, and are the same in Local and Remote.
+	// Empty for delete-vs-edit conflicts.
+	Fields []string
+}
+
+// SchemaVersion is the version of the change format, i.e. the fields of data.Session, data.Timeframe and data.Task that are synced.
+// It must be increased when a synced field is added, so that peers that cannot store the field refuse the changes instead of dropping it.
+// Version 0 (changes from before versions were recorded) has the same fields as version 1.
+// Version 2 added ModifiedAt.
+const SchemaVersion = 2
+
+var (
+	// ErrNewerSchema is returned for changes in a newer schema version than SchemaVersion.
+	ErrNewerSchema = errors.New("changes are in a newer schema version; update jts")
+	// ErrNoID is returned for changes to rows without an ID (e.g. unresolved conflicts).
+	ErrNoID = errors.New("change has no ID")
+)
+
+func checkVersion(version int) error {
+	if version > SchemaVersion {
+		return fmt.Errorf("%w (version %d, this is version %d)", ErrNewerSchema, version, SchemaVersion)
+	}
+	return nil
+}
+
+// checkChangeIDs returns ErrNoID if a change has no ID, as ImportChanges would.
+func checkChangeIDs(changes Changes) error {
+	for i, c := range changes.Sessions {
+		if c.Data.ID == "" {
+			return fmt.Errorf("session change %d: %w", i, ErrNoID)
+		}
+	}
+	for i, c := range changes.Timeframes {
+		if c.Data.ID == "" {
+			return fmt.Errorf("timeframe change %d: %w", i, ErrNoID)
+		}
+	}
+	for i, c := range changes.Tasks {
+		if c.Data.ID == "" {
+			return fmt.Errorf("task change %d: %w", i, ErrNoID)
+		}
+	}
+	return nil
 }
 
 type Changes struct {
+	// Version is the schema version the changes are in (see SchemaVersion).
+	Version    int
 	Sessions   []Change[data.Session]
 	Timeframes []Change[data.Timeframe]
 	Tasks      []Change[data.Task]
@@ -173,81 +298,179 @@ func (co ChangeOperation) String() string {
 	}
 }
 
+// MergeOptions changes how Merge merges rows.
+type MergeOptions struct {
+	// MergeNotes merges the notes of a session line by line (like diff3) when both sides changed them,
+	// so that edits to different paragraphs do not conflict.
+	MergeNotes bool
+	// Policies resolve the conflicts that remain without asking the user.
+	Policies Policies
+}
+
+// Merge merges with the default MergeOptions.
 func Merge(original, local, remote ExportedDatabase) (Changes, MergeConflicts) {
+	return MergeWithOptions(original, local, remote, MergeOptions{})
+}
+
+// MergeWithOptions returns the changes to apply to remote, and the conflicts to resolve.
+// Rows are merged field by field against original, so only fields changed differently on both sides conflict.
+// Conflicts are then resolved by opts.Policies where possible.
+func MergeWithOptions(original, local, remote ExportedDatabase, opts MergeOptions) (Changes, MergeConflicts) {
+	localTombstones := tombstoneSets(local.Tombstones)
+	remoteTombstones := tombstoneSets(remote.Tombstones)
 	// sessions
-	changesS, conflictsS := mergeSlice(mergeSession, getIDSession, original.Sessions, local.Sessions, remote.Sessions)
+	changesS, conflictsS := mergeSliceTombstones(sessionMerger(opts), getIDSession, original.Sessions, local.Sessions, remote.Sessions, localTombstones["sessions"], remoteTombstones["sessions"])
 	// timeframes
-	changesT, conflictsT := mergeSlice(mergeTimeframe, getIDTimeframe, original.Timeframes, local.Timeframes, remote.Timeframes)
+	changesT, conflictsT := mergeSliceTombstones(mergeTimeframe, getIDTimeframe, original.Timeframes, local.Timeframes, remote.Timeframes, localTombstones["time_frames"], remoteTombstones["time_frames"])
 	// tasks
-	changesTasks, conflictsTasks := mergeSlice(mergeTask, getIDTask, original.Tasks, local.Tasks, remote.Tasks)
-	return Changes{changesS, changesT, changesTasks}, MergeConflicts{conflictsS, conflictsT, conflictsTasks}
+	changesTasks, conflictsTasks := mergeSliceTombstones(mergeTask, getIDTask, original.Tasks, local.Tasks, remote.Tasks, localTombstones["tasks"], remoteTombstones["tasks"])
+	changes := Changes{SchemaVersion, changesS, changesT, changesTasks}
+	conflicts := MergeConflicts{conflictsS, conflictsT, conflictsTasks}
+	return opts.Policies.resolve(changes, conflicts)
+}
+
+// tombstoneSets returns the set of deleted IDs for each table.
+func tombstoneSets(tombstones []data.Tombstone) map[string]map[string]struct{} {
+	sets := map[string]map[string]struct{}{}
+	for _, t := range tombstones {
+		if sets[t.TableName] == nil {
+			sets[t.TableName] = map[string]struct{}{}
+		}
+		sets[t.TableName][t.ID] = struct{}{}
+	}
+	return sets
 }
 
 func mergeSlice[T any](merge func(original, local, remote T) ([]Change[T], []MergeConflict[T]), getID func(T) string, original, local, remote []T) ([]Change[T], []MergeConflict[T]) {
+	return mergeSliceTombstones(merge, getID, original, local, remote, nil, nil)
+}
+
+// mergeSliceTombstones merges rows by ID and returns changes to apply to remote.
+// A row is considered deleted on a side if it is in original but missing on that side, or if that side has a tombstone for it.
+func mergeSliceTombstones[T any](merge func(original, local, remote T) ([]Change[T], []MergeConflict[T]), getID func(T) string, original, local, remote []T, localTombstones, remoteTombstones map[string]struct{}) ([]Change[T], []MergeConflict[T]) {
 	var changes []Change[T]
 	var conflicts []MergeConflict[T]
-	sort.Slice(local, func(i, j int) bool {
-		return getID(local[i]) < getID(local[j])
-	})
-	sort.Slice(remote, func(i, j int) bool {
-		return getID(remote[i]) < getID(remote[j])
-	})
-	log.Printf("remote length %d", len(remote))
-	var i int
-	for j := 0; i < len(local) && j < len(remote); {
-		log.Printf("comparing local=%s remote=%s", getID(local[i]), getID(remote[j]))
-		if getID(local[i]) == getID(remote[j]) {
-			log.Printf("merge %s", getID(local[i]))
-			originalIndex := slices.IndexFunc(original, func(s T) bool {
-				return getID(s) == getID(local[i])
-			})
-			chs, cfs := merge(original[originalIndex], local[i], remote[j])
+	originalM := makeIDMap(getID, original)
+	localM := makeIDMap(getID, local)
+	remoteM := makeIDMap(getID, remote)
+	ids := make([]string, 0, len(localM)+len(remoteM))
+	for id := range localM {
+		ids = append(ids, id)
+	}
+	for id := range remoteM {
+		if _, ok := localM[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		o, inO := originalM[id]
+		l, inL := localM[id]
+		r, inR := remoteM[id]
+		_, localTombstoned := localTombstones[id]
+		_, remoteTombstoned := remoteTombstones[id]
+		switch {
+		case inL && inR:
+			// if not in original, o is the zero value, so this will conflict unless local and remote are equal
+			chs, cfs := merge(o, l, r)
 			changes = append(changes, chs...)
 			conflicts = append(conflicts, cfs...)
-			i++
-			j++
-		} else if getID(local[i]) < getID(remote[j]) {
-			// remote is missing local[i]
-			log.Printf("remote is missing local=%s", getID(local[i]))
-			changes = append(changes, Change[T]{
-				ChangeOperationExist,
-				local[i],
-			})
-			i++
-		} else {
-			// local is missing remote[j]
+		case inL && (inO || remoteTombstoned):
+			// remote deleted
+			if inO && !changed(merge, o, l) {
+				log.Printf("remote removed %s", id)
+				continue
+			}
+			log.Printf("remote removed %s, but local changed it", id)
+			conflicts = append(conflicts, MergeConflict[T]{Original: o, Local: l, RemoteRemoved: true})
+		case inL:
+			// remote is missing local
+			log.Printf("remote is missing local=%s", id)
+			changes = append(changes, Change[T]{ChangeOperationExist, l})
+		case inR && (inO || localTombstoned):
+			// local deleted
+			if inO && !changed(merge, o, r) {
+				log.Printf("local removed %s", id)
+				changes = append(changes, Change[T]{ChangeOperationRemove, r})
+				continue
+			}
+			log.Printf("local removed %s, but remote changed it", id)
+			conflicts = append(conflicts, MergeConflict[T]{Original: o, Remote: r, LocalRemoved: true})
+		default:
+			// local is missing remote
 			// no changes needed to remote
-			log.Printf("local is missing remote=%s", getID(remote[j]))
-			j++
+			log.Printf("local is missing remote=%s", id)
 		}
 	}
-	for ; i < len(local); i++ {
-		log.Printf("() remote is missing local=%s", getID(local[i]))
-		changes = append(changes, Change[T]{
-			ChangeOperationExist,
-			local[i],
-		})
-	}
 	return changes, conflicts
 }
 
-// merge returns changes to apply to remote.
-func merge[T any](equal func(a, b T) bool, original, local, remote T) ([]Change[T], []MergeConflict[T]) {
-	if equal(local, remote) {
-		return nil, nil
+func makeIDMap[T any](getID func(T) string, s []T) map[string]T {
+	m := make(map[string]T, len(s))
+	for _, v := range s {
+		m[getID(v)] = v
+	}
+	return m
+}
+
+// changed reports whether v differs from original, according to merge.
+func changed[T any](merge func(original, local, remote T) ([]Change[T], []MergeConflict[T]), original, v T) bool {
+	chs, cfs := merge(original, v, original)
+	return len(chs) > 0 || len(cfs) > 0
+}
+
+// mergeRow returns changes to apply to remote.
+// local and remote are the sides after merging each field with mergeField, and fields are the fields that conflicted.
+// A conflict thus only differs in the conflicting fields.
+func mergeRow[T any](equal func(a, b T) bool, original, oldRemote, local, remote T, fields []string) ([]Change[T], []MergeConflict[T]) {
+	if len(fields) > 0 {
+		log.Printf("merge conflict in %v: original=%#v, local=%#v, remote=%#v", fields, original, local, remote)
+		return nil, []MergeConflict[T]{
+			{Original: original, Local: local, Remote: remote, Fields: fields},
+		}
 	}
-	if equal(original, local) {
+	if equal(local, oldRemote) {
 		return nil, nil
 	}
-	if equal(original, remote) {
-		return []Change[T]{
-			{ChangeOperationExist, local},
-		}, nil
+	return []Change[T]{
+		{ChangeOperationExist, local},
+	}, nil
+}
+
+// mergeField merges one field of a row.
+// If both sides changed the field differently, the name is added to conflicts, and local and remote are left as is.
+// Otherwise, local and remote are both set to the merged value.
+func mergeField[V any](equal func(a, b V) bool, name string, original V, local, remote *V, conflicts *[]string) {
+	switch {
+	case equal(*local, *remote), equal(original, *remote):
+		*remote = *local
+	case equal(original, *local):
+		*local = *remote
+	default:
+		*conflicts = append(*conflicts, name)
 	}
-	log.Printf("merge conflict: original=%#v, local=%#v, remote=%#v", original, local, remote)
-	return nil, []MergeConflict[T]{
-		{original, local, remote},
+}
+
+// mergeModifiedAt sets both modification times to the later one if the row merged without conflicts, as the merged row has the edits of both sides.
+// In a conflict, each side keeps its own modification time, for PolicyLatest.
+func mergeModifiedAt(local, remote **time.Time, conflicts []string) {
+	if len(conflicts) > 0 {
+		return
 	}
+	latest := laterTime(*local, *remote)
+	*local, *remote = latest, latest
+}
+
+// laterTime returns the later of a and b, where nil is earlier than any time.
+func laterTime(a, b *time.Time) *time.Time {
+	if a == nil || (b != nil && b.After(*a)) {
+		return b
+	}
+	return a
+}
+
+func equalComparable[V comparable](a, b V) bool {
+	return a == b
 }
 
 func getIDSession(s data.Session) string {
@@ -258,24 +481,89 @@ func getIDTimeframe(tf data.Timeframe) string {
 	return tf.ID
 }
 
+// taskStatus defaults the status of tasks from clients that predate task statuses to open.
+func taskStatus(s data.TaskStatus) data.TaskStatus {
+	if s == "" {
+		return data.TaskStatusOpen
+	}
+	return s
+}
+
 func getIDTask(t data.Task) string {
 	return t.ID
 }
 
-func mergeSession(original, local, remote data.Session) ([]Change[data.Session], []MergeConflict[data.Session]) {
-	return merge[data.Session](func(a, b data.Session) bool {
-		return a.Equal(b)
-	}, original, local, remote)
+// mergeSession merges sessions with the default MergeOptions.
+var mergeSession = sessionMerger(MergeOptions{})
+
+// sessionMerger returns a function that merges the columns of sessions field by field, and the tags as a set.
+// Tags never conflict: the merged tags are used for the change and for both sides of any conflict.
+func sessionMerger(opts MergeOptions) func(original, local, remote data.Session) ([]Change[data.Session], []MergeConflict[data.Session]) {
+	return func(original, local, remote data.Session) ([]Change[data.Session], []MergeConflict[data.Session]) {
+		var fields []string
+		l, r := local, remote
+		mergeField(equalComparable, "Description", original.Description, &l.Description, &r.Description, &fields)
+		if opts.MergeNotes && l.Notes != r.Notes && l.Notes != original.Notes && r.Notes != original.Notes {
+			if notes, ok := mergeLines(original.Notes, l.Notes, r.Notes); ok {
+				l.Notes, r.Notes = notes, notes
+			}
+		}
+		mergeField(equalComparable, "Notes", original.Notes, &l.Notes, &r.Notes, &fields)
+		mergeField(data.EqualStringPtr, "TaskID", original.TaskID, &l.TaskID, &r.TaskID, &fields)
+		tags := mergeTags(original.Tags, local.Tags, remote.Tags)
+		l.Tags, r.Tags = tags, tags
+		mergeModifiedAt(&l.ModifiedAt, &r.ModifiedAt, fields)
+		return mergeRow(equalSession, original, remote, l, r, fields)
+	}
+}
+
+// equalSession reports whether the synced fields of a and b are equal, given that the tags of a are normalized.
+func equalSession(a, b data.Session) bool {
+	return a.EqualProperties(b) && slices.Equal(a.Tags, data.NormalizeTags(b.Tags))
+}
+
+// mergeTags merges tag sets: tags added on either side are added, and tags removed on either side are removed.
+func mergeTags(original, local, remote []string) []string {
+	original = data.NormalizeTags(original)
+	local = data.NormalizeTags(local)
+	remote = data.NormalizeTags(remote)
+	var merged []string
+	for _, tag := range data.NormalizeTags(slices.Concat(original, local, remote)) {
+		inO := slices.Contains(original, tag)
+		inL := slices.Contains(local, tag)
+		inR := slices.Contains(remote, tag)
+		if inO && (!inL || !inR) {
+			// removed on a side
+			continue
+		}
+		merged = append(merged, tag)
+	}
+	if merged == nil {
+		merged = []string{}
+	}
+	return merged
 }
 
+// mergeTimeframe merges timeframes field by field.
 func mergeTimeframe(original, local, remote data.Timeframe) ([]Change[data.Timeframe], []MergeConflict[data.Timeframe]) {
-	return merge[data.Timeframe](func(a, b data.Timeframe) bool {
-		return a.Equal(b)
-	}, original, local, remote)
+	var fields []string
+	l, r := local, remote
+	mergeField(equalComparable, "SessionID", original.SessionID, &l.SessionID, &r.SessionID, &fields)
+	mergeField(time.Time.Equal, "Start", original.Start, &l.Start, &r.Start, &fields)
+	mergeField(data.EqualTimePtr, "End", original.End, &l.End, &r.End, &fields)
+	mergeField(equalComparable, "Done", original.Done, &l.Done, &r.Done, &fields)
+	mergeModifiedAt(&l.ModifiedAt, &r.ModifiedAt, fields)
+	return mergeRow(data.Timeframe.Equal, original, remote, l, r, fields)
 }
 
+// mergeTask merges tasks field by field.
 func mergeTask(original, local, remote data.Task) ([]Change[data.Task], []MergeConflict[data.Task]) {
-	return merge[data.Task](func(a, b data.Task) bool {
-		return a.Equal(b)
-	}, original, local, remote)
+	var fields []string
+	l, r := local, remote
+	mergeField(equalComparable, "Description", original.Description, &l.Description, &r.Description, &fields)
+	mergeField(equalComparable, "Status", original.Status, &l.Status, &r.Status, &fields)
+	mergeField(data.EqualTimePtr, "Due", original.Due, &l.Due, &r.Due, &fields)
+	mergeField(data.EqualDurationPtr, "Estimate", original.Estimate, &l.Estimate, &r.Estimate, &fields)
+	mergeModifiedAt(&l.ModifiedAt, &r.ModifiedAt, fields)
+	return mergeRow(data.Task.Equal, original, remote, l, r, fields)
 }
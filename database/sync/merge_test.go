@@ -1,7 +1,9 @@
 package sync
 
 import (
+	"slices"
 	"testing"
+	"time"
 
 	"nyiyui.ca/jts/data"
 )
@@ -63,3 +65,234 @@ func TestMerge2(t *testing.T) {
 		t.Fatalf("expected no changes, got %v", len(changes))
 	}
 }
+
+func TestMergeRemove(t *testing.T) {
+	original := []data.Session{
+		{ID: "1", Description: "learn Haskell"},
+		{ID: "2", Description: "learn Rust"},
+	}
+	local := []data.Session{
+		{ID: "1", Description: "learn Haskell"},
+	}
+	remote := []data.Session{
+		{ID: "1", Description: "learn Haskell"},
+		{ID: "2", Description: "learn Rust"},
+	}
+	changes, conflicts := mergeSlice(mergeSession, getIDSession, original, local, remote)
+	if len(conflicts) != 0 {
+		t.Fatalf("expected no conflicts, got %v", conflicts)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("expected 1 change, got %v", len(changes))
+	}
+	if changes[0].Operation != ChangeOperationRemove {
+		t.Fatalf("expected change operation %v, got %v", ChangeOperationRemove, changes[0].Operation)
+	}
+	if changes[0].Data.ID != "2" {
+		t.Fatalf("expected change ID 2, got %v", changes[0].Data.ID)
+	}
+}
+
+func TestMergeRemoveRemote(t *testing.T) {
+	original := []data.Session{
+		{ID: "1", Description: "learn Haskell"},
+		{ID: "2", Description: "learn Rust"},
+	}
+	local := []data.Session{
+		{ID: "1", Description: "learn Haskell"},
+		{ID: "2", Description: "learn Rust"},
+	}
+	remote := []data.Session{
+		{ID: "1", Description: "learn Haskell"},
+	}
+	changes, conflicts := mergeSlice(mergeSession, getIDSession, original, local, remote)
+	if len(conflicts) != 0 {
+		t.Fatalf("expected no conflicts, got %v", conflicts)
+	}
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes (remote removal should stand), got %v", changes)
+	}
+}
+
+func TestMergeRemoveEditConflict(t *testing.T) {
+	original := []data.Session{
+		{ID: "1", Description: "learn Haskell"},
+	}
+	local := []data.Session{}
+	remote := []data.Session{
+		{ID: "1", Description: "learn Haskell and Idris"},
+	}
+	changes, conflicts := mergeSlice(mergeSession, getIDSession, original, local, remote)
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v", changes)
+	}
+	if len(conflicts) != 1 {
+		t.Fatalf("expected 1 conflict, got %v", conflicts)
+	}
+	if !conflicts[0].LocalRemoved || conflicts[0].RemoteRemoved {
+		t.Fatalf("expected local removal conflict, got %#v", conflicts[0])
+	}
+}
+
+func TestMergeTombstone(t *testing.T) {
+	// the original copy is unavailable, but the local side has a tombstone
+	local := ExportedDatabase{
+		Tombstones: []data.Tombstone{{ID: "2", TableName: "sessions"}},
+	}
+	remote := ExportedDatabase{
+		Sessions: []data.Session{{ID: "2", Description: "learn Rust"}},
+	}
+	changes, conflicts := Merge(ExportedDatabase{}, local, remote)
+	if len(changes.Sessions) != 0 {
+		t.Fatalf("expected no changes, got %v", changes.Sessions)
+	}
+	if len(conflicts.Sessions) != 1 || !conflicts.Sessions[0].LocalRemoved {
+		t.Fatalf("expected a delete-vs-edit conflict, got %v", conflicts.Sessions)
+	}
+}
+
+func TestMergeTags(t *testing.T) {
+	original := []data.Session{
+		{ID: "1", Description: "meeting", Tags: []string{"billable", "client-acme"}},
+	}
+	// local removed client-acme and added meeting; remote changed the description and added urgent
+	local := []data.Session{
+		{ID: "1", Description: "meeting", Tags: []string{"billable", "meeting"}},
+	}
+	remote := []data.Session{
+		{ID: "1", Description: "meeting with Acme", Tags: []string{"billable", "client-acme", "urgent"}},
+	}
+	changes, conflicts := mergeSlice(mergeSession, getIDSession, original, local, remote)
+	if len(conflicts) != 0 {
+		t.Fatalf("expected no conflicts, got %v", conflicts)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("expected 1 change, got %v", changes)
+	}
+	expected := []string{"billable", "meeting", "urgent"}
+	if !slices.Equal(changes[0].Data.Tags, expected) {
+		t.Fatalf("expected tags %v, got %v", expected, changes[0].Data.Tags)
+	}
+	if changes[0].Data.Description != "meeting with Acme" {
+		t.Fatalf("expected remote description, got %q", changes[0].Data.Description)
+	}
+}
+
+func TestMergeFields(t *testing.T) {
+	task := "task"
+	original := []data.Session{
+		{ID: "1", Description: "write report", Notes: "draft"},
+	}
+	// local edited the notes and linked a task; remote edited the description
+	local := []data.Session{
+		{ID: "1", Description: "write report", Notes: "draft\nsources", TaskID: &task},
+	}
+	remote := []data.Session{
+		{ID: "1", Description: "write quarterly report", Notes: "draft"},
+	}
+	changes, conflicts := mergeSlice(mergeSession, getIDSession, original, local, remote)
+	if len(conflicts) != 0 {
+		t.Fatalf("expected no conflicts, got %v", conflicts)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("expected 1 change, got %v", changes)
+	}
+	got := changes[0].Data
+	if got.Description != "write quarterly report" || got.Notes != "draft\nsources" || got.TaskID == nil || *got.TaskID != task {
+		t.Fatalf("unexpected merge: %#v", got)
+	}
+}
+
+func TestMergeFieldConflict(t *testing.T) {
+	original := []data.Session{
+		{ID: "1", Description: "write report", Notes: "draft"},
+	}
+	local := []data.Session{
+		{ID: "1", Description: "write report", Notes: "local notes"},
+	}
+	remote := []data.Session{
+		{ID: "1", Description: "write quarterly report", Notes: "remote notes"},
+	}
+	changes, conflicts := mergeSlice(mergeSession, getIDSession, original, local, remote)
+	if len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v", changes)
+	}
+	if len(conflicts) != 1 {
+		t.Fatalf("expected 1 conflict, got %v", conflicts)
+	}
+	c := conflicts[0]
+	if !slices.Equal(c.Fields, []string{"Notes"}) {
+		t.Fatalf("expected only Notes to conflict, got %v", c.Fields)
+	}
+	// the description is merged on both sides
+	if c.Local.Description != "write quarterly report" || c.Remote.Description != "write quarterly report" {
+		t.Fatalf("expected merged description, got %q and %q", c.Local.Description, c.Remote.Description)
+	}
+	if c.Local.Notes != "local notes" || c.Remote.Notes != "remote notes" {
+		t.Fatalf("expected each side's notes, got %q and %q", c.Local.Notes, c.Remote.Notes)
+	}
+}
+
+func TestMergeTaskIDValue(t *testing.T) {
+	// TaskIDs are compared by value, not by pointer
+	a, b := "task", "task"
+	original := []data.Session{{ID: "1", Description: "x", TaskID: &a}}
+	local := []data.Session{{ID: "1", Description: "x", TaskID: &b}}
+	remote := []data.Session{{ID: "1", Description: "x", TaskID: &a}}
+	changes, conflicts := mergeSlice(mergeSession, getIDSession, original, local, remote)
+	if len(changes) != 0 || len(conflicts) != 0 {
+		t.Fatalf("expected nothing, got %v and %v", changes, conflicts)
+	}
+}
+
+func TestMergeNotesLines(t *testing.T) {
+	original := []data.Session{
+		{ID: "1", Description: "trip", Notes: "day 1\n\nday 2\n\nday 3"},
+	}
+	local := []data.Session{
+		{ID: "1", Description: "trip", Notes: "day 1: museum\n\nday 2\n\nday 3"},
+	}
+	remote := []data.Session{
+		{ID: "1", Description: "trip", Notes: "day 1\n\nday 2\n\nday 3: beach"},
+	}
+	_, conflicts := mergeSlice(mergeSession, getIDSession, original, local, remote)
+	if len(conflicts) != 1 {
+		t.Fatalf("expected a conflict without MergeNotes, got %v", conflicts)
+	}
+	changes, conflicts := mergeSlice(sessionMerger(MergeOptions{MergeNotes: true}), getIDSession, original, local, remote)
+	if len(conflicts) != 0 {
+		t.Fatalf("expected no conflicts, got %v", conflicts)
+	}
+	if len(changes) != 1 {
+		t.Fatalf("expected 1 change, got %v", changes)
+	}
+	expected := "day 1: museum\n\nday 2\n\nday 3: beach"
+	if changes[0].Data.Notes != expected {
+		t.Fatalf("expected notes %q, got %q", expected, changes[0].Data.Notes)
+	}
+}
+
+func TestMergeTimeframeFields(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	later := end.Add(time.Hour)
+	original := []data.Timeframe{{ID: "1", SessionID: "s", Start: start, End: &end}}
+	// local extended the end; remote marked it done
+	local := []data.Timeframe{{ID: "1", SessionID: "s", Start: start, End: &later}}
+	remote := []data.Timeframe{{ID: "1", SessionID: "s", Start: start, End: &end, Done: true}}
+	changes, conflicts := mergeSlice(mergeTimeframe, getIDTimeframe, original, local, remote)
+	if len(conflicts) != 0 {
+		t.Fatalf("expected no conflicts, got %v", conflicts)
+	}
+	if len(changes) != 1 || !changes[0].Data.End.Equal(later) || !changes[0].Data.Done {
+		t.Fatalf("unexpected changes: %v", changes)
+	}
+
+	earlier := start.Add(-time.Hour)
+	local[0].Start = start.Add(time.Minute)
+	remote[0].Start = earlier
+	_, conflicts = mergeSlice(mergeTimeframe, getIDTimeframe, original, local, remote)
+	if len(conflicts) != 1 || !slices.Equal(conflicts[0].Fields, []string{"Start"}) {
+		t.Fatalf("expected Start to conflict, got %v", conflicts)
+	}
+}
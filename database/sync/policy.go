@@ -0,0 +1,199 @@
+package sync
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"nyiyui.ca/jts/data"
+)
+
+// Policy is how conflicts of one entity type are resolved without asking the user.
+// Conflicts a policy cannot decide are left to the resolver passed to SyncDatabase.
+type Policy string
+
+const (
+	// PolicyAsk leaves every conflict to the resolver.
+	PolicyAsk Policy = ""
+	// PolicyLocal keeps the local side, including local deletions.
+	PolicyLocal Policy = "local"
+	// PolicyRemote keeps the remote side, including remote deletions.
+	PolicyRemote Policy = "remote"
+	// PolicyLatest keeps the side edited last, according to ModifiedAt.
+	// Deletions are not decided, as tombstones do not carry a deletion time through every transport, nor are rows without a modification time (e.g. from older clients).
+	PolicyLatest Policy = "latest"
+	// PolicyLongest keeps the longer of two timeframes. For timeframes only.
+	// Deletions and running timeframes are not decided.
+	PolicyLongest Policy = "longest"
+	// PolicyUnionNotes keeps the lines of both sides when the notes of a session conflict (see unionLines). For sessions only.
+	// Conflicts in other fields and deletions are not decided.
+	PolicyUnionNotes Policy = "union-notes"
+)
+
+// Policies are the policies for each entity type.
+type Policies struct {
+	Sessions   Policy
+	Timeframes Policy
+	Tasks      Policy
+}
+
+// Validate returns an error if a policy is unknown or not meant for its entity type.
+func (p Policies) Validate() error {
+	for _, v := range []struct {
+		name    string
+		policy  Policy
+		allowed []Policy
+	}{
+		{"sessions", p.Sessions, []Policy{PolicyUnionNotes}},
+		{"timeframes", p.Timeframes, []Policy{PolicyLongest}},
+		{"tasks", p.Tasks, nil},
+	} {
+		switch v.policy {
+		case PolicyAsk, PolicyLocal, PolicyRemote, PolicyLatest:
+			continue
+		}
+		if !slices.Contains(v.allowed, v.policy) {
+			return fmt.Errorf("conflict policy %q cannot be used for %s", v.policy, v.name)
+		}
+	}
+	return nil
+}
+
+// side is the side of a conflict that wins.
+type side int
+
+const (
+	sideNone side = iota
+	sideLocal
+	sideRemote
+)
+
+// resolve resolves the conflicts the policies can decide, adding the resolutions to changes, and returns the rest of the conflicts.
+func (p Policies) resolve(changes Changes, conflicts MergeConflicts) (Changes, MergeConflicts) {
+	var sessions []Change[data.Session]
+	sessions, conflicts.Sessions = resolveConflicts(conflicts.Sessions, func(c *MergeConflict[data.Session]) side {
+		if p.Sessions == PolicyUnionNotes {
+			return unionNotes(c)
+		}
+		return chooseSide(p.Sessions, c, modifiedAtSession)
+	}, modifiedAtSession, func(s *data.Session, t *time.Time) { s.ModifiedAt = t })
+	changes.Sessions = append(changes.Sessions, sessions...)
+
+	var timeframes []Change[data.Timeframe]
+	timeframes, conflicts.Timeframes = resolveConflicts(conflicts.Timeframes, func(c *MergeConflict[data.Timeframe]) side {
+		if p.Timeframes == PolicyLongest {
+			return longerTimeframe(*c)
+		}
+		return chooseSide(p.Timeframes, c, modifiedAtTimeframe)
+	}, modifiedAtTimeframe, func(tf *data.Timeframe, t *time.Time) { tf.ModifiedAt = t })
+	changes.Timeframes = append(changes.Timeframes, timeframes...)
+
+	var tasks []Change[data.Task]
+	tasks, conflicts.Tasks = resolveConflicts(conflicts.Tasks, func(c *MergeConflict[data.Task]) side {
+		return chooseSide(p.Tasks, c, modifiedAtTask)
+	}, modifiedAtTask, func(task *data.Task, t *time.Time) { task.ModifiedAt = t })
+	changes.Tasks = append(changes.Tasks, tasks...)
+	return changes, conflicts
+}
+
+func modifiedAtSession(s data.Session) *time.Time {
+	return s.ModifiedAt
+}
+
+func modifiedAtTimeframe(tf data.Timeframe) *time.Time {
+	return tf.ModifiedAt
+}
+
+func modifiedAtTask(t data.Task) *time.Time {
+	return t.ModifiedAt
+}
+
+// resolveConflicts returns the changes to apply to remote for the conflicts decide resolves, and the conflicts it does not.
+// decide may change a conflict, e.g. to merge some of its fields, even if it does not resolve it.
+// A row kept from an edit-vs-edit conflict gets the later modification time of the two sides, as it was decided after both edits.
+func resolveConflicts[T any](conflicts []MergeConflict[T], decide func(*MergeConflict[T]) side, modifiedAt func(T) *time.Time, setModifiedAt func(*T, *time.Time)) ([]Change[T], []MergeConflict[T]) {
+	var changes []Change[T]
+	var rest []MergeConflict[T]
+	for _, c := range conflicts {
+		var row T
+		switch decide(&c) {
+		case sideLocal:
+			if c.LocalRemoved {
+				changes = append(changes, Change[T]{ChangeOperationRemove, c.Remote})
+				continue
+			}
+			row = c.Local
+			if c.RemoteRemoved {
+				changes = append(changes, Change[T]{ChangeOperationExist, row})
+				continue
+			}
+		case sideRemote:
+			if c.LocalRemoved || c.RemoteRemoved {
+				// the remote row (or its tombstone) is applied locally with the rest of the remote delta
+				continue
+			}
+			row = c.Remote
+		default:
+			rest = append(rest, c)
+			continue
+		}
+		setModifiedAt(&row, laterTime(modifiedAt(c.Local), modifiedAt(c.Remote)))
+		changes = append(changes, Change[T]{ChangeOperationExist, row})
+	}
+	return changes, rest
+}
+
+// chooseSide decides a conflict by the policies common to every entity type.
+func chooseSide[T any](policy Policy, c *MergeConflict[T], modifiedAt func(T) *time.Time) side {
+	switch policy {
+	case PolicyLocal:
+		return sideLocal
+	case PolicyRemote:
+		return sideRemote
+	case PolicyLatest:
+		if c.LocalRemoved || c.RemoteRemoved {
+			return sideNone
+		}
+		l, r := modifiedAt(c.Local), modifiedAt(c.Remote)
+		switch {
+		case l == nil || r == nil || l.Equal(*r):
+			return sideNone
+		case l.After(*r):
+			return sideLocal
+		default:
+			return sideRemote
+		}
+	}
+	return sideNone
+}
+
+// longerTimeframe decides a conflict between two ended timeframes by their length.
+func longerTimeframe(c MergeConflict[data.Timeframe]) side {
+	if c.LocalRemoved || c.RemoteRemoved || c.Local.Running() || c.Remote.Running() {
+		return sideNone
+	}
+	switch l, r := c.Local.Duration(), c.Remote.Duration(); {
+	case l > r:
+		return sideLocal
+	case r > l:
+		return sideRemote
+	}
+	return sideNone
+}
+
+// unionNotes merges conflicting notes with unionLines, and resolves the conflict if no other field conflicts.
+func unionNotes(c *MergeConflict[data.Session]) side {
+	if c.LocalRemoved || c.RemoteRemoved || !slices.Contains(c.Fields, "Notes") {
+		return sideNone
+	}
+	notes, ok := unionLines(c.Original.Notes, c.Local.Notes, c.Remote.Notes)
+	if !ok {
+		return sideNone
+	}
+	c.Local.Notes, c.Remote.Notes = notes, notes
+	c.Fields = slices.DeleteFunc(slices.Clone(c.Fields), func(f string) bool { return f == "Notes" })
+	if len(c.Fields) > 0 {
+		return sideNone
+	}
+	return sideLocal
+}
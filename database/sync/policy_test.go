@@ -0,0 +1,121 @@
+package sync
+
+import (
+	"testing"
+	"time"
+
+	"nyiyui.ca/jts/data"
+)
+
+func TestPolicies(t *testing.T) {
+	start := time.Date(2025, 4, 1, 9, 0, 0, 0, time.UTC)
+	earlier, later := start.Add(time.Hour), start.Add(2*time.Hour)
+	original := ExportedDatabase{
+		Sessions:   []data.Session{{ID: "s", Description: "trip", Notes: "day 1\nday 2"}},
+		Timeframes: []data.Timeframe{{ID: "tf", SessionID: "s", Start: start, End: timePtr(start.Add(time.Hour))}},
+		Tasks:      []data.Task{{ID: "task", Description: "pack", Status: data.TaskStatusOpen}},
+	}
+	local := ExportedDatabase{
+		Sessions:   []data.Session{{ID: "s", Description: "trip", Notes: "day 1: museum\nday 2", ModifiedAt: &later}},
+		Timeframes: []data.Timeframe{{ID: "tf", SessionID: "s", Start: start, End: timePtr(start.Add(2 * time.Hour)), ModifiedAt: &earlier}},
+		Tasks:      []data.Task{{ID: "task", Description: "pack", Status: data.TaskStatusDone, ModifiedAt: &earlier}},
+	}
+	remote := ExportedDatabase{
+		Sessions:   []data.Session{{ID: "s", Description: "trip", Notes: "day 1: beach\nday 2", ModifiedAt: &earlier}},
+		Timeframes: []data.Timeframe{{ID: "tf", SessionID: "s", Start: start, End: timePtr(start.Add(90 * time.Minute)), ModifiedAt: &later}},
+		Tasks:      []data.Task{{ID: "task", Description: "pack", Status: data.TaskStatusArchived, ModifiedAt: &later}},
+	}
+	_, conflicts := MergeWithOptions(original, local, remote, MergeOptions{})
+	if conflicts.Len() != 3 {
+		t.Fatalf("expected a conflict for each row without policies, got %#v", conflicts)
+	}
+
+	for name, tc := range map[string]struct {
+		policies Policies
+		notes    string
+		end      time.Duration
+		status   data.TaskStatus
+	}{
+		"local":  {Policies{PolicyLocal, PolicyLocal, PolicyLocal}, "day 1: museum\nday 2", 2 * time.Hour, data.TaskStatusDone},
+		"remote": {Policies{PolicyRemote, PolicyRemote, PolicyRemote}, "day 1: beach\nday 2", 90 * time.Minute, data.TaskStatusArchived},
+		"latest": {Policies{PolicyLatest, PolicyLatest, PolicyLatest}, "day 1: museum\nday 2", 90 * time.Minute, data.TaskStatusArchived},
+		"types":  {Policies{PolicyUnionNotes, PolicyLongest, PolicyRemote}, "day 1: museum\nday 1: beach\nday 2", 2 * time.Hour, data.TaskStatusArchived},
+	} {
+		t.Run(name, func(t *testing.T) {
+			changes, conflicts := MergeWithOptions(original, local, remote, MergeOptions{Policies: tc.policies})
+			if conflicts.Len() != 0 {
+				t.Fatalf("expected no conflicts, got %#v", conflicts)
+			}
+			if len(changes.Sessions) != 1 || changes.Sessions[0].Data.Notes != tc.notes {
+				t.Errorf("expected notes %q, got %#v", tc.notes, changes.Sessions)
+			}
+			if len(changes.Timeframes) != 1 || !changes.Timeframes[0].Data.End.Equal(start.Add(tc.end)) {
+				t.Errorf("expected a timeframe ending after %s, got %#v", tc.end, changes.Timeframes)
+			}
+			if len(changes.Tasks) != 1 || changes.Tasks[0].Data.Status != tc.status {
+				t.Errorf("expected status %s, got %#v", tc.status, changes.Tasks)
+			}
+			for _, c := range changes.Sessions {
+				if c.Data.ModifiedAt == nil || !c.Data.ModifiedAt.Equal(later) {
+					t.Errorf("expected the resolved row to be modified at the later time, got %v", c.Data.ModifiedAt)
+				}
+			}
+		})
+	}
+}
+
+func TestPoliciesUndecided(t *testing.T) {
+	start := time.Date(2025, 4, 1, 9, 0, 0, 0, time.UTC)
+	original := ExportedDatabase{
+		Sessions:   []data.Session{{ID: "s", Description: "trip", Notes: "day 1"}},
+		Timeframes: []data.Timeframe{{ID: "tf", SessionID: "s", Start: start, End: timePtr(start.Add(time.Hour))}},
+		Tasks:      []data.Task{{ID: "task", Description: "pack"}},
+	}
+	local := ExportedDatabase{
+		// both notes and description conflict
+		Sessions: []data.Session{{ID: "s", Description: "trip (local)", Notes: "day 1: museum"}},
+		// running
+		Timeframes: []data.Timeframe{{ID: "tf", SessionID: "s", Start: start}},
+		// removed
+		Tombstones: []data.Tombstone{{ID: "task", TableName: "tasks"}},
+	}
+	remote := ExportedDatabase{
+		Sessions:   []data.Session{{ID: "s", Description: "trip (remote)", Notes: "day 1: beach"}},
+		Timeframes: []data.Timeframe{{ID: "tf", SessionID: "s", Start: start, End: timePtr(start.Add(2 * time.Hour))}},
+		Tasks:      []data.Task{{ID: "task", Description: "pack bags"}},
+	}
+	changes, conflicts := MergeWithOptions(original, local, remote, MergeOptions{Policies: Policies{PolicyUnionNotes, PolicyLongest, PolicyLatest}})
+	if len(changes.Sessions)+len(changes.Timeframes)+len(changes.Tasks) != 0 {
+		t.Fatalf("expected no changes, got %#v", changes)
+	}
+	if conflicts.Len() != 3 {
+		t.Fatalf("expected every conflict to remain, got %#v", conflicts)
+	}
+	// the notes are merged even though the description still conflicts
+	c := conflicts.Sessions[0]
+	if len(c.Fields) != 1 || c.Fields[0] != "Description" || c.Local.Notes != c.Remote.Notes {
+		t.Errorf("expected only the description to conflict, got %#v", c)
+	}
+}
+
+func TestPoliciesValidate(t *testing.T) {
+	if err := (Policies{PolicyUnionNotes, PolicyLongest, PolicyLatest}).Validate(); err != nil {
+		t.Error(err)
+	}
+	for _, p := range []Policies{{Sessions: PolicyLongest}, {Timeframes: PolicyUnionNotes}, {Tasks: "newest"}} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("expected %#v to be invalid", p)
+		}
+	}
+}
+
+func TestUnionLines(t *testing.T) {
+	merged, ok := unionLines("a\nb\nc", "a\nB (local)\nc\nd", "a\nB (remote)\nc")
+	if !ok || merged != "a\nB (local)\nB (remote)\nc\nd" {
+		t.Fatalf("unexpected union %q", merged)
+	}
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
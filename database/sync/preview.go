@@ -0,0 +1,113 @@
+package sync
+
+import (
+	"context"
+
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+)
+
+// DiffOperation is how a sync would change a row.
+type DiffOperation string
+
+const (
+	DiffOperationAdd    DiffOperation = "add"
+	DiffOperationChange DiffOperation = "change"
+	DiffOperationRemove DiffOperation = "remove"
+)
+
+// RowDiff is a row that a sync would add, change, or remove.
+type RowDiff[T any] struct {
+	Operation DiffOperation
+	// Old is the row before the sync, or the zero value if it would be added.
+	Old T
+	// New is the row after the sync, or the zero value if it would be removed.
+	New T
+}
+
+// Diff are the rows that a sync would add, change, or remove in one database.
+type Diff struct {
+	Sessions   []RowDiff[data.Session]
+	Timeframes []RowDiff[data.Timeframe]
+	Tasks      []RowDiff[data.Task]
+}
+
+// Len returns the number of rows in the diff.
+func (d Diff) Len() int {
+	return len(d.Sessions) + len(d.Timeframes) + len(d.Tasks)
+}
+
+// Preview is what a sync would do (see Syncer.Preview).
+type Preview struct {
+	// Outgoing are the rows the sync would upload to the canonical database.
+	Outgoing Diff
+	// Incoming are the rows the sync would write to the local database.
+	Incoming Diff
+	// Conflicts are the conflicts the conflict policies do not resolve, which the sync would ask the resolver about (or queue).
+	// Their rows are in neither diff.
+	Conflicts MergeConflicts
+}
+
+// Preview downloads and merges like SyncDatabase, and returns what a sync would change, without writing to either database or to state.
+// Like SyncDatabase, it locks the canonical database while downloading, but sets up nothing else (e.g. encryption; see previewLocker).
+// A sync afterwards merges again, so it differs from the preview if another device synced in between.
+func (s Syncer) Preview(ctx context.Context, state SyncState, db *database.Database, status chan<- string) (Preview, error) {
+	ctx, unlock, err := s.lock(ctx, status, true)
+	if err != nil {
+		return Preview{}, err
+	}
+	defer unlock()
+
+	m, err := s.merge(ctx, state, db, status)
+	if err != nil {
+		return Preview{}, err
+	}
+	// the conflicting rows stay as they are locally until the conflicts are resolved
+	remoteDelta := m.remoteDelta.only(m.conflicts.touched(), false)
+	incoming := deltaChanges(Delta{ExportedDatabase: remoteDelta})
+	return Preview{
+		Outgoing: Diff{
+			Sessions:   diffRows(getIDSession, equalSession, m.remote.Sessions, m.changes.Sessions),
+			Timeframes: diffRows(getIDTimeframe, data.Timeframe.Equal, m.remote.Timeframes, m.changes.Timeframes),
+			Tasks:      diffRows(getIDTask, data.Task.Equal, m.remote.Tasks, m.changes.Tasks),
+		},
+		Incoming: Diff{
+			Sessions:   diffRows(getIDSession, equalSession, m.local.Sessions, incoming.Sessions, m.changes.Sessions),
+			Timeframes: diffRows(getIDTimeframe, data.Timeframe.Equal, m.local.Timeframes, incoming.Timeframes, m.changes.Timeframes),
+			Tasks:      diffRows(getIDTask, data.Task.Equal, m.local.Tasks, incoming.Tasks, m.changes.Tasks),
+		},
+		Conflicts: m.conflicts,
+	}, nil
+}
+
+// diffRows returns how applying each list of changes in turn would change rows.
+// Changes that leave a row as it is (e.g. local edits, in the local database) are left out.
+func diffRows[T any](getID func(T) string, equal func(a, b T) bool, rows []T, changes ...[]Change[T]) []RowDiff[T] {
+	old := makeIDMap(getID, rows)
+	var ids []string
+	final := map[string]Change[T]{}
+	for _, cs := range changes {
+		for _, c := range cs {
+			id := getID(c.Data)
+			if _, ok := final[id]; !ok {
+				ids = append(ids, id)
+			}
+			final[id] = c
+		}
+	}
+	var diffs []RowDiff[T]
+	for _, id := range ids {
+		c := final[id]
+		o, exists := old[id]
+		switch {
+		case c.Operation == ChangeOperationRemove && exists:
+			diffs = append(diffs, RowDiff[T]{Operation: DiffOperationRemove, Old: o})
+		case c.Operation == ChangeOperationRemove:
+		case !exists:
+			diffs = append(diffs, RowDiff[T]{Operation: DiffOperationAdd, New: c.Data})
+		case !equal(c.Data, o):
+			diffs = append(diffs, RowDiff[T]{Operation: DiffOperationChange, Old: o, New: c.Data})
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,112 @@
+package sync
+
+import (
+	"github.com/jmoiron/sqlx"
+	"nyiyui.ca/jts/data"
+)
+
+// QueuedConflicts are the rows of conflicts that a sync without a resolver left unresolved (see Syncer.QueueConflicts).
+// The next sync merges them again, so that a resolver sees them.
+type QueuedConflicts struct {
+	// Original are the rows as of the sync before the conflict, i.e. the common ancestors. Rows added on both sides are missing.
+	Original ExportedDatabase
+	// Remote are the remote rows, and tombstones for rows removed remotely.
+	Remote ExportedDatabase
+}
+
+// Len returns the number of queued conflicts.
+func (q QueuedConflicts) Len() int {
+	return len(q.Remote.Sessions) + len(q.Remote.Timeframes) + len(q.Remote.Tasks) + len(q.Remote.Tombstones)
+}
+
+// Len returns the number of conflicts.
+func (mc MergeConflicts) Len() int {
+	return len(mc.Sessions) + len(mc.Timeframes) + len(mc.Tasks)
+}
+
+// touched returns the IDs of the conflicting rows.
+func (mc MergeConflicts) touched() touched {
+	t := touched{}
+	addConflictIDs(t, "sessions", getIDSession, mc.Sessions)
+	addConflictIDs(t, "time_frames", getIDTimeframe, mc.Timeframes)
+	addConflictIDs(t, "tasks", getIDTask, mc.Tasks)
+	return t
+}
+
+func addConflictIDs[T any](t touched, table string, getID func(T) string, conflicts []MergeConflict[T]) {
+	for _, c := range conflicts {
+		if c.LocalRemoved {
+			t.add(table, getID(c.Remote))
+		} else {
+			t.add(table, getID(c.Local))
+		}
+	}
+}
+
+// queue returns the rows of the conflicting rows t from the inputs of the merge.
+func queue(t touched, original, remote ExportedDatabase) QueuedConflicts {
+	return QueuedConflicts{Original: original.only(t, true), Remote: remote.only(t, true)}
+}
+
+// only returns the rows and tombstones in t if in is set, and the others otherwise.
+func (ed ExportedDatabase) only(t touched, in bool) ExportedDatabase {
+	return ExportedDatabase{
+		Sessions:   filterIDs(ed.Sessions, getIDSession, t["sessions"], in),
+		Timeframes: filterIDs(ed.Timeframes, getIDTimeframe, t["time_frames"], in),
+		Tasks:      filterIDs(ed.Tasks, getIDTask, t["tasks"], in),
+		Tombstones: filterTombstones(ed.Tombstones, t, in),
+	}
+}
+
+func filterIDs[T any](rows []T, getID func(T) string, ids map[string]struct{}, in bool) []T {
+	var filtered []T
+	for _, row := range rows {
+		if _, ok := ids[getID(row)]; ok == in {
+			filtered = append(filtered, row)
+		}
+	}
+	return filtered
+}
+
+func filterTombstones(tombstones []data.Tombstone, t touched, in bool) []data.Tombstone {
+	var filtered []data.Tombstone
+	for _, ts := range tombstones {
+		if _, ok := t[ts.TableName][ts.ID]; ok == in {
+			filtered = append(filtered, ts)
+		}
+	}
+	return filtered
+}
+
+// appendRows appends the rows and tombstones of other to ed.
+func (ed *ExportedDatabase) appendRows(other ExportedDatabase) {
+	ed.Sessions = append(ed.Sessions, other.Sessions...)
+	ed.Timeframes = append(ed.Timeframes, other.Timeframes...)
+	ed.Tasks = append(ed.Tasks, other.Tasks...)
+	ed.Tombstones = append(ed.Tombstones, other.Tombstones...)
+}
+
+// requeue adds the queued conflicts to the inputs of a sync, so that they are merged again:
+// the current local row against the queued remote row (unless the remote delta has a newer one), with the queued original as the common ancestor.
+func requeue(tx *sqlx.Tx, q QueuedConflicts, localDelta, remoteDelta *Delta, localOriginal *ExportedDatabase) error {
+	queued := Delta{ExportedDatabase: q.Remote}.touched()
+	localTouched := localDelta.touched()
+	missing := touched{}
+	for table, ids := range queued {
+		for id := range ids {
+			if _, ok := localTouched[table][id]; !ok {
+				missing.add(table, id)
+			}
+		}
+	}
+	current, err := exportIDs(tx, missing)
+	if err != nil {
+		return err
+	}
+	localDelta.appendRows(current)
+	// the rows as of the last sync are the local sides of the queued conflicts, not their ancestors
+	*localOriginal = localOriginal.only(queued, false)
+	localOriginal.appendRows(q.Original.only(queued, true))
+	remoteDelta.appendRows(q.Remote.only(remoteDelta.touched(), false))
+	return nil
+}
@@ -0,0 +1,236 @@
+package sync_test
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+	"nyiyui.ca/jts/database/sync"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+// fullDatabase returns rows with every synced field set (see TestFullDatabaseSetsEveryField).
+func fullDatabase() sync.ExportedDatabase {
+	start := time.Date(2025, 4, 1, 9, 0, 0, 0, time.UTC)
+	return sync.ExportedDatabase{
+		Tasks: []data.Task{
+			{ID: "task1", Description: "write thesis", Status: data.TaskStatusDone, Due: ptr(start.AddDate(0, 1, 0)), Estimate: ptr(40 * time.Hour), ModifiedAt: ptr(start.Add(-time.Hour))},
+			{ID: "task2", Description: "review", Status: data.TaskStatusArchived},
+		},
+		Sessions: []data.Session{
+			{ID: "session1", Description: "draft chapter 1", Notes: "# Plan\n- outline", TaskID: ptr("task1"), Tags: []string{"thesis", "writing"}, ModifiedAt: ptr(start.Add(-time.Hour))},
+		},
+		Timeframes: []data.Timeframe{
+			{ID: "tf1", SessionID: "session1", Start: start, End: ptr(start.Add(time.Hour)), Done: true, ModifiedAt: ptr(start.Add(time.Hour))},
+			{ID: "tf2", SessionID: "session1", Start: start.Add(2 * time.Hour)},
+		},
+	}
+}
+
+// assertFieldsSet fails unless every exported field of v except those named in except is set.
+func assertFieldsSet(t *testing.T, v interface{}, except ...string) {
+	t.Helper()
+	rv := reflect.ValueOf(v)
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		if field.IsExported() && !slices.Contains(except, field.Name) && rv.Field(i).IsZero() {
+			t.Errorf("%s.%s is not set in the fixture; set it so that the round trip tests cover it", rv.Type().Name(), field.Name)
+		}
+	}
+}
+
+func TestFullDatabaseSetsEveryField(t *testing.T) {
+	ed := fullDatabase()
+	// Rowid is local to each database, and timeframes are exported separately from sessions
+	assertFieldsSet(t, ed.Tasks[0], "Rowid")
+	assertFieldsSet(t, ed.Sessions[0], "Rowid", "Timeframes")
+	assertFieldsSet(t, ed.Timeframes[0], "Rowid")
+}
+
+func existChanges(ed sync.ExportedDatabase) sync.Changes {
+	c := sync.Changes{Version: sync.SchemaVersion}
+	for _, s := range ed.Sessions {
+		c.Sessions = append(c.Sessions, sync.Change[data.Session]{Operation: sync.ChangeOperationExist, Data: s})
+	}
+	for _, tf := range ed.Timeframes {
+		c.Timeframes = append(c.Timeframes, sync.Change[data.Timeframe]{Operation: sync.ChangeOperationExist, Data: tf})
+	}
+	for _, task := range ed.Tasks {
+		c.Tasks = append(c.Tasks, sync.Change[data.Task]{Operation: sync.ChangeOperationExist, Data: task})
+	}
+	return c
+}
+
+func export(t *testing.T, db *database.Database) sync.ExportedDatabase {
+	t.Helper()
+	ed, err := sync.Export(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ed
+}
+
+// assertSameRows fails unless got has the same sessions, timeframes and tasks as expected, in any order.
+// Unlike the Equal methods, modification times are compared too.
+func assertSameRows(t *testing.T, expected, got sync.ExportedDatabase) {
+	t.Helper()
+	assertSameSlice(t, "sessions", expected.Sessions, got.Sessions, func(s data.Session) string { return s.ID }, func(a, b data.Session) bool {
+		return a.EqualProperties(b) && slices.Equal(data.NormalizeTags(a.Tags), data.NormalizeTags(b.Tags)) && data.EqualTimePtr(a.ModifiedAt, b.ModifiedAt)
+	})
+	assertSameSlice(t, "timeframes", expected.Timeframes, got.Timeframes, func(tf data.Timeframe) string { return tf.ID }, func(a, b data.Timeframe) bool {
+		return a.Equal(b) && data.EqualTimePtr(a.ModifiedAt, b.ModifiedAt)
+	})
+	assertSameSlice(t, "tasks", expected.Tasks, got.Tasks, func(task data.Task) string { return task.ID }, func(a, b data.Task) bool {
+		return a.Equal(b) && data.EqualTimePtr(a.ModifiedAt, b.ModifiedAt)
+	})
+}
+
+func assertSameSlice[T any](t *testing.T, name string, expected, got []T, getID func(T) string, equal func(a, b T) bool) {
+	t.Helper()
+	byID := func(a, b T) int { return cmp.Compare(getID(a), getID(b)) }
+	expected, got = slices.Clone(expected), slices.Clone(got)
+	slices.SortFunc(expected, byID)
+	slices.SortFunc(got, byID)
+	if !slices.EqualFunc(expected, got, equal) {
+		t.Errorf("%s differ:\nexpected %#v\ngot      %#v", name, expected, got)
+	}
+}
+
+func TestRoundTripImport(t *testing.T) {
+	db := newTestDatabase(t, "import.db")
+	if err := sync.ImportChanges(db, existChanges(fullDatabase())); err != nil {
+		t.Fatal(err)
+	}
+	assertSameRows(t, fullDatabase(), export(t, db))
+
+	// importing the same rows again updates them in place
+	if err := sync.ImportChanges(db, existChanges(fullDatabase())); err != nil {
+		t.Fatal(err)
+	}
+	assertSameRows(t, fullDatabase(), export(t, db))
+}
+
+func TestRoundTripReplace(t *testing.T) {
+	db := newTestDatabase(t, "replace.db")
+	if err := sync.ReplaceAndImport(db, fullDatabase(), sync.Changes{}); err != nil {
+		t.Fatal(err)
+	}
+	assertSameRows(t, fullDatabase(), export(t, db))
+}
+
+func TestRoundTripJSON(t *testing.T) {
+	raw, err := json.Marshal(existChanges(fullDatabase()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changes sync.Changes
+	if err := json.Unmarshal(raw, &changes); err != nil {
+		t.Fatal(err)
+	}
+	if changes.Version != sync.SchemaVersion {
+		t.Errorf("expected version %d, got %d", sync.SchemaVersion, changes.Version)
+	}
+	var ed sync.ExportedDatabase
+	for _, c := range changes.Sessions {
+		ed.Sessions = append(ed.Sessions, c.Data)
+	}
+	for _, c := range changes.Timeframes {
+		ed.Timeframes = append(ed.Timeframes, c.Data)
+	}
+	for _, c := range changes.Tasks {
+		ed.Tasks = append(ed.Tasks, c.Data)
+	}
+	assertSameRows(t, fullDatabase(), ed)
+}
+
+// editEveryField returns the rows with every synced field changed.
+func editEveryField(ed sync.ExportedDatabase) sync.ExportedDatabase {
+	task := &ed.Tasks[0]
+	task.Description = "write dissertation"
+	task.Status = data.TaskStatusOpen
+	task.Due = ptr(task.Due.AddDate(0, 0, 7))
+	task.Estimate = nil
+	task.ModifiedAt = ptr(task.ModifiedAt.Add(time.Hour))
+	session := &ed.Sessions[0]
+	session.Description = "draft chapter 2"
+	session.Notes = "# Plan\n- outline\n- sources"
+	session.TaskID = ptr("task2")
+	session.Tags = []string{"thesis"}
+	session.ModifiedAt = ptr(session.ModifiedAt.Add(time.Hour))
+	tf := &ed.Timeframes[0]
+	tf.Start = tf.Start.Add(-time.Minute)
+	tf.End = nil
+	tf.Done = false
+	tf.ModifiedAt = ptr(tf.ModifiedAt.Add(time.Hour))
+	ed.Timeframes[1].SessionID = "session2"
+	ed.Sessions = append(ed.Sessions, data.Session{ID: "session2", Description: "read papers", Tags: []string{}})
+	return ed
+}
+
+func TestRoundTripMerge(t *testing.T) {
+	db := newTestDatabase(t, "merge.db")
+	if err := sync.ImportChanges(db, existChanges(fullDatabase())); err != nil {
+		t.Fatal(err)
+	}
+	local := editEveryField(fullDatabase())
+	changes, conflicts := sync.Merge(fullDatabase(), local, export(t, db))
+	if len(conflicts.Sessions)+len(conflicts.Timeframes)+len(conflicts.Tasks) != 0 {
+		t.Fatalf("expected no conflicts, got %#v", conflicts)
+	}
+	if err := sync.ImportChanges(db, changes); err != nil {
+		t.Fatal(err)
+	}
+	assertSameRows(t, local, export(t, db))
+}
+
+func TestRoundTripSync(t *testing.T) {
+	forEachTransport(t, testRoundTripSync)
+}
+
+func testRoundTripSync(t *testing.T, a, b *testClient) {
+	if err := sync.ImportChanges(a.db, existChanges(fullDatabase())); err != nil {
+		t.Fatal(err)
+	}
+	a.sync(t)
+	b.sync(t)
+	assertSameRows(t, fullDatabase(), export(t, b.db))
+
+	// edits to every field on b reach a
+	edited := editEveryField(fullDatabase())
+	if err := sync.ImportChanges(b.db, existChanges(edited)); err != nil {
+		t.Fatal(err)
+	}
+	b.sync(t)
+	a.sync(t)
+	assertSameRows(t, edited, export(t, a.db))
+	assertSameRows(t, edited, export(t, b.db))
+}
+
+func TestImportChangesVersion(t *testing.T) {
+	db := newTestDatabase(t, "version.db")
+	changes := existChanges(fullDatabase())
+	changes.Version = sync.SchemaVersion + 1
+	if err := sync.ImportChanges(db, changes); !errors.Is(err, sync.ErrNewerSchema) {
+		t.Errorf("expected %v, got %v", sync.ErrNewerSchema, err)
+	}
+	// changes from clients that predate versions have the same fields
+	changes.Version = 0
+	if err := sync.ImportChanges(db, changes); err != nil {
+		t.Fatal(err)
+	}
+	assertSameRows(t, fullDatabase(), export(t, db))
+
+	err := sync.ImportChanges(db, sync.Changes{Sessions: []sync.Change[data.Session]{{Operation: sync.ChangeOperationExist}}})
+	if !errors.Is(err, sync.ErrNoID) {
+		t.Errorf("expected %v, got %v", sync.ErrNoID, err)
+	}
+}
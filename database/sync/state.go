@@ -0,0 +1,38 @@
+package sync
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// ReadSyncState reads the sync state from a file written by WriteSyncState.
+// If the file does not exist, the returned error satisfies errors.Is(err, os.ErrNotExist) and the zero state (a full sync) should be used.
+func ReadSyncState(path string) (SyncState, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return SyncState{}, err
+	}
+	defer file.Close()
+	var state SyncState
+	err = json.NewDecoder(file).Decode(&state)
+	return state, err
+}
+
+// WriteSyncState writes the sync state to a file.
+// The file is replaced atomically, as the GTK app, the CLI and the daemon may share it.
+func WriteSyncState(path string, state SyncState) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	err = json.NewEncoder(file).Encode(state)
+	if err2 := file.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,253 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"nyiyui.ca/jts/database"
+)
+
+// Transport is how a client reaches the canonical database that every device syncs with, e.g. the server (ServerClient) or a shared folder (DirTransport).
+type Transport interface {
+	// Lock locks the canonical database so that no other client syncs until unlock is called.
+	// The lock is kept alive (e.g. by renewing a lease) until then.
+	// DownloadDelta and ApplyChanges must be called with locked, which is canceled with ErrLockLost if the lock cannot be kept alive.
+	Lock(ctx context.Context) (locked context.Context, unlock func(context.Context) error, err error)
+	// DownloadDelta returns the rows changed in the canonical database after the sequence number since.
+	DownloadDelta(ctx context.Context, since int64) (Delta, error)
+	// ApplyChanges applies changes to the canonical database, and returns its sequence number afterwards.
+	ApplyChanges(ctx context.Context, changes Changes) (int64, error)
+}
+
+// previewLocker is implemented by transports whose Lock sets up more than the lock (e.g. encryption, or the directory), so that Preview does not.
+type previewLocker interface {
+	// lockPreview is like Lock, but only readies the transport for DownloadDelta, and changes nothing else.
+	lockPreview(ctx context.Context) (locked context.Context, unlock func(context.Context) error, err error)
+}
+
+// setUpper is implemented by transports that set up the canonical database in the first sync, removing the rows synced through another transport before (e.g. EncryptedTransport removes the plaintext rows on the server).
+type setUpper interface {
+	// beforeSetUp returns the transport the rows were synced through before, if this sync sets up the canonical database, or nil.
+	beforeSetUp() Transport
+	// setUp sets up the canonical database, if it was not set up yet when locking.
+	setUp(ctx context.Context) error
+}
+
+// ErrLockLost is the cause of the cancellation of a context returned by Transport.Lock when the lock could not be kept alive, e.g. as the server was unreachable until the lease expired.
+// Changes must not be applied then, as another client may be syncing.
+var ErrLockLost = errors.New("lock lost")
+
+// Syncer syncs a local database with the canonical database through a Transport.
+type Syncer struct {
+	Transport Transport
+	// MergeOptions are used to merge local and remote changes.
+	MergeOptions MergeOptions
+	// QueueConflicts makes a sync without a resolver sync everything but the conflicting rows, and queue the conflicts in the returned state, instead of failing with ErrConflictNoResolver.
+	// The next sync (e.g. an interactive one) merges the queued conflicts again.
+	QueueConflicts bool
+}
+
+// SyncDatabase syncs the local database with the canonical database, transferring only rows changed since the last sync (as recorded in state).
+// The returned state must be passed to the next call.
+func (s Syncer) SyncDatabase(ctx context.Context, state SyncState, db *database.Database, resolver func(MergeConflicts) (Changes, error), status chan<- string) (Changes, SyncState, error) {
+	ctx, unlock, err := s.lock(ctx, status, false)
+	if err != nil {
+		return Changes{}, SyncState{}, err
+	}
+	defer unlock()
+
+	if su, ok := s.Transport.(setUpper); ok {
+		if before := su.beforeSetUp(); before != nil {
+			// rows only there (e.g. made in the web UI since our last sync) are removed in the set up, so get them first
+			// Conflicts cannot be queued, as the other side of them is removed.
+			log.Printf("syncing through %T before setting up", before)
+			_, state, err = Syncer{Transport: before, MergeOptions: s.MergeOptions}.syncLocked(ctx, state, db, resolver, status)
+			if err != nil {
+				return Changes{}, SyncState{}, fmt.Errorf("sync before setting up: %w", err)
+			}
+		}
+		if err := su.setUp(ctx); err != nil {
+			return Changes{}, SyncState{}, err
+		}
+	}
+	return s.syncLocked(ctx, state, db, resolver, status)
+}
+
+// syncLocked is SyncDatabase after locking.
+func (s Syncer) syncLocked(ctx context.Context, state SyncState, db *database.Database, resolver func(MergeConflicts) (Changes, error), status chan<- string) (Changes, SyncState, error) {
+	m, err := s.merge(ctx, state, db, status)
+	if err != nil {
+		return Changes{}, SyncState{}, err
+	}
+	changes, conflicts, remoteDelta := m.changes, m.conflicts, m.remoteDelta
+	var queued QueuedConflicts
+	if conflicts.Len() > 0 {
+		switch {
+		case resolver != nil:
+			changes2, err := resolver(conflicts)
+			if err != nil {
+				return Changes{}, SyncState{}, ErrResolverError{err}
+			}
+			changes.Sessions = append(changes.Sessions, changes2.Sessions...)
+			changes.Timeframes = append(changes.Timeframes, changes2.Timeframes...)
+			changes.Tasks = append(changes.Tasks, changes2.Tasks...)
+		case s.QueueConflicts:
+			t := conflicts.touched()
+			queued = queue(t, m.original, m.remote)
+			// the conflicting rows stay as they are locally until the conflicts are resolved
+			remoteDelta.ExportedDatabase = remoteDelta.only(t, false)
+			log.Printf("queued %d conflicts", queued.Len())
+		default:
+			return Changes{}, SyncState{}, ErrConflictNoResolver
+		}
+	}
+
+	for i, c := range changes.Sessions {
+		log.Printf("change %d: session: %v", i, c)
+	}
+	for i, t := range changes.Timeframes {
+		log.Printf("change %d: timeframe: %v", i, t)
+	}
+	for i, t := range changes.Tasks {
+		log.Printf("change %d: task: %v", i, t)
+	}
+
+	if err := context.Cause(ctx); err != nil {
+		// e.g. the lock was lost while the user was resolving conflicts
+		return Changes{}, SyncState{}, err
+	}
+	if status != nil {
+		status <- "更新"
+	}
+	newState := SyncState{ServerSeq: remoteDelta.Seq, LocalSeq: m.localDelta.Seq, Queued: queued}
+	if len(changes.Sessions) > 0 || len(changes.Timeframes) > 0 || len(changes.Tasks) > 0 {
+		newState.ServerSeq, err = s.Transport.ApplyChanges(ctx, changes)
+		if err != nil {
+			return Changes{}, SyncState{}, fmt.Errorf("apply changes: %w", err)
+		}
+	}
+	err = applyDelta(db, remoteDelta, changes)
+	if err != nil {
+		return Changes{}, SyncState{}, fmt.Errorf("local apply: %w", err)
+	}
+	err = db.PruneChangelog(newState.LocalSeq)
+	if err != nil {
+		log.Printf("SyncDatabase: prune changelog: %s", err)
+	}
+	return changes, newState, nil
+}
+
+// lock locks the canonical database (only for downloading if preview is set), and returns the locked context and a function to unlock it that logs errors.
+func (s Syncer) lock(ctx context.Context, status chan<- string, preview bool) (locked context.Context, unlock func(), err error) {
+	if status != nil {
+		status <- "施錠"
+	}
+	var unlockTransport func(context.Context) error
+	if pl, ok := s.Transport.(previewLocker); ok && preview {
+		locked, unlockTransport, err = pl.lockPreview(ctx)
+	} else {
+		locked, unlockTransport, err = s.Transport.Lock(ctx)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("lock: %w", err)
+	}
+	return locked, func() {
+		if status != nil {
+			status <- "解錠"
+		}
+		err := unlockTransport(ctx)
+		if err != nil {
+			log.Printf("SyncDatabase: unlock: %s", err)
+		}
+	}, nil
+}
+
+// merged is a merge of the local and remote deltas, before conflicts are resolved.
+type merged struct {
+	localDelta, remoteDelta Delta
+	// original, local and remote are the inputs of the merge.
+	original, local, remote ExportedDatabase
+	changes                 Changes
+	conflicts               MergeConflicts
+}
+
+// merge downloads the remote delta, collects the local delta (and the queued conflicts in state), and merges them.
+// It does not write to either database.
+func (s Syncer) merge(ctx context.Context, state SyncState, db *database.Database, status chan<- string) (merged, error) {
+	if status != nil {
+		status <- "取得"
+	}
+	var remoteDelta, localDelta Delta
+	var localOriginal ExportedDatabase
+	var err1, err2 error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		remoteDelta, err1 = s.Transport.DownloadDelta(ctx, state.ServerSeq)
+	}()
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		return merged{}, err
+	}
+	defer tx.Rollback()
+	go func() {
+		defer wg.Done()
+		localDelta, localOriginal, err2 = exportDelta(tx, state.LocalSeq, true)
+	}()
+	wg.Wait()
+	if err1 != nil {
+		return merged{}, fmt.Errorf("download: %w", err1)
+	}
+	if err2 != nil {
+		return merged{}, fmt.Errorf("local export: %w", err2)
+	}
+	if remoteDelta.Seq == 0 {
+		// the canonical database is empty (e.g. a new server, or encryption was just enabled), so upload every row, even if the sync state says they were synced
+		localDelta, err = exportAll(tx)
+		if err != nil {
+			return merged{}, fmt.Errorf("local export: %w", err)
+		}
+		localOriginal = ExportedDatabase{}
+	} else {
+		if state.Queued.Len() > 0 {
+			log.Printf("merging %d queued conflicts again", state.Queued.Len())
+			if err := requeue(tx, state.Queued, &localDelta, &remoteDelta, &localOriginal); err != nil {
+				return merged{}, fmt.Errorf("queued conflicts: %w", err)
+			}
+		}
+		if remoteDelta.ResetSeq > 0 && state.ServerSeq <= remoteDelta.ResetSeq {
+			// the canonical database was emptied since our last sync (e.g. another client set up encryption), so upload the rows it lacks as if they were new
+			log.Printf("canonical database was reset at seq %d, after our last sync at %d", remoteDelta.ResetSeq, state.ServerSeq)
+			all, err := exportAll(tx)
+			if err != nil {
+				return merged{}, fmt.Errorf("local export: %w", err)
+			}
+			t := localDelta.touched()
+			for table, ids := range remoteDelta.touched() {
+				for id := range ids {
+					t.add(table, id)
+				}
+			}
+			localDelta.appendRows(all.only(t, false))
+		}
+	}
+	log.Printf("remote delta has %d sessions and %d timeframes (seq %d)", len(remoteDelta.Sessions), len(remoteDelta.Timeframes), remoteDelta.Seq)
+	log.Printf("local delta has %d sessions and %d timeframes (seq %d)", len(localDelta.Sessions), len(localDelta.Timeframes), localDelta.Seq)
+	if status != nil {
+		status <- "マージ"
+	}
+	m := merged{localDelta: localDelta, remoteDelta: remoteDelta}
+	m.original, m.local, m.remote, err = mergeInputs(tx, localDelta, remoteDelta, localOriginal)
+	if err != nil {
+		return merged{}, fmt.Errorf("merge inputs: %w", err)
+	}
+	tx.Rollback()
+
+	m.changes, m.conflicts = MergeWithOptions(m.original, m.local, m.remote, s.MergeOptions)
+	log.Printf("num of conflicts: %d", m.conflicts.Len())
+	return m, nil
+}
@@ -0,0 +1,163 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/jmoiron/sqlx"
+	"nyiyui.ca/jts/data"
+)
+
+var ErrEmptyTag = errors.New("tag must not be empty")
+
+// LoadSessionTags sets the Tags of each session from the database.
+func LoadSessionTags(q sqlx.Queryer, sessions []data.Session) error {
+	for i := range sessions {
+		var tags []string
+		err := sqlx.Select(q, &tags, "SELECT tag FROM session_tags WHERE session_id = ? ORDER BY tag", sessions[i].ID)
+		if err != nil {
+			return fmt.Errorf("get tags for session %s: %w", sessions[i].ID, err)
+		}
+		sessions[i].Tags = tags
+	}
+	return nil
+}
+
+// SetTags makes the session have exactly the given tags.
+// Only the difference is written, so unchanged tags do not show up in the changelog.
+func SetTags(tx *sqlx.Tx, sessionID string, tags []string) error {
+	_, err := setTags(tx, sessionID, tags)
+	return err
+}
+
+// setTags is SetTags, and also reports whether the tags changed.
+func setTags(tx *sqlx.Tx, sessionID string, tags []string) (bool, error) {
+	tags = data.NormalizeTags(tags)
+	var current []string
+	err := tx.Select(&current, "SELECT tag FROM session_tags WHERE session_id = ?", sessionID)
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for _, tag := range current {
+		if !slices.Contains(tags, tag) {
+			_, err = tx.Exec("DELETE FROM session_tags WHERE session_id = ? AND tag = ?", sessionID, tag)
+			if err != nil {
+				return false, err
+			}
+			changed = true
+		}
+	}
+	for _, tag := range tags {
+		if !slices.Contains(current, tag) {
+			if _, err := addTag(tx, sessionID, tag); err != nil {
+				return false, err
+			}
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// addTag adds the tag to the session, and reports whether the session did not have it yet.
+func addTag(tx *sqlx.Tx, sessionID, tag string) (bool, error) {
+	_, err := tx.Exec("INSERT OR IGNORE INTO tags (name) VALUES (?)", tag)
+	if err != nil {
+		return false, err
+	}
+	res, err := tx.Exec("INSERT OR IGNORE INTO session_tags (session_id, tag) VALUES (?, ?)", sessionID, tag)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// SetSessionTags makes the session have exactly the given tags.
+func (d *Database) SetSessionTags(sessionID string, tags []string) error {
+	tx := d.DB.MustBegin()
+	defer tx.Rollback()
+	changed, err := setTags(tx, sessionID, tags)
+	if err != nil {
+		return err
+	}
+	if changed {
+		if err := touchSession(tx, sessionID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// TagSession adds the tag to the session. It is not an error if the session already has the tag.
+func (d *Database) TagSession(sessionID, tag string) error {
+	tx := d.DB.MustBegin()
+	defer tx.Rollback()
+	if err := TagSession(tx, sessionID, tag); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// TagSession adds the tag to the session in tx. It is not an error if the session already has the tag.
+func TagSession(tx *sqlx.Tx, sessionID, tag string) error {
+	tags := data.NormalizeTags([]string{tag})
+	if len(tags) == 0 {
+		return ErrEmptyTag
+	}
+	added, err := addTag(tx, sessionID, tags[0])
+	if err != nil {
+		return err
+	}
+	if added {
+		return touchSession(tx, sessionID)
+	}
+	return nil
+}
+
+// UntagSession removes the tag from the session. It is not an error if the session does not have the tag.
+func (d *Database) UntagSession(sessionID, tag string) error {
+	tx := d.DB.MustBegin()
+	defer tx.Rollback()
+	res, err := tx.Exec("DELETE FROM session_tags WHERE session_id = ? AND tag = ?", sessionID, tag)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n > 0 {
+		if err := touchSession(tx, sessionID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetTags returns all tag names in use, sorted.
+func (d *Database) GetTags() ([]string, error) {
+	var tags []string
+	err := d.DB.Select(&tags, "SELECT DISTINCT tag FROM session_tags ORDER BY tag")
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// GetSessionsByTag returns sessions with the tag, most recently created first.
+func (d *Database) GetSessionsByTag(tag string) ([]data.Session, error) {
+	var ids []string
+	err := d.DB.Select(&ids, "SELECT session_id FROM session_tags WHERE tag = ? ORDER BY (SELECT rowid FROM sessions WHERE id = session_id) DESC", tag)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]data.Session, 0, len(ids))
+	for _, id := range ids {
+		session, err := d.GetSession(id)
+		if err != nil {
+			return nil, fmt.Errorf("get session %s: %w", id, err)
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, nil
+}
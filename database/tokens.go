@@ -0,0 +1,114 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// APIToken is an API token issued by the server (see migration 010). The token itself is not stored, only its hash.
+type APIToken struct {
+	Rowid int    `db:"rowid"`
+	ID    string `db:"id"`
+	// Hash is the hash of the token (see tokens.TokenHash.String).
+	Hash        string           `db:"hash"`
+	Name        string           `db:"name"`
+	Permissions TokenPermissions `db:"permissions"`
+	CreatedAt   time.Time        `db:"created_at"`
+	LastUsedAt  *time.Time       `db:"last_used_at"`
+	// ExpiresAt is nil if the token does not expire.
+	ExpiresAt *time.Time `db:"expires_at"`
+	// RevokedAt is nil unless the token was revoked.
+	RevokedAt *time.Time `db:"revoked_at"`
+}
+
+// Valid reports whether the token can be used at now, i.e. it is neither revoked nor expired.
+func (t APIToken) Valid(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || now.Before(*t.ExpiresAt))
+}
+
+// TokenPermissions are the permissions of a token, stored space-separated.
+type TokenPermissions []string
+
+// Scan implements sql.Scanner.
+func (p *TokenPermissions) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case string:
+		*p = strings.Fields(src)
+	case []byte:
+		*p = strings.Fields(string(src))
+	default:
+		return fmt.Errorf("cannot scan %T into permissions", src)
+	}
+	return nil
+}
+
+// Value implements driver.Valuer.
+func (p TokenPermissions) Value() (driver.Value, error) {
+	return strings.Join(p, " "), nil
+}
+
+// AddAPIToken adds a token and returns its ID.
+func (d *Database) AddAPIToken(token APIToken) (string, error) {
+	tx := d.DB.MustBegin()
+	defer tx.Rollback()
+	res, err := tx.Exec("INSERT INTO api_tokens (hash, name, permissions, created_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		token.Hash, token.Name, token.Permissions, token.CreatedAt, token.ExpiresAt)
+	if err != nil {
+		return "", err
+	}
+	rowid, err := res.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	var id string
+	err = tx.Get(&id, "SELECT id FROM api_tokens WHERE rowid = ?", rowid)
+	if err != nil {
+		return "", err
+	}
+	return id, tx.Commit()
+}
+
+// GetAPITokens returns all tokens, including revoked and expired ones, most recently created first.
+func (d *Database) GetAPITokens() ([]APIToken, error) {
+	var tokens []APIToken
+	err := d.DB.Select(&tokens, "SELECT * FROM api_tokens ORDER BY created_at DESC, rowid DESC")
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// GetAPITokenByHash returns the token with the hash, or sql.ErrNoRows.
+func (d *Database) GetAPITokenByHash(hash string) (APIToken, error) {
+	var token APIToken
+	err := d.DB.Get(&token, "SELECT * FROM api_tokens WHERE hash = ?", hash)
+	if err != nil {
+		return APIToken{}, err
+	}
+	return token, nil
+}
+
+// RevokeAPIToken revokes the token at the given time. Revoking a revoked token keeps the first revocation time.
+func (d *Database) RevokeAPIToken(id string, at time.Time) error {
+	res, err := d.DB.Exec("UPDATE api_tokens SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?", at, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TouchAPIToken records that the token was used at the given time.
+func (d *Database) TouchAPIToken(id string, at time.Time) error {
+	_, err := d.DB.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?", at, id)
+	return err
+}
@@ -0,0 +1,104 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Columns lists all CSV columns.
+var Columns = []string{"date", "start", "end", "duration", "hours", "session", "task", "tags", "notes", "done", "session_id", "timeframe_id", "task_id"}
+
+// DefaultColumns are the CSV columns used if none are given.
+var DefaultColumns = []string{"date", "start", "end", "duration", "session", "task"}
+
+// ValidateColumns returns an error if a column is unknown.
+func ValidateColumns(columns []string) error {
+	for _, c := range columns {
+		if !slices.Contains(Columns, c) {
+			return fmt.Errorf("unknown column %q", c)
+		}
+	}
+	return nil
+}
+
+// WriteCSV writes the entries with a header row.
+// Dates and times are in opts.Location; running timeframes have an empty end.
+func WriteCSV(w io.Writer, entries []Entry, opts Options) error {
+	columns := opts.Columns
+	if len(columns) == 0 {
+		columns = DefaultColumns
+	}
+	if err := ValidateColumns(columns); err != nil {
+		return err
+	}
+	loc := opts.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return err
+	}
+	record := make([]string, len(columns))
+	for _, e := range entries {
+		for i, c := range columns {
+			record[i] = csvField(e, c, loc)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvField(e Entry, column string, loc *time.Location) string {
+	switch column {
+	case "date":
+		return e.Start.In(loc).Format("2006-01-02")
+	case "start":
+		return e.Start.In(loc).Format("15:04")
+	case "end":
+		if e.End == nil {
+			return ""
+		}
+		// the date is included if the timeframe ends on another day
+		if e.End.In(loc).Format("2006-01-02") != e.Start.In(loc).Format("2006-01-02") {
+			return e.End.In(loc).Format("2006-01-02 15:04")
+		}
+		return e.End.In(loc).Format("15:04")
+	case "duration":
+		d := time.Duration(e.DurationSeconds) * time.Second
+		return fmt.Sprintf("%d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	case "hours":
+		return strconv.FormatFloat(float64(e.DurationSeconds)/3600, 'f', 2, 64)
+	case "session":
+		return e.Session
+	case "task":
+		if e.Task == nil {
+			return ""
+		}
+		return *e.Task
+	case "tags":
+		return strings.Join(e.Tags, ",")
+	case "notes":
+		return e.Notes
+	case "done":
+		return strconv.FormatBool(e.Done)
+	case "session_id":
+		return e.SessionID
+	case "timeframe_id":
+		return e.TimeframeID
+	case "task_id":
+		if e.TaskID == nil {
+			return ""
+		}
+		return *e.TaskID
+	}
+	return ""
+}
@@ -0,0 +1,179 @@
+// Package export writes timesheets (timeframes joined with their session and task) for use outside jts.
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+)
+
+// Format is an export file format.
+type Format string
+
+const (
+	FormatCSV  Format = "csv"
+	FormatJSON Format = "json"
+	FormatICal Format = "ical"
+)
+
+// Formats lists all formats.
+var Formats = []Format{FormatCSV, FormatJSON, FormatICal}
+
+// ContentType returns the MIME type of the format.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatJSON:
+		return "application/json"
+	case FormatICal:
+		return "text/calendar; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// Extension returns the file name extension of the format, without the dot.
+func (f Format) Extension() string {
+	if f == FormatICal {
+		return "ics"
+	}
+	return string(f)
+}
+
+// Entry is one timeframe joined with its session and task.
+// Its JSON encoding is part of the documented schema (see README.md), so fields must only be added.
+type Entry struct {
+	TimeframeID string `json:"timeframe_id"`
+	SessionID   string `json:"session_id"`
+	// Session is the description of the session.
+	Session string `json:"session"`
+	Notes   string `json:"notes"`
+	// TaskID and Task (the description of the task) are null if the session has no task.
+	TaskID *string   `json:"task_id"`
+	Task   *string   `json:"task"`
+	Start  time.Time `json:"start"`
+	// End is null if the timeframe is running.
+	End *time.Time `json:"end"`
+	// DurationSeconds is up to the time of export for running timeframes.
+	DurationSeconds int64 `json:"duration_seconds"`
+	Done            bool  `json:"done"`
+	// Tags are the sorted tags of the session.
+	Tags []string `json:"tags"`
+}
+
+// Document is the top-level JSON object.
+type Document struct {
+	// Version is incremented on incompatible changes to the schema.
+	Version int       `json:"version"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Entries []Entry   `json:"entries"`
+}
+
+// SchemaVersion is the current Document.Version.
+const SchemaVersion = 1
+
+// Options specifies what to export and how.
+type Options struct {
+	// From and To is the range to export. Timeframes overlapping the range are exported whole.
+	From, To time.Time
+	// Location is used for dates and times in CSV. It defaults to UTC.
+	Location *time.Location
+	// Columns are the CSV columns. They default to DefaultColumns.
+	Columns []string
+	// Now is used for running timeframes. It defaults to the current time.
+	Now time.Time
+}
+
+// Entries returns the timeframes overlapping the range in opts, ordered by start time.
+func Entries(db *database.Database, opts Options) ([]Entry, error) {
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	timeframes, err := db.GetTimeframesBetween(opts.From, opts.To)
+	if err != nil {
+		return nil, fmt.Errorf("get timeframes: %w", err)
+	}
+	tasks, err := db.GetTasks()
+	if err != nil {
+		return nil, fmt.Errorf("get tasks: %w", err)
+	}
+	taskDescriptions := map[string]string{}
+	for _, task := range tasks {
+		taskDescriptions[task.ID] = task.Description
+	}
+	sessions := map[string]data.Session{}
+	entries := make([]Entry, 0, len(timeframes))
+	for _, tf := range timeframes {
+		session, ok := sessions[tf.SessionID]
+		if !ok {
+			session, err = db.GetSession(tf.SessionID)
+			if err != nil {
+				return nil, fmt.Errorf("get session %s: %w", tf.SessionID, err)
+			}
+			sessions[tf.SessionID] = session
+		}
+		entries = append(entries, newEntry(session, tf, taskDescriptions, opts.Now))
+	}
+	return entries, nil
+}
+
+func newEntry(session data.Session, tf data.Timeframe, taskDescriptions map[string]string, now time.Time) Entry {
+	e := Entry{
+		TimeframeID: tf.ID,
+		SessionID:   session.ID,
+		Session:     session.Description,
+		Notes:       session.Notes,
+		TaskID:      session.TaskID,
+		Start:       tf.Start,
+		End:         tf.End,
+		Done:        tf.Done,
+		Tags:        session.Tags,
+	}
+	if e.Tags == nil {
+		e.Tags = []string{}
+	}
+	if session.TaskID != nil {
+		if description, ok := taskDescriptions[*session.TaskID]; ok {
+			e.Task = &description
+		}
+	}
+	end := now
+	if tf.End != nil {
+		end = *tf.End
+	}
+	e.DurationSeconds = int64(end.Sub(tf.Start) / time.Second)
+	return e
+}
+
+// Write writes the entries in the format.
+func Write(w io.Writer, format Format, entries []Entry, opts Options) error {
+	switch format {
+	case FormatCSV:
+		return WriteCSV(w, entries, opts)
+	case FormatJSON:
+		return WriteJSON(w, entries, opts)
+	case FormatICal:
+		return WriteICal(w, entries, opts)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// WriteJSON writes the entries as a Document.
+func WriteJSON(w io.Writer, entries []Entry, opts Options) error {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(Document{
+		Version: SchemaVersion,
+		From:    opts.From,
+		To:      opts.To,
+		Entries: entries,
+	})
+}
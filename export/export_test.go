@@ -0,0 +1,106 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func testEntries() []Entry {
+	jst := time.FixedZone("JST", 9*60*60)
+	return []Entry{
+		{
+			TimeframeID:     "tf1",
+			SessionID:       "s1",
+			Session:         "write report, part 1",
+			Notes:           "line 1\nline 2",
+			TaskID:          ptr("t1"),
+			Task:            ptr("jts"),
+			Start:           time.Date(2025, 4, 1, 23, 0, 0, 0, jst),
+			End:             ptr(time.Date(2025, 4, 2, 0, 30, 0, 0, jst)),
+			DurationSeconds: 90 * 60,
+		},
+		{
+			TimeframeID:     "tf2",
+			SessionID:       "s2",
+			Session:         "running",
+			Start:           time.Date(2025, 4, 2, 9, 0, 0, 0, jst),
+			DurationSeconds: 60 * 60,
+		},
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteCSV(&buf, testEntries(), Options{
+		Location: time.FixedZone("JST", 9*60*60),
+		Columns:  []string{"date", "start", "end", "duration", "hours", "session", "task"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `date,start,end,duration,hours,session,task
+2025-04-01,23:00,2025-04-02 00:30,1:30,1.50,"write report, part 1",jts
+2025-04-02,09:00,,1:00,1.00,running,
+`
+	if buf.String() != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+	if err := WriteCSV(&buf, nil, Options{Columns: []string{"cost"}}); err == nil {
+		t.Fatal("expected unknown column to fail")
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, testEntries(), Options{}); err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["version"] != float64(SchemaVersion) {
+		t.Fatalf("unexpected version %v", doc["version"])
+	}
+	entries := doc["entries"].([]any)
+	running := entries[1].(map[string]any)
+	if running["end"] != nil || running["task"] != nil || running["duration_seconds"] != float64(3600) {
+		t.Fatalf("unexpected running entry %#v", running)
+	}
+}
+
+func TestWriteICal(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteICal(&buf, testEntries(), Options{Now: time.Date(2025, 4, 2, 1, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := buf.String()
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"UID:tf1@jts.nyiyui.ca\r\n",
+		"DTSTART:20250401T140000Z\r\n",
+		"DTEND:20250401T153000Z\r\n",
+		`SUMMARY:write report\, part 1` + "\r\n",
+		`DESCRIPTION:line 1\nline 2` + "\r\n",
+		"CATEGORIES:jts\r\n",
+		// running timeframe ends at Now
+		"DTEND:20250402T010000Z\r\n",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %q in\n%s", want, s)
+		}
+	}
+	for _, line := range strings.Split(s, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line longer than 75 octets: %q", line)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package export
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const icalTimeFormat = "20060102T150405Z"
+
+// WriteICal writes the entries as an iCalendar (RFC 5545) calendar with one VEVENT per entry.
+// Running timeframes end at opts.Now.
+func WriteICal(w io.Writer, entries []Entry, opts Options) error {
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	bw := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeICalLine(bw, name+":"+value)
+	}
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//nyiyui.ca//jts//EN")
+	line("CALSCALE", "GREGORIAN")
+	for _, e := range entries {
+		end := now
+		if e.End != nil {
+			end = *e.End
+		}
+		line("BEGIN", "VEVENT")
+		line("UID", e.TimeframeID+"@jts.nyiyui.ca")
+		line("DTSTAMP", now.UTC().Format(icalTimeFormat))
+		line("DTSTART", e.Start.UTC().Format(icalTimeFormat))
+		line("DTEND", end.UTC().Format(icalTimeFormat))
+		line("SUMMARY", escapeICalText(e.Session))
+		if e.Notes != "" {
+			line("DESCRIPTION", escapeICalText(e.Notes))
+		}
+		var categories []string
+		if e.Task != nil {
+			categories = append(categories, escapeICalText(*e.Task))
+		}
+		for _, tag := range e.Tags {
+			categories = append(categories, escapeICalText(tag))
+		}
+		if len(categories) > 0 {
+			line("CATEGORIES", strings.Join(categories, ","))
+		}
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+var icalTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func escapeICalText(s string) string {
+	return icalTextEscaper.Replace(s)
+}
+
+// writeICalLine writes a content line, folded so that no line is longer than 75 octets.
+func writeICalLine(w *bufio.Writer, s string) {
+	limit := 75
+	for len(s) > limit {
+		// do not split UTF-8 sequences
+		i := limit
+		for i > 0 && !utf8.RuneStart(s[i]) {
+			i--
+		}
+		w.WriteString(s[:i])
+		w.WriteString("\r\n ")
+		s = s[i:]
+		// continuation lines start with a space
+		limit = 74
+	}
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
@@ -24,6 +24,8 @@
             src = ./.;
             vendorHash = "sha256-ZS5KYdFQgeIW8FdT0GXNcQAYVEdhkSD7CGmVcQI36c4=";
             subPackages = [ package ];
+            # full-text search uses FTS5
+            tags = [ "sqlite_fts5" ];
             ldflags = [ "-X nyiyui.ca/jts/server.vcsInfo=${version}" ];
             buildInputs = with pkgs; [
               gtk4
@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/diamondburned/gotk4/pkg/core/gioutil"
@@ -20,10 +22,17 @@ type SessionListModel struct {
 	*gioutil.ListModel[data.Session]
 	db   *database.Database
 	sema *semaphore.Weighted
+	// problems are the problems found by CheckTimeframes, by session ID. Only used on the main thread.
+	problems map[string][]database.Problem
+	// query is the search query set by SetQuery.
+	query atomic.Value
+	// snippets are the highlighted snippets of sessions matching the query, by session ID. Only used on the main thread.
+	snippets map[string]string
 }
 
 func NewSessionListModel(db *database.Database) *SessionListModel {
-	m := &SessionListModel{SessionListModelType.New(), db, semaphore.NewWeighted(1)}
+	m := &SessionListModel{SessionListModelType.New(), db, semaphore.NewWeighted(1), nil, atomic.Value{}, nil}
+	m.query.Store("")
 	m.FillFromDatabase()
 	db.Notify(m.updateHook) // TODO: leak?
 	return m
@@ -36,34 +45,88 @@ func (m *SessionListModel) FillFromDatabase() {
 		// there is no backpressure, so we should not add more to the metaphorical backlog
 		return
 	}
-	sessions, err := m.db.GetLatestSessions(100, 0)
-	if err != nil && err != sql.ErrNoRows {
-		panic(err)
+	query := m.query.Load().(string)
+	var sessions []data.Session
+	var err error
+	snippets := map[string]string{}
+	if strings.TrimSpace(query) == "" {
+		sessions, err = m.db.GetLatestSessions(100, 0)
+		if err != nil && err != sql.ErrNoRows {
+			panic(err)
+		}
+	} else {
+		var results []database.SearchResult
+		results, err = m.db.SearchSessions(query, database.SearchRange{})
+		if err != nil {
+			panic(err)
+		}
+		for _, result := range results {
+			sessions = append(sessions, result.Session)
+			snippets[result.Session.ID] = result.Snippet
+		}
+	}
+	problems, err2 := m.db.CheckTimeframes()
+	if err2 != nil {
+		panic(err2)
+	}
+	problemsBySession := map[string][]database.Problem{}
+	for _, p := range problems {
+		for _, tf := range p.Timeframes {
+			problemsBySession[tf.SessionID] = append(problemsBySession[tf.SessionID], p)
+		}
 	}
 	log.Printf("idleadd")
 	if err == sql.ErrNoRows {
 		glib.IdleAdd(func() {
-			defer m.sema.Release(1)
+			defer m.refillIfQueryChanged(query)
+			m.problems = problemsBySession
+			m.snippets = snippets
 			log.Printf("splice0")
 			m.Splice(0, m.Len())
 		})
 	} else {
 		glib.IdleAdd(func() {
-			defer m.sema.Release(1)
+			defer m.refillIfQueryChanged(query)
+			m.problems = problemsBySession
+			m.snippets = snippets
 			log.Printf("splice")
 			m.Splice(0, m.Len(), sessions...)
 		})
 	}
 }
 
+// refillIfQueryChanged releases the semaphore, and fills the list again if the query was changed while it was filled with query.
+func (m *SessionListModel) refillIfQueryChanged(query string) {
+	m.sema.Release(1)
+	if m.query.Load().(string) != query {
+		go m.FillFromDatabase()
+	}
+}
+
+// SetQuery shows only the sessions matching query (see database.SearchSessions), or the latest sessions if query is empty.
+func (m *SessionListModel) SetQuery(query string) {
+	m.query.Store(query)
+	go m.FillFromDatabase()
+}
+
+// Snippet returns the highlighted snippet of the session matching the query, or "".
+func (m *SessionListModel) Snippet(sessionID string) string {
+	return m.snippets[sessionID]
+}
+
+// Problems returns the problems with the timeframes of the session.
+func (m *SessionListModel) Problems(sessionID string) []database.Problem {
+	return m.problems[sessionID]
+}
+
 func (m *SessionListModel) updateHook(op int, db string, table string, rowid int64) {
-	if table != "sessions" && table != "time_frames" {
+	if table != "sessions" && table != "time_frames" && table != "session_tags" {
 		return
 	}
 	m.FillFromDatabase()
 }
 
-func NewSessionListItemFactory(parent *gtk.Window, db *database.Database, changed chan<- struct{}) *gtk.SignalListItemFactory {
+func NewSessionListItemFactory(parent *gtk.Window, db *database.Database, m *SessionListModel, changed chan<- struct{}) *gtk.SignalListItemFactory {
 	factory := gtk.NewSignalListItemFactory()
 	// we can't use builder factory as it doesn't support introspection of Go objects
 	factory.ConnectSetup(func(object *glib.Object) {
@@ -71,15 +134,24 @@ func NewSessionListItemFactory(parent *gtk.Window, db *database.Database, change
 		label := gtk.NewLabel("")
 		label.SetHExpand(true)
 		timeframes := gtk.NewLabel("")
+		snippet := gtk.NewLabel("")
+		snippet.AddCSSClass("caption")
+		snippet.SetWrap(true)
+		snippet.SetXAlign(0)
+		tags := gtk.NewBox(gtk.OrientationHorizontal, 4)
 		actions := gtk.NewBox(gtk.OrientationHorizontal, 0)
 		extend := gtk.NewButtonWithLabel("打刻延長")
+		timer := gtk.NewButtonWithLabel("開始")
 		edit := gtk.NewButtonWithLabel("修正")
 		actions.Append(extend)
+		actions.Append(timer)
 		actions.Append(edit)
 		actions.SetHAlign(gtk.AlignEnd)
 		box := gtk.NewBox(gtk.OrientationVertical, 0)
 		box.Append(label)
 		box.Append(timeframes)
+		box.Append(snippet)
+		box.Append(tags)
 		box.Append(actions)
 		listItem.SetChild(box)
 	})
@@ -88,15 +160,42 @@ func NewSessionListItemFactory(parent *gtk.Window, db *database.Database, change
 		box := listItem.Child().(*gtk.Box)
 		label := box.FirstChild().(*gtk.Label)
 		timeframes := label.NextSibling().(*gtk.Label)
-		actions := timeframes.NextSibling().(*gtk.Box)
+		snippet := timeframes.NextSibling().(*gtk.Label)
+		tags := snippet.NextSibling().(*gtk.Box)
+		actions := tags.NextSibling().(*gtk.Box)
 		session := SessionListModelType.ObjectValue(listItem.Item())
 		label.SetText(session.Description)
 		text := ""
+		running := false
 		for _, tf := range session.Timeframes {
-			text += fmt.Sprintf("%s - %s", tf.Start.Local(), tf.End.Local())
+			text += fmt.Sprintf("%s - %s", tf.StringStart(), tf.StringEnd())
+			if tf.Running() {
+				running = true
+			}
 		}
 		timeframes.SetText(text)
+		snippet.SetMarkup(snippetMarkup(m.Snippet(session.ID)))
+		snippet.SetVisible(m.Snippet(session.ID) != "")
+		for child := tags.FirstChild(); child != nil; child = tags.FirstChild() {
+			tags.Remove(child)
+		}
+		problems := m.Problems(session.ID)
+		if len(problems) > 0 {
+			badge := gtk.NewLabel(fmt.Sprintf("⚠ 問題%d件", len(problems)))
+			badge.AddCSSClass("caption")
+			badge.AddCSSClass("warning")
+			badge.SetTooltipText(problemsTooltip(session.ID, problems))
+			tags.Append(badge)
+		}
+		for _, tag := range session.Tags {
+			chip := gtk.NewLabel(tag)
+			chip.AddCSSClass("caption")
+			chip.AddCSSClass("accent")
+			tags.Append(chip)
+		}
+		tags.SetVisible(len(session.Tags) > 0 || len(problems) > 0)
 		extend := actions.FirstChild().(*gtk.Button)
+		extend.SetSensitive(!running)
 		extend.ConnectClicked(func() {
 			err := db.ExtendSession(session.ID, time.Now())
 			if err != nil {
@@ -106,7 +205,27 @@ func NewSessionListItemFactory(parent *gtk.Window, db *database.Database, change
 				changed <- struct{}{}
 			}
 		})
-		edit := extend.NextSibling().(*gtk.Button)
+		timer := extend.NextSibling().(*gtk.Button)
+		if running {
+			timer.SetLabel("停止")
+		} else {
+			timer.SetLabel("開始")
+		}
+		timer.ConnectClicked(func() {
+			var err error
+			if running {
+				err = db.StopTimer(session.ID)
+			} else {
+				err = db.StartTimer(session.ID)
+			}
+			if err != nil {
+				panic(err)
+			}
+			if changed != nil {
+				changed <- struct{}{}
+			}
+		})
+		edit := timer.NextSibling().(*gtk.Button)
 		edit.ConnectClicked(func() {
 			esw := NewEditSessionWindow(db, session.ID, changed)
 			esw.Window.SetTransientFor(parent)
@@ -118,6 +237,42 @@ func NewSessionListItemFactory(parent *gtk.Window, db *database.Database, change
 	return factory
 }
 
+// snippetMarkup returns Pango markup for a snippet from database.SearchSessions, with the matches in bold.
+func snippetMarkup(snippet string) string {
+	var b strings.Builder
+	for _, part := range database.SplitHighlights(snippet) {
+		if part.Match {
+			b.WriteString("<b>" + glib.MarkupEscapeText(part.Text) + "</b>")
+		} else {
+			b.WriteString(glib.MarkupEscapeText(part.Text))
+		}
+	}
+	return b.String()
+}
+
+// problemsTooltip describes the problems with the timeframes of the session.
+func problemsTooltip(sessionID string, problems []database.Problem) string {
+	lines := make([]string, 0, len(problems)+1)
+	for _, p := range problems {
+		tf := p.Timeframes[0]
+		switch {
+		case p.Kind == database.ProblemZeroLength:
+			lines = append(lines, fmt.Sprintf("%s - %s: 長さが0です", tf.StringStart(), tf.StringEnd()))
+		case p.Kind == database.ProblemNegativeLength:
+			lines = append(lines, fmt.Sprintf("%s - %s: 終了が開始より前です", tf.StringStart(), tf.StringEnd()))
+		case p.SameSession():
+			lines = append(lines, fmt.Sprintf("%s - %s: このセッションの別の打刻と%s重複しています", tf.StringStart(), tf.StringEnd(), p.Overlap.Round(time.Minute)))
+		default:
+			if tf.SessionID != sessionID {
+				tf = p.Timeframes[1]
+			}
+			lines = append(lines, fmt.Sprintf("%s - %s: 他のセッションと%s重複しています", tf.StringStart(), tf.StringEnd(), p.Overlap.Round(time.Minute)))
+		}
+	}
+	lines = append(lines, "jts check -fix で修正できます")
+	return strings.Join(lines, "\n")
+}
+
 var TaskListModelType = gioutil.NewListModelType[data.Task]()
 
 type TaskListModel struct {
@@ -176,6 +331,10 @@ func NewTaskListItemFactory(parent *gtk.Window, db *database.Database, changed c
 		actions := gtk.NewBox(gtk.OrientationHorizontal, 0)
 		newSession := gtk.NewButtonWithLabel("セッション作成")
 		actions.Append(newSession)
+		done := gtk.NewButtonWithLabel("完了")
+		actions.Append(done)
+		edit := gtk.NewButtonWithLabel("修正")
+		actions.Append(edit)
 		actions.SetHAlign(gtk.AlignEnd)
 		box.Append(label)
 		box.Append(actions)
@@ -187,9 +346,21 @@ func NewTaskListItemFactory(parent *gtk.Window, db *database.Database, changed c
 		label := box.FirstChild().(*gtk.Label)
 		actions := label.NextSibling().(*gtk.Box)
 		newSession := actions.FirstChild().(*gtk.Button)
+		done := newSession.NextSibling().(*gtk.Button)
+		edit := done.NextSibling().(*gtk.Button)
 
 		task := TaskListModelType.ObjectValue(listItem.Item())
-		label.SetText(task.Description)
+		label.SetText(taskLabel(task))
+		done.ConnectClicked(func() {
+			err := db.SetTaskStatus(task.ID, data.TaskStatusDone)
+			if err != nil {
+				panic(err)
+			}
+			changed <- struct{}{}
+		})
+		edit.ConnectClicked(func() {
+			PresentDialog(parent, NewEditTaskWindow(db, task.ID, changed).Window)
+		})
 		newSession.ConnectClicked(func() {
 			nsw := NewNewSessionWindow(db, changed)
 			nsw.SetTask(task)
@@ -202,3 +373,15 @@ func NewTaskListItemFactory(parent *gtk.Window, db *database.Database, changed c
 	// nothing to do for unbind and teardown
 	return factory
 }
+
+// taskLabel returns the description of the task with its due date and estimate, if any.
+func taskLabel(task data.Task) string {
+	label := task.Description
+	if task.Due != nil {
+		label += fmt.Sprintf("（期限：%s）", task.Due.Local().Format("2006-01-02 15:04"))
+	}
+	if task.Estimate != nil {
+		label += fmt.Sprintf("（見積：%s）", task.Estimate.String())
+	}
+	return label
+}
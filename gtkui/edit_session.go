@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/diamondburned/gotk4-adwaita/pkg/adw"
@@ -29,9 +30,11 @@ type EditSessionWindow struct {
 	TaskID             *gtk.Label
 	SessionDescription *gtk.Entry
 	SessionNotes       *gtk.TextView
+	SessionTags        *gtk.Entry
 	Timeframes         *gtk.ColumnView
 
 	sessionID string
+	taskID    *string
 	db        *database.Database
 	changed   chan<- struct{}
 }
@@ -48,6 +51,7 @@ func NewEditSessionWindow(db *database.Database, sessionID string, changed chan<
 	esw.TaskID = builder.GetObject("TaskID").Cast().(*gtk.Label)
 	esw.SessionDescription = builder.GetObject("SessionDescription").Cast().(*gtk.Entry)
 	esw.SessionNotes = builder.GetObject("SessionNotes").Cast().(*gtk.TextView)
+	esw.SessionTags = builder.GetObject("SessionTags").Cast().(*gtk.Entry)
 	esw.SaveButton.ConnectClicked(esw.save)
 	esw.DeleteButton.ConnectClicked(esw.delete_)
 
@@ -60,7 +64,9 @@ func NewEditSessionWindow(db *database.Database, sessionID string, changed chan<
 		esw.Window.SetTitle(fmt.Sprintf("%sを修正", session.Description))
 		esw.SessionDescription.SetText(session.Description)
 		esw.SessionNotes.Buffer().SetText(session.Notes)
+		esw.SessionTags.SetText(strings.Join(session.Tags, ", "))
 		log.Printf("task id: %v", session.TaskID)
+		esw.taskID = session.TaskID
 		if session.TaskID != nil {
 			esw.TaskIDLabel.SetVisible(true)
 			esw.TaskID.SetVisible(true)
@@ -95,10 +101,15 @@ func (esw *EditSessionWindow) save() {
 		Description: esw.SessionDescription.Buffer().Text(),
 		Notes:       buf.Text(buf.StartIter(), buf.EndIter(), false),
 		ID:          esw.sessionID,
+		TaskID:      esw.taskID,
 	})
 	if err != nil {
 		panic(err)
 	}
+	err = esw.db.SetSessionTags(esw.sessionID, strings.Split(esw.SessionTags.Text(), ","))
+	if err != nil {
+		panic(err)
+	}
 	esw.Window.Close()
 	esw.changed <- struct{}{}
 }
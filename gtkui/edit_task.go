@@ -0,0 +1,179 @@
+package gtkui
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/diamondburned/gotk4-adwaita/pkg/adw"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+)
+
+//go:embed edit_task.ui
+var EditTaskXML string
+
+// taskStatuses is in the same order as the TaskStatus drop down.
+var taskStatuses = []data.TaskStatus{data.TaskStatusOpen, data.TaskStatusDone, data.TaskStatusArchived}
+
+type EditTaskWindow struct {
+	Window           *gtk.Window
+	TaskId           *gtk.Label
+	SaveButton       *gtk.Button
+	DeleteButton     *gtk.Button
+	TaskDescription  *gtk.Entry
+	TaskStatus       *gtk.DropDown
+	TaskDue          *gtk.Entry
+	TaskDueHint      *gtk.Label
+	TaskEstimate     *gtk.Entry
+	TaskEstimateHint *gtk.Label
+
+	taskID     string
+	timeFormat string
+	db         *database.Database
+	changed    chan<- struct{}
+}
+
+// NewEditTaskWindow returns a window to edit the task, or to create a new task if taskID is empty.
+func NewEditTaskWindow(db *database.Database, taskID string, changed chan<- struct{}) *EditTaskWindow {
+	builder := gtk.NewBuilderFromString(EditTaskXML)
+	etw := new(EditTaskWindow)
+	etw.timeFormat = "2006-01-02 15:04"
+	etw.Window = builder.GetObject("EditTaskWindow").Cast().(*gtk.Window)
+	etw.TaskId = builder.GetObject("TaskId").Cast().(*gtk.Label)
+	etw.SaveButton = builder.GetObject("SaveButton").Cast().(*gtk.Button)
+	etw.DeleteButton = builder.GetObject("DeleteButton").Cast().(*gtk.Button)
+	etw.TaskDescription = builder.GetObject("TaskDescription").Cast().(*gtk.Entry)
+	etw.TaskStatus = builder.GetObject("TaskStatus").Cast().(*gtk.DropDown)
+	etw.TaskDue = builder.GetObject("TaskDue").Cast().(*gtk.Entry)
+	etw.TaskDueHint = builder.GetObject("TaskDueHint").Cast().(*gtk.Label)
+	etw.TaskEstimate = builder.GetObject("TaskEstimate").Cast().(*gtk.Entry)
+	etw.TaskEstimateHint = builder.GetObject("TaskEstimateHint").Cast().(*gtk.Label)
+	etw.changed = changed
+
+	etw.TaskId.SetLabel(taskID)
+	etw.SaveButton.ConnectClicked(etw.save)
+	etw.DeleteButton.ConnectClicked(etw.delete_)
+	etw.TaskDue.ConnectChanged(etw.update)
+	etw.TaskEstimate.ConnectChanged(etw.update)
+
+	etw.db = db
+	etw.taskID = taskID
+	if taskID == "" {
+		etw.DeleteButton.SetVisible(false)
+		return etw
+	}
+	task, err := db.GetTask(taskID)
+	if err == nil {
+		etw.Window.SetTitle(fmt.Sprintf("%sを修正", task.Description))
+		etw.TaskDescription.SetText(task.Description)
+		if i := slices.Index(taskStatuses, task.Status); i != -1 {
+			etw.TaskStatus.SetSelected(uint(i))
+		}
+		if task.Due != nil {
+			etw.TaskDue.SetText(task.Due.Local().Format(etw.timeFormat))
+		}
+		if task.Estimate != nil {
+			etw.TaskEstimate.SetText(task.Estimate.String())
+		}
+	}
+	etw.update()
+	return etw
+}
+
+func (etw *EditTaskWindow) update() {
+	due, err := etw.parseDue()
+	if err != nil {
+		etw.TaskDueHint.SetLabel(err.Error())
+	} else if due == nil {
+		etw.TaskDueHint.SetLabel("")
+	} else {
+		etw.TaskDueHint.SetLabel(time.Until(*due).Round(1 * time.Minute).String())
+	}
+	_, err = etw.parseEstimate()
+	if err != nil {
+		etw.TaskEstimateHint.SetLabel(err.Error())
+	} else {
+		etw.TaskEstimateHint.SetLabel("")
+	}
+}
+
+// parseDue parses the due date entry. An empty entry means no due date.
+func (etw *EditTaskWindow) parseDue() (*time.Time, error) {
+	if etw.TaskDue.Text() == "" {
+		return nil, nil
+	}
+	due, err := time.ParseInLocation(etw.timeFormat, etw.TaskDue.Text(), time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &due, nil
+}
+
+// parseEstimate parses the estimate entry. An empty entry means no estimate.
+func (etw *EditTaskWindow) parseEstimate() (*time.Duration, error) {
+	if etw.TaskEstimate.Text() == "" {
+		return nil, nil
+	}
+	estimate, err := time.ParseDuration(etw.TaskEstimate.Text())
+	if err != nil {
+		return nil, err
+	}
+	if estimate < 0 {
+		return nil, errors.New("見積は0以上にしてください")
+	}
+	return &estimate, nil
+}
+
+func (etw *EditTaskWindow) save() {
+	if etw.TaskDescription.Text() == "" {
+		return
+	}
+	due, err := etw.parseDue()
+	if err != nil {
+		return
+	}
+	estimate, err := etw.parseEstimate()
+	if err != nil {
+		return
+	}
+	task := data.Task{
+		ID:          etw.taskID,
+		Description: etw.TaskDescription.Text(),
+		Status:      taskStatuses[etw.TaskStatus.Selected()],
+		Due:         due,
+		Estimate:    estimate,
+	}
+	if etw.taskID == "" {
+		_, err = etw.db.AddTask(task)
+	} else {
+		err = etw.db.EditTask(task)
+	}
+	if err != nil {
+		panic(err)
+	}
+	etw.Window.Destroy()
+	etw.changed <- struct{}{}
+}
+
+func (etw *EditTaskWindow) delete_() {
+	ad := adw.NewAlertDialog("タスクを削除", "タスクを削除します。セッションは残りますが、タスクとの関連は外れます。")
+	ad.AddResponse("cancel", "削除しない")
+	ad.AddResponse("delete", "削除する")
+	ad.SetCloseResponse("cancel")
+	ad.SetDefaultResponse("delete")
+	ad.ConnectResponse(func(response string) {
+		if response == "delete" {
+			err := etw.db.DeleteTask(etw.taskID)
+			if err != nil {
+				panic(err)
+			}
+			etw.changed <- struct{}{}
+		}
+		etw.Window.Destroy()
+	})
+	ad.Present(etw.Window)
+}
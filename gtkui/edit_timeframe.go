@@ -63,7 +63,9 @@ func NewEditTimeframeWindow(db *database.Database, sessionID, timeframeID string
 		})
 		etw.timeframe = session.Timeframes[i]
 		etw.TimeframeStart.SetText(etw.timeframe.Start.Local().Format(etw.timeFormat))
-		etw.TimeframeEnd.SetText(etw.timeframe.End.Local().Format(etw.timeFormat))
+		if etw.timeframe.End != nil {
+			etw.TimeframeEnd.SetText(etw.timeframe.End.Local().Format(etw.timeFormat))
+		}
 		etw.update()
 	}
 	return etw
@@ -76,12 +78,26 @@ func (etw *EditTimeframeWindow) update() {
 	} else {
 		etw.TimeframeStartHint.SetLabel(time.Until(start).Round(1 * time.Minute).String())
 	}
-	end, err := time.ParseInLocation(etw.timeFormat, etw.TimeframeEnd.Text(), time.Local)
+	end, err := etw.parseEnd()
 	if err != nil {
 		etw.TimeframeEndHint.SetLabel(err.Error())
+	} else if end == nil {
+		etw.TimeframeEndHint.SetLabel("実行中")
 	} else {
-		etw.TimeframeEndHint.SetLabel(time.Until(end).Round(1 * time.Minute).String())
+		etw.TimeframeEndHint.SetLabel(time.Until(*end).Round(1 * time.Minute).String())
+	}
+}
+
+// parseEnd parses the end time entry. An empty entry means the timeframe is running.
+func (etw *EditTimeframeWindow) parseEnd() (*time.Time, error) {
+	if etw.TimeframeEnd.Text() == "" {
+		return nil, nil
 	}
+	end, err := time.ParseInLocation(etw.timeFormat, etw.TimeframeEnd.Text(), time.Local)
+	if err != nil {
+		return nil, err
+	}
+	return &end, nil
 }
 
 func (etw *EditTimeframeWindow) save() {
@@ -89,13 +105,14 @@ func (etw *EditTimeframeWindow) save() {
 	if err != nil {
 		return
 	}
-	end, err := time.ParseInLocation(etw.timeFormat, etw.TimeframeEnd.Text(), time.Local)
+	end, err := etw.parseEnd()
 	if err != nil {
 		return
 	}
 	err = etw.db.EditTimeframe(etw.sessionID, etw.timeframe.ID, data.Timeframe{
 		Start: start,
 		End:   end,
+		Done:  etw.timeframe.Done,
 	})
 	if err != nil {
 		panic(err)
@@ -3,57 +3,74 @@ package gtkui
 import (
 	"context"
 	_ "embed"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
-	"net/http"
-	"net/url"
-	"os"
 	"time"
 
 	"github.com/diamondburned/gotk4-adwaita/pkg/adw"
 	"github.com/diamondburned/gotk4/pkg/glib/v2"
 	"github.com/diamondburned/gotk4/pkg/gtk/v4"
 	"golang.org/x/sync/semaphore"
+	"nyiyui.ca/jts/config"
+	"nyiyui.ca/jts/daemon"
 	"nyiyui.ca/jts/database"
 	"nyiyui.ca/jts/database/sync"
-	"nyiyui.ca/jts/tokens"
 )
 
 //go:embed main_window.ui
 var MainWindowXML string
 
 type MainWindow struct {
-	token            tokens.Token
+	cfg              *config.Config
+	configPath       string
+	profile          config.Profile
 	db               *database.Database
-	originalEDPath   string
+	daemon           *daemon.Client
 	syncSemaphore    *semaphore.Weighted
 	syncBackgroundCh chan<- struct{}
 
 	Window                *adw.ApplicationWindow
 	newSessionButton      *gtk.Button
+	newTaskButton         *gtk.Button
+	reportButton          *gtk.Button
+	preferencesButton     *gtk.Button
 	toastOverlay          *adw.ToastOverlay
 	syncStatus            *gtk.Box
 	syncButton            *gtk.Button
+	syncPreviewButton     *gtk.Button
 	syncStatusLabel       *gtk.Label
 	syncConflictButtonBox *gtk.Box
 	currentListView       *gtk.ListView
 	taskListView          *gtk.ListView
+
+	// OnProfileSwitched is called after the current profile is switched or edited in the preferences.
+	// The window should then be replaced by one for the new profile.
+	OnProfileSwitched func()
 }
 
-func NewMainWindow(db *database.Database, token tokens.Token, originalEDPath string) *MainWindow {
+// NewMainWindow creates the main window for db, the database of the current profile in cfg.
+// Syncs are delegated to the profile's daemon when it is running, and run in-process otherwise.
+func NewMainWindow(db *database.Database, cfg *config.Config, configPath string) *MainWindow {
 	mw := new(MainWindow)
 	builder := gtk.NewBuilderFromString(MainWindowXML)
 	mw.db = db
-	mw.token = token
-	mw.originalEDPath = originalEDPath
+	mw.cfg = cfg
+	mw.configPath = configPath
+	mw.profile = cfg.CurrentProfile()
+	mw.daemon = daemon.NewClient(mw.profile.SocketPath())
 	mw.syncSemaphore = semaphore.NewWeighted(1)
 
 	mw.Window = builder.GetObject("MainWindow").Cast().(*adw.ApplicationWindow)
 	mw.newSessionButton = builder.GetObject("NewSessionButton").Cast().(*gtk.Button)
+	mw.newTaskButton = builder.GetObject("NewTaskButton").Cast().(*gtk.Button)
+	mw.reportButton = builder.GetObject("ReportButton").Cast().(*gtk.Button)
+	mw.preferencesButton = builder.GetObject("PreferencesButton").Cast().(*gtk.Button)
 	mw.toastOverlay = builder.GetObject("ToastOverlay").Cast().(*adw.ToastOverlay)
 	mw.syncStatus = builder.GetObject("SyncStatus").Cast().(*gtk.Box)
 	mw.syncButton = builder.GetObject("SyncButton").Cast().(*gtk.Button)
+	mw.syncPreviewButton = builder.GetObject("SyncPreviewButton").Cast().(*gtk.Button)
 	mw.syncStatusLabel = builder.GetObject("SyncStatusLabel").Cast().(*gtk.Label)
 	mw.syncConflictButtonBox = builder.GetObject("SyncConflictButtonBox").Cast().(*gtk.Box)
 
@@ -64,9 +81,28 @@ func NewMainWindow(db *database.Database, token tokens.Token, originalEDPath str
 		nsw.Window.SetApplication(mw.Window.Application())
 		nsw.Window.Show()
 	})
+	mw.newTaskButton.ConnectClicked(func() {
+		PresentDialog(&mw.Window.Window, NewEditTaskWindow(db, "", mw.syncBackgroundCh).Window)
+	})
+	mw.reportButton.ConnectClicked(func() {
+		PresentDialog(&mw.Window.Window, NewReportWindow(db).Window)
+	})
+	mw.preferencesButton.ConnectClicked(func() {
+		PresentDialog(&mw.Window.Window, NewPreferencesWindow(mw.cfg, mw.configPath, func() {
+			if mw.OnProfileSwitched != nil {
+				mw.OnProfileSwitched()
+			}
+		}).Window)
+	})
+	if len(cfg.Profiles) > 1 {
+		mw.Window.SetTitle(fmt.Sprintf("JTS - %s", mw.profile.Name))
+	}
 	mw.syncButton.ConnectClicked(func() {
 		go mw.sync(true)
 	})
+	mw.syncPreviewButton.ConnectClicked(func() {
+		go mw.preview()
+	})
 	syncBackgroundCh := make(chan struct{})
 	mw.syncBackgroundCh = syncBackgroundCh
 	go func() {
@@ -81,8 +117,12 @@ func NewMainWindow(db *database.Database, token tokens.Token, originalEDPath str
 		m := NewSessionListModel(db)
 		m2 := gtk.NewNoSelection(m)
 		mw.currentListView.SetModel(m2)
-		factory := NewSessionListItemFactory(&mw.Window.Window, db, mw.syncBackgroundCh)
+		factory := NewSessionListItemFactory(&mw.Window.Window, db, m, mw.syncBackgroundCh)
 		mw.currentListView.SetFactory(&factory.ListItemFactory)
+		search := builder.GetObject("SessionSearchEntry").Cast().(*gtk.SearchEntry)
+		search.ConnectSearchChanged(func() {
+			m.SetQuery(search.Text())
+		})
 	}
 	{
 		m := NewTaskListModel(db)
@@ -91,6 +131,11 @@ func NewMainWindow(db *database.Database, token tokens.Token, originalEDPath str
 		factory := NewTaskListItemFactory(&mw.Window.Window, db, mw.syncBackgroundCh)
 		mw.taskListView.SetFactory(&factory.ListItemFactory)
 	}
+	{
+		stack := builder.GetObject("stack").Cast().(*adw.ViewStack)
+		tv := NewTimelineView(&mw.Window.Window, db, mw.syncBackgroundCh)
+		stack.AddTitled(tv.Box, "timeline", "タイムライン")
+	}
 
 	return mw
 }
@@ -122,70 +167,45 @@ func (mw *MainWindow) resolveConflicts(mc sync.MergeConflicts) (sync.Changes, er
 	}
 }
 
-func (mw *MainWindow) readOriginalED() (sync.ExportedDatabase, error) {
-	file, err := os.Open(mw.originalEDPath)
-	if err != nil {
-		return sync.ExportedDatabase{}, err
-	}
-	defer file.Close()
-	var ed sync.ExportedDatabase
-	err = json.NewDecoder(file).Decode(&ed)
-	return ed, err
-}
-
-func (mw *MainWindow) updateOriginalED(ed sync.ExportedDatabase) error {
-	file, err := os.Create(mw.originalEDPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-	return json.NewEncoder(file).Encode(ed)
-}
-
 // sync synchronizes the local database with the server database.
 // Does not have to be called from the UI goroutine.
 func (mw *MainWindow) sync(interactive bool) {
-	ok := mw.syncSemaphore.TryAcquire(1)
+	end, ok := mw.beginSync()
 	if !ok {
-		// do not allow multiple syncs to happen at the same time
-		// as that is pretty much useless
 		return
 	}
-	defer mw.syncSemaphore.Release(1)
-	glib.IdleAdd(func() {
-		mw.syncButton.SetSensitive(false)
-		mw.syncStatus.SetVisible(true)
-	})
-	defer glib.IdleAdd(func() {
-		mw.syncButton.SetSensitive(true)
-		mw.syncStatus.SetVisible(false)
-	})
-	baseURL, err := url.Parse("https://jts.kiyuri.ca")
-	if err != nil {
-		panic(err)
+	defer end()
+	if mw.syncWithDaemon(interactive) {
+		return
 	}
-	sc := sync.NewServerClient(&http.Client{Timeout: 5 * time.Second}, baseURL, mw.token)
-	status := make(chan string)
-	defer close(status)
-	go func() {
-		for s := range status {
-			log.Println("sync status: ", s)
+	syncer, err := mw.syncer()
+	if err != nil {
+		if interactive {
 			glib.IdleAdd(func() {
-				mw.syncStatusLabel.SetLabel(s)
+				toast := adw.NewToast(fmt.Sprintf("同期できません。 %s", err))
+				toast.SetPriority(adw.ToastPriorityHigh)
+				mw.toastOverlay.AddToast(toast)
 			})
 		}
-	}()
-	original, err := mw.readOriginalED()
-	if err != nil {
-		log.Printf("read original ed: %s", err)
+		return
+	}
+	status := mw.syncStatusChan()
+	defer close(status)
+	state, err := sync.ReadSyncState(mw.profile.SyncStatePath())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("read sync state: %s", err)
 	}
-	_, _ = original, sc
 	resolver := mw.resolveConflicts
 	if !interactive {
+		// leave what the conflict policies cannot resolve for the next interactive sync
 		resolver = nil
+		syncer.QueueConflicts = true
 	}
 	// TODO: SyncDatabase call causes choppiness in GTK
-	changes, newED, err := sc.SyncDatabase(context.Background(), original, mw.db, resolver, status)
+	changes, newState, err := syncer.SyncDatabase(context.Background(), state, mw.db, resolver, status)
+	if errors.Is(err, sync.ErrWrongPassphrase) {
+		err = errors.New("暗号化パスフレーズが他の端末と異なります")
+	}
 	if err != nil {
 		log.Println("sync: ", err)
 		glib.IdleAdd(func() {
@@ -195,12 +215,10 @@ func (mw *MainWindow) sync(interactive bool) {
 		})
 		return
 	}
-	_ = changes
-	_ = newED
-	if err = mw.updateOriginalED(newED); err != nil {
-		log.Printf("update original ed: %s", err)
+	if err = sync.WriteSyncState(mw.profile.SyncStatePath(), newState); err != nil {
+		log.Printf("update sync state: %s", err)
 		glib.IdleAdd(func() {
-			toast := adw.NewToast(fmt.Sprintf("元データベース更新に失敗しました。 %s", err))
+			toast := adw.NewToast(fmt.Sprintf("同期状態の更新に失敗しました。 %s", err))
 			toast.SetPriority(adw.ToastPriorityHigh)
 			mw.toastOverlay.AddToast(toast)
 		})
@@ -211,4 +229,141 @@ func (mw *MainWindow) sync(interactive bool) {
 		toast.SetPriority(adw.ToastPriorityNormal)
 		mw.toastOverlay.AddToast(toast)
 	})
+	if n := newState.Queued.Len(); n > 0 {
+		glib.IdleAdd(func() {
+			toast := adw.NewToast(fmt.Sprintf("競合が%d件あります。同期ボタンで解決してください。", n))
+			toast.SetPriority(adw.ToastPriorityHigh)
+			mw.toastOverlay.AddToast(toast)
+		})
+	}
+}
+
+// preview shows what a sync would change, and syncs if the user continues.
+// The preview runs in-process even if the daemon is running, as the daemon only syncs.
+// Does not have to be called from the UI goroutine.
+func (mw *MainWindow) preview() {
+	end, ok := mw.beginSync()
+	if !ok {
+		return
+	}
+	defer end()
+	syncer, err := mw.syncer()
+	if err != nil {
+		glib.IdleAdd(func() {
+			toast := adw.NewToast(fmt.Sprintf("同期できません。 %s", err))
+			toast.SetPriority(adw.ToastPriorityHigh)
+			mw.toastOverlay.AddToast(toast)
+		})
+		return
+	}
+	status := mw.syncStatusChan()
+	defer close(status)
+	state, err := sync.ReadSyncState(mw.profile.SyncStatePath())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("read sync state: %s", err)
+	}
+	preview, err := syncer.Preview(context.Background(), state, mw.db, status)
+	if errors.Is(err, sync.ErrWrongPassphrase) {
+		err = errors.New("暗号化パスフレーズが他の端末と異なります")
+	}
+	if err != nil {
+		log.Println("preview: ", err)
+		glib.IdleAdd(func() {
+			toast := adw.NewToast(fmt.Sprintf("同期内容を取得できませんでした。 %s", err))
+			toast.SetPriority(adw.ToastPriorityHigh)
+			mw.toastOverlay.AddToast(toast)
+		})
+		return
+	}
+	glib.IdleAdd(func() {
+		PresentDialog(&mw.Window.Window, NewSyncPreviewWindow(preview, func() {
+			go mw.sync(true)
+		}).Window)
+	})
+}
+
+// beginSync shows that a sync is running, and returns a function to call when it ends.
+// It returns false if another sync is running.
+func (mw *MainWindow) beginSync() (end func(), ok bool) {
+	ok = mw.syncSemaphore.TryAcquire(1)
+	if !ok {
+		// do not allow multiple syncs to happen at the same time
+		// as that is pretty much useless
+		return nil, false
+	}
+	glib.IdleAdd(func() {
+		mw.syncButton.SetSensitive(false)
+		mw.syncPreviewButton.SetSensitive(false)
+		mw.syncStatus.SetVisible(true)
+	})
+	return func() {
+		mw.syncSemaphore.Release(1)
+		glib.IdleAdd(func() {
+			mw.syncButton.SetSensitive(true)
+			mw.syncPreviewButton.SetSensitive(true)
+			mw.syncStatus.SetVisible(false)
+		})
+	}, true
+}
+
+func (mw *MainWindow) syncer() (sync.Syncer, error) {
+	syncer, err := mw.profile.Syncer()
+	if errors.Is(err, config.ErrNoToken) {
+		err = errors.New("トークンが設定されていません")
+	}
+	return syncer, err
+}
+
+// syncStatusChan returns a channel whose statuses are shown in the header bar. The caller must close it.
+func (mw *MainWindow) syncStatusChan() chan<- string {
+	status := make(chan string)
+	go func() {
+		for s := range status {
+			log.Println("sync status: ", s)
+			glib.IdleAdd(func() {
+				mw.syncStatusLabel.SetLabel(s)
+			})
+		}
+	}()
+	return status
+}
+
+// syncWithDaemon asks the daemon to sync, and reports whether it handled the sync.
+// It did not if it is not running, or if an interactive sync has conflicts to resolve here.
+func (mw *MainWindow) syncWithDaemon(interactive bool) bool {
+	glib.IdleAdd(func() {
+		mw.syncStatusLabel.SetLabel("デーモン")
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err := mw.daemon.Sync(ctx)
+	var se *daemon.SyncError
+	switch {
+	case errors.Is(err, daemon.ErrNotRunning):
+		return false
+	case errors.As(err, &se) && se.Conflict:
+		if interactive {
+			return false
+		}
+		log.Println("sync (daemon): ", err)
+		glib.IdleAdd(func() {
+			toast := adw.NewToast("競合があります。同期ボタンで解決してください。")
+			toast.SetPriority(adw.ToastPriorityHigh)
+			mw.toastOverlay.AddToast(toast)
+		})
+	case err != nil:
+		log.Println("sync (daemon): ", err)
+		glib.IdleAdd(func() {
+			toast := adw.NewToast(fmt.Sprintf("同期に失敗しました。 %s", err))
+			toast.SetPriority(adw.ToastPriorityHigh)
+			mw.toastOverlay.AddToast(toast)
+		})
+	case interactive:
+		glib.IdleAdd(func() {
+			toast := adw.NewToast("同期しました。")
+			toast.SetPriority(adw.ToastPriorityNormal)
+			mw.toastOverlay.AddToast(toast)
+		})
+	}
+	return true
 }
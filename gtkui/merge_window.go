@@ -125,7 +125,16 @@ func NewMergeWindow(mc sync.MergeConflicts, changes chan<- sync.Changes, errs ch
 	mw.mergeTimeframe = NewMergeTimeframe()
 
 	mw.saveButton.ConnectClicked(func() {
-		changes <- mw.changes
+		// conflicts not shown to the user yet have no change, and are left as on the server
+		changes <- sync.Changes{
+			Version: sync.SchemaVersion,
+			Sessions: slices.DeleteFunc(slices.Clone(mw.changes.Sessions), func(c sync.Change[data.Session]) bool {
+				return c.Data.ID == ""
+			}),
+			Timeframes: slices.DeleteFunc(slices.Clone(mw.changes.Timeframes), func(c sync.Change[data.Timeframe]) bool {
+				return c.Data.ID == ""
+			}),
+		}
 		mw.saved = true
 		mw.Window.Close()
 	})
@@ -139,21 +148,20 @@ func NewMergeWindow(mc sync.MergeConflicts, changes chan<- sync.Changes, errs ch
 }
 
 func (mw *MergeWindow) renderSelected() {
-	index := mw.sessionConflictsListSelection.Selected()
 	listItem := mw.sessionConflictsListSelection.SelectedItem()
 	mcRef := sessionConflictsListModelType.ObjectValue(listItem)
 	switch mcRef.mergeConflictType {
 	case mergeConflictTypeSession:
-		mw.mergeSession.SetMergeConflict(mw.mc.Sessions[mcRef.Index])
 		mw.mergeSession.onSave = func(c sync.Change[data.Session]) {
-			mw.changes.Sessions[index] = c
+			mw.changes.Sessions[mcRef.Index] = c
 		}
+		mw.mergeSession.SetMergeConflict(mw.mc.Sessions[mcRef.Index])
 		mw.splitView.SetContent(mw.mergeSession.Main)
 	case mergeConflictTypeTimeframe:
-		mw.mergeTimeframe.SetMergeConflict(mw.mc.Timeframes[mcRef.Index])
 		mw.mergeTimeframe.onSave = func(c sync.Change[data.Timeframe]) {
-			mw.changes.Timeframes[index] = c
+			mw.changes.Timeframes[mcRef.Index] = c
 		}
+		mw.mergeTimeframe.SetMergeConflict(mw.mc.Timeframes[mcRef.Index])
 		mw.splitView.SetContent(mw.mergeTimeframe.Main)
 	default:
 		panic(fmt.Sprintf("unknown merge conflict type %d", mcRef.mergeConflictType))
@@ -183,6 +191,8 @@ type MergeSession struct {
 	mc     sync.MergeConflict[data.Session]
 	c      sync.Change[data.Session]
 	onSave func(sync.Change[data.Session])
+	// removed is set when the side chosen by the user deleted the session.
+	removed bool
 
 	Main                     *gtk.Box
 	useLocal                 *gtk.Button
@@ -209,20 +219,31 @@ func NewMergeSession() *MergeSession {
 	ms.sessionNotesRemote = builder.GetObject("SessionNotesRemote").Cast().(*gtk.TextView)
 
 	ms.useLocal.ConnectClicked(func() {
+		ms.removed = ms.mc.LocalRemoved
 		ms.sessionDescriptionResult.SetText(ms.mc.Local.Description)
 		ms.sessionNotesResult.Buffer().SetText(ms.mc.Local.Notes)
+		ms.saveChanges()
 	})
 	ms.useRemote.ConnectClicked(func() {
+		ms.removed = ms.mc.RemoteRemoved
 		ms.sessionDescriptionResult.SetText(ms.mc.Remote.Description)
 		ms.sessionNotesResult.Buffer().SetText(ms.mc.Remote.Notes)
+		ms.saveChanges()
 	})
 	return ms
 }
 
 func (ms *MergeSession) SetMergeConflict(mc sync.MergeConflict[data.Session]) {
 	ms.mc = mc
+	ms.removed = false
 	ms.sessionDescriptionLocal.SetText(mc.Local.Description)
 	ms.sessionDescriptionRemote.SetText(mc.Remote.Description)
+	if mc.LocalRemoved {
+		ms.sessionDescriptionLocal.SetPlaceholderText("（削除済み）")
+	}
+	if mc.RemoteRemoved {
+		ms.sessionDescriptionRemote.SetPlaceholderText("（削除済み）")
+	}
 	ms.sessionNotesLocal.Buffer().SetText(mc.Local.Notes)
 	ms.sessionNotesRemote.Buffer().SetText(mc.Remote.Notes)
 	if ms.mc.Local.Notes == ms.mc.Remote.Notes {
@@ -236,17 +257,34 @@ func (ms *MergeSession) SetMergeConflict(mc sync.MergeConflict[data.Session]) {
 
 func (ms *MergeSession) saveChanges() {
 	buf := ms.sessionNotesResult.Buffer()
-	ms.c = sync.Change[data.Session]{sync.ChangeOperationExist, data.Session{
-		ID:          ms.mc.Original.ID,
-		Description: ms.sessionDescriptionResult.Text(),
-		Notes:       buf.Text(buf.StartIter(), buf.EndIter(), false),
-	}}
+	id := ms.mc.Local.ID
+	if id == "" {
+		id = ms.mc.Remote.ID
+	}
+	if ms.removed {
+		ms.c = sync.Change[data.Session]{sync.ChangeOperationRemove, data.Session{ID: id}}
+	} else {
+		// the fields not shown (e.g. the task and tags) are already merged, so keep them
+		session := ms.mc.Local
+		if ms.mc.LocalRemoved {
+			session = ms.mc.Remote
+		}
+		session.ID = id
+		session.Description = ms.sessionDescriptionResult.Text()
+		session.Notes = buf.Text(buf.StartIter(), buf.EndIter(), false)
+		ms.c = sync.Change[data.Session]{sync.ChangeOperationExist, session}
+	}
+	if ms.onSave != nil {
+		ms.onSave(ms.c)
+	}
 }
 
 type MergeTimeframe struct {
 	mc     sync.MergeConflict[data.Timeframe]
 	c      sync.Change[data.Timeframe]
 	onSave func(sync.Change[data.Timeframe])
+	// removed is set when the side chosen by the user deleted the timeframe.
+	removed bool
 
 	Main                 *gtk.Box
 	useLocal             *gtk.Button
@@ -267,6 +305,14 @@ func timeFormat(t time.Time) string {
 	return t.Local().Format(TimeFormat)
 }
 
+// timeFormatEnd formats an end time. Running timeframes have an empty end time.
+func timeFormatEnd(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return timeFormat(*t)
+}
+
 func NewMergeTimeframe() *MergeTimeframe {
 	builder := gtk.NewBuilderFromString(mergeTimeframeXML)
 	mt := new(MergeTimeframe)
@@ -286,14 +332,16 @@ func NewMergeTimeframe() *MergeTimeframe {
 	mt.errorMessage = builder.GetObject("ErrorMessage").Cast().(*gtk.Label)
 
 	mt.useLocal.ConnectClicked(func() {
+		mt.removed = mt.mc.LocalRemoved
 		mt.timeframeStartResult.SetText(timeFormat(mt.mc.Local.Start))
-		mt.timeframeEndResult.SetText(timeFormat(mt.mc.Local.End))
+		mt.timeframeEndResult.SetText(timeFormatEnd(mt.mc.Local.End))
 		mt.timeframeDoneResult.SetActive(mt.mc.Local.Done)
 		mt.saveChanges()
 	})
 	mt.useRemote.ConnectClicked(func() {
+		mt.removed = mt.mc.RemoteRemoved
 		mt.timeframeStartResult.SetText(timeFormat(mt.mc.Remote.Start))
-		mt.timeframeEndResult.SetText(timeFormat(mt.mc.Remote.End))
+		mt.timeframeEndResult.SetText(timeFormatEnd(mt.mc.Remote.End))
 		mt.timeframeDoneResult.SetActive(mt.mc.Remote.Done)
 		mt.saveChanges()
 	})
@@ -301,21 +349,28 @@ func NewMergeTimeframe() *MergeTimeframe {
 }
 
 func (mt *MergeTimeframe) SetMergeConflict(mc sync.MergeConflict[data.Timeframe]) {
-	if mc.Local.SessionID != mc.Remote.SessionID {
+	if !mc.LocalRemoved && !mc.RemoteRemoved && mc.Local.SessionID != mc.Remote.SessionID {
 		panic("bail")
 	}
 	mt.mc = mc
+	mt.removed = false
+	if mc.LocalRemoved {
+		mt.timeframeStartLocal.SetPlaceholderText("（削除済み）")
+	}
+	if mc.RemoteRemoved {
+		mt.timeframeStartRemote.SetPlaceholderText("（削除済み）")
+	}
 	mt.timeframeStartLocal.SetText(timeFormat(mc.Local.Start))
 	mt.timeframeStartRemote.SetText(timeFormat(mc.Remote.Start))
-	mt.timeframeEndLocal.SetText(timeFormat(mc.Local.End))
-	mt.timeframeEndRemote.SetText(timeFormat(mc.Remote.End))
+	mt.timeframeEndLocal.SetText(timeFormatEnd(mc.Local.End))
+	mt.timeframeEndRemote.SetText(timeFormatEnd(mc.Remote.End))
 	mt.timeframeDoneLocal.SetActive(mc.Local.Done)
 	mt.timeframeDoneRemote.SetActive(mc.Remote.Done)
 	if mt.mc.Local.Start.Equal(mt.mc.Remote.Start) {
 		mt.timeframeStartResult.SetText(timeFormat(mc.Local.Start))
 	}
-	if mt.mc.Local.End.Equal(mt.mc.Remote.End) {
-		mt.timeframeEndResult.SetText(timeFormat(mc.Local.End))
+	if (mt.mc.Local.End == nil && mt.mc.Remote.End == nil) || (mt.mc.Local.End != nil && mt.mc.Remote.End != nil && mt.mc.Local.End.Equal(*mt.mc.Remote.End)) {
+		mt.timeframeEndResult.SetText(timeFormatEnd(mc.Local.End))
 	}
 	if mt.mc.Local.Done == mt.mc.Remote.Done {
 		mt.timeframeDoneResult.SetActive(mc.Local.Done)
@@ -327,21 +382,43 @@ const TimeFormat = "2006-01-02 15:04"
 
 func (mt *MergeTimeframe) saveChanges() {
 	mt.errorMessage.SetLabel("")
+	if mt.removed {
+		id := mt.mc.Local.ID
+		if id == "" {
+			id = mt.mc.Remote.ID
+		}
+		mt.c = sync.Change[data.Timeframe]{sync.ChangeOperationRemove, data.Timeframe{ID: id}}
+		if mt.onSave != nil {
+			mt.onSave(mt.c)
+		}
+		return
+	}
 	start, err := time.ParseInLocation(TimeFormat, mt.timeframeStartResult.Text(), time.Local)
 	if err != nil {
 		mt.errorMessage.SetLabel(fmt.Sprintf("Invalid start time: %v", err))
 		return
 	}
-	end, err := time.ParseInLocation(TimeFormat, mt.timeframeEndResult.Text(), time.Local)
-	if err != nil {
-		mt.errorMessage.SetLabel(fmt.Sprintf("Invalid end time: %v", err))
-		return
+	var end *time.Time
+	if mt.timeframeEndResult.Text() != "" {
+		end2, err := time.ParseInLocation(TimeFormat, mt.timeframeEndResult.Text(), time.Local)
+		if err != nil {
+			mt.errorMessage.SetLabel(fmt.Sprintf("Invalid end time: %v", err))
+			return
+		}
+		end = &end2
+	}
+	base := mt.mc.Local
+	if mt.mc.LocalRemoved {
+		base = mt.mc.Remote
 	}
 	mt.c = sync.Change[data.Timeframe]{sync.ChangeOperationExist, data.Timeframe{
-		ID:        mt.mc.Original.ID,
-		SessionID: mt.mc.Original.SessionID,
+		ID:        base.ID,
+		SessionID: base.SessionID,
 		Start:     start,
 		End:       end,
 		Done:      mt.timeframeDoneResult.Active(),
 	}}
+	if mt.onSave != nil {
+		mt.onSave(mt.c)
+	}
 }
@@ -58,7 +58,7 @@ func (nsw *NewSessionWindow) save() {
 		Timeframes: []data.Timeframe{
 			{
 				Start: time.Now(),
-				End:   time.Now(),
+				End:   nil, // start running right away
 			},
 		},
 		TaskID: taskID,
@@ -0,0 +1,239 @@
+package gtkui
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"nyiyui.ca/jts/config"
+	"nyiyui.ca/jts/database/sync"
+)
+
+//go:embed preferences.ui
+var PreferencesXML string
+
+// sessionPolicies, timeframePolicies, and taskPolicies are in the same order as the conflict policy drop downs.
+var (
+	sessionPolicies   = []sync.Policy{sync.PolicyAsk, sync.PolicyLocal, sync.PolicyRemote, sync.PolicyLatest, sync.PolicyUnionNotes}
+	timeframePolicies = []sync.Policy{sync.PolicyAsk, sync.PolicyLocal, sync.PolicyRemote, sync.PolicyLatest, sync.PolicyLongest}
+	taskPolicies      = []sync.Policy{sync.PolicyAsk, sync.PolicyLocal, sync.PolicyRemote, sync.PolicyLatest}
+)
+
+// PreferencesWindow edits the profiles in the client config, and switches between them.
+type PreferencesWindow struct {
+	Window                 *gtk.Window
+	ProfileDropDown        *gtk.DropDown
+	NewProfileButton       *gtk.Button
+	DeleteProfileButton    *gtk.Button
+	UseProfileButton       *gtk.Button
+	SaveButton             *gtk.Button
+	ProfileName            *gtk.Entry
+	ProfileServerURL       *gtk.Entry
+	ProfileToken           *gtk.PasswordEntry
+	ProfileSyncDir         *gtk.Entry
+	ProfilePassphrase      *gtk.PasswordEntry
+	ProfileTimeout         *gtk.Entry
+	ProfileDBPath          *gtk.Entry
+	ProfileStatePath       *gtk.Entry
+	ProfileMergeNotes      *gtk.CheckButton
+	ProfileSessionPolicy   *gtk.DropDown
+	ProfileTimeframePolicy *gtk.DropDown
+	ProfileTaskPolicy      *gtk.DropDown
+	ProfileCurrentLabel    *gtk.Label
+	PreferencesErrorHint   *gtk.Label
+
+	profileNames *gtk.StringList
+	// editing is the name of the profile shown, or empty for a new profile.
+	editing    string
+	cfg        *config.Config
+	configPath string
+	onSwitch   func()
+}
+
+// NewPreferencesWindow returns a window to edit cfg, which is saved to configPath.
+// onSwitch is called after the current profile is changed or edited, so that the caller can reopen it.
+func NewPreferencesWindow(cfg *config.Config, configPath string, onSwitch func()) *PreferencesWindow {
+	builder := gtk.NewBuilderFromString(PreferencesXML)
+	pw := new(PreferencesWindow)
+	pw.Window = builder.GetObject("PreferencesWindow").Cast().(*gtk.Window)
+	pw.ProfileDropDown = builder.GetObject("ProfileDropDown").Cast().(*gtk.DropDown)
+	pw.NewProfileButton = builder.GetObject("NewProfileButton").Cast().(*gtk.Button)
+	pw.DeleteProfileButton = builder.GetObject("DeleteProfileButton").Cast().(*gtk.Button)
+	pw.UseProfileButton = builder.GetObject("UseProfileButton").Cast().(*gtk.Button)
+	pw.SaveButton = builder.GetObject("SaveButton").Cast().(*gtk.Button)
+	pw.ProfileName = builder.GetObject("ProfileName").Cast().(*gtk.Entry)
+	pw.ProfileServerURL = builder.GetObject("ProfileServerURL").Cast().(*gtk.Entry)
+	pw.ProfileToken = builder.GetObject("ProfileToken").Cast().(*gtk.PasswordEntry)
+	pw.ProfileSyncDir = builder.GetObject("ProfileSyncDir").Cast().(*gtk.Entry)
+	pw.ProfilePassphrase = builder.GetObject("ProfilePassphrase").Cast().(*gtk.PasswordEntry)
+	pw.ProfileTimeout = builder.GetObject("ProfileTimeout").Cast().(*gtk.Entry)
+	pw.ProfileDBPath = builder.GetObject("ProfileDBPath").Cast().(*gtk.Entry)
+	pw.ProfileStatePath = builder.GetObject("ProfileStatePath").Cast().(*gtk.Entry)
+	pw.ProfileMergeNotes = builder.GetObject("ProfileMergeNotes").Cast().(*gtk.CheckButton)
+	pw.ProfileSessionPolicy = builder.GetObject("ProfileSessionPolicy").Cast().(*gtk.DropDown)
+	pw.ProfileTimeframePolicy = builder.GetObject("ProfileTimeframePolicy").Cast().(*gtk.DropDown)
+	pw.ProfileTaskPolicy = builder.GetObject("ProfileTaskPolicy").Cast().(*gtk.DropDown)
+	pw.ProfileCurrentLabel = builder.GetObject("ProfileCurrentLabel").Cast().(*gtk.Label)
+	pw.PreferencesErrorHint = builder.GetObject("PreferencesErrorHint").Cast().(*gtk.Label)
+	pw.cfg = cfg
+	pw.configPath = configPath
+	pw.onSwitch = onSwitch
+
+	pw.profileNames = gtk.NewStringList(nil)
+	pw.ProfileDropDown.SetModel(pw.profileNames)
+	pw.fillProfiles(cfg.Current)
+	pw.ProfileDropDown.NotifyProperty("selected", pw.showSelected)
+	pw.NewProfileButton.ConnectClicked(pw.newProfile)
+	pw.DeleteProfileButton.ConnectClicked(pw.deleteProfile)
+	pw.SaveButton.ConnectClicked(func() {
+		pw.save(false)
+	})
+	pw.UseProfileButton.ConnectClicked(func() {
+		pw.save(true)
+	})
+	return pw
+}
+
+// fillProfiles lists the profiles in the drop down, and shows the one with the name.
+func (pw *PreferencesWindow) fillProfiles(selected string) {
+	names := make([]string, len(pw.cfg.Profiles))
+	index := 0
+	for i, p := range pw.cfg.Profiles {
+		names[i] = p.Name
+		if p.Name == selected {
+			index = i
+		}
+	}
+	pw.profileNames.Splice(0, pw.profileNames.NItems(), names)
+	pw.ProfileDropDown.SetSelected(uint(index))
+	pw.showSelected()
+}
+
+func (pw *PreferencesWindow) showSelected() {
+	i := pw.ProfileDropDown.Selected()
+	if i == gtk.InvalidListPosition || int(i) >= len(pw.cfg.Profiles) {
+		return
+	}
+	pw.showProfile(pw.cfg.Profiles[i])
+}
+
+func (pw *PreferencesWindow) showProfile(p config.Profile) {
+	pw.editing = p.Name
+	pw.ProfileName.SetText(p.Name)
+	pw.ProfileServerURL.SetText(p.ServerURL)
+	pw.ProfileToken.SetText(p.Token)
+	pw.ProfileSyncDir.SetText(p.SyncDir)
+	pw.ProfilePassphrase.SetText(p.Passphrase)
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = config.DefaultTimeout
+	}
+	pw.ProfileTimeout.SetText(time.Duration(timeout).String())
+	pw.ProfileDBPath.SetText(p.DBPath)
+	pw.ProfileDBPath.SetPlaceholderText(p.DatabasePath())
+	pw.ProfileStatePath.SetText(p.StatePath)
+	pw.ProfileStatePath.SetPlaceholderText(p.SyncStatePath())
+	pw.ProfileMergeNotes.SetActive(p.MergeNotes)
+	selectPolicy(pw.ProfileSessionPolicy, sessionPolicies, p.ConflictPolicies.Sessions)
+	selectPolicy(pw.ProfileTimeframePolicy, timeframePolicies, p.ConflictPolicies.Timeframes)
+	selectPolicy(pw.ProfileTaskPolicy, taskPolicies, p.ConflictPolicies.Tasks)
+	pw.ProfileCurrentLabel.SetVisible(p.Name != "" && p.Name == pw.cfg.Current)
+	pw.DeleteProfileButton.SetSensitive(p.Name != "" && p.Name != pw.cfg.Current)
+	pw.PreferencesErrorHint.SetLabel("")
+}
+
+// selectPolicy shows policy in the drop down, or asking if the drop down does not have it.
+func selectPolicy(dd *gtk.DropDown, policies []sync.Policy, policy sync.Policy) {
+	dd.SetSelected(uint(max(slices.Index(policies, policy), 0)))
+}
+
+func (pw *PreferencesWindow) newProfile() {
+	pw.showProfile(config.Profile{ServerURL: config.DefaultServerURL})
+	pw.ProfileDBPath.SetPlaceholderText("")
+	pw.ProfileStatePath.SetPlaceholderText("")
+	pw.ProfileName.GrabFocus()
+}
+
+// profile returns the profile in the form.
+func (pw *PreferencesWindow) profile() (config.Profile, error) {
+	p := config.Profile{
+		Name:       pw.ProfileName.Text(),
+		ServerURL:  pw.ProfileServerURL.Text(),
+		Token:      pw.ProfileToken.Text(),
+		SyncDir:    pw.ProfileSyncDir.Text(),
+		Passphrase: pw.ProfilePassphrase.Text(),
+		DBPath:     pw.ProfileDBPath.Text(),
+		StatePath:  pw.ProfileStatePath.Text(),
+		MergeNotes: pw.ProfileMergeNotes.Active(),
+		ConflictPolicies: sync.Policies{
+			Sessions:   sessionPolicies[pw.ProfileSessionPolicy.Selected()],
+			Timeframes: timeframePolicies[pw.ProfileTimeframePolicy.Selected()],
+			Tasks:      taskPolicies[pw.ProfileTaskPolicy.Selected()],
+		},
+	}
+	timeout, err := time.ParseDuration(pw.ProfileTimeout.Text())
+	if err != nil {
+		return config.Profile{}, fmt.Errorf("タイムアウト: %w", err)
+	}
+	p.Timeout = config.Duration(timeout)
+	if p.Name != pw.editing {
+		if _, ok := pw.cfg.Profile(p.Name); ok {
+			return config.Profile{}, errors.New("同じ名前のプロファイルがあります")
+		}
+	}
+	return p, p.Validate()
+}
+
+// save saves the profile in the form, and makes it the current profile if use is true.
+func (pw *PreferencesWindow) save(use bool) {
+	p, err := pw.profile()
+	if err != nil {
+		pw.PreferencesErrorHint.SetLabel(err.Error())
+		return
+	}
+	switched := use && p.Name != pw.cfg.Current
+	if pw.editing == pw.cfg.Current {
+		// the current profile was edited, so it has to be reopened
+		switched = true
+		pw.cfg.Current = p.Name
+	}
+	if pw.editing != "" && pw.editing != p.Name {
+		// renamed; keep using the files at the old name's default paths
+		old, _ := pw.cfg.Profile(pw.editing)
+		if p.DBPath == "" {
+			p.DBPath = old.DatabasePath()
+		}
+		if p.StatePath == "" {
+			p.StatePath = old.SyncStatePath()
+		}
+		pw.cfg.Profiles[pw.ProfileDropDown.Selected()] = p
+	} else {
+		pw.cfg.SetProfile(p)
+	}
+	if use {
+		pw.cfg.Current = p.Name
+	}
+	if err := pw.cfg.Save(pw.configPath); err != nil {
+		pw.PreferencesErrorHint.SetLabel(fmt.Sprintf("保存に失敗しました。 %s", err))
+		return
+	}
+	pw.fillProfiles(p.Name)
+	if switched && pw.onSwitch != nil {
+		pw.onSwitch()
+	}
+}
+
+func (pw *PreferencesWindow) deleteProfile() {
+	if err := pw.cfg.DeleteProfile(pw.editing); err != nil {
+		pw.PreferencesErrorHint.SetLabel(err.Error())
+		return
+	}
+	if err := pw.cfg.Save(pw.configPath); err != nil {
+		pw.PreferencesErrorHint.SetLabel(fmt.Sprintf("保存に失敗しました。 %s", err))
+		return
+	}
+	pw.fillProfiles(pw.cfg.Current)
+}
@@ -0,0 +1,106 @@
+package gtkui
+
+import (
+	_ "embed"
+	"fmt"
+	"time"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"nyiyui.ca/jts/database"
+	"nyiyui.ca/jts/report"
+)
+
+//go:embed report.ui
+var ReportXML string
+
+// reportGroupBys is in the same order as the ReportGroupBy drop down.
+var reportGroupBys = []report.GroupBy{report.GroupByDay, report.GroupByWeek, report.GroupByMonth, report.GroupByTask, report.GroupBySession, report.GroupByTag}
+
+type ReportWindow struct {
+	Window        *gtk.Window
+	ReportFrom    *gtk.Entry
+	ReportTo      *gtk.Entry
+	ReportGroupBy *gtk.DropDown
+	ReportHint    *gtk.Label
+	ReportRows    *gtk.ListBox
+	ReportTotal   *gtk.Label
+
+	dateFormat string
+	db         *database.Database
+}
+
+func NewReportWindow(db *database.Database) *ReportWindow {
+	builder := gtk.NewBuilderFromString(ReportXML)
+	rw := new(ReportWindow)
+	rw.dateFormat = "2006-01-02"
+	rw.Window = builder.GetObject("ReportWindow").Cast().(*gtk.Window)
+	rw.ReportFrom = builder.GetObject("ReportFrom").Cast().(*gtk.Entry)
+	rw.ReportTo = builder.GetObject("ReportTo").Cast().(*gtk.Entry)
+	rw.ReportGroupBy = builder.GetObject("ReportGroupBy").Cast().(*gtk.DropDown)
+	rw.ReportHint = builder.GetObject("ReportHint").Cast().(*gtk.Label)
+	rw.ReportRows = builder.GetObject("ReportRows").Cast().(*gtk.ListBox)
+	rw.ReportTotal = builder.GetObject("ReportTotal").Cast().(*gtk.Label)
+	rw.db = db
+
+	now := time.Now()
+	rw.ReportFrom.SetText(now.AddDate(0, 0, -6).Format(rw.dateFormat))
+	rw.ReportTo.SetText(now.Format(rw.dateFormat))
+	rw.ReportFrom.ConnectChanged(rw.update)
+	rw.ReportTo.ConnectChanged(rw.update)
+	rw.ReportGroupBy.NotifyProperty("selected", rw.update)
+	rw.update()
+	return rw
+}
+
+func (rw *ReportWindow) update() {
+	from, err := time.ParseInLocation(rw.dateFormat, rw.ReportFrom.Text(), time.Local)
+	if err != nil {
+		rw.ReportHint.SetLabel(err.Error())
+		return
+	}
+	to, err := time.ParseInLocation(rw.dateFormat, rw.ReportTo.Text(), time.Local)
+	if err != nil {
+		rw.ReportHint.SetLabel(err.Error())
+		return
+	}
+	rw.ReportHint.SetLabel("")
+	r, err := report.Generate(rw.db, report.Options{
+		From:     from,
+		To:       to.AddDate(0, 0, 1), // the end date is inclusive
+		Location: time.Local,
+		GroupBy:  reportGroupBys[rw.ReportGroupBy.Selected()],
+	})
+	if err != nil {
+		rw.ReportHint.SetLabel(err.Error())
+		return
+	}
+	rw.ReportRows.RemoveAll()
+	for _, row := range r.Rows {
+		box := gtk.NewBox(gtk.OrientationHorizontal, 0)
+		label := gtk.NewLabel(reportRowLabel(r.GroupBy, row))
+		label.SetHExpand(true)
+		label.SetXAlign(0)
+		box.Append(label)
+		box.Append(gtk.NewLabel(row.Duration.Round(1 * time.Minute).String()))
+		rw.ReportRows.Append(box)
+	}
+	rw.ReportTotal.SetLabel(fmt.Sprintf("合計：%s", r.Total.Round(1*time.Minute)))
+}
+
+func reportRowLabel(groupBy report.GroupBy, row report.Row) string {
+	switch groupBy {
+	case report.GroupByDay:
+		return row.Start.Format("2006-01-02 Mon")
+	case report.GroupByWeek:
+		return fmt.Sprintf("%s（%s〜）", row.Key, row.Start.Format("01-02"))
+	case report.GroupByTask:
+		if row.Key == "" {
+			return "（タスクなし）"
+		}
+	case report.GroupByTag:
+		if row.Key == "" {
+			return "（タグなし）"
+		}
+	}
+	return row.Label
+}
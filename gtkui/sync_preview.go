@@ -0,0 +1,109 @@
+package gtkui
+
+import (
+	_ "embed"
+	"fmt"
+
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database/sync"
+)
+
+//go:embed sync_preview.ui
+var SyncPreviewXML string
+
+// SyncPreviewWindow shows what a sync would change, and lets the user continue to sync.
+type SyncPreviewWindow struct {
+	Window                  *gtk.Window
+	SyncPreviewSummary      *gtk.Label
+	SyncPreviewRows         *gtk.ListBox
+	SyncPreviewCancelButton *gtk.Button
+	SyncPreviewSyncButton   *gtk.Button
+}
+
+// NewSyncPreviewWindow returns a window showing p. onSync is called when the user continues to sync.
+// The sync merges again, so it can differ from p if another device synced in between.
+func NewSyncPreviewWindow(p sync.Preview, onSync func()) *SyncPreviewWindow {
+	builder := gtk.NewBuilderFromString(SyncPreviewXML)
+	spw := new(SyncPreviewWindow)
+	spw.Window = builder.GetObject("SyncPreviewWindow").Cast().(*gtk.Window)
+	spw.SyncPreviewSummary = builder.GetObject("SyncPreviewSummary").Cast().(*gtk.Label)
+	spw.SyncPreviewRows = builder.GetObject("SyncPreviewRows").Cast().(*gtk.ListBox)
+	spw.SyncPreviewCancelButton = builder.GetObject("SyncPreviewCancelButton").Cast().(*gtk.Button)
+	spw.SyncPreviewSyncButton = builder.GetObject("SyncPreviewSyncButton").Cast().(*gtk.Button)
+
+	spw.SyncPreviewSummary.SetLabel(fmt.Sprintf("送信: %d, 受信: %d, 競合: %d", p.Outgoing.Len(), p.Incoming.Len(), p.Conflicts.Len()))
+	spw.addDiff("送信（この端末の変更）", p.Outgoing)
+	spw.addDiff("受信（他の端末の変更）", p.Incoming)
+	if p.Conflicts.Len() > 0 {
+		spw.addLabel("競合（同期時に解決します）")
+		addConflictRows(spw, "セッション", p.Conflicts.Sessions, previewSession)
+		addConflictRows(spw, "打刻", p.Conflicts.Timeframes, previewTimeframe)
+		addConflictRows(spw, "タスク", p.Conflicts.Tasks, previewTask)
+	}
+
+	spw.SyncPreviewCancelButton.ConnectClicked(func() {
+		spw.Window.Close()
+	})
+	spw.SyncPreviewSyncButton.ConnectClicked(func() {
+		spw.Window.Close()
+		onSync()
+	})
+	return spw
+}
+
+func (spw *SyncPreviewWindow) addLabel(s string) {
+	label := gtk.NewLabel(s)
+	label.SetXAlign(0)
+	spw.SyncPreviewRows.Append(label)
+}
+
+func (spw *SyncPreviewWindow) addDiff(title string, d sync.Diff) {
+	spw.addLabel(fmt.Sprintf("%s: %d", title, d.Len()))
+	addRowDiffs(spw, "セッション", d.Sessions, previewSession)
+	addRowDiffs(spw, "打刻", d.Timeframes, previewTimeframe)
+	addRowDiffs(spw, "タスク", d.Tasks, previewTask)
+}
+
+func addRowDiffs[T any](spw *SyncPreviewWindow, kind string, diffs []sync.RowDiff[T], describe func(T) string) {
+	for _, d := range diffs {
+		switch d.Operation {
+		case sync.DiffOperationAdd:
+			spw.addLabel(fmt.Sprintf("　追加 %s: %s", kind, describe(d.New)))
+		case sync.DiffOperationChange:
+			before, after := describe(d.Old), describe(d.New)
+			if before == after {
+				spw.addLabel(fmt.Sprintf("　変更 %s: %s", kind, after))
+			} else {
+				spw.addLabel(fmt.Sprintf("　変更 %s: %s → %s", kind, before, after))
+			}
+		case sync.DiffOperationRemove:
+			spw.addLabel(fmt.Sprintf("　削除 %s: %s", kind, describe(d.Old)))
+		}
+	}
+}
+
+func addConflictRows[T any](spw *SyncPreviewWindow, kind string, mcs []sync.MergeConflict[T], describe func(T) string) {
+	for _, mc := range mcs {
+		local, remote := describe(mc.Local), describe(mc.Remote)
+		if mc.LocalRemoved {
+			local = "（削除）"
+		}
+		if mc.RemoteRemoved {
+			remote = "（削除）"
+		}
+		spw.addLabel(fmt.Sprintf("　%s: この端末 %s / 他の端末 %s", kind, local, remote))
+	}
+}
+
+func previewSession(s data.Session) string {
+	return s.Description
+}
+
+func previewTimeframe(tf data.Timeframe) string {
+	return fmt.Sprintf("%s〜%s", timeFormat(tf.Start), timeFormatEnd(tf.End))
+}
+
+func previewTask(t data.Task) string {
+	return t.Description
+}
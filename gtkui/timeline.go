@@ -0,0 +1,383 @@
+package gtkui
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/diamondburned/gotk4/pkg/cairo"
+	"github.com/diamondburned/gotk4/pkg/glib/v2"
+	"github.com/diamondburned/gotk4/pkg/gtk/v4"
+	"github.com/diamondburned/gotk4/pkg/pango"
+	"github.com/diamondburned/gotk4/pkg/pangocairo"
+	"nyiyui.ca/jts/database"
+	"nyiyui.ca/jts/timeline"
+)
+
+//go:embed timeline.ui
+var TimelineXML string
+
+// timelineSpans and timelineColorBys are in the same order as the TimelineSpan and TimelineColorBy drop downs.
+var (
+	timelineSpans    = []timeline.Span{timeline.SpanDay, timeline.SpanWeek, timeline.SpanMonth}
+	timelineColorBys = []timeline.ColorBy{timeline.ColorBySession, timeline.ColorByTask}
+)
+
+// Dimensions of the timeline, in pixels.
+const (
+	timelineLabelWidth = 80.0
+	timelineHeader     = 20.0
+	timelineLane       = 22.0
+	timelineRowPadding = 4.0
+	// timelineEdgeWidth is how close to the edge of a block the pointer has to be to drag the edge.
+	timelineEdgeWidth = 5.0
+)
+
+// timelineSnap is what dragged edges are rounded to.
+const timelineSnap = 5 * time.Minute
+
+type timelineEdge int
+
+const (
+	edgeNone timelineEdge = iota
+	edgeStart
+	edgeEnd
+)
+
+// timelineHit is the block (or the edge of it) under the pointer.
+// The day and block are copied, so that they stay valid if the timeline is updated while dragging.
+type timelineHit struct {
+	day   timeline.Day
+	block timeline.Block
+	edge  timelineEdge
+}
+
+type timelineDrag struct {
+	hit    timelineHit
+	startX float64
+	// dx and dy are how far the pointer has moved since the drag started.
+	dx, dy float64
+}
+
+// TimelineView shows the timeframes of a day, week or month, one row per day.
+// Clicking a block opens its session, and dragging the start or end of a block changes the timeframe.
+type TimelineView struct {
+	Box     *gtk.Box
+	prev    *gtk.Button
+	today   *gtk.Button
+	next    *gtk.Button
+	period  *gtk.Label
+	span    *gtk.DropDown
+	colorBy *gtk.DropDown
+	hint    *gtk.Label
+	area    *gtk.DrawingArea
+
+	parent  *gtk.Window
+	db      *database.Database
+	changed chan<- struct{}
+	// date is a day in the period shown.
+	date time.Time
+	tl   timeline.Timeline
+	// drag is set while a block is being dragged. Only used on the main thread.
+	drag *timelineDrag
+	// updateQueued is set while an update for a database change is queued, so that a sync does not queue one for each row.
+	updateQueued atomic.Bool
+}
+
+func NewTimelineView(parent *gtk.Window, db *database.Database, changed chan<- struct{}) *TimelineView {
+	builder := gtk.NewBuilderFromString(TimelineXML)
+	tv := new(TimelineView)
+	tv.Box = builder.GetObject("TimelineView").Cast().(*gtk.Box)
+	tv.prev = builder.GetObject("TimelinePrev").Cast().(*gtk.Button)
+	tv.today = builder.GetObject("TimelineToday").Cast().(*gtk.Button)
+	tv.next = builder.GetObject("TimelineNext").Cast().(*gtk.Button)
+	tv.period = builder.GetObject("TimelinePeriod").Cast().(*gtk.Label)
+	tv.span = builder.GetObject("TimelineSpan").Cast().(*gtk.DropDown)
+	tv.colorBy = builder.GetObject("TimelineColorBy").Cast().(*gtk.DropDown)
+	tv.hint = builder.GetObject("TimelineHint").Cast().(*gtk.Label)
+	tv.area = builder.GetObject("TimelineArea").Cast().(*gtk.DrawingArea)
+	tv.parent = parent
+	tv.db = db
+	tv.changed = changed
+	tv.date = time.Now()
+
+	tv.prev.ConnectClicked(func() {
+		tv.date = timeline.Step(tv.currentSpan(), tv.date, -1)
+		tv.update()
+	})
+	tv.today.ConnectClicked(func() {
+		tv.date = time.Now()
+		tv.update()
+	})
+	tv.next.ConnectClicked(func() {
+		tv.date = timeline.Step(tv.currentSpan(), tv.date, 1)
+		tv.update()
+	})
+	tv.span.NotifyProperty("selected", tv.update)
+	tv.colorBy.NotifyProperty("selected", tv.update)
+
+	tv.area.SetDrawFunc(tv.draw)
+	drag := gtk.NewGestureDrag()
+	drag.ConnectDragBegin(tv.dragBegin)
+	drag.ConnectDragUpdate(tv.dragUpdate)
+	drag.ConnectDragEnd(tv.dragEnd)
+	tv.area.AddController(drag)
+	motion := gtk.NewEventControllerMotion()
+	motion.ConnectMotion(tv.motion)
+	tv.area.AddController(motion)
+
+	db.Notify(func(op int, name, table string, rowid int64) {
+		if table != "sessions" && table != "time_frames" && table != "tasks" {
+			return
+		}
+		if tv.updateQueued.CompareAndSwap(false, true) {
+			glib.IdleAdd(func() {
+				tv.updateQueued.Store(false)
+				tv.update()
+			})
+		}
+	}) // TODO: leak?
+	tv.update()
+	return tv
+}
+
+func (tv *TimelineView) currentSpan() timeline.Span {
+	return timelineSpans[tv.span.Selected()]
+}
+
+func (tv *TimelineView) update() {
+	tl, err := timeline.Generate(tv.db, timeline.Options{
+		Span:     tv.currentSpan(),
+		Date:     tv.date,
+		Location: time.Local,
+		ColorBy:  timelineColorBys[tv.colorBy.Selected()],
+	})
+	if err != nil {
+		tv.showHint(err.Error())
+		return
+	}
+	tv.showHint("")
+	tv.tl = tl
+	tv.period.SetLabel(fmt.Sprintf("%s〜%s　合計：%s", tl.From.Format("2006-01-02"), tl.To.AddDate(0, 0, -1).Format("2006-01-02"), tl.Total.Round(time.Minute)))
+	height := timelineHeader
+	for _, day := range tl.Days {
+		height += rowHeight(day)
+	}
+	tv.area.SetContentHeight(int(height))
+	tv.area.QueueDraw()
+}
+
+func (tv *TimelineView) showHint(hint string) {
+	tv.hint.SetLabel(hint)
+	tv.hint.SetVisible(hint != "")
+}
+
+func rowHeight(day timeline.Day) float64 {
+	return float64(day.Lanes)*timelineLane + 2*timelineRowPadding
+}
+
+// x returns where t on the day is drawn.
+func (tv *TimelineView) x(day timeline.Day, t time.Time) float64 {
+	barWidth := float64(tv.area.Width()) - timelineLabelWidth
+	return timelineLabelWidth + day.Offset(t)*barWidth
+}
+
+// hitTest returns the block at (x, y), if any.
+func (tv *TimelineView) hitTest(x, y float64) (timelineHit, bool) {
+	top := timelineHeader
+	for _, day := range tv.tl.Days {
+		height := rowHeight(day)
+		if y < top || y >= top+height {
+			top += height
+			continue
+		}
+		lane := int(math.Floor((y - top - timelineRowPadding) / timelineLane))
+		for _, b := range day.Blocks {
+			if b.Lane != lane {
+				continue
+			}
+			x1, x2 := tv.x(day, b.Start), tv.x(day, b.End)
+			switch {
+			case !b.ClippedStart && math.Abs(x-x1) <= timelineEdgeWidth:
+				return timelineHit{day, b, edgeStart}, true
+			case !b.ClippedEnd && math.Abs(x-x2) <= timelineEdgeWidth:
+				return timelineHit{day, b, edgeEnd}, true
+			case x >= x1 && x <= x2:
+				return timelineHit{day, b, edgeNone}, true
+			}
+		}
+		break
+	}
+	return timelineHit{}, false
+}
+
+// dragTime returns the time the dragged edge is at, rounded to timelineSnap.
+func (tv *TimelineView) dragTime(d *timelineDrag) time.Time {
+	barWidth := float64(tv.area.Width()) - timelineLabelWidth
+	offset := (d.startX + d.dx - timelineLabelWidth) / barWidth
+	return d.hit.day.At(math.Min(math.Max(offset, 0), 1)).Round(timelineSnap)
+}
+
+func (tv *TimelineView) motion(x, y float64) {
+	if tv.drag != nil {
+		return
+	}
+	hit, ok := tv.hitTest(x, y)
+	switch {
+	case !ok:
+		tv.area.SetCursorFromName("default")
+	case hit.edge != edgeNone:
+		tv.area.SetCursorFromName("col-resize")
+	default:
+		tv.area.SetCursorFromName("pointer")
+	}
+}
+
+func (tv *TimelineView) dragBegin(x, y float64) {
+	hit, ok := tv.hitTest(x, y)
+	if !ok {
+		return
+	}
+	tv.drag = &timelineDrag{hit: hit, startX: x}
+}
+
+func (tv *TimelineView) dragUpdate(dx, dy float64) {
+	if tv.drag == nil {
+		return
+	}
+	tv.drag.dx, tv.drag.dy = dx, dy
+	if tv.drag.hit.edge != edgeNone {
+		tv.area.QueueDraw()
+	}
+}
+
+func (tv *TimelineView) dragEnd(dx, dy float64) {
+	d := tv.drag
+	tv.drag = nil
+	if d == nil {
+		return
+	}
+	d.dx, d.dy = dx, dy
+	defer tv.area.QueueDraw()
+	if d.hit.edge == edgeNone {
+		// a click, unless the pointer moved away
+		if math.Hypot(dx, dy) < 4 {
+			esw := NewEditSessionWindow(tv.db, d.hit.block.Timeframe.SessionID, tv.changed)
+			PresentDialog(tv.parent, esw.Window)
+		}
+		return
+	}
+	tf := d.hit.block.Timeframe
+	t := tv.dragTime(d)
+	if d.hit.edge == edgeStart {
+		if !t.Before(tf.EndOrNow()) {
+			tv.showHint("開始は終了より前にしてください")
+			return
+		}
+		tf.Start = t
+	} else {
+		if !t.After(tf.Start) {
+			tv.showHint("終了は開始より後にしてください")
+			return
+		}
+		tf.End = &t
+	}
+	if err := tv.db.EditTimeframe(tf.SessionID, tf.ID, tf); err != nil {
+		tv.showHint(err.Error())
+		return
+	}
+	if tv.changed != nil {
+		tv.changed <- struct{}{}
+	}
+	tv.update()
+}
+
+// text draws text with its top left at (x, y), ellipsized to maxWidth if it is positive.
+func (tv *TimelineView) text(cr *cairo.Context, x, y, maxWidth float64, text string) {
+	layout := tv.area.CreatePangoLayout(text)
+	if maxWidth > 0 {
+		layout.SetWidth(int(maxWidth * pango.SCALE))
+		layout.SetEllipsize(pango.EllipsizeEnd)
+	}
+	cr.MoveTo(x, y)
+	pangocairo.ShowLayout(cr, layout)
+}
+
+func (tv *TimelineView) draw(area *gtk.DrawingArea, cr *cairo.Context, width, height int) {
+	w, h := float64(width), float64(height)
+	barWidth := w - timelineLabelWidth
+	cr.SetLineWidth(1)
+	// hours between labels, so that they do not overlap
+	labelEvery := 3
+	if tv.tl.Span == timeline.SpanMonth {
+		labelEvery = 6
+	}
+	for hour := 0; hour <= 24; hour++ {
+		x := timelineLabelWidth + float64(hour)/24*barWidth
+		switch {
+		case hour%labelEvery == 0:
+			cr.SetSourceRGBA(0.5, 0.5, 0.5, 0.8)
+			if hour < 24 {
+				tv.text(cr, x+2, 2, 0, fmt.Sprintf("%02d:00", hour))
+			}
+			cr.SetSourceRGBA(0.5, 0.5, 0.5, 0.5)
+		case tv.tl.Span != timeline.SpanMonth:
+			cr.SetSourceRGBA(0.5, 0.5, 0.5, 0.15)
+		default:
+			continue
+		}
+		cr.MoveTo(x, timelineHeader)
+		cr.LineTo(x, h)
+		cr.Stroke()
+	}
+	now := time.Now()
+	top := timelineHeader
+	for _, day := range tv.tl.Days {
+		cr.SetSourceRGBA(0.5, 0.5, 0.5, 0.5)
+		cr.MoveTo(0, top)
+		cr.LineTo(w, top)
+		cr.Stroke()
+		cr.SetSourceRGBA(0.5, 0.5, 0.5, 1)
+		tv.text(cr, 4, top+timelineRowPadding+2, timelineLabelWidth-8, day.Start.Format("01-02 Mon"))
+		for _, b := range day.Blocks {
+			start, end := b.Start, b.End
+			d := tv.drag
+			dragged := d != nil && d.hit.edge != edgeNone && d.hit.block.Timeframe.ID == b.Timeframe.ID && d.hit.day.Start.Equal(day.Start)
+			if dragged {
+				if d.hit.edge == edgeStart {
+					start = tv.dragTime(d)
+				} else {
+					end = tv.dragTime(d)
+				}
+			}
+			x1, x2 := tv.x(day, start), tv.x(day, end)
+			y := top + timelineRowPadding + float64(b.Lane)*timelineLane
+			r, g, bl := timeline.Color(b.Key)
+			cr.SetSourceRGB(r, g, bl)
+			cr.Rectangle(x1, y+1, math.Max(x2-x1, 1), timelineLane-2)
+			cr.Fill()
+			if x2-x1 > 24 {
+				cr.SetSourceRGB(0, 0, 0)
+				tv.text(cr, x1+3, y+3, x2-x1-6, b.Label)
+			}
+			if dragged {
+				t := start
+				if d.hit.edge == edgeEnd {
+					t = end
+				}
+				cr.SetSourceRGB(0, 0, 0)
+				tv.text(cr, tv.x(day, t)+4, y-timelineRowPadding, 0, t.Format("15:04"))
+			}
+		}
+		if !now.Before(day.Start) && now.Before(day.End) {
+			x := tv.x(day, now)
+			cr.SetSourceRGB(0.9, 0.1, 0.1)
+			cr.MoveTo(x, top)
+			cr.LineTo(x, top+rowHeight(day))
+			cr.Stroke()
+		}
+		top += rowHeight(day)
+	}
+}
@@ -0,0 +1,60 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+)
+
+// ParseClockify parses a Clockify detailed report CSV export.
+// The project (and the Clockify task, if any) becomes the task.
+func ParseClockify(r io.Reader, opts Options) ([]Record, error) {
+	rows, err := csvRows(r)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for i, row := range rows {
+		if err := requireColumns(row, "Description", "Project", "Start Date", "Start Time", "End Date", "End Time"); err != nil {
+			return nil, err
+		}
+		record, err := clockifyRecord(row, opts)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i+2, err)
+		}
+		records = append(records, record)
+	}
+	sortRecords(records)
+	return records, nil
+}
+
+// Clockify formats dates and times according to the user's settings.
+var (
+	clockifyDateLayouts = []string{"01/02/2006", "2006-01-02", "02/01/2006", "02.01.2006", "02-01-2006"}
+	clockifyTimeLayouts = []string{"03:04:05 PM", "03:04 PM", "15:04:05", "15:04"}
+)
+
+func clockifyRecord(row map[string]string, opts Options) (Record, error) {
+	start, err := parseDateTime(row["Start Date"], row["Start Time"], clockifyDateLayouts, clockifyTimeLayouts, opts.location())
+	if err != nil {
+		return Record{}, fmt.Errorf("start: %w", err)
+	}
+	end, err := parseDateTime(row["End Date"], row["End Time"], clockifyDateLayouts, clockifyTimeLayouts, opts.location())
+	if err != nil {
+		return Record{}, fmt.Errorf("end: %w", err)
+	}
+	task := joinNonEmpty(" / ", row["Project"], row["Task"])
+	description := row["Description"]
+	if description == "" {
+		description = task
+	}
+	if description == "" {
+		description = "(no description)"
+	}
+	return Record{
+		Description: description,
+		Task:        task,
+		Tags:        splitTags(row["Tags"]),
+		Start:       start,
+		End:         &end,
+	}, nil
+}
@@ -0,0 +1,82 @@
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"nyiyui.ca/jts/data"
+)
+
+// csvRows reads a CSV file with a header row, and returns each row as a map from column name.
+func csvRows(r io.Reader) ([]map[string]string, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	for i, name := range header {
+		// Excel-friendly exports start with a byte order mark
+		header[i] = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+	}
+	var rows []map[string]string
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := map[string]string{}
+		for i, value := range record {
+			if i < len(header) {
+				row[header[i]] = strings.TrimSpace(value)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// requireColumns returns an error if a column is missing from the row.
+func requireColumns(row map[string]string, columns ...string) error {
+	for _, c := range columns {
+		if _, ok := row[c]; !ok {
+			return fmt.Errorf("missing column %q", c)
+		}
+	}
+	return nil
+}
+
+// parseDateTime parses a date and a time given in separate columns, trying each layout.
+func parseDateTime(date, clock string, dateLayouts, timeLayouts []string, loc *time.Location) (time.Time, error) {
+	for _, dl := range dateLayouts {
+		for _, tl := range timeLayouts {
+			t, err := time.ParseInLocation(dl+" "+tl, date+" "+clock, loc)
+			if err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date and time %q %q", date, clock)
+}
+
+// joinNonEmpty joins the non-empty strings with sep.
+func joinNonEmpty(sep string, ss ...string) string {
+	var nonEmpty []string
+	for _, s := range ss {
+		if s != "" {
+			nonEmpty = append(nonEmpty, s)
+		}
+	}
+	return strings.Join(nonEmpty, sep)
+}
+
+// splitTags splits a comma-separated list of tags, as in Toggl and Clockify exports.
+func splitTags(s string) []string {
+	return data.NormalizeTags(strings.Split(s, ","))
+}
@@ -0,0 +1,306 @@
+// Package importer imports time entries from other time trackers.
+//
+// Each source format is parsed into Records, which are then planned against the database:
+// records with the same description and task become timeframes of one session,
+// tasks are matched by description, and records that were already imported are skipped.
+package importer
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+)
+
+// Format is a source format.
+type Format string
+
+const (
+	FormatToggl       Format = "toggl"       // Toggl Track detailed report CSV
+	FormatClockify    Format = "clockify"    // Clockify detailed report CSV
+	FormatTimewarrior Format = "timewarrior" // Timewarrior data file (e.g. ~/.timewarrior/data/2025-04.data)
+	FormatWatson      Format = "watson"      // Watson frames file (e.g. ~/.config/watson/frames)
+)
+
+// Formats lists all formats.
+var Formats = []Format{FormatToggl, FormatClockify, FormatTimewarrior, FormatWatson}
+
+// Record is one time entry from another tracker.
+type Record struct {
+	Description string
+	Notes       string
+	// Task is the description of the task (e.g. the project), or empty if none.
+	Task  string
+	Tags  []string
+	Start time.Time
+	// End is nil if the entry is running.
+	End *time.Time
+}
+
+// Options specifies how to parse records.
+type Options struct {
+	// Location is the time zone of times without an offset (e.g. in CSV exports). It defaults to UTC.
+	Location *time.Location
+}
+
+func (o Options) location() *time.Location {
+	if o.Location == nil {
+		return time.UTC
+	}
+	return o.Location
+}
+
+// Parse parses records in the format.
+func Parse(r io.Reader, format Format, opts Options) ([]Record, error) {
+	switch format {
+	case FormatToggl:
+		return ParseToggl(r, opts)
+	case FormatClockify:
+		return ParseClockify(r, opts)
+	case FormatTimewarrior:
+		return ParseTimewarrior(r)
+	case FormatWatson:
+		return ParseWatson(r)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// PlannedSession is a session that records are imported into.
+type PlannedSession struct {
+	// ID is the ID of an existing session, or empty if a new session is created.
+	ID          string
+	Description string
+	Notes       string
+	// Task is the description of the task, or empty if none.
+	Task string
+	// Tags are the tags of all records, added to the session.
+	Tags       []string
+	Timeframes []data.Timeframe
+}
+
+// Plan is what an import would change.
+type Plan struct {
+	// Tasks are the descriptions of tasks to create.
+	Tasks    []string
+	Sessions []PlannedSession
+	// Duplicates is the number of records skipped because they were already imported.
+	Duplicates int
+	// taskIDs maps descriptions of existing tasks to their IDs.
+	taskIDs map[string]string
+}
+
+// NewPlan plans importing the records into db.
+// A record is a duplicate if a session with the same description already has a timeframe with the same start and end.
+// Sessions with the same description and task in the time range of the records are reused.
+func NewPlan(db *database.Database, records []Record) (*Plan, error) {
+	p := &Plan{taskIDs: map[string]string{}}
+	if len(records) == 0 {
+		return p, nil
+	}
+	tasks, err := db.GetTasks()
+	if err != nil {
+		return nil, fmt.Errorf("get tasks: %w", err)
+	}
+	taskDescriptions := map[string]string{}
+	for _, task := range tasks {
+		if _, ok := p.taskIDs[task.Description]; !ok {
+			p.taskIDs[task.Description] = task.ID
+		}
+		taskDescriptions[task.ID] = task.Description
+	}
+	from, to := records[0].Start, records[0].Start
+	for _, r := range records {
+		from = minTime(from, r.Start)
+		to = maxTime(to, r.Start)
+		if r.End != nil {
+			to = maxTime(to, *r.End)
+		}
+	}
+	existing, err := db.GetSessionsBetween(from, to.Add(time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("get sessions: %w", err)
+	}
+
+	type sessionKey struct{ description, task string }
+	type timeframeKey struct {
+		description string
+		start, end  int64
+	}
+	tfKey := func(description string, start time.Time, end *time.Time) timeframeKey {
+		k := timeframeKey{description: description, start: start.UnixNano()}
+		if end != nil {
+			k.end = end.UnixNano()
+		}
+		return k
+	}
+	seen := map[timeframeKey]struct{}{}
+	sessions := map[sessionKey]*PlannedSession{}
+	for _, s := range existing {
+		task := ""
+		if s.TaskID != nil {
+			task = taskDescriptions[*s.TaskID]
+		}
+		for _, tf := range s.Timeframes {
+			seen[tfKey(s.Description, tf.Start, tf.End)] = struct{}{}
+		}
+		k := sessionKey{s.Description, task}
+		if _, ok := sessions[k]; !ok {
+			sessions[k] = &PlannedSession{ID: s.ID, Description: s.Description, Task: task}
+		}
+	}
+	newTasks := map[string]struct{}{}
+	var order []sessionKey
+	for _, r := range records {
+		k := tfKey(r.Description, r.Start, r.End)
+		if _, ok := seen[k]; ok {
+			p.Duplicates++
+			continue
+		}
+		seen[k] = struct{}{}
+		if r.Task != "" {
+			if _, ok := p.taskIDs[r.Task]; !ok {
+				if _, ok := newTasks[r.Task]; !ok {
+					newTasks[r.Task] = struct{}{}
+					p.Tasks = append(p.Tasks, r.Task)
+				}
+			}
+		}
+		sk := sessionKey{r.Description, r.Task}
+		s, ok := sessions[sk]
+		if !ok {
+			s = &PlannedSession{Description: r.Description, Notes: r.Notes, Task: r.Task}
+			sessions[sk] = s
+		}
+		if len(s.Timeframes) == 0 {
+			order = append(order, sk)
+		}
+		s.Timeframes = append(s.Timeframes, data.Timeframe{Start: r.Start, End: r.End})
+		s.Tags = data.NormalizeTags(append(s.Tags, r.Tags...))
+	}
+	for _, sk := range order {
+		s := sessions[sk]
+		slices.SortFunc(s.Timeframes, func(a, b data.Timeframe) int {
+			return a.Start.Compare(b.Start)
+		})
+		p.Sessions = append(p.Sessions, *s)
+	}
+	slices.SortStableFunc(p.Sessions, func(a, b PlannedSession) int {
+		return a.Timeframes[0].Start.Compare(b.Timeframes[0].Start)
+	})
+	return p, nil
+}
+
+// Timeframes returns the number of timeframes to import.
+func (p *Plan) Timeframes() int {
+	n := 0
+	for _, s := range p.Sessions {
+		n += len(s.Timeframes)
+	}
+	return n
+}
+
+// WriteSummary writes a human-readable description of the plan, e.g. for a dry run.
+func (p *Plan) WriteSummary(w io.Writer, loc *time.Location) error {
+	for _, task := range p.Tasks {
+		if _, err := fmt.Fprintf(w, "new task: %s\n", task); err != nil {
+			return err
+		}
+	}
+	for _, s := range p.Sessions {
+		verb := "new session"
+		if s.ID != "" {
+			verb = "existing session " + s.ID
+		}
+		task := ""
+		if s.Task != "" {
+			task = fmt.Sprintf(" (task: %s)", s.Task)
+		}
+		if len(s.Tags) > 0 {
+			task += fmt.Sprintf(" (tags: %s)", strings.Join(s.Tags, ", "))
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s%s\n", verb, s.Description, task); err != nil {
+			return err
+		}
+		for _, tf := range s.Timeframes {
+			end := "running"
+			if tf.End != nil {
+				end = tf.End.In(loc).Format("2006-01-02 15:04")
+			}
+			if _, err := fmt.Fprintf(w, "  %s - %s\n", tf.Start.In(loc).Format("2006-01-02 15:04"), end); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "%d new tasks, %d sessions, %d timeframes, %d duplicates skipped\n", len(p.Tasks), len(p.Sessions), p.Timeframes(), p.Duplicates)
+	return err
+}
+
+// Apply makes the changes in the plan in one transaction, so that a failed import changes nothing and can be retried.
+func (p *Plan) Apply(db *database.Database) error {
+	tx, err := db.DB.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	taskIDs := map[string]string{}
+	for k, v := range p.taskIDs {
+		taskIDs[k] = v
+	}
+	for _, description := range p.Tasks {
+		id, err := database.AddTask(tx, data.Task{Description: description})
+		if err != nil {
+			return fmt.Errorf("add task %s: %w", description, err)
+		}
+		taskIDs[description] = id
+	}
+	for _, s := range p.Sessions {
+		if s.ID != "" {
+			for _, tf := range s.Timeframes {
+				if _, err := database.AddTimeframe(tx, s.ID, tf); err != nil {
+					return fmt.Errorf("add timeframe to %s: %w", s.ID, err)
+				}
+			}
+			for _, tag := range s.Tags {
+				if err := database.TagSession(tx, s.ID, tag); err != nil {
+					return fmt.Errorf("tag %s: %w", s.ID, err)
+				}
+			}
+			continue
+		}
+		session := data.Session{Description: s.Description, Notes: s.Notes, Timeframes: s.Timeframes, Tags: s.Tags}
+		if s.Task != "" {
+			id := taskIDs[s.Task]
+			session.TaskID = &id
+		}
+		if _, err := database.AddSession(tx, session); err != nil {
+			return fmt.Errorf("add session %s: %w", s.Description, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// sortRecords sorts records by start time, for stable output.
+func sortRecords(records []Record) {
+	slices.SortStableFunc(records, func(a, b Record) int {
+		return cmp.Compare(a.Start.UnixNano(), b.Start.UnixNano())
+	})
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,169 @@
+package importer
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"nyiyui.ca/jts/database"
+)
+
+func newTestDatabase(t *testing.T) *database.Database {
+	t.Helper()
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "jts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+	return db
+}
+
+const togglCSV = "\ufeffUser,Email,Client,Project,Task,Description,Billable,Start date,Start time,End date,End time,Duration,Tags,Amount ()\n" +
+	"Ken,ken@example.com,,jts,,write importer,No,2025-04-01,23:00:00,2025-04-02,00:30:00,01:30:00,\"billable, go\",\n" +
+	"Ken,ken@example.com,,jts,,write importer,No,2025-04-02,10:00:00,2025-04-02,11:00:00,01:00:00,,\n" +
+	"Ken,ken@example.com,,,,lunch,No,2025-04-02,12:00:00,2025-04-02,13:00:00,01:00:00,,\n"
+
+func TestParseToggl(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+	records, err := ParseToggl(strings.NewReader(togglCSV), Options{Location: jst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+	r := records[0]
+	if r.Description != "write importer" || r.Task != "jts" || !slices.Equal(r.Tags, []string{"billable", "go"}) || !r.Start.Equal(time.Date(2025, 4, 1, 23, 0, 0, 0, jst)) || !r.End.Equal(time.Date(2025, 4, 2, 0, 30, 0, 0, jst)) {
+		t.Fatalf("unexpected record %#v", r)
+	}
+}
+
+func TestParseClockify(t *testing.T) {
+	csv := "Project,Client,Description,Task,User,Group,Email,Tags,Billable,Start Date,Start Time,End Date,End Time,Duration (h),Duration (decimal)\n" +
+		"jts,,review,sync,Ken,,ken@example.com,,No,04/01/2025,01:00:00 PM,04/01/2025,02:15:00 PM,01:15:00,1.25\n"
+	records, err := ParseClockify(strings.NewReader(csv), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Task != "jts / sync" || records[0].End.Sub(records[0].Start) != 75*time.Minute {
+		t.Fatalf("unexpected records %#v", records)
+	}
+}
+
+func TestParseTimewarrior(t *testing.T) {
+	data := `inc 20250401T010000Z - 20250401T023000Z # coding "project x" # "fix bug"
+inc 20250401T030000Z
+`
+	records, err := ParseTimewarrior(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if r := records[0]; r.Description != "coding project x" || r.Notes != "fix bug" || r.End.Sub(r.Start) != 90*time.Minute {
+		t.Fatalf("unexpected record %#v", r)
+	}
+	if r := records[1]; r.End != nil || r.Description != "(no tags)" {
+		t.Fatalf("expected running record, got %#v", r)
+	}
+}
+
+func TestParseWatson(t *testing.T) {
+	data := `[[1743469200, 1743474600, "jts", "abc", ["go", "sync"], 1743474600]]`
+	records, err := ParseWatson(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Description != "jts +go +sync" || records[0].Task != "jts" || records[0].End.Sub(records[0].Start) != 90*time.Minute {
+		t.Fatalf("unexpected records %#v", records)
+	}
+}
+
+func TestImportDedup(t *testing.T) {
+	db := newTestDatabase(t)
+	records, err := ParseToggl(strings.NewReader(togglCSV), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := NewPlan(db, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Tasks) != 1 || len(plan.Sessions) != 2 || plan.Timeframes() != 3 || plan.Duplicates != 0 {
+		t.Fatalf("unexpected plan %#v", plan)
+	}
+	var buf bytes.Buffer
+	if err := plan.WriteSummary(&buf, time.UTC); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "new task: jts") {
+		t.Fatalf("unexpected summary:\n%s", buf.String())
+	}
+	if err := plan.Apply(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// re-importing skips everything
+	plan, err = NewPlan(db, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Tasks) != 0 || len(plan.Sessions) != 0 || plan.Duplicates != 3 {
+		t.Fatalf("expected only duplicates, got %#v", plan)
+	}
+
+	// new records are added to the existing session and task
+	end := time.Date(2025, 4, 3, 11, 0, 0, 0, time.UTC)
+	records = append(records, Record{Description: "write importer", Task: "jts", Start: time.Date(2025, 4, 3, 10, 0, 0, 0, time.UTC), End: &end})
+	plan, err = NewPlan(db, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Tasks) != 0 || len(plan.Sessions) != 1 || plan.Sessions[0].ID == "" || plan.Timeframes() != 1 {
+		t.Fatalf("expected 1 timeframe for an existing session, got %#v", plan)
+	}
+	if err := plan.Apply(db); err != nil {
+		t.Fatal(err)
+	}
+	session, err := db.GetSession(plan.Sessions[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(session.Timeframes) != 3 || session.TaskID == nil {
+		t.Fatalf("unexpected session %#v", session)
+	}
+}
+
+func TestApplyAtomic(t *testing.T) {
+	db := newTestDatabase(t)
+	records, err := ParseToggl(strings.NewReader(togglCSV), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := NewPlan(db, records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fails after the tasks and the other sessions are added
+	plan.Sessions = append(plan.Sessions, PlannedSession{ID: "missing", Tags: []string{""}})
+	if err := plan.Apply(db); !errors.Is(err, database.ErrEmptyTag) {
+		t.Fatalf("expected %v, got %v", database.ErrEmptyTag, err)
+	}
+	for _, table := range []string{"tasks", "sessions", "time_frames"} {
+		var n int
+		if err := db.DB.Get(&n, "SELECT COUNT(*) FROM "+table); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("expected nothing in %s after a failed import, got %d rows", table, n)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package importer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const timewarriorTimeFormat = "20060102T150405Z"
+
+// ParseTimewarrior parses a Timewarrior data file.
+// Each line is an interval like:
+//
+//	inc 20250401T010000Z - 20250401T023000Z # coding "project x" # "fix bug"
+//
+// The tags become the description, and the annotation (after the second #) becomes the notes.
+// Intervals without an end are running.
+func ParseTimewarrior(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		record, err := timewarriorRecord(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sortRecords(records)
+	return records, nil
+}
+
+func timewarriorRecord(line string) (Record, error) {
+	rest, ok := strings.CutPrefix(line, "inc ")
+	if !ok {
+		return Record{}, fmt.Errorf("expected inc, got %q", line)
+	}
+	interval, tagsRaw, _ := strings.Cut(rest, " # ")
+	tagsRaw, annotationRaw, _ := strings.Cut(tagsRaw, " # ")
+	startRaw, endRaw, hasEnd := strings.Cut(strings.TrimSpace(interval), " - ")
+	var record Record
+	var err error
+	record.Start, err = time.Parse(timewarriorTimeFormat, strings.TrimSpace(startRaw))
+	if err != nil {
+		return Record{}, fmt.Errorf("start: %w", err)
+	}
+	if hasEnd {
+		end, err := time.Parse(timewarriorTimeFormat, strings.TrimSpace(endRaw))
+		if err != nil {
+			return Record{}, fmt.Errorf("end: %w", err)
+		}
+		record.End = &end
+	}
+	record.Description = strings.Join(splitTimewarriorTags(tagsRaw), " ")
+	if record.Description == "" {
+		record.Description = "(no tags)"
+	}
+	annotation := splitTimewarriorTags(annotationRaw)
+	record.Notes = strings.Join(annotation, " ")
+	return record, nil
+}
+
+// splitTimewarriorTags splits space-separated tags, which are quoted if they contain spaces.
+func splitTimewarriorTags(s string) []string {
+	var tags []string
+	var b strings.Builder
+	quoted := false
+	escaped := false
+	flush := func() {
+		if b.Len() > 0 {
+			tags = append(tags, b.String())
+			b.Reset()
+		}
+	}
+	for _, r := range s {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			flush()
+		default:
+			b.WriteRune(r)
+		}
+	}
+	flush()
+	return tags
+}
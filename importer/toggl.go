@@ -0,0 +1,59 @@
+package importer
+
+import (
+	"fmt"
+	"io"
+)
+
+// ParseToggl parses a Toggl Track detailed report CSV export.
+// The project (and the Toggl task, if any) becomes the task.
+func ParseToggl(r io.Reader, opts Options) ([]Record, error) {
+	rows, err := csvRows(r)
+	if err != nil {
+		return nil, err
+	}
+	var records []Record
+	for i, row := range rows {
+		if err := requireColumns(row, "Description", "Project", "Start date", "Start time", "End date", "End time"); err != nil {
+			return nil, err
+		}
+		record, err := togglRecord(row, opts)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", i+2, err)
+		}
+		records = append(records, record)
+	}
+	sortRecords(records)
+	return records, nil
+}
+
+var (
+	togglDateLayouts = []string{"2006-01-02", "01/02/2006", "02.01.2006"}
+	togglTimeLayouts = []string{"15:04:05", "15:04", "03:04:05 PM", "03:04 PM"}
+)
+
+func togglRecord(row map[string]string, opts Options) (Record, error) {
+	start, err := parseDateTime(row["Start date"], row["Start time"], togglDateLayouts, togglTimeLayouts, opts.location())
+	if err != nil {
+		return Record{}, fmt.Errorf("start: %w", err)
+	}
+	end, err := parseDateTime(row["End date"], row["End time"], togglDateLayouts, togglTimeLayouts, opts.location())
+	if err != nil {
+		return Record{}, fmt.Errorf("end: %w", err)
+	}
+	task := joinNonEmpty(" / ", row["Project"], row["Task"])
+	description := row["Description"]
+	if description == "" {
+		description = task
+	}
+	if description == "" {
+		description = "(no description)"
+	}
+	return Record{
+		Description: description,
+		Task:        task,
+		Tags:        splitTags(row["Tags"]),
+		Start:       start,
+		End:         &end,
+	}, nil
+}
@@ -0,0 +1,64 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ParseWatson parses Watson's frames file, a JSON array of
+// [start, stop, project, id, tags, updated_at] with times in Unix seconds.
+// The project becomes both the task and the description; tags are also appended to the description.
+func ParseWatson(r io.Reader) ([]Record, error) {
+	var frames [][]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&frames); err != nil {
+		return nil, fmt.Errorf("decode frames: %w", err)
+	}
+	var records []Record
+	for i, frame := range frames {
+		record, err := watsonRecord(frame)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", i, err)
+		}
+		records = append(records, record)
+	}
+	sortRecords(records)
+	return records, nil
+}
+
+func watsonRecord(frame []json.RawMessage) (Record, error) {
+	if len(frame) < 3 {
+		return Record{}, fmt.Errorf("expected at least 3 fields, got %d", len(frame))
+	}
+	var start, stop int64
+	var project string
+	var tags []string
+	if err := json.Unmarshal(frame[0], &start); err != nil {
+		return Record{}, fmt.Errorf("start: %w", err)
+	}
+	if err := json.Unmarshal(frame[1], &stop); err != nil {
+		return Record{}, fmt.Errorf("stop: %w", err)
+	}
+	if err := json.Unmarshal(frame[2], &project); err != nil {
+		return Record{}, fmt.Errorf("project: %w", err)
+	}
+	if len(frame) > 4 {
+		if err := json.Unmarshal(frame[4], &tags); err != nil {
+			return Record{}, fmt.Errorf("tags: %w", err)
+		}
+	}
+	end := time.Unix(stop, 0)
+	description := project
+	if len(tags) > 0 {
+		description += " +" + strings.Join(tags, " +")
+	}
+	return Record{
+		Description: description,
+		Task:        project,
+		Tags:        tags,
+		Start:       time.Unix(start, 0),
+		End:         &end,
+	}, nil
+}
@@ -0,0 +1,201 @@
+// Package report aggregates time spent over a date range.
+package report
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+)
+
+// GroupBy is what durations are grouped by.
+type GroupBy string
+
+const (
+	GroupByDay     GroupBy = "day"
+	GroupByWeek    GroupBy = "week" // ISO 8601 week
+	GroupByMonth   GroupBy = "month"
+	GroupByTask    GroupBy = "task"
+	GroupBySession GroupBy = "session"
+	// GroupByTag counts time in each tag of the session, so rows can add up to more than the total.
+	GroupByTag GroupBy = "tag"
+)
+
+// GroupBys lists all groupings, e.g. for selection in a UI.
+var GroupBys = []GroupBy{GroupByDay, GroupByWeek, GroupByMonth, GroupByTask, GroupBySession, GroupByTag}
+
+// Valid reports whether g is one of the known groupings.
+func (g GroupBy) Valid() bool {
+	return slices.Contains(GroupBys, g)
+}
+
+// Options specifies what to aggregate.
+type Options struct {
+	// From and To is the range to aggregate over. Timeframes partly outside the range are clipped.
+	From, To time.Time
+	// Location is where days start and end. It defaults to UTC.
+	Location *time.Location
+	GroupBy  GroupBy
+	// Now is used as the end of running timeframes. It defaults to the current time.
+	Now time.Time
+}
+
+// Row is the time spent in one group.
+type Row struct {
+	// Key identifies the group: the date (2006-01-02), ISO week (2006-W01), month (2006-01), task ID, session ID or tag.
+	// For GroupByTask and GroupByTag, sessions without a task or tags are grouped under an empty key.
+	Key string
+	// Label is a human-readable name of the group, e.g. the description of the task.
+	Label string
+	// Start is the start of the period for time-based groupings.
+	Start    time.Time
+	Duration time.Duration
+}
+
+type Report struct {
+	From, To time.Time
+	GroupBy  GroupBy
+	Rows     []Row
+	Total    time.Duration
+}
+
+// Generate aggregates the sessions in db.
+func Generate(db *database.Database, opts Options) (Report, error) {
+	sessions, err := db.GetSessionsBetween(opts.From, opts.To)
+	if err != nil {
+		return Report{}, fmt.Errorf("get sessions: %w", err)
+	}
+	tasks, err := db.GetTasks()
+	if err != nil {
+		return Report{}, fmt.Errorf("get tasks: %w", err)
+	}
+	return Aggregate(sessions, tasks, opts)
+}
+
+// Aggregate aggregates the durations of the sessions' timeframes.
+// Timeframes are split at midnight in opts.Location, so each day only counts time spent on that day.
+func Aggregate(sessions []data.Session, tasks []data.Task, opts Options) (Report, error) {
+	if !opts.GroupBy.Valid() {
+		return Report{}, fmt.Errorf("invalid grouping %q", opts.GroupBy)
+	}
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	taskDescriptions := map[string]string{}
+	for _, task := range tasks {
+		taskDescriptions[task.ID] = task.Description
+	}
+	r := Report{From: opts.From, To: opts.To, GroupBy: opts.GroupBy}
+	rows := map[string]*Row{}
+	add := func(key, label string, start time.Time, d time.Duration) {
+		row, ok := rows[key]
+		if !ok {
+			row = &Row{Key: key, Label: label, Start: start}
+			rows[key] = row
+		}
+		row.Duration += d
+	}
+	for _, s := range sessions {
+		for _, tf := range s.Timeframes {
+			start, end := tf.Start, opts.Now
+			if tf.End != nil {
+				end = *tf.End
+			}
+			start, end = maxTime(start, opts.From), minTime(end, opts.To)
+			for _, day := range splitDays(start, end, opts.Location) {
+				d := day.end.Sub(day.start)
+				r.Total += d
+				switch opts.GroupBy {
+				case GroupByDay:
+					key := day.midnight.Format("2006-01-02")
+					add(key, key, day.midnight, d)
+				case GroupByWeek:
+					year, week := day.midnight.ISOWeek()
+					key := fmt.Sprintf("%04d-W%02d", year, week)
+					add(key, key, weekStart(day.midnight), d)
+				case GroupByMonth:
+					key := day.midnight.Format("2006-01")
+					add(key, key, day.midnight.AddDate(0, 0, 1-day.midnight.Day()), d)
+				case GroupByTask:
+					if s.TaskID == nil {
+						add("", "", time.Time{}, d)
+					} else {
+						add(*s.TaskID, taskDescriptions[*s.TaskID], time.Time{}, d)
+					}
+				case GroupBySession:
+					add(s.ID, s.Description, time.Time{}, d)
+				case GroupByTag:
+					if len(s.Tags) == 0 {
+						add("", "", time.Time{}, d)
+					}
+					for _, tag := range s.Tags {
+						add(tag, tag, time.Time{}, d)
+					}
+				}
+			}
+		}
+	}
+	for _, row := range rows {
+		r.Rows = append(r.Rows, *row)
+	}
+	switch opts.GroupBy {
+	case GroupByDay, GroupByWeek, GroupByMonth:
+		slices.SortFunc(r.Rows, func(a, b Row) int {
+			return a.Start.Compare(b.Start)
+		})
+	default:
+		slices.SortFunc(r.Rows, func(a, b Row) int {
+			if c := cmp.Compare(b.Duration, a.Duration); c != 0 {
+				return c
+			}
+			return cmp.Compare(a.Key, b.Key)
+		})
+	}
+	return r, nil
+}
+
+type dayPart struct {
+	midnight   time.Time // start of the day in the location
+	start, end time.Time
+}
+
+// splitDays splits [start, end) at midnight in loc.
+func splitDays(start, end time.Time, loc *time.Location) []dayPart {
+	var parts []dayPart
+	for start.Before(end) {
+		local := start.In(loc)
+		midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+		// AddDate handles days that are not 24 hours long (e.g. DST changes)
+		next := midnight.AddDate(0, 0, 1)
+		partEnd := minTime(end, next)
+		parts = append(parts, dayPart{midnight, start, partEnd})
+		start = partEnd
+	}
+	return parts
+}
+
+// weekStart returns the Monday of the ISO week of the day.
+func weekStart(midnight time.Time) time.Time {
+	offset := (int(midnight.Weekday()) + 6) % 7
+	return midnight.AddDate(0, 0, -offset)
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
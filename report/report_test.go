@@ -0,0 +1,107 @@
+package report
+
+import (
+	"testing"
+	"time"
+
+	"nyiyui.ca/jts/data"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestAggregateMidnight(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+	sessions := []data.Session{
+		{ID: "a", Description: "late night", TaskID: ptr("t"), Tags: []string{"billable", "coding"}, Timeframes: []data.Timeframe{
+			// 22:00 on Sunday to 02:00 on Monday in JST
+			{Start: time.Date(2025, 3, 30, 22, 0, 0, 0, jst), End: ptr(time.Date(2025, 3, 31, 2, 0, 0, 0, jst))},
+		}},
+		{ID: "b", Description: "running", Timeframes: []data.Timeframe{
+			{Start: time.Date(2025, 3, 31, 9, 0, 0, 0, jst)},
+		}},
+	}
+	tasks := []data.Task{{ID: "t", Description: "jts"}}
+	opts := Options{
+		From:     time.Date(2025, 3, 30, 0, 0, 0, 0, jst),
+		To:       time.Date(2025, 4, 1, 0, 0, 0, 0, jst),
+		Location: jst,
+		Now:      time.Date(2025, 3, 31, 10, 30, 0, 0, jst),
+	}
+
+	opts.GroupBy = GroupByDay
+	r, err := Aggregate(sessions, tasks, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRows(t, r, []Row{
+		{Key: "2025-03-30", Duration: 2 * time.Hour},
+		{Key: "2025-03-31", Duration: 3*time.Hour + 30*time.Minute},
+	})
+	if r.Total != 5*time.Hour+30*time.Minute {
+		t.Fatalf("unexpected total %s", r.Total)
+	}
+
+	opts.GroupBy = GroupByWeek
+	r, err = Aggregate(sessions, tasks, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRows(t, r, []Row{
+		{Key: "2025-W13", Duration: 2 * time.Hour},
+		{Key: "2025-W14", Duration: 3*time.Hour + 30*time.Minute},
+	})
+	if !r.Rows[1].Start.Equal(time.Date(2025, 3, 31, 0, 0, 0, 0, jst)) {
+		t.Fatalf("expected week to start on Monday, got %s", r.Rows[1].Start)
+	}
+
+	opts.GroupBy = GroupByTask
+	r, err = Aggregate(sessions, tasks, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRows(t, r, []Row{
+		{Key: "t", Label: "jts", Duration: 4 * time.Hour},
+		{Key: "", Duration: 1*time.Hour + 30*time.Minute},
+	})
+
+	opts.GroupBy = GroupByTag
+	r, err = Aggregate(sessions, tasks, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRows(t, r, []Row{
+		{Key: "billable", Duration: 4 * time.Hour},
+		{Key: "coding", Duration: 4 * time.Hour},
+		{Key: "", Duration: 1*time.Hour + 30*time.Minute},
+	})
+	if r.Total != 5*time.Hour+30*time.Minute {
+		t.Fatalf("expected tags not to be double counted in total, got %s", r.Total)
+	}
+
+	// clipped to the range
+	opts.GroupBy = GroupBySession
+	opts.From = time.Date(2025, 3, 31, 0, 0, 0, 0, jst)
+	r, err = Aggregate(sessions, tasks, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRows(t, r, []Row{
+		{Key: "a", Label: "late night", Duration: 2 * time.Hour},
+		{Key: "b", Label: "running", Duration: 1*time.Hour + 30*time.Minute},
+	})
+}
+
+func expectRows(t *testing.T, r Report, rows []Row) {
+	t.Helper()
+	if len(r.Rows) != len(rows) {
+		t.Fatalf("expected %d rows, got %#v", len(rows), r.Rows)
+	}
+	for i, row := range rows {
+		got := r.Rows[i]
+		if got.Key != row.Key || got.Duration != row.Duration || (row.Label != "" && got.Label != row.Label) {
+			t.Fatalf("row %d: expected %#v, got %#v", i, row, got)
+		}
+	}
+}
@@ -3,9 +3,11 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"slices"
+	"strconv"
 
 	"nyiyui.ca/jts/database/sync"
 	"nyiyui.ca/jts/tokens"
@@ -18,6 +20,8 @@ type tokenInfoKey struct{}
 type TokenInfo struct {
 	Name        string
 	Permissions []Permission
+	// Hash identifies the token, e.g. as the holder of the sync lock, as names are not unique. It is set by apiAuthz.
+	Hash tokens.TokenHash
 }
 
 type Permission string
@@ -25,8 +29,15 @@ type Permission string
 const (
 	PermissionSyncDatabase Permission = "database:sync"
 	PermissionViewDatabase Permission = "database:view"
+	// PermissionWriteDatabase allows editing the database through the REST API.
+	PermissionWriteDatabase Permission = "database:write"
+	// PermissionAdmin allows issuing and revoking API tokens.
+	PermissionAdmin Permission = "admin"
 )
 
+// Permissions lists all permissions, e.g. for selection in a UI.
+var Permissions = []Permission{PermissionSyncDatabase, PermissionViewDatabase, PermissionWriteDatabase, PermissionAdmin}
+
 func (s *Server) apiAuthz(permissionsRequired ...Permission) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -35,13 +46,18 @@ func (s *Server) apiAuthz(permissionsRequired ...Permission) func(next http.Hand
 				http.Error(w, "invalid token format", 400)
 				return
 			}
-			tokenInfo := s.tokens[token.Hash()]
+			tokenInfo, ok := s.lookupToken(token)
+			if !ok {
+				http.Error(w, "invalid token", 403)
+				return
+			}
 			for _, permissionRequired := range permissionsRequired {
 				if !slices.Contains(tokenInfo.Permissions, permissionRequired) {
 					http.Error(w, "insufficient permissions", 403)
 					return
 				}
 			}
+			tokenInfo.Hash = token.Hash()
 			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), TokenInfoKey, tokenInfo)))
 		})
 	}
@@ -49,17 +65,56 @@ func (s *Server) apiAuthz(permissionsRequired ...Permission) func(next http.Hand
 
 func (s *Server) handleLock(w http.ResponseWriter, r *http.Request) {
 	tokenInfo := r.Context().Value(TokenInfoKey).(TokenInfo)
-	ok := s.lock.TryLock(tokenInfo.Name)
+	lease, ok := s.lock.TryLock(tokenInfo.Hash.String(), tokenInfo.Name)
 	if !ok {
 		http.Error(w, "already locked", 409)
 		return
 	}
-	http.Error(w, "locked", 200)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(lease)
+}
+
+// withLease rejects uploads unless the client holds the current lease (sent in sync.LeaseIDHeader), as another client may be syncing otherwise.
+func (s *Server) withLease(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenInfo := r.Context().Value(TokenInfoKey).(TokenInfo)
+		if !s.lock.Holding(tokenInfo.Hash.String(), r.Header.Get(sync.LeaseIDHeader), func() { next(w, r) }) {
+			http.Error(w, "lease not held (expired?)", 409)
+		}
+	})
+}
+
+func (s *Server) handleRenewLock(w http.ResponseWriter, r *http.Request) {
+	tokenInfo := r.Context().Value(TokenInfoKey).(TokenInfo)
+	var lr sync.LeaseRequest
+	err := json.NewDecoder(r.Body).Decode(&lr)
+	if err != nil {
+		http.Error(w, "failed to decode lease request", 400)
+		return
+	}
+	lease, ok := s.lock.Renew(tokenInfo.Hash.String(), lr.LeaseID)
+	if !ok {
+		http.Error(w, "lease not held (expired?)", 409)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(lease)
 }
 
 func (s *Server) handleUnlock(w http.ResponseWriter, r *http.Request) {
 	tokenInfo := r.Context().Value(TokenInfoKey).(TokenInfo)
-	ok := s.lock.Unlock(tokenInfo.Name)
+	var lr sync.LeaseRequest
+	// older clients do not send a lease ID
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&lr)
+		if err != nil {
+			http.Error(w, "failed to decode lease request", 400)
+			return
+		}
+	}
+	ok := s.lock.Unlock(tokenInfo.Hash.String(), lr.LeaseID)
 	if !ok {
 		http.Error(w, "failed to unlock", 403)
 		return
@@ -93,10 +148,51 @@ func (s *Server) handlePostDatabaseChanges(w http.ResponseWriter, r *http.Reques
 	}
 	log.Printf("importing changes: sessions=%d, timeframes=%d", len(changes.Sessions), len(changes.Timeframes))
 	err = sync.ImportChanges(s.db, changes)
-	if err != nil {
+	switch {
+	case errors.Is(err, sync.ErrNewerSchema):
+		http.Error(w, err.Error(), 409)
+		return
+	case errors.Is(err, sync.ErrNoID):
+		http.Error(w, err.Error(), 422)
+		return
+	case err != nil:
+		log.Printf("import changes: %s", err)
 		http.Error(w, "failed to import database changes", 500)
 		return
 	}
 	log.Println("changes imported")
+	seq, err := s.db.LatestSeq()
+	if err != nil {
+		http.Error(w, "failed to get sequence number", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(sync.UploadChangesResponse{Seq: seq})
+}
+
+func (s *Server) handleGetDatabaseChanges(w http.ResponseWriter, r *http.Request) {
+	var since int64
+	if r.URL.Query().Has("since") {
+		var err error
+		since, err = strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid since", 400)
+			return
+		}
+	}
+	delta, err := sync.ExportDelta(s.db, since)
+	if err != nil {
+		log.Printf("export delta: %s", err)
+		http.Error(w, "failed to export changes", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	err = json.NewEncoder(w).Encode(delta)
+	if err != nil {
+		// cannot WriteHeader now
+		w.Write([]byte("failed to encode changes"))
+		return
+	}
 }
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+)
+
+// checkedProblem is a problem with the sessions of its timeframes, for display.
+type checkedProblem struct {
+	database.Problem
+	Sessions map[string]data.Session
+}
+
+func (s *Server) handleGetCheck(w http.ResponseWriter, r *http.Request) {
+	problems, err := s.db.CheckTimeframes()
+	if err != nil {
+		log.Printf("check: %s", err)
+		http.Error(w, "failed to check timeframes", 500)
+		return
+	}
+	checked := make([]checkedProblem, len(problems))
+	for i, p := range problems {
+		checked[i] = checkedProblem{Problem: p, Sessions: map[string]data.Session{}}
+		for _, tf := range p.Timeframes {
+			if _, ok := checked[i].Sessions[tf.SessionID]; ok {
+				continue
+			}
+			session, err := s.db.GetSession(tf.SessionID)
+			if err != nil {
+				log.Printf("check: session %s: %s", tf.SessionID, err)
+				http.Error(w, "failed to get session", 500)
+				return
+			}
+			checked[i].Sessions[tf.SessionID] = session
+		}
+	}
+	s.renderTemplate("check.html", w, r, map[string]interface{}{
+		"Problems": checked,
+	})
+}
+
+// handlePostCheckFix applies a fix of a problem found by checking again, so that a stale page cannot apply an outdated fix.
+func (s *Server) handlePostCheckFix(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("problem")
+	index, err := strconv.Atoi(r.FormValue("fix"))
+	if err != nil {
+		http.Error(w, "invalid fix", 400)
+		return
+	}
+	problems, err := s.db.CheckTimeframes()
+	if err != nil {
+		log.Printf("check: %s", err)
+		http.Error(w, "failed to check timeframes", 500)
+		return
+	}
+	for _, p := range problems {
+		if p.Key() != key {
+			continue
+		}
+		if index < 0 || index >= len(p.Fixes) {
+			http.Error(w, "invalid fix", 400)
+			return
+		}
+		if err := s.db.ApplyFix(p.Fixes[index]); err != nil {
+			log.Printf("fix %s: %s", key, err)
+			http.Error(w, "failed to apply fix", 500)
+			return
+		}
+		http.Redirect(w, r, "/check", 303)
+		return
+	}
+	http.Error(w, "problem not found; it may have been fixed already", 404)
+}
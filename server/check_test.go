@@ -0,0 +1,52 @@
+package server
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"nyiyui.ca/jts/data"
+)
+
+func TestCheck(t *testing.T) {
+	s, do := newTestWeb(t)
+	start := time.Date(2025, 4, 1, 9, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+	later := end.Add(time.Hour)
+	if _, err := s.db.AddSession(data.Session{Description: "coding", Timeframes: []data.Timeframe{{Start: start, End: &end}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.AddSession(data.Session{Description: "meeting", Timeframes: []data.Timeframe{{Start: start.Add(time.Hour), End: &later}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := do("GET", "/check", "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("check: status %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "Overlap of 1h0m0s") || !strings.Contains(string(body), "meeting") {
+		t.Fatalf("unexpected page:\n%s", body)
+	}
+
+	problems, err := s.db.CheckTimeframes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := url.Values{"problem": {problems[0].Key()}, "fix": {"0"}}
+	resp = do("POST", "/check/fix", form.Encode())
+	if resp.StatusCode != 303 {
+		t.Fatalf("fix: status %d", resp.StatusCode)
+	}
+	resp = do("POST", "/check/fix", form.Encode())
+	if resp.StatusCode != 404 {
+		t.Fatalf("fix again: status %d", resp.StatusCode)
+	}
+	resp = do("GET", "/check", "")
+	body, _ = io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "No overlapping or invalid timeframes.") {
+		t.Fatalf("unexpected page after fix:\n%s", body)
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"nyiyui.ca/jts/database"
+	"nyiyui.ca/jts/database/sync"
+)
+
+// maxEncryptionParamsSize is the maximum size of the encryption parameters, which are only a salt and a few numbers.
+const maxEncryptionParamsSize = 4096
+
+// encrypted reports whether a client set up end-to-end encryption, after which the server only has encrypted rows.
+// Encryption cannot be turned off, so the result is cached once it is true.
+func (s *Server) encrypted() bool {
+	if s.encryptedCache.Load() {
+		return true
+	}
+	_, err := s.db.EncryptionParams()
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("encryption params: %s", err)
+		}
+		return false
+	}
+	s.encryptedCache.Store(true)
+	return true
+}
+
+// unlessEncrypted rejects API requests for plaintext rows once encryption is set up, so that a client without the passphrase does not upload plaintext.
+func (s *Server) unlessEncrypted(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.encrypted() {
+			http.Error(w, "database is end-to-end encrypted; set the passphrase of the profile to sync", 409)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// encryptedNotice shows a notice instead of web pages with the contents of the database once encryption is set up, as the server cannot decrypt them.
+func (s *Server) encryptedNotice(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.encrypted() {
+			if r.Method != "GET" {
+				http.Error(w, "database is end-to-end encrypted", 409)
+				return
+			}
+			s.renderTemplate("encrypted.html", w, r, nil)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleGetEncryptionParams(w http.ResponseWriter, r *http.Request) {
+	params, err := s.db.EncryptionParams()
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "encryption is not set up", 404)
+		return
+	}
+	if err != nil {
+		log.Printf("encryption params: %s", err)
+		http.Error(w, "database error", 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(params)
+}
+
+// handlePutEncryptionParams sets up encryption, which removes the plaintext rows.
+// The client must hold the lease (see withLease), so that no other client uploads plaintext rows meanwhile.
+func (s *Server) handlePutEncryptionParams(w http.ResponseWriter, r *http.Request) {
+	params, err := io.ReadAll(io.LimitReader(r.Body, maxEncryptionParamsSize+1))
+	if err != nil {
+		http.Error(w, "failed to read body", 400)
+		return
+	}
+	if len(params) > maxEncryptionParamsSize || !json.Valid(params) {
+		http.Error(w, "params must be JSON", 422)
+		return
+	}
+	err = s.db.SetEncryptionParams(params)
+	switch {
+	case errors.Is(err, database.ErrEncryptionParamsSet):
+		http.Error(w, err.Error(), 409)
+		return
+	case err != nil:
+		log.Printf("set encryption params: %s", err)
+		http.Error(w, "database error", 500)
+		return
+	}
+	log.Println("end-to-end encryption set up")
+	s.encryptedCache.Store(true)
+	http.Error(w, "set", 201)
+}
+
+func (s *Server) handleGetEncryptedChanges(w http.ResponseWriter, r *http.Request) {
+	var since int64
+	if r.URL.Query().Has("since") {
+		var err error
+		since, err = strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid since", 400)
+			return
+		}
+	}
+	rows, seq, err := s.db.EncryptedRowsSince(since)
+	if err != nil {
+		log.Printf("encrypted rows: %s", err)
+		http.Error(w, "failed to export changes", 500)
+		return
+	}
+	resetSeq, err := s.db.EncryptionResetSeq()
+	if err != nil {
+		log.Printf("encryption reset seq: %s", err)
+		http.Error(w, "failed to export changes", 500)
+		return
+	}
+	sd := sync.SealedDelta{Version: sync.SchemaVersion, Rows: make([]sync.SealedRow, len(rows)), Seq: seq, ResetSeq: resetSeq}
+	for i, row := range rows {
+		sd.Rows[i] = sync.SealedRow{Table: row.TableName, ID: row.ID, Blob: row.Blob}
+	}
+	writeJSON(w, 200, sd)
+}
+
+func (s *Server) handlePostEncryptedChanges(w http.ResponseWriter, r *http.Request) {
+	var changes sync.SealedChanges
+	err := json.NewDecoder(r.Body).Decode(&changes)
+	if err != nil {
+		http.Error(w, "failed to decode database changes", 400)
+		return
+	}
+	if changes.Version > sync.SchemaVersion {
+		http.Error(w, sync.ErrNewerSchema.Error(), 409)
+		return
+	}
+	if !s.encrypted() {
+		http.Error(w, "encryption is not set up", 409)
+		return
+	}
+	rows := make([]database.EncryptedRow, len(changes.Rows))
+	for i, row := range changes.Rows {
+		switch row.Table {
+		case "sessions", "time_frames", "tasks":
+		default:
+			http.Error(w, "unknown table "+row.Table, 422)
+			return
+		}
+		if row.ID == "" {
+			http.Error(w, sync.ErrNoID.Error(), 422)
+			return
+		}
+		rows[i] = database.EncryptedRow{TableName: row.Table, ID: row.ID, Blob: row.Blob}
+	}
+	log.Printf("importing %d encrypted changes", len(rows))
+	seq, err := s.db.PutEncryptedRows(rows)
+	if err != nil {
+		log.Printf("put encrypted rows: %s", err)
+		http.Error(w, "failed to import database changes", 500)
+		return
+	}
+	writeJSON(w, 200, sync.UploadChangesResponse{Seq: seq})
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"nyiyui.ca/jts/data"
+)
+
+func TestEncryptedNotice(t *testing.T) {
+	s, do := newTestWeb(t)
+	if _, err := s.db.AddSession(data.Session{Description: "client meeting"}); err != nil {
+		t.Fatal(err)
+	}
+	if page := readPage(t, do("GET", "/latest", "")); !strings.Contains(page, "client meeting") || strings.Contains(page, "end-to-end encrypted") {
+		t.Fatalf("expected the session before encryption is set up, got %s", page)
+	}
+	if err := s.db.SetEncryptionParams([]byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/latest", "/tasks", "/timeline", "/search?q=client"} {
+		page := readPage(t, do("GET", path, ""))
+		if !strings.Contains(page, "end-to-end encrypted") || strings.Contains(page, "client meeting") {
+			t.Errorf("%s: expected the encrypted notice instead of the contents, got %s", path, page)
+		}
+	}
+	if resp := do("POST", "/session/new", "description=x"); resp.StatusCode != 409 {
+		t.Errorf("expected edits to be rejected, got status %d", resp.StatusCode)
+	}
+	// pages without the contents of the database still work
+	if page := readPage(t, do("GET", "/admin/tokens", "")); strings.Contains(page, "end-to-end encrypted") {
+		t.Errorf("expected the tokens page, got %s", page)
+	}
+}
+
+func TestEncryptedREST(t *testing.T) {
+	s, do := newTestREST(t)
+	if resp := do("GET", "/api/sessions", ""); resp.StatusCode != 200 {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if err := s.db.SetEncryptionParams([]byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if resp := do("GET", "/api/sessions", ""); resp.StatusCode != 409 {
+		t.Errorf("expected plaintext API to be rejected once encrypted, got status %d", resp.StatusCode)
+	}
+}
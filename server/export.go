@@ -0,0 +1,53 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"nyiyui.ca/jts/export"
+)
+
+// handleGetExport writes a timesheet in the format given in the path.
+// The query parameters are from and to (dates), tz (IANA time zone name) and columns (comma-separated, CSV only).
+// Without tz, the time zone of the logged-in user is used, or UTC for API tokens.
+func (s *Server) handleGetExport(w http.ResponseWriter, r *http.Request) {
+	format := export.Format(r.PathValue("format"))
+	if !slices.Contains(export.Formats, format) {
+		http.Error(w, "unknown format", 404)
+		return
+	}
+	loc, err := queryLocation(r, getTimeLocation(r))
+	if err != nil {
+		http.Error(w, "invalid time zone", 422)
+		return
+	}
+	from, to, err := dateRange(r, loc)
+	if err != nil {
+		http.Error(w, "invalid date", 422)
+		return
+	}
+	opts := export.Options{From: from, To: to, Location: loc, Now: time.Now()}
+	if columns := r.URL.Query().Get("columns"); columns != "" {
+		opts.Columns = strings.Split(columns, ",")
+		if err := export.ValidateColumns(opts.Columns); err != nil {
+			http.Error(w, err.Error(), 422)
+			return
+		}
+	}
+	entries, err := export.Entries(s.db, opts)
+	if err != nil {
+		log.Printf("export: %s", err)
+		http.Error(w, "failed to export", 500)
+		return
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="jts-%s-%s.%s"`, from.Format("20060102"), to.AddDate(0, 0, -1).Format("20060102"), format.Extension()))
+	err = export.Write(w, format, entries, opts)
+	if err != nil {
+		log.Printf("export: write: %s", err)
+	}
+}
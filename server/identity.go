@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/oauth2"
+)
+
+// Identity is a user who logged in with a Provider.
+type Identity struct {
+	// Provider is the name of the provider the user logged in with.
+	Provider string
+	// Subject identifies the user at the provider, e.g. the GitHub login, or the configured claim of an OIDC ID token.
+	Subject string
+	// Name is the name to display.
+	Name       string
+	AvatarURL  string
+	ProfileURL string
+}
+
+// Key returns the key of the user in the users map.
+// GitHub users are keyed by their login, as before there were other providers.
+// Other users are keyed by provider:subject, so that providers cannot log in as each other's users.
+func (i Identity) Key() string {
+	if i.Provider == GitHubProviderName {
+		return i.Subject
+	}
+	return i.Provider + ":" + i.Subject
+}
+
+func init() {
+	gob.RegisterName("identity", Identity{})
+}
+
+// LoginAttempt is what is remembered between redirecting to a provider and the callback.
+type LoginAttempt struct {
+	// State is checked in the callback, so that logins cannot be started by another site.
+	State string
+	// Verifier is the PKCE code verifier.
+	Verifier string
+	// Nonce is checked in OIDC ID tokens, so that they cannot be replayed.
+	Nonce string
+}
+
+func newLoginAttempt() (LoginAttempt, error) {
+	state, err := randomString()
+	if err != nil {
+		return LoginAttempt{}, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return LoginAttempt{}, err
+	}
+	return LoginAttempt{State: state, Verifier: oauth2.GenerateVerifier(), Nonce: nonce}, nil
+}
+
+func randomString() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Provider is an identity provider users can log in with.
+type Provider interface {
+	// Name identifies the provider in URLs and user keys. It must not contain slashes or colons.
+	Name() string
+	// DisplayName is shown on the login page.
+	DisplayName() string
+	// AuthCodeURL returns the URL to redirect the user to, to log in.
+	AuthCodeURL(attempt LoginAttempt) string
+	// Identify exchanges the code given to the callback for the identity of the user.
+	Identify(ctx context.Context, code string, attempt LoginAttempt) (Identity, error)
+}
+
+// GitHubProviderName is the name of the GitHub provider.
+const GitHubProviderName = "github"
+
+// GitHubProvider logs in with GitHub OAuth.
+type GitHubProvider struct {
+	config *oauth2.Config
+	// userURL is the GitHub API endpoint for the logged in user. It is only changed in tests.
+	userURL string
+}
+
+// NewGitHubProvider returns a provider for the OAuth app in config (see golang.org/x/oauth2/github).
+func NewGitHubProvider(config *oauth2.Config) *GitHubProvider {
+	return &GitHubProvider{config: config, userURL: "https://api.github.com/user"}
+}
+
+func (p *GitHubProvider) Name() string {
+	return GitHubProviderName
+}
+
+func (p *GitHubProvider) DisplayName() string {
+	return "GitHub"
+}
+
+func (p *GitHubProvider) AuthCodeURL(attempt LoginAttempt) string {
+	return p.config.AuthCodeURL(attempt.State, oauth2.S256ChallengeOption(attempt.Verifier))
+}
+
+// githubUserData is the user from the GitHub API.
+// Sessions from before there were other providers store it instead of an Identity.
+type githubUserData struct {
+	Login     string `json:"login"`
+	AvatarURL string `json:"avatar_url"`
+	HTMLURL   string `json:"html_url"`
+}
+
+func init() {
+	gob.RegisterName("githubUserData", githubUserData{})
+}
+
+func (data githubUserData) identity() Identity {
+	return Identity{
+		Provider:   GitHubProviderName,
+		Subject:    data.Login,
+		Name:       data.Login,
+		AvatarURL:  data.AvatarURL,
+		ProfileURL: data.HTMLURL,
+	}
+}
+
+func (p *GitHubProvider) Identify(ctx context.Context, code string, attempt LoginAttempt) (Identity, error) {
+	token, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(attempt.Verifier))
+	if err != nil {
+		return Identity{}, fmt.Errorf("exchange: %w", err)
+	}
+	resp, err := p.config.Client(ctx, token).Get(p.userURL)
+	if err != nil {
+		return Identity{}, fmt.Errorf("get user: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return Identity{}, fmt.Errorf("get user: status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Identity{}, fmt.Errorf("get user: %w", err)
+	}
+	var data githubUserData
+	if err := json.Unmarshal(body, &data); err != nil {
+		return Identity{}, fmt.Errorf("get user: %w", err)
+	}
+	if data.Login == "" {
+		return Identity{}, errors.New("get user: no login")
+	}
+	return data.identity(), nil
+}
@@ -2,16 +2,14 @@ package server
 
 import (
 	"context"
-	"encoding/gob"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"slices"
 	"time"
 
-	"golang.org/x/oauth2"
+	"github.com/gorilla/sessions"
 )
 
 type UserInfo struct {
@@ -21,7 +19,7 @@ type UserInfo struct {
 type key struct{}
 
 // LoginUserDataKey is the key for the login user data in the request context.
-// When using mainLogin, this key will be set to the githubUserData struct.
+// When using userAuthz, this key will be set to the Identity of the user.
 var LoginUserDataKey key
 
 // TimeLocationKey is the key for the *time.Location in the request context.
@@ -36,8 +34,16 @@ func getTimeLocation(r *http.Request) *time.Location {
 	return loc
 }
 
-func init() {
-	gob.RegisterName("githubUserData", githubUserData{})
+// loginIdentity returns the identity of the user logged in to the session.
+// Sessions from before there were other providers have a githubUserData instead.
+func loginIdentity(loginSession *sessions.Session) (Identity, bool) {
+	if identity, ok := loginSession.Values["identity"].(Identity); ok {
+		return identity, true
+	}
+	if data, ok := loginSession.Values["githubUserData"].(githubUserData); ok {
+		return data.identity(), true
+	}
+	return Identity{}, false
 }
 
 func (s *Server) userAuthz(permissionRequired ...Permission) func(next http.Handler) http.Handler {
@@ -49,13 +55,12 @@ func (s *Server) userAuthz(permissionRequired ...Permission) func(next http.Hand
 				http.Error(w, "session failure", 400)
 				return
 			}
-			_, ok := loginSession.Values["githubUserData"]
+			identity, ok := loginIdentity(loginSession)
 			if !ok {
 				http.Redirect(w, r, "/login", 302)
 				return
 			}
-			data := loginSession.Values["githubUserData"].(githubUserData)
-			userInfo, ok := s.users[data.Login]
+			userInfo, ok := s.users[identity.Key()]
 			if !ok {
 				http.Error(w, "insufficient permissions", 403)
 				return
@@ -75,39 +80,67 @@ func (s *Server) userAuthz(permissionRequired ...Permission) func(next http.Hand
 				}
 				r = r.WithContext(context.WithValue(r.Context(), TimeLocationKey, loc))
 			}
-			r = r.WithContext(context.WithValue(r.Context(), LoginUserDataKey, data))
+			r = r.WithContext(context.WithValue(r.Context(), LoginUserDataKey, identity))
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
+func (s *Server) provider(name string) (Provider, bool) {
+	i := slices.IndexFunc(s.providers, func(p Provider) bool { return p.Name() == name })
+	if i == -1 {
+		return nil, false
+	}
+	return s.providers[i], true
+}
+
+// handleLogin lets the user choose a provider, or goes straight to the provider if there is only one.
 func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Query().Has("code") {
 		http.Error(w, "login page should not have query parameter `code' - make sure your redirect URI is set correctly.", 500)
 		return
 	}
+	switch len(s.providers) {
+	case 0:
+		http.Error(w, "no identity providers are configured", 500)
+	case 1:
+		http.Redirect(w, r, "/login/"+url.PathEscape(s.providers[0].Name()), 302)
+	default:
+		s.renderTemplate("login.html", w, r, map[string]interface{}{
+			"Providers": s.providers,
+		})
+	}
+}
+
+func (s *Server) handleLoginProvider(w http.ResponseWriter, r *http.Request) {
+	provider, ok := s.provider(r.PathValue("provider"))
+	if !ok {
+		http.Error(w, "unknown identity provider", 404)
+		return
+	}
 	session, err := s.store.Get(r, "login-oauth2")
 	if err != nil {
 		log.Printf("session get: %s", err)
 		http.Error(w, "session failure", 400)
 		return
 	}
-	verifier := oauth2.GenerateVerifier()
-	session.Values["verifier"] = verifier
+	attempt, err := newLoginAttempt()
+	if err != nil {
+		log.Printf("new login attempt: %s", err)
+		http.Error(w, "failed to start login", 500)
+		return
+	}
+	session.Values["provider"] = provider.Name()
+	session.Values["state"] = attempt.State
+	session.Values["verifier"] = attempt.Verifier
+	session.Values["nonce"] = attempt.Nonce
 	err = session.Save(r, w)
 	if err != nil {
 		log.Printf("session save: %s", err)
 		http.Error(w, "session failure", 400)
 		return
 	}
-	url := s.oauthConfig.AuthCodeURL("", oauth2.S256ChallengeOption(verifier))
-	http.Redirect(w, r, url, 302)
-}
-
-type githubUserData struct {
-	Login     string `json:"login"`
-	AvatarURL string `json:"avatar_url"`
-	HTMLURL   string `json:"html_url"`
+	http.Redirect(w, r, provider.AuthCodeURL(attempt), 302)
 }
 
 func (s *Server) handleLoginCallback(w http.ResponseWriter, r *http.Request) {
@@ -124,56 +157,53 @@ func (s *Server) handleLoginCallback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, ok := session.Values["verifier"]
-	if !ok {
+	providerName, _ := session.Values["provider"].(string)
+	state, _ := session.Values["state"].(string)
+	verifier, _ := session.Values["verifier"].(string)
+	nonce, _ := session.Values["nonce"].(string)
+	if providerName == "" || verifier == "" {
 		http.Error(w, "try logging in again", 400)
 		return
 	}
-
-	verifier := session.Values["verifier"].(string)
-	delete(session.Values, "verifier")
-	code := r.URL.Query().Get("code")
-	token, err := s.oauthConfig.Exchange(r.Context(), code, oauth2.VerifierOption(verifier))
-	if err != nil {
-		http.Error(w, err.Error(), 500)
-		return
+	for _, key := range []string{"provider", "state", "verifier", "nonce"} {
+		delete(session.Values, key)
 	}
-	client := s.oauthConfig.Client(r.Context(), token)
-	resp, err := client.Get("https://api.github.com/user")
+	err = session.Save(r, w)
 	if err != nil {
-		http.Error(w, "failed to get user data from GitHub (request)", 500)
+		log.Printf("session save: %s", err)
+		http.Error(w, "session failure", 400)
 		return
 	}
-	if resp.StatusCode != 200 {
-		http.Error(w, "failed to get user data from GitHub (response status code)", 500)
+	provider, ok := s.provider(providerName)
+	if !ok {
+		http.Error(w, "try logging in again", 400)
 		return
 	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		http.Error(w, "failed to get user data from GitHub (response body)", 500)
+	q := r.URL.Query()
+	if q.Get("state") != state {
+		http.Error(w, "state does not match - try logging in again", 400)
 		return
 	}
-	var data githubUserData
-	err = json.Unmarshal(body, &data)
-	if err != nil {
-		http.Error(w, "failed to get user data from GitHub (response json)", 500)
+	if q.Has("error") {
+		http.Error(w, fmt.Sprintf("%s returned an error: %s %s", provider.DisplayName(), q.Get("error"), q.Get("error_description")), 400)
 		return
 	}
-	loginSession.Values["githubUserData"] = data
-	err = session.Save(r, w)
+	identity, err := provider.Identify(r.Context(), q.Get("code"), LoginAttempt{State: state, Verifier: verifier, Nonce: nonce})
 	if err != nil {
-		log.Printf("session save: %s", err)
-		http.Error(w, "session failure", 400)
+		log.Printf("login with %s: %s", provider.Name(), err)
+		http.Error(w, fmt.Sprintf("failed to log in with %s", provider.DisplayName()), 500)
 		return
 	}
+	delete(loginSession.Values, "githubUserData")
+	loginSession.Values["identity"] = identity
 	err = loginSession.Save(r, w)
 	if err != nil {
 		log.Printf("login session save: %s", err)
 		http.Error(w, "session failure", 400)
 		return
 	}
-	http.Error(w, fmt.Sprintf("logged in as %s", data.Login), 200)
+	log.Printf("logged in as %s", identity.Key())
+	http.Redirect(w, r, "/", 303)
 }
 
 func (s *Server) handleLoginSettings(w http.ResponseWriter, r *http.Request) {
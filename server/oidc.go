@@ -0,0 +1,185 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// OIDCConfig configures an OpenID Connect provider.
+type OIDCConfig struct {
+	// Name identifies the provider in URLs and user keys (see Identity.Key).
+	Name string
+	// DisplayName is shown on the login page. It defaults to Name.
+	DisplayName string
+	// Issuer is the issuer URL. The provider is configured using the discovery document under it.
+	Issuer       string
+	ClientID     string
+	ClientSecret string `json:"-"`
+	RedirectURL  string
+	// Scopes are requested in addition to openid.
+	Scopes []string
+	// UserClaim is the ID token claim users are keyed by. It defaults to sub.
+	// Users keyed by email must have email_verified set.
+	UserClaim string
+	// NameClaim is the ID token claim shown as the name of the user. It defaults to name, and falls back to the UserClaim.
+	NameClaim string
+	// HTTPClient is used to talk to the provider. It defaults to http.DefaultClient.
+	HTTPClient *http.Client `json:"-"`
+}
+
+// OIDCProvider logs in with an OpenID Connect provider, using the authorization code flow.
+type OIDCProvider struct {
+	config   OIDCConfig
+	oauth2   *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	timeNow  func() time.Time
+}
+
+// oidcLeeway is how much clock skew with the provider is tolerated when checking when ID tokens were issued.
+const oidcLeeway = time.Minute
+
+// NewOIDCProvider configures a provider from its discovery document.
+func NewOIDCProvider(ctx context.Context, config OIDCConfig) (*OIDCProvider, error) {
+	if config.Name == "" || strings.ContainsAny(config.Name, "/:") {
+		return nil, fmt.Errorf("invalid provider name %q", config.Name)
+	}
+	if config.DisplayName == "" {
+		config.DisplayName = config.Name
+	}
+	if config.UserClaim == "" {
+		config.UserClaim = "sub"
+	}
+	if config.NameClaim == "" {
+		config.NameClaim = "name"
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, config.HTTPClient), config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	scopes := []string{oidc.ScopeOpenID}
+	for _, scope := range config.Scopes {
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	p := &OIDCProvider{
+		config: config,
+		oauth2: &oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  config.RedirectURL,
+			Scopes:       scopes,
+		},
+		timeNow: time.Now,
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: config.ClientID, Now: func() time.Time { return p.timeNow() }})
+	return p, nil
+}
+
+func (p *OIDCProvider) Name() string {
+	return p.config.Name
+}
+
+func (p *OIDCProvider) DisplayName() string {
+	return p.config.DisplayName
+}
+
+func (p *OIDCProvider) AuthCodeURL(attempt LoginAttempt) string {
+	return p.oauth2.AuthCodeURL(attempt.State, oauth2.S256ChallengeOption(attempt.Verifier), oidc.Nonce(attempt.Nonce))
+}
+
+func (p *OIDCProvider) Identify(ctx context.Context, code string, attempt LoginAttempt) (Identity, error) {
+	ctx = oidc.ClientContext(ctx, p.config.HTTPClient)
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(attempt.Verifier))
+	if err != nil {
+		return Identity{}, fmt.Errorf("exchange: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, errors.New("no ID token in token response")
+	}
+	claims, err := p.verifyIDToken(ctx, rawIDToken, attempt.Nonce)
+	if err != nil {
+		return Identity{}, fmt.Errorf("verify ID token: %w", err)
+	}
+	return p.identity(claims)
+}
+
+// identity makes an Identity from the claims of a verified ID token.
+func (p *OIDCProvider) identity(claims map[string]interface{}) (Identity, error) {
+	subject, ok := claimString(claims, p.config.UserClaim)
+	if !ok || subject == "" {
+		return Identity{}, fmt.Errorf("ID token has no %s claim", p.config.UserClaim)
+	}
+	if p.config.UserClaim == "email" && claims["email_verified"] != true {
+		return Identity{}, errors.New("email is not verified")
+	}
+	name, ok := claimString(claims, p.config.NameClaim)
+	if !ok || name == "" {
+		name = subject
+	}
+	avatarURL, _ := claimString(claims, "picture")
+	profileURL, _ := claimString(claims, "profile")
+	return Identity{
+		Provider:   p.config.Name,
+		Subject:    subject,
+		Name:       name,
+		AvatarURL:  avatarURL,
+		ProfileURL: profileURL,
+	}, nil
+}
+
+// claimString returns a string or number claim as a string.
+func claimString(claims map[string]interface{}, name string) (string, bool) {
+	switch v := claims[name].(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	default:
+		return "", false
+	}
+}
+
+// verifyIDToken checks the signature and claims of a compact serialized ID token, and returns its claims.
+// The checks go-oidc leaves to its callers (azp, iat and nonce) are done here.
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, rawIDToken, nonce string) (map[string]interface{}, error) {
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	var raw json.RawMessage
+	if err := idToken.Claims(&raw); err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&claims); err != nil {
+		return nil, fmt.Errorf("claims: %w", err)
+	}
+	if azp, ok := claimString(claims, "azp"); (ok || len(idToken.Audience) > 1) && azp != p.config.ClientID {
+		return nil, errors.New("token is not authorized for this client")
+	}
+	if idToken.IssuedAt.After(p.timeNow().Add(oidcLeeway)) {
+		return nil, errors.New("token issued in the future")
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("nonce does not match")
+	}
+	return claims, nil
+}
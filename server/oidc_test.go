@@ -0,0 +1,295 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"nyiyui.ca/jts/database"
+)
+
+// stubOIDC is an OpenID Connect provider that logs in whoever is in claims without asking.
+type stubOIDC struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	// claims are put in ID tokens, in addition to iss, aud, exp, iat and nonce.
+	claims map[string]interface{}
+	// codes maps codes to the nonce and PKCE challenge of the authorization request.
+	codes map[string][2]string
+}
+
+func newStubOIDC(t *testing.T) *stubOIDC {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &stubOIDC{key: key, claims: map[string]interface{}{}, codes: map[string][2]string{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "stub",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("GET /authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("client_id") != "jts" || q.Get("code_challenge_method") != "S256" || !strings.Contains(q.Get("scope"), "openid") {
+			http.Error(w, "bad authorization request", 400)
+			return
+		}
+		code := fmt.Sprintf("code%d", len(p.codes))
+		p.codes[code] = [2]string{q.Get("nonce"), q.Get("code_challenge")}
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), 302)
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		request, ok := p.codes[r.Form.Get("code")]
+		delete(p.codes, r.Form.Get("code"))
+		verifier := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != request[1] {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     p.sign(t, "RS256", p.idTokenClaims(request[0]), key),
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *stubOIDC) idTokenClaims(nonce string) map[string]interface{} {
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":   p.URL,
+		"aud":   "jts",
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"nonce": nonce,
+	}
+	for k, v := range p.claims {
+		claims[k] = v
+	}
+	return claims
+}
+
+func (p *stubOIDC) sign(t *testing.T, alg string, claims map[string]interface{}, key *rsa.PrivateKey) string {
+	t.Helper()
+	encode := func(v interface{}) string {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	signed := encode(map[string]string{"alg": alg, "kid": "stub", "typ": "JWT"}) + "." + encode(claims)
+	if alg == "none" {
+		return signed + "."
+	}
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (p *stubOIDC) provider(t *testing.T, config OIDCConfig) *OIDCProvider {
+	t.Helper()
+	config.Issuer = p.URL
+	config.ClientID = "jts"
+	provider, err := NewOIDCProvider(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestOIDCLogin(t *testing.T) {
+	stub := newStubOIDC(t)
+	stub.claims["sub"] = "1234"
+	stub.claims["email"] = "alice@example.com"
+	stub.claims["email_verified"] = true
+	stub.claims["preferred_username"] = "alice"
+
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "server.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+	var s *Server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.ServeHTTP(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	corp := stub.provider(t, OIDCConfig{
+		Name:        "corp",
+		DisplayName: "Corp SSO",
+		RedirectURL: ts.URL + "/login/callback",
+		UserClaim:   "email",
+		NameClaim:   "preferred_username",
+	})
+	github := NewGitHubProvider(nil)
+	s, err = New([]Provider{github, corp}, db, nil, map[string]UserInfo{
+		"corp:alice@example.com": {Permissions: []Permission{PermissionViewDatabase}},
+	}, sessions.NewCookieStore([]byte("test")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Jar: jar}
+	get := func(path string) (*http.Response, string) {
+		t.Helper()
+		resp, err := client.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	resp, body := get("/latest")
+	if resp.Request.URL.Path != "/login" || !strings.Contains(body, "Corp SSO") || !strings.Contains(body, "GitHub") {
+		t.Fatalf("expected the login page listing providers, got %s %s", resp.Request.URL, body)
+	}
+	resp, body = get("/login/corp")
+	if resp.StatusCode != 200 || resp.Request.URL.Path != "/latest" {
+		t.Fatalf("expected to be logged in, got %d at %s: %s", resp.StatusCode, resp.Request.URL, body)
+	}
+	if !strings.Contains(body, "alice") {
+		t.Errorf("expected the name from the ID token, got %s", body)
+	}
+	// the login attempt has been used, so replaying the callback fails
+	resp, _ = get("/login/callback?code=code0&state=x")
+	if resp.StatusCode != 400 {
+		t.Errorf("expected replaying the callback to fail, got %d", resp.StatusCode)
+	}
+	if resp, _ := get("/login/unknown"); resp.StatusCode != 404 {
+		t.Errorf("expected 404 for an unknown provider, got %d", resp.StatusCode)
+	}
+
+	// a different user of the same provider is not in users
+	stub.claims["email"] = "bob@example.com"
+	resp, _ = get("/login/corp")
+	if resp.StatusCode != 403 {
+		t.Errorf("expected 403 for an unknown user, got %d", resp.StatusCode)
+	}
+	stub.claims["email_verified"] = false
+	stub.claims["email"] = "alice@example.com"
+	resp, _ = get("/login/corp")
+	if resp.StatusCode != 500 {
+		t.Errorf("expected an unverified email to be rejected, got %d", resp.StatusCode)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	stub := newStubOIDC(t)
+	stub.claims["sub"] = "1234"
+	p := stub.provider(t, OIDCConfig{Name: "corp"})
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid := stub.idTokenClaims("nonce")
+	with := func(k string, v interface{}) map[string]interface{} {
+		claims := stub.idTokenClaims("nonce")
+		claims[k] = v
+		return claims
+	}
+	for _, c := range []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", stub.sign(t, "RS256", valid, stub.key), true},
+		{"azp", stub.sign(t, "RS256", with("azp", "jts"), stub.key), true},
+		{"other key", stub.sign(t, "RS256", valid, otherKey), false},
+		{"alg none", stub.sign(t, "none", valid, stub.key), false},
+		{"alg mismatch", stub.sign(t, "ES256", valid, stub.key), false},
+		{"other issuer", stub.sign(t, "RS256", with("iss", "https://evil.example"), stub.key), false},
+		{"other audience", stub.sign(t, "RS256", with("aud", "other"), stub.key), false},
+		{"multiple audiences without azp", stub.sign(t, "RS256", with("aud", []string{"jts", "other"}), stub.key), false},
+		{"expired", stub.sign(t, "RS256", with("exp", time.Now().Add(-time.Hour).Unix()), stub.key), false},
+		{"issued in the future", stub.sign(t, "RS256", with("iat", time.Now().Add(time.Hour).Unix()), stub.key), false},
+		{"other nonce", stub.sign(t, "RS256", with("nonce", "other"), stub.key), false},
+		{"malformed", "a.b", false},
+	} {
+		claims, err := p.verifyIDToken(context.Background(), c.token, "nonce")
+		if c.ok && err != nil {
+			t.Errorf("%s: %s", c.name, err)
+		}
+		if !c.ok && err == nil {
+			t.Errorf("%s: expected an error, got %v", c.name, claims)
+		}
+	}
+	identity, err := p.identity(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Key() != "corp:1234" || identity.Name != "1234" {
+		t.Errorf("unexpected identity %#v", identity)
+	}
+}
+
+func TestLegacyGitHubSession(t *testing.T) {
+	store := sessions.NewCookieStore([]byte("test"))
+	req := httptest.NewRequest("GET", "/", nil)
+	loginSession, err := store.Get(req, "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginSession.Values["githubUserData"] = githubUserData{Login: "alice", HTMLURL: "https://github.com/alice"}
+	identity, ok := loginIdentity(loginSession)
+	if !ok || identity.Key() != "alice" || identity.ProfileURL != "https://github.com/alice" {
+		t.Errorf("unexpected identity %#v", identity)
+	}
+}
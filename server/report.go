@@ -0,0 +1,108 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"nyiyui.ca/jts/report"
+)
+
+// dateRange parses the from and to query parameters, which are dates in loc (to is inclusive).
+// The returned range is [from, to). By default, it is the last 7 days.
+func dateRange(r *http.Request, loc *time.Location) (from, to time.Time, err error) {
+	q := r.URL.Query()
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	from = today.AddDate(0, 0, -6)
+	to = today.AddDate(0, 0, 1)
+	if q.Get("from") != "" {
+		from, err = time.ParseInLocation("2006-01-02", q.Get("from"), loc)
+		if err != nil {
+			return
+		}
+	}
+	if q.Get("to") != "" {
+		to, err = time.ParseInLocation("2006-01-02", q.Get("to"), loc)
+		if err != nil {
+			return
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	return
+}
+
+// queryLocation returns the time zone named by the tz query parameter, or def if it is not set.
+func queryLocation(r *http.Request, def *time.Location) (*time.Location, error) {
+	tz := r.URL.Query().Get("tz")
+	if tz == "" {
+		return def, nil
+	}
+	return time.LoadLocation(tz)
+}
+
+// reportOptions parses the report options from the query.
+func reportOptions(r *http.Request, loc *time.Location) (report.Options, error) {
+	from, to, err := dateRange(r, loc)
+	if err != nil {
+		return report.Options{}, err
+	}
+	opts := report.Options{
+		From:     from,
+		To:       to,
+		Location: loc,
+		GroupBy:  report.GroupByDay,
+	}
+	if group := r.URL.Query().Get("group"); group != "" {
+		opts.GroupBy = report.GroupBy(group)
+	}
+	return opts, nil
+}
+
+func (s *Server) handleGetReport(w http.ResponseWriter, r *http.Request) {
+	opts, err := reportOptions(r, getTimeLocation(r))
+	if err != nil {
+		http.Error(w, "invalid date", 400)
+		return
+	}
+	if !opts.GroupBy.Valid() {
+		http.Error(w, "invalid grouping", 400)
+		return
+	}
+	rep, err := report.Generate(s.db, opts)
+	if err != nil {
+		log.Printf("report: %s", err)
+		http.Error(w, "failed to generate report", 500)
+		return
+	}
+	s.renderTemplate("report.html", w, r, map[string]interface{}{
+		"Report":   rep,
+		"GroupBys": report.GroupBys,
+		// the form shows the inclusive end date
+		"ToInclusive": opts.To.AddDate(0, 0, -1),
+	})
+}
+
+// handleAPIGetReport returns a report as JSON.
+// The tz query parameter is an IANA time zone name used for day boundaries, and defaults to UTC.
+func (s *Server) handleAPIGetReport(w http.ResponseWriter, r *http.Request) {
+	loc, err := queryLocation(r, time.UTC)
+	if err != nil {
+		http.Error(w, "invalid time zone", 422)
+		return
+	}
+	opts, err := reportOptions(r, loc)
+	if err != nil {
+		http.Error(w, "invalid date", 422)
+		return
+	}
+	if !opts.GroupBy.Valid() {
+		http.Error(w, "invalid grouping", 422)
+		return
+	}
+	rep, err := report.Generate(s.db, opts)
+	if handleDBError(w, err, "report") {
+		return
+	}
+	writeJSON(w, 200, rep)
+}
@@ -0,0 +1,472 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+)
+
+// setupRESTHandlers sets up the JSON API for scripts under /api.
+func (s *Server) setupRESTHandlers() {
+	view := func(h http.Handler) http.Handler { return s.apiAuthz(PermissionViewDatabase)(s.unlessEncrypted(h)) }
+	write := func(h http.Handler) http.Handler { return s.apiAuthz(PermissionWriteDatabase)(s.unlessEncrypted(h)) }
+	s.mux.Handle("GET /api/sessions", view(http.HandlerFunc(s.handleAPIListSessions)))
+	s.mux.Handle("POST /api/sessions", write(s.unlessLocked(s.handleAPICreateSession)))
+	s.mux.Handle("GET /api/sessions/{id}", view(http.HandlerFunc(s.handleAPIGetSession)))
+	s.mux.Handle("PUT /api/sessions/{id}", write(s.unlessLocked(s.handleAPIUpdateSession)))
+	s.mux.Handle("DELETE /api/sessions/{id}", write(s.unlessLocked(s.handleAPIDeleteSession)))
+	s.mux.Handle("POST /api/sessions/{id}/start", write(s.unlessLocked(s.handleAPIStartTimer)))
+	s.mux.Handle("POST /api/sessions/{id}/stop", write(s.unlessLocked(s.handleAPIStopTimer)))
+	s.mux.Handle("POST /api/sessions/{id}/timeframes", write(s.unlessLocked(s.handleAPICreateTimeframe)))
+	s.mux.Handle("PUT /api/sessions/{id}/timeframes/{timeframeID}", write(s.unlessLocked(s.handleAPIUpdateTimeframe)))
+	s.mux.Handle("DELETE /api/sessions/{id}/timeframes/{timeframeID}", write(s.unlessLocked(s.handleAPIDeleteTimeframe)))
+	s.mux.Handle("GET /api/report", view(http.HandlerFunc(s.handleAPIGetReport)))
+	s.mux.Handle("GET /api/export/{format}", view(http.HandlerFunc(s.handleGetExport)))
+	s.mux.Handle("GET /api/tags", view(http.HandlerFunc(s.handleAPIListTags)))
+	s.mux.Handle("GET /api/tasks", view(http.HandlerFunc(s.handleAPIListTasks)))
+	s.mux.Handle("POST /api/tasks", write(s.unlessLocked(s.handleAPICreateTask)))
+	s.mux.Handle("GET /api/tasks/{id}", view(http.HandlerFunc(s.handleAPIGetTask)))
+	s.mux.Handle("PUT /api/tasks/{id}", write(s.unlessLocked(s.handleAPIUpdateTask)))
+	s.mux.Handle("DELETE /api/tasks/{id}", write(s.unlessLocked(s.handleAPIDeleteTask)))
+}
+
+// unlessLocked rejects writes while a client is syncing, as they would be overwritten.
+// No client can lock while the write is handled.
+func (s *Server) unlessLocked(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lockedBy := s.lock.Unlocked(func() {
+			// a client may have set up encryption (removing the plaintext rows) since encryptedNotice or unlessEncrypted checked
+			if s.encrypted() {
+				http.Error(w, "database is end-to-end encrypted", 409)
+				return
+			}
+			next(w, r)
+		})
+		if lockedBy != "" {
+			http.Error(w, "database is locked for syncing by "+lockedBy, 409)
+		}
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("encode response: %s", err)
+	}
+}
+
+// readJSON decodes the request body into v, and writes an error response if it fails.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		http.Error(w, "failed to decode request body: "+err.Error(), 422)
+		return false
+	}
+	return true
+}
+
+// handleDBError writes an error response for err, and reports whether there was an error.
+func handleDBError(w http.ResponseWriter, err error, what string) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, sql.ErrNoRows):
+		http.Error(w, what+" not found", 404)
+	case errors.Is(err, database.ErrTimerRunning), errors.Is(err, database.ErrTimerNotRunning):
+		http.Error(w, err.Error(), 409)
+	case errors.Is(err, database.ErrInvalidTaskStatus):
+		http.Error(w, err.Error(), 422)
+	default:
+		log.Printf("%s: %s", what, err)
+		http.Error(w, "database error", 500)
+	}
+	return true
+}
+
+func (s *Server) validateSession(w http.ResponseWriter, session data.Session) bool {
+	if session.Description == "" {
+		http.Error(w, "description must not be empty", 422)
+		return false
+	}
+	if session.TaskID != nil {
+		_, err := s.db.GetTask(*session.TaskID)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "task not found", 422)
+			return false
+		} else if handleDBError(w, err, "task") {
+			return false
+		}
+	}
+	for _, tf := range session.Timeframes {
+		if !validateTimeframe(w, tf) {
+			return false
+		}
+	}
+	return true
+}
+
+func validateTimeframe(w http.ResponseWriter, tf data.Timeframe) bool {
+	if tf.Start.IsZero() {
+		http.Error(w, "start must be set", 422)
+		return false
+	}
+	if tf.End != nil && tf.End.Before(tf.Start) {
+		http.Error(w, "end must not be before start", 422)
+		return false
+	}
+	return true
+}
+
+// parseTimeQuery parses an RFC 3339 time from the query parameter, or returns def if it is not set.
+func parseTimeQuery(r *http.Request, key string, def time.Time) (time.Time, error) {
+	if !r.URL.Query().Has(key) {
+		return def, nil
+	}
+	return time.Parse(time.RFC3339, r.URL.Query().Get(key))
+}
+
+func (s *Server) handleAPIListSessions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Has("tag") {
+		sessions, err := s.db.GetSessionsByTag(q.Get("tag"))
+		if handleDBError(w, err, "sessions") {
+			return
+		}
+		writeJSON(w, 200, sessions)
+		return
+	}
+	if q.Has("from") || q.Has("to") {
+		from, err := parseTimeQuery(r, "from", time.Time{})
+		if err != nil {
+			http.Error(w, "invalid from", 422)
+			return
+		}
+		to, err := parseTimeQuery(r, "to", time.Now())
+		if err != nil {
+			http.Error(w, "invalid to", 422)
+			return
+		}
+		sessions, err := s.db.GetSessionsBetween(from, to)
+		if handleDBError(w, err, "sessions") {
+			return
+		}
+		writeJSON(w, 200, sessions)
+		return
+	}
+	limit, offset := 100, 0
+	var err error
+	if q.Has("limit") {
+		limit, err = strconv.Atoi(q.Get("limit"))
+		if err != nil || limit < 0 {
+			http.Error(w, "invalid limit", 422)
+			return
+		}
+	}
+	if q.Has("offset") {
+		offset, err = strconv.Atoi(q.Get("offset"))
+		if err != nil || offset < 0 {
+			http.Error(w, "invalid offset", 422)
+			return
+		}
+	}
+	sessions, err := s.db.GetLatestSessions(limit, offset)
+	if handleDBError(w, err, "sessions") {
+		return
+	}
+	writeJSON(w, 200, sessions)
+}
+
+func (s *Server) handleAPICreateSession(w http.ResponseWriter, r *http.Request) {
+	var session data.Session
+	if !readJSON(w, r, &session) || !s.validateSession(w, session) {
+		return
+	}
+	id, err := s.db.AddSession(session)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	session, err = s.db.GetSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	writeJSON(w, 201, session)
+}
+
+func (s *Server) handleAPIGetSession(w http.ResponseWriter, r *http.Request) {
+	session, err := s.db.GetSession(r.PathValue("id"))
+	if handleDBError(w, err, "session") {
+		return
+	}
+	writeJSON(w, 200, session)
+}
+
+// handleAPIUpdateSession edits the fields given in the body, leaving the omitted ones as they are.
+func (s *Server) handleAPIUpdateSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	session, err := s.db.GetSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	session.Tags = nil
+	if !readJSON(w, r, &session) {
+		return
+	}
+	if session.ID != "" && session.ID != id {
+		http.Error(w, "ID does not match path", 422)
+		return
+	}
+	session.ID = id
+	session.Timeframes = nil // timeframes are edited separately
+	if !s.validateSession(w, session) {
+		return
+	}
+	err = s.db.EditSessionProperties(session)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	if session.Tags != nil {
+		// tags are left as is if omitted
+		err = s.db.SetSessionTags(id, session.Tags)
+		if handleDBError(w, err, "tags") {
+			return
+		}
+	}
+	session, err = s.db.GetSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	writeJSON(w, 200, session)
+}
+
+func (s *Server) handleAPIDeleteSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	_, err := s.db.GetSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	err = s.db.DeleteSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (s *Server) handleAPIStartTimer(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	_, err := s.db.GetSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	err = s.db.StartTimer(id)
+	if handleDBError(w, err, "timer") {
+		return
+	}
+	session, err := s.db.GetSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	writeJSON(w, 200, session)
+}
+
+func (s *Server) handleAPIStopTimer(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	_, err := s.db.GetSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	err = s.db.StopTimer(id)
+	if handleDBError(w, err, "timer") {
+		return
+	}
+	session, err := s.db.GetSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	writeJSON(w, 200, session)
+}
+
+// getTimeframe returns the session and the index of the timeframe in it, writing an error response if either is not found.
+func (s *Server) getTimeframe(w http.ResponseWriter, r *http.Request) (data.Session, int, bool) {
+	session, err := s.db.GetSession(r.PathValue("id"))
+	if handleDBError(w, err, "session") {
+		return data.Session{}, 0, false
+	}
+	i := slices.IndexFunc(session.Timeframes, func(tf data.Timeframe) bool {
+		return tf.ID == r.PathValue("timeframeID")
+	})
+	if i == -1 {
+		http.Error(w, "timeframe not found", 404)
+		return data.Session{}, 0, false
+	}
+	return session, i, true
+}
+
+func (s *Server) handleAPICreateTimeframe(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	_, err := s.db.GetSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	var tf data.Timeframe
+	if !readJSON(w, r, &tf) || !validateTimeframe(w, tf) {
+		return
+	}
+	tfID, err := s.db.AddTimeframe(id, tf)
+	if handleDBError(w, err, "timeframe") {
+		return
+	}
+	session, err := s.db.GetSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	i := slices.IndexFunc(session.Timeframes, func(tf data.Timeframe) bool {
+		return tf.ID == tfID
+	})
+	writeJSON(w, 201, session.Timeframes[i])
+}
+
+func (s *Server) handleAPIUpdateTimeframe(w http.ResponseWriter, r *http.Request) {
+	session, i, ok := s.getTimeframe(w, r)
+	if !ok {
+		return
+	}
+	var tf data.Timeframe
+	if !readJSON(w, r, &tf) || !validateTimeframe(w, tf) {
+		return
+	}
+	err := s.db.EditTimeframe(session.ID, session.Timeframes[i].ID, tf)
+	if handleDBError(w, err, "timeframe") {
+		return
+	}
+	session, i, ok = s.getTimeframe(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, 200, session.Timeframes[i])
+}
+
+func (s *Server) handleAPIDeleteTimeframe(w http.ResponseWriter, r *http.Request) {
+	session, i, ok := s.getTimeframe(w, r)
+	if !ok {
+		return
+	}
+	err := s.db.DeleteTimeframe(session.ID, session.Timeframes[i].ID)
+	if handleDBError(w, err, "timeframe") {
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (s *Server) handleAPIListTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := s.db.GetTags()
+	if handleDBError(w, err, "tags") {
+		return
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	writeJSON(w, 200, tags)
+}
+
+func (s *Server) handleAPIListTasks(w http.ResponseWriter, r *http.Request) {
+	tasks, err := s.db.GetTasks()
+	if handleDBError(w, err, "tasks") {
+		return
+	}
+	writeJSON(w, 200, tasks)
+}
+
+func validateTask(w http.ResponseWriter, task data.Task) bool {
+	if task.Description == "" {
+		http.Error(w, "description must not be empty", 422)
+		return false
+	}
+	if task.Status != "" && !task.Status.Valid() {
+		http.Error(w, "invalid status", 422)
+		return false
+	}
+	if task.Estimate != nil && *task.Estimate < 0 {
+		http.Error(w, "estimate must not be negative", 422)
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleAPICreateTask(w http.ResponseWriter, r *http.Request) {
+	var task data.Task
+	if !readJSON(w, r, &task) || !validateTask(w, task) {
+		return
+	}
+	id, err := s.db.AddTask(task)
+	if handleDBError(w, err, "task") {
+		return
+	}
+	task, err = s.db.GetTask(id)
+	if handleDBError(w, err, "task") {
+		return
+	}
+	writeJSON(w, 201, task)
+}
+
+func (s *Server) handleAPIGetTask(w http.ResponseWriter, r *http.Request) {
+	task, err := s.db.GetTask(r.PathValue("id"))
+	if handleDBError(w, err, "task") {
+		return
+	}
+	writeJSON(w, 200, task)
+}
+
+// handleAPIUpdateTask edits the fields given in the body, leaving the omitted ones as they are.
+func (s *Server) handleAPIUpdateTask(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	task, err := s.db.GetTask(id)
+	if handleDBError(w, err, "task") {
+		return
+	}
+	if !readJSON(w, r, &task) {
+		return
+	}
+	if task.ID != "" && task.ID != id {
+		http.Error(w, "ID does not match path", 422)
+		return
+	}
+	task.ID = id
+	if !validateTask(w, task) {
+		return
+	}
+	if task.Status == "" {
+		task.Status = data.TaskStatusOpen
+	}
+	err = s.db.EditTask(task)
+	if handleDBError(w, err, "task") {
+		return
+	}
+	task, err = s.db.GetTask(id)
+	if handleDBError(w, err, "task") {
+		return
+	}
+	writeJSON(w, 200, task)
+}
+
+func (s *Server) handleAPIDeleteTask(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	_, err := s.db.GetTask(id)
+	if handleDBError(w, err, "task") {
+		return
+	}
+	err = s.db.DeleteTask(id)
+	if handleDBError(w, err, "task") {
+		return
+	}
+	w.WriteHeader(204)
+}
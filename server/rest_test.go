@@ -0,0 +1,210 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+	"nyiyui.ca/jts/report"
+	"nyiyui.ca/jts/tokens"
+)
+
+func newTestREST(t *testing.T) (*Server, func(method, path, body string) *http.Response) {
+	t.Helper()
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "server.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+	token, err := tokens.RandomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(nil, db, map[tokens.TokenHash]TokenInfo{
+		token.Hash(): {Name: "script", Permissions: []Permission{PermissionViewDatabase, PermissionWriteDatabase}},
+	}, nil, sessions.NewCookieStore([]byte("test")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(method, path, body string) *http.Response {
+		t.Helper()
+		var r io.Reader
+		if body != "" {
+			r = strings.NewReader(body)
+		}
+		req := httptest.NewRequest(method, path, r)
+		req.Header.Set("X-API-Token", token.String())
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w.Result()
+	}
+	return s, do
+}
+
+func decodeBody[T any](t *testing.T, resp *http.Response) T {
+	t.Helper()
+	var v T
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestREST(t *testing.T) {
+	s, do := newTestREST(t)
+
+	resp := do("POST", "/api/tasks", `{"Description": "jts"}`)
+	if resp.StatusCode != 201 {
+		t.Fatalf("create task: status %d", resp.StatusCode)
+	}
+	task := decodeBody[data.Task](t, resp)
+
+	resp = do("POST", "/api/sessions", `{"Description": "REST API", "TaskID": "`+task.ID+`", "Timeframes": [{"Start": "2025-04-01T10:00:00+09:00", "End": "2025-04-01T11:00:00+09:00"}]}`)
+	if resp.StatusCode != 201 {
+		t.Fatalf("create session: status %d", resp.StatusCode)
+	}
+	session := decodeBody[data.Session](t, resp)
+	if len(session.Timeframes) != 1 || session.TaskID == nil || *session.TaskID != task.ID {
+		t.Fatalf("unexpected session %#v", session)
+	}
+
+	resp = do("PUT", "/api/sessions/"+session.ID+"/timeframes/"+session.Timeframes[0].ID, `{"Start": "2025-04-01T10:00:00+09:00", "End": "2025-04-01T09:00:00+09:00"}`)
+	if resp.StatusCode != 422 {
+		t.Fatalf("end before start: status %d", resp.StatusCode)
+	}
+	resp = do("POST", "/api/sessions", `{"Description": "x", "TaskID": "nonexistent"}`)
+	if resp.StatusCode != 422 {
+		t.Fatalf("unknown task: status %d", resp.StatusCode)
+	}
+	resp = do("GET", "/api/sessions/nonexistent", "")
+	if resp.StatusCode != 404 {
+		t.Fatalf("get unknown session: status %d", resp.StatusCode)
+	}
+
+	resp = do("POST", "/api/sessions/"+session.ID+"/start", "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("start: status %d", resp.StatusCode)
+	}
+	resp = do("POST", "/api/sessions/"+session.ID+"/start", "")
+	if resp.StatusCode != 409 {
+		t.Fatalf("start twice: status %d", resp.StatusCode)
+	}
+
+	resp = do("GET", "/api/sessions?from=2025-04-01T00:00:00%2B09:00&to=2025-04-02T00:00:00%2B09:00", "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("list sessions: status %d", resp.StatusCode)
+	}
+	if sessions := decodeBody[[]data.Session](t, resp); len(sessions) != 1 {
+		t.Fatalf("expected 1 session, got %d", len(sessions))
+	}
+
+	if _, ok := s.lock.TryLock("laptop", "laptop"); !ok {
+		t.Fatal("expected to lock")
+	}
+	resp = do("DELETE", "/api/sessions/"+session.ID, "")
+	if resp.StatusCode != 409 {
+		t.Fatalf("delete while syncing: status %d", resp.StatusCode)
+	}
+	s.lock.Unlock("laptop", "")
+	resp = do("DELETE", "/api/sessions/"+session.ID, "")
+	if resp.StatusCode != 204 {
+		t.Fatalf("delete: status %d", resp.StatusCode)
+	}
+	resp = do("GET", "/api/sessions/"+session.ID, "")
+	if resp.StatusCode != 404 {
+		t.Fatalf("get deleted session: status %d", resp.StatusCode)
+	}
+}
+
+func TestRESTUpdateOmittedFields(t *testing.T) {
+	_, do := newTestREST(t)
+
+	resp := do("POST", "/api/tasks", `{"Description": "jts", "Status": "done"}`)
+	if resp.StatusCode != 201 {
+		t.Fatalf("create task: status %d", resp.StatusCode)
+	}
+	task := decodeBody[data.Task](t, resp)
+	resp = do("PUT", "/api/tasks/"+task.ID, `{"Description": "jts server"}`)
+	if resp.StatusCode != 200 {
+		t.Fatalf("update task: status %d", resp.StatusCode)
+	}
+	if task = decodeBody[data.Task](t, resp); task.Description != "jts server" || task.Status != data.TaskStatusDone {
+		t.Fatalf("expected only the description to change, got %#v", task)
+	}
+
+	resp = do("POST", "/api/sessions", `{"Description": "REST API", "TaskID": "`+task.ID+`", "Tags": ["go"]}`)
+	if resp.StatusCode != 201 {
+		t.Fatalf("create session: status %d", resp.StatusCode)
+	}
+	session := decodeBody[data.Session](t, resp)
+	resp = do("PUT", "/api/sessions/"+session.ID, `{"Notes": "partial updates"}`)
+	if resp.StatusCode != 200 {
+		t.Fatalf("update session: status %d", resp.StatusCode)
+	}
+	session = decodeBody[data.Session](t, resp)
+	if session.Description != "REST API" || session.Notes != "partial updates" || session.TaskID == nil || *session.TaskID != task.ID || !slices.Equal(session.Tags, []string{"go"}) {
+		t.Fatalf("expected only the notes to change, got %#v", session)
+	}
+	resp = do("PUT", "/api/sessions/"+session.ID, `{"TaskID": null}`)
+	if resp.StatusCode != 200 {
+		t.Fatalf("unlink session: status %d", resp.StatusCode)
+	}
+	if session = decodeBody[data.Session](t, resp); session.TaskID != nil {
+		t.Fatalf("expected the session to be unlinked, got %#v", session)
+	}
+}
+
+func TestRESTReport(t *testing.T) {
+	_, do := newTestREST(t)
+	resp := do("POST", "/api/sessions", `{"Description": "report", "Timeframes": [{"Start": "2025-04-01T23:00:00+09:00", "End": "2025-04-02T01:00:00+09:00"}]}`)
+	if resp.StatusCode != 201 {
+		t.Fatalf("create session: status %d", resp.StatusCode)
+	}
+	resp = do("GET", "/api/report?from=2025-04-01&to=2025-04-02&group=day&tz=Asia/Tokyo", "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("report: status %d", resp.StatusCode)
+	}
+	r := decodeBody[report.Report](t, resp)
+	if len(r.Rows) != 2 || r.Rows[0].Duration != time.Hour || r.Rows[1].Duration != time.Hour {
+		t.Fatalf("expected 1 hour on each day, got %#v", r.Rows)
+	}
+	resp = do("GET", "/api/report?group=year", "")
+	if resp.StatusCode != 422 {
+		t.Fatalf("invalid grouping: status %d", resp.StatusCode)
+	}
+}
+
+func TestRESTExport(t *testing.T) {
+	_, do := newTestREST(t)
+	resp := do("POST", "/api/sessions", `{"Description": "export", "Timeframes": [{"Start": "2025-04-01T10:00:00+09:00", "End": "2025-04-01T11:30:00+09:00"}]}`)
+	if resp.StatusCode != 201 {
+		t.Fatalf("create session: status %d", resp.StatusCode)
+	}
+	resp = do("GET", "/api/export/csv?from=2025-04-01&to=2025-04-01&tz=Asia/Tokyo&columns=date,start,hours,session", "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("export: status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "date,start,hours,session\n2025-04-01,10:00,1.50,export\n"; string(body) != expected {
+		t.Fatalf("expected %q, got %q", expected, body)
+	}
+	resp = do("GET", "/api/export/xlsx", "")
+	if resp.StatusCode != 404 {
+		t.Fatalf("unknown format: status %d", resp.StatusCode)
+	}
+}
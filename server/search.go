@@ -0,0 +1,44 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"nyiyui.ca/jts/database"
+)
+
+// handleGetSearch searches sessions with the q query parameter, optionally in a date range (from and to, inclusive).
+func (s *Server) handleGetSearch(w http.ResponseWriter, r *http.Request) {
+	loc := getTimeLocation(r)
+	q := r.URL.Query()
+	var sr database.SearchRange
+	if q.Get("from") != "" {
+		from, err := time.ParseInLocation("2006-01-02", q.Get("from"), loc)
+		if err != nil {
+			http.Error(w, "invalid date", 400)
+			return
+		}
+		sr.From = from
+	}
+	if q.Get("to") != "" {
+		to, err := time.ParseInLocation("2006-01-02", q.Get("to"), loc)
+		if err != nil {
+			http.Error(w, "invalid date", 400)
+			return
+		}
+		sr.To = to.AddDate(0, 0, 1)
+	}
+	results, err := s.db.SearchSessions(q.Get("q"), sr)
+	if err != nil {
+		log.Printf("search: %s", err)
+		http.Error(w, "failed to search", 500)
+		return
+	}
+	s.renderTemplate("search.html", w, r, map[string]interface{}{
+		"Query":   q.Get("q"),
+		"From":    q.Get("from"),
+		"To":      q.Get("to"),
+		"Results": results,
+	})
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"nyiyui.ca/jts/data"
+)
+
+func TestSearch(t *testing.T) {
+	s, do := newTestWeb(t)
+	start := time.Date(2025, 3, 12, 14, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	if _, err := s.db.AddSession(data.Session{Description: "debugging", Notes: "the flaky test <again>", Timeframes: []data.Timeframe{{Start: start, End: &end}}}); err != nil {
+		t.Fatal(err)
+	}
+	resp := do("GET", "/search?q=flaky&from=2025-03-01&to=2025-03-31", "")
+	if resp.StatusCode != 200 {
+		t.Fatalf("search: status %d", resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "<mark>flaky</mark> test &lt;again&gt;") {
+		t.Fatalf("unexpected page:\n%s", body)
+	}
+	resp = do("GET", "/search?q=flaky&from=2025-04-01", "")
+	body, _ = io.ReadAll(resp.Body)
+	if !strings.Contains(string(body), "0 sessions") {
+		t.Fatalf("expected no results after March:\n%s", body)
+	}
+}
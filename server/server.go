@@ -1,37 +1,54 @@
 package server
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
 	"net/http"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	jtssync "nyiyui.ca/jts/database/sync"
 
 	"github.com/google/safehtml/template"
 	"github.com/gorilla/sessions"
-	"golang.org/x/oauth2"
 	"nyiyui.ca/jts/database"
 	"nyiyui.ca/jts/tokens"
 )
 
 type Server struct {
-	mux         *http.ServeMux
-	lock        *serverLock
-	tokens      map[tokens.TokenHash]TokenInfo
-	users       map[string]UserInfo
-	db          *database.Database
-	store       sessions.Store
-	oauthConfig *oauth2.Config
-	tps         map[string]*template.Template
+	mux       *http.ServeMux
+	lock      *serverLock
+	tokens    map[tokens.TokenHash]TokenInfo
+	users     map[string]UserInfo
+	db        *database.Database
+	store     sessions.Store
+	providers []Provider
+	tps       map[string]*template.Template
+	// encryptedCache is set once end-to-end encryption is known to be set up (see encrypted).
+	encryptedCache atomic.Bool
 }
 
-func New(oauthConfig *oauth2.Config, db *database.Database, tokens map[tokens.TokenHash]TokenInfo, users map[string]UserInfo, store sessions.Store) (*Server, error) {
+// New returns a server where users log in with one of the providers.
+// Users are keyed by Identity.Key.
+func New(providers []Provider, db *database.Database, tokens map[tokens.TokenHash]TokenInfo, users map[string]UserInfo, store sessions.Store) (*Server, error) {
+	for i, p := range providers {
+		if slices.ContainsFunc(providers[:i], func(q Provider) bool { return q.Name() == p.Name() }) {
+			return nil, fmt.Errorf("duplicate identity provider %q", p.Name())
+		}
+	}
 	s := &Server{
-		mux:         http.NewServeMux(),
-		lock:        new(serverLock),
-		tokens:      tokens,
-		users:       users,
-		db:          db,
-		store:       store,
-		oauthConfig: oauthConfig,
-		tps:         make(map[string]*template.Template),
+		mux:       http.NewServeMux(),
+		lock:      newServerLock(LockTTL),
+		tokens:    tokens,
+		users:     users,
+		db:        db,
+		store:     store,
+		providers: providers,
+		tps:       make(map[string]*template.Template),
 	}
 	s.setupHandlers()
 	err := s.parseTemplates()
@@ -47,47 +64,174 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func (s *Server) setupHandlers() {
 	s.mux.Handle("POST /lock", s.apiAuthz(PermissionSyncDatabase)(http.HandlerFunc(s.handleLock)))
+	s.mux.Handle("POST /lock/renew", s.apiAuthz(PermissionSyncDatabase)(http.HandlerFunc(s.handleRenewLock)))
 	s.mux.Handle("POST /unlock", s.apiAuthz(PermissionSyncDatabase)(http.HandlerFunc(s.handleUnlock)))
-	s.mux.Handle("GET /database", s.apiAuthz(PermissionSyncDatabase)(http.HandlerFunc(s.handleGetDatabase)))
-	s.mux.Handle("POST /database/changes", s.apiAuthz(PermissionSyncDatabase)(http.HandlerFunc(s.handlePostDatabaseChanges)))
+	s.mux.Handle("GET /database", s.apiAuthz(PermissionSyncDatabase)(s.unlessEncrypted(http.HandlerFunc(s.handleGetDatabase))))
+	s.mux.Handle("GET /database/changes", s.apiAuthz(PermissionSyncDatabase)(s.unlessEncrypted(http.HandlerFunc(s.handleGetDatabaseChanges))))
+	// encryption is checked while holding the lease, so that it cannot be set up in between (see handlePutEncryptionParams)
+	s.mux.Handle("POST /database/changes", s.apiAuthz(PermissionSyncDatabase)(s.withLease(s.unlessEncrypted(http.HandlerFunc(s.handlePostDatabaseChanges)).ServeHTTP)))
+	s.mux.Handle("GET /database/encrypted/params", s.apiAuthz(PermissionSyncDatabase)(http.HandlerFunc(s.handleGetEncryptionParams)))
+	s.mux.Handle("PUT /database/encrypted/params", s.apiAuthz(PermissionSyncDatabase)(s.withLease(s.handlePutEncryptionParams)))
+	s.mux.Handle("GET /database/encrypted/changes", s.apiAuthz(PermissionSyncDatabase)(http.HandlerFunc(s.handleGetEncryptedChanges)))
+	s.mux.Handle("POST /database/encrypted/changes", s.apiAuthz(PermissionSyncDatabase)(s.withLease(s.handlePostEncryptedChanges)))
+	s.setupRESTHandlers()
 
 	s.mux.HandleFunc("GET /login", s.handleLogin)
 	s.mux.HandleFunc("GET /login/callback", s.handleLoginCallback)
-	s.mux.HandleFunc("GET /login/settings", s.handleLoginSettings)
+	s.mux.Handle("GET /login/settings", s.userAuthz()(http.HandlerFunc(s.handleLoginSettings)))
+	s.mux.Handle("POST /login/settings", s.userAuthz()(http.HandlerFunc(s.handleLoginSettings)))
+	s.mux.HandleFunc("GET /login/{provider}", s.handleLoginProvider)
 
-	s.mux.Handle("GET /session/{id}", s.userAuthz(PermissionViewDatabase)(http.HandlerFunc(s.handleGetSession)))
+	// pages with the contents of the database show a notice instead once encryption is set up
+	view := func(h http.Handler) http.Handler { return s.userAuthz(PermissionViewDatabase)(s.encryptedNotice(h)) }
+	write := func(h http.Handler) http.Handler { return s.userAuthz(PermissionWriteDatabase)(s.encryptedNotice(h)) }
+	s.mux.Handle("GET /{$}", http.RedirectHandler("/latest", 303))
+	s.mux.Handle("GET /latest", view(http.HandlerFunc(s.handleGetLatest)))
+	s.mux.Handle("GET /session/new", view(http.HandlerFunc(s.handleGetNewSession)))
+	s.mux.Handle("POST /session/new", write(s.unlessLocked(s.handlePostNewSession)))
+	s.mux.Handle("GET /session/{id}", view(http.HandlerFunc(s.handleGetSession)))
+	s.mux.Handle("GET /session/{id}/edit", view(http.HandlerFunc(s.handleGetEditSession)))
+	s.mux.Handle("POST /session/{id}/edit", write(s.unlessLocked(s.handlePostEditSession)))
+	s.mux.Handle("POST /session/{id}/delete", write(s.unlessLocked(s.handlePostDeleteSession)))
+	s.mux.Handle("POST /session/{id}/start", write(s.unlessLocked(s.handlePostStartSession)))
+	s.mux.Handle("POST /session/{id}/stop", write(s.unlessLocked(s.handlePostStopSession)))
+	s.mux.Handle("GET /session/{id}/timeframes/new", view(http.HandlerFunc(s.handleGetNewTimeframe)))
+	s.mux.Handle("POST /session/{id}/timeframes/new", write(s.unlessLocked(s.handlePostNewTimeframe)))
+	s.mux.Handle("GET /session/{id}/timeframes/{timeframeID}/edit", view(http.HandlerFunc(s.handleGetEditTimeframe)))
+	s.mux.Handle("POST /session/{id}/timeframes/{timeframeID}/edit", write(s.unlessLocked(s.handlePostEditTimeframe)))
+	s.mux.Handle("POST /session/{id}/timeframes/{timeframeID}/delete", write(s.unlessLocked(s.handlePostDeleteTimeframe)))
+	s.mux.Handle("GET /tasks", view(http.HandlerFunc(s.handleGetTasks)))
+	s.mux.Handle("GET /task/new", view(http.HandlerFunc(s.handleGetNewTask)))
+	s.mux.Handle("POST /task/new", write(s.unlessLocked(s.handlePostNewTask)))
+	s.mux.Handle("GET /task/{id}", view(http.HandlerFunc(s.handleGetTask)))
+	s.mux.Handle("GET /task/{id}/edit", view(http.HandlerFunc(s.handleGetEditTask)))
+	s.mux.Handle("POST /task/{id}/edit", write(s.unlessLocked(s.handlePostEditTask)))
+	s.mux.Handle("POST /task/{id}/status", write(s.unlessLocked(s.handlePostTaskStatus)))
+	s.mux.Handle("POST /task/{id}/delete", write(s.unlessLocked(s.handlePostDeleteTask)))
+	s.mux.Handle("GET /timeline", view(http.HandlerFunc(s.handleGetTimeline)))
+	s.mux.Handle("GET /report", view(http.HandlerFunc(s.handleGetReport)))
+	s.mux.Handle("GET /search", view(http.HandlerFunc(s.handleGetSearch)))
+	s.mux.Handle("GET /check", view(http.HandlerFunc(s.handleGetCheck)))
+	s.mux.Handle("POST /check/fix", write(s.unlessLocked(s.handlePostCheckFix)))
+	admin := s.userAuthz(PermissionAdmin)
+	s.mux.Handle("GET /admin/tokens", admin(http.HandlerFunc(s.handleGetTokens)))
+	s.mux.Handle("POST /admin/tokens/new", admin(http.HandlerFunc(s.handlePostNewToken)))
+	s.mux.Handle("POST /admin/tokens/{id}/revoke", admin(http.HandlerFunc(s.handlePostRevokeToken)))
+	s.mux.Handle("GET /export/{format}", view(http.HandlerFunc(s.handleGetExport)))
 }
 
+// LockTTL is how long a lock lease lasts unless renewed.
+const LockTTL = 30 * time.Second
+
+// serverLock is held by the token identified by holder (see TokenInfo.Hash).
+// lockedBy is only its name, which other tokens can have too.
 type serverLock struct {
 	mutex    sync.Mutex
+	ttl      time.Duration
 	locked   bool
+	holder   string
 	lockedBy string
+	lease    jtssync.Lease
+	timeNow  func() time.Time
+}
+
+func newServerLock(ttl time.Duration) *serverLock {
+	return &serverLock{ttl: ttl, timeNow: time.Now}
+}
+
+// expire releases the lock if its lease has expired. sl.mutex must be held.
+func (sl *serverLock) expire() {
+	if sl.locked && !sl.timeNow().Before(sl.lease.ExpiresAt) {
+		log.Printf("lock held by %s expired", sl.lockedBy)
+		sl.locked = false
+		sl.holder = ""
+		sl.lockedBy = ""
+		sl.lease = jtssync.Lease{}
+	}
 }
 
-func (sl *serverLock) TryLock(lockedBy string) (ok bool) {
+// TryLock takes the lock for holder, whose name is lockedBy, unless it is held.
+func (sl *serverLock) TryLock(holder, lockedBy string) (lease jtssync.Lease, ok bool) {
 	sl.mutex.Lock()
 	defer sl.mutex.Unlock()
+	sl.expire()
 	if !sl.locked {
 		sl.locked = true
+		sl.holder = holder
 		sl.lockedBy = lockedBy
+		sl.lease = jtssync.Lease{ID: newLeaseID(), ExpiresAt: sl.timeNow().Add(sl.ttl)}
+		lease = sl.lease
 		ok = true
 	}
 	return
 }
 
-func (sl *serverLock) Unlock(mustBeLockedBy string) (ok bool) {
+func newLeaseID() string {
+	raw := make([]byte, 16)
+	_, err := rand.Read(raw)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(raw)
+}
+
+// Renew extends the lease, if it is still held.
+func (sl *serverLock) Renew(mustBeHeldBy, leaseID string) (lease jtssync.Lease, ok bool) {
 	sl.mutex.Lock()
 	defer sl.mutex.Unlock()
-	if sl.lockedBy == mustBeLockedBy {
+	sl.expire()
+	if sl.locked && sl.holder == mustBeHeldBy && sl.lease.ID == leaseID {
+		sl.lease.ExpiresAt = sl.timeNow().Add(sl.ttl)
+		lease = sl.lease
+		ok = true
+	}
+	return
+}
+
+// Unlock releases the lock. If leaseID is empty, only the holder is checked.
+func (sl *serverLock) Unlock(mustBeHeldBy, leaseID string) (ok bool) {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+	sl.expire()
+	if sl.locked && sl.holder == mustBeHeldBy && (leaseID == "" || sl.lease.ID == leaseID) {
 		sl.locked = false
+		sl.holder = ""
 		sl.lockedBy = ""
+		sl.lease = jtssync.Lease{}
 		ok = true
 	}
 	return
 }
 
+// Holding calls fn if the lease is held, and reports whether it was.
+// The lease does not expire and is not released while fn runs.
+func (sl *serverLock) Holding(mustBeHeldBy, leaseID string, fn func()) (ok bool) {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+	sl.expire()
+	if !sl.locked || sl.holder != mustBeHeldBy || sl.lease.ID != leaseID {
+		return false
+	}
+	fn()
+	return true
+}
+
+// Unlocked calls fn if the lock is not held, and returns who holds it otherwise.
+// The lock cannot be taken while fn runs.
+func (sl *serverLock) Unlocked(fn func()) (lockedBy string) {
+	sl.mutex.Lock()
+	defer sl.mutex.Unlock()
+	sl.expire()
+	if sl.locked {
+		return sl.lockedBy
+	}
+	fn()
+	return ""
+}
+
 func (sl *serverLock) LockedBy() string {
 	sl.mutex.Lock()
 	defer sl.mutex.Unlock()
+	sl.expire()
 	return sl.lockedBy
 }
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"nyiyui.ca/jts/database"
+)
+
+// newTestWeb returns a server, and a function to make requests to it logged in as a user who can view and edit the database.
+func newTestWeb(t *testing.T) (*Server, func(method, path, body string) *http.Response) {
+	t.Helper()
+	return newTestWebIn(t, "")
+}
+
+// newTestWebIn is like newTestWeb, with the user's timezone set to tz unless it is empty.
+func newTestWebIn(t *testing.T, tz string) (*Server, func(method, path, body string) *http.Response) {
+	t.Helper()
+	db, err := database.NewDatabase(filepath.Join(t.TempDir(), "server.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+	store := sessions.NewCookieStore([]byte("test"))
+	s, err := New(nil, db, nil, map[string]UserInfo{
+		"alice": {Permissions: []Permission{PermissionViewDatabase, PermissionWriteDatabase, PermissionAdmin}},
+	}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	loginSession, err := store.Get(req, "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginSession.Values["identity"] = Identity{Provider: GitHubProviderName, Subject: "alice", Name: "alice"}
+	if tz != "" {
+		loginSession.Values["timezone"] = tz
+	}
+	w := httptest.NewRecorder()
+	if err := loginSession.Save(req, w); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	do := func(method, path, body string) *http.Response {
+		t.Helper()
+		var r io.Reader
+		if body != "" {
+			r = strings.NewReader(body)
+		}
+		req := httptest.NewRequest(method, path, r)
+		if method == "POST" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w.Result()
+	}
+	return s, do
+}
+
+func TestServerLockUnlocked(t *testing.T) {
+	sl := newServerLock(30 * time.Second)
+	locked := make(chan struct{})
+	lockedBy := sl.Unlocked(func() {
+		go func() {
+			sl.TryLock("laptop", "laptop")
+			close(locked)
+		}()
+		select {
+		case <-locked:
+			t.Error("expected the lock not to be taken during a write")
+		case <-time.After(10 * time.Millisecond):
+		}
+	})
+	if lockedBy != "" {
+		t.Fatalf("expected the write to be allowed, got locked by %q", lockedBy)
+	}
+	<-locked
+	if lockedBy := sl.Unlocked(func() { t.Error("expected no write while locked") }); lockedBy != "laptop" {
+		t.Fatalf("expected to be locked by laptop, got %q", lockedBy)
+	}
+}
+
+func TestServerLockLease(t *testing.T) {
+	now := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)
+	sl := newServerLock(30 * time.Second)
+	sl.timeNow = func() time.Time { return now }
+
+	lease, ok := sl.TryLock("laptop", "laptop")
+	if !ok {
+		t.Fatal("expected to lock")
+	}
+	if _, ok := sl.TryLock("desktop", "desktop"); ok {
+		t.Fatal("expected lock to be held")
+	}
+	now = now.Add(20 * time.Second)
+	if _, ok := sl.Renew("laptop", "wrong"); ok {
+		t.Fatal("expected renew with wrong lease ID to fail")
+	}
+	lease, ok = sl.Renew("laptop", lease.ID)
+	if !ok {
+		t.Fatal("expected renew to succeed")
+	}
+	now = now.Add(20 * time.Second)
+	if _, ok := sl.TryLock("desktop", "desktop"); ok {
+		t.Fatal("expected renewed lock to be held")
+	}
+	called := false
+	if ok := sl.Holding("laptop", lease.ID, func() { called = true }); !ok || !called {
+		t.Fatal("expected the holder to be able to upload")
+	}
+	if ok := sl.Holding("desktop", lease.ID, func() { t.Fatal("expected fn not to be called") }); ok {
+		t.Fatal("expected a client not holding the lease to be rejected")
+	}
+
+	// the laptop crashed, so the lease expires
+	now = now.Add(20 * time.Second)
+	if _, ok := sl.TryLock("desktop", "desktop"); !ok {
+		t.Fatal("expected expired lock to be released")
+	}
+	if ok := sl.Unlock("laptop", lease.ID); ok {
+		t.Fatal("expected unlock of expired lease to fail")
+	}
+	if ok := sl.Holding("laptop", lease.ID, func() { t.Fatal("expected fn not to be called") }); ok {
+		t.Fatal("expected upload with expired lease to be rejected")
+	}
+	if sl.LockedBy() != "desktop" {
+		t.Fatalf("expected lock to be held by desktop, got %q", sl.LockedBy())
+	}
+}
+
+func TestServerLockSameName(t *testing.T) {
+	sl := newServerLock(30 * time.Second)
+	lease, ok := sl.TryLock("hash-a", "laptop")
+	if !ok {
+		t.Fatal("expected to lock")
+	}
+	// another token with the same name
+	if _, ok := sl.Renew("hash-b", lease.ID); ok {
+		t.Fatal("expected renew by another token to fail")
+	}
+	if ok := sl.Holding("hash-b", lease.ID, func() { t.Fatal("expected fn not to be called") }); ok {
+		t.Fatal("expected another token to be rejected")
+	}
+	if ok := sl.Unlock("hash-b", ""); ok {
+		t.Fatal("expected unlock by another token to fail")
+	}
+	if sl.LockedBy() != "laptop" {
+		t.Fatalf("expected lock to be held by laptop, got %q", sl.LockedBy())
+	}
+	if ok := sl.Unlock("hash-a", lease.ID); !ok {
+		t.Fatal("expected the holder to unlock")
+	}
+}
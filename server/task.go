@@ -0,0 +1,159 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"nyiyui.ca/jts/data"
+)
+
+func (s *Server) handleGetTasks(w http.ResponseWriter, r *http.Request) {
+	open, err := s.db.GetUndoneTasks()
+	if handleDBError(w, err, "tasks") {
+		return
+	}
+	tasks, err := s.db.GetTasks()
+	if handleDBError(w, err, "tasks") {
+		return
+	}
+	var closed []data.Task
+	for _, task := range tasks {
+		if task.Status != data.TaskStatusOpen {
+			closed = append(closed, task)
+		}
+	}
+	s.renderTemplate("tasks.html", w, r, map[string]interface{}{
+		"Open":   open,
+		"Closed": closed,
+	})
+}
+
+func (s *Server) handleGetTask(w http.ResponseWriter, r *http.Request) {
+	task, err := s.db.GetTask(r.PathValue("id"))
+	if handleDBError(w, err, "task") {
+		return
+	}
+	sessions, err := s.db.GetSessionsByTask(task.ID)
+	if handleDBError(w, err, "sessions") {
+		return
+	}
+	var total time.Duration
+	summaries := make([]sessionSummary, len(sessions))
+	for i, session := range sessions {
+		summaries[i] = summarizeSession(session)
+		total += summaries[i].Total
+	}
+	s.renderTemplate("task.html", w, r, map[string]interface{}{
+		"Task":     task,
+		"Sessions": summaries,
+		"Total":    total,
+		"Statuses": []data.TaskStatus{data.TaskStatusOpen, data.TaskStatusDone, data.TaskStatusArchived},
+	})
+}
+
+// parseTaskForm returns the task submitted by task-form.html, in the user's timezone.
+func parseTaskForm(r *http.Request) (data.Task, error) {
+	task := data.Task{
+		Description: strings.TrimSpace(r.FormValue("description")),
+		Status:      data.TaskStatus(r.FormValue("status")),
+	}
+	if r.FormValue("due") != "" {
+		due, err := time.ParseInLocation(datetimeLocalLayout, r.FormValue("due"), getTimeLocation(r))
+		if err != nil {
+			return data.Task{}, errors.New("invalid due")
+		}
+		task.Due = &due
+	}
+	if r.FormValue("estimate") != "" {
+		estimate, err := time.ParseDuration(r.FormValue("estimate"))
+		if err != nil {
+			return data.Task{}, errors.New("invalid estimate; use e.g. 1h30m")
+		}
+		task.Estimate = &estimate
+	}
+	return task, nil
+}
+
+func (s *Server) renderTaskForm(w http.ResponseWriter, r *http.Request, task data.Task) {
+	s.renderTemplate("task-form.html", w, r, map[string]interface{}{
+		"Task":     task,
+		"Statuses": []data.TaskStatus{data.TaskStatusOpen, data.TaskStatusDone, data.TaskStatusArchived},
+	})
+}
+
+func (s *Server) handleGetNewTask(w http.ResponseWriter, r *http.Request) {
+	s.renderTaskForm(w, r, data.Task{Status: data.TaskStatusOpen})
+}
+
+func (s *Server) handlePostNewTask(w http.ResponseWriter, r *http.Request) {
+	task, err := parseTaskForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+	if !validateTask(w, task) {
+		return
+	}
+	id, err := s.db.AddTask(task)
+	if handleDBError(w, err, "task") {
+		return
+	}
+	http.Redirect(w, r, "/task/"+id, 303)
+}
+
+func (s *Server) handleGetEditTask(w http.ResponseWriter, r *http.Request) {
+	task, err := s.db.GetTask(r.PathValue("id"))
+	if handleDBError(w, err, "task") {
+		return
+	}
+	s.renderTaskForm(w, r, task)
+}
+
+func (s *Server) handlePostEditTask(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	_, err := s.db.GetTask(id)
+	if handleDBError(w, err, "task") {
+		return
+	}
+	task, err := parseTaskForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+	task.ID = id
+	if !validateTask(w, task) {
+		return
+	}
+	if task.Status == "" {
+		task.Status = data.TaskStatusOpen
+	}
+	err = s.db.EditTask(task)
+	if handleDBError(w, err, "task") {
+		return
+	}
+	http.Redirect(w, r, "/task/"+id, 303)
+}
+
+func (s *Server) handlePostTaskStatus(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	err := s.db.SetTaskStatus(id, data.TaskStatus(r.FormValue("status")))
+	if handleDBError(w, err, "task") {
+		return
+	}
+	http.Redirect(w, r, "/task/"+id, 303)
+}
+
+func (s *Server) handlePostDeleteTask(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	_, err := s.db.GetTask(id)
+	if handleDBError(w, err, "task") {
+		return
+	}
+	err = s.db.DeleteTask(id)
+	if handleDBError(w, err, "task") {
+		return
+	}
+	http.Redirect(w, r, "/tasks", 303)
+}
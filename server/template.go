@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"path/filepath"
 	"runtime/debug"
+	"slices"
 	"time"
 
 	"github.com/Masterminds/sprig/v3"
@@ -18,6 +19,7 @@ import (
 	"github.com/google/safehtml/uncheckedconversions"
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/extension"
+	"nyiyui.ca/jts/database"
 )
 
 var buildInfo debug.BuildInfo
@@ -42,12 +44,14 @@ func (s *Server) renderTemplate(path stringConstant, w http.ResponseWriter, r *h
 	t, ok := s.tps[string(path)]
 	if !ok {
 		panic("template not found")
-		return
 	}
 	if data == nil {
 		data = map[string]interface{}{}
 	}
-	data["login"], _ = r.Context().Value(LoginUserDataKey).(githubUserData)
+	if identity, ok := r.Context().Value(LoginUserDataKey).(Identity); ok {
+		data["login"] = identity
+		data["admin"] = slices.Contains(s.users[identity.Key()].Permissions, PermissionAdmin)
+	}
 	data["tzloc"] = getTimeLocation(r)
 	err := t.Execute(w, data)
 	if err != nil {
@@ -86,6 +90,7 @@ func (s *Server) parseTemplate(basename string) (*template.Template, error) {
 				}
 				return uncheckedconversions.HTMLFromStringKnownToSatisfyTypeContract(buf.String()), nil
 			},
+			"splitHighlights": database.SplitHighlights,
 			"formatDayLong": func(loc *time.Location, t time.Time) string {
 				return t.In(loc).Format("2006-01-02 Mon")
 			},
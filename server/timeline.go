@@ -0,0 +1,161 @@
+package server
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/safehtml"
+	"github.com/google/safehtml/uncheckedconversions"
+	"nyiyui.ca/jts/timeline"
+)
+
+// Dimensions of the timeline SVG, in pixels.
+const (
+	timelineWidth      = 900
+	timelineLabelWidth = 110
+	timelineHeader     = 20
+	timelineLane       = 18
+	timelineRowPadding = 4
+)
+
+func (s *Server) handleGetTimeline(w http.ResponseWriter, r *http.Request) {
+	loc := getTimeLocation(r)
+	q := r.URL.Query()
+	opts := timeline.Options{
+		Span:     timeline.SpanWeek,
+		Date:     time.Now().In(loc),
+		Location: loc,
+		ColorBy:  timeline.ColorBySession,
+	}
+	if q.Get("span") != "" {
+		opts.Span = timeline.Span(q.Get("span"))
+	}
+	if q.Get("color") != "" {
+		opts.ColorBy = timeline.ColorBy(q.Get("color"))
+	}
+	if q.Get("date") != "" {
+		date, err := time.ParseInLocation("2006-01-02", q.Get("date"), loc)
+		if err != nil {
+			http.Error(w, "invalid date", 400)
+			return
+		}
+		opts.Date = date
+	}
+	if !opts.Span.Valid() || !opts.ColorBy.Valid() {
+		http.Error(w, "invalid span or color", 400)
+		return
+	}
+	tl, err := timeline.Generate(s.db, opts)
+	if err != nil {
+		log.Printf("timeline: %s", err)
+		http.Error(w, "failed to generate timeline", 500)
+		return
+	}
+	link := func(date time.Time) string {
+		return "/timeline?" + url.Values{
+			"span":  {string(opts.Span)},
+			"date":  {date.Format("2006-01-02")},
+			"color": {string(opts.ColorBy)},
+		}.Encode()
+	}
+	s.renderTemplate("timeline.html", w, r, map[string]interface{}{
+		"Timeline": tl,
+		"Date":     opts.Date.Format("2006-01-02"),
+		"Spans":    timeline.Spans,
+		"ColorBys": timeline.ColorBys,
+		"Prev":     link(timeline.Step(opts.Span, opts.Date, -1)),
+		"Next":     link(timeline.Step(opts.Span, opts.Date, 1)),
+		"SVG":      timelineSVG(tl, loc, time.Now()),
+		"Legend":   timelineLegend(tl),
+	})
+}
+
+// timelineSVG draws the timeline, one row per day, with each block linking to its session.
+// It is generated here instead of in the template, as safehtml does not allow actions in SVG attributes.
+func timelineSVG(tl timeline.Timeline, loc *time.Location, now time.Time) safehtml.HTML {
+	// hours between labels, so that they do not overlap
+	labelEvery := 3
+	if tl.Span == timeline.SpanMonth {
+		labelEvery = 6
+	}
+	barWidth := float64(timelineWidth - timelineLabelWidth)
+	x := func(offset float64) float64 {
+		return timelineLabelWidth + offset*barWidth
+	}
+	height := timelineHeader
+	for _, day := range tl.Days {
+		height += day.Lanes*timelineLane + 2*timelineRowPadding
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg class="timeline" xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="11">`, timelineWidth, height)
+	for hour := 0; hour <= 24; hour++ {
+		hx := x(float64(hour) / 24)
+		if hour%labelEvery == 0 {
+			fmt.Fprintf(&b, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="#aaa" />`, hx, timelineHeader-4, hx, height)
+			if hour < 24 {
+				fmt.Fprintf(&b, `<text x="%.1f" y="%d">%02d:00</text>`, hx+2, timelineHeader-6, hour)
+			}
+		} else if tl.Span != timeline.SpanMonth {
+			fmt.Fprintf(&b, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="#eee" />`, hx, timelineHeader, hx, height)
+		}
+	}
+	y := timelineHeader
+	for _, day := range tl.Days {
+		rowHeight := day.Lanes*timelineLane + 2*timelineRowPadding
+		fmt.Fprintf(&b, `<line x1="0" y1="%d" x2="%d" y2="%d" stroke="#ccc" />`, y, timelineWidth, y)
+		fmt.Fprintf(&b, `<text x="4" y="%d">%s<title>%s</title></text>`, y+timelineRowPadding+timelineLane-5, day.Start.Format("01-02 Mon"), html.EscapeString(day.Total.Round(time.Minute).String()))
+		for _, block := range day.Blocks {
+			bx, bx2 := x(day.Offset(block.Start)), x(day.Offset(block.End))
+			by := y + timelineRowPadding + block.Lane*timelineLane
+			end := "running"
+			if block.Timeframe.End != nil {
+				end = block.Timeframe.End.In(loc).Format("01-02 15:04")
+			}
+			title := fmt.Sprintf("%s %s - %s", block.Label, block.Timeframe.Start.In(loc).Format("01-02 15:04"), end)
+			fmt.Fprintf(&b, `<a href="/session/%s">`, html.EscapeString(url.PathEscape(block.Timeframe.SessionID)))
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%d" width="%.1f" height="%d" rx="2" fill="%s"><title>%s</title></rect>`, bx, by+1, max(bx2-bx, 1), timelineLane-2, timeline.ColorHex(block.Key), html.EscapeString(title))
+			// only label blocks wide enough for a few characters, assuming they are about 6 pixels wide
+			if chars := int((bx2 - bx - 4) / 6); chars >= 4 {
+				label := []rune(block.Label)
+				if len(label) > chars {
+					label = append(label[:chars-1], '…')
+				}
+				fmt.Fprintf(&b, `<text x="%.1f" y="%d" pointer-events="none">%s</text>`, bx+2, by+timelineLane-5, html.EscapeString(string(label)))
+			}
+			b.WriteString(`</a>`)
+		}
+		if !now.Before(day.Start) && now.Before(day.End) {
+			nx := x(day.Offset(now))
+			fmt.Fprintf(&b, `<line x1="%.1f" y1="%d" x2="%.1f" y2="%d" stroke="red" />`, nx, y, nx, y+rowHeight)
+		}
+		y += rowHeight
+	}
+	b.WriteString(`</svg>`)
+	return uncheckedconversions.HTMLFromStringKnownToSatisfyTypeContract(b.String())
+}
+
+// timelineLegend lists the sessions or tasks in the timeline with their colors.
+func timelineLegend(tl timeline.Timeline) safehtml.HTML {
+	var b strings.Builder
+	b.WriteString(`<ul class="legend">`)
+	for _, entry := range tl.Legend {
+		b.WriteString(`<li>`)
+		fmt.Fprintf(&b, `<svg width="12" height="12"><rect width="12" height="12" fill="%s" /></svg> `, timeline.ColorHex(entry.Key))
+		switch {
+		case entry.Key == "":
+			b.WriteString(`(no task)`)
+		case tl.ColorBy == timeline.ColorByTask:
+			fmt.Fprintf(&b, `<a href="/task/%s">%s</a>`, html.EscapeString(url.PathEscape(entry.Key)), html.EscapeString(entry.Label))
+		default:
+			fmt.Fprintf(&b, `<a href="/session/%s">%s</a>`, html.EscapeString(url.PathEscape(entry.Key)), html.EscapeString(entry.Label))
+		}
+		fmt.Fprintf(&b, ` %s</li>`, html.EscapeString(entry.Duration.Round(time.Minute).String()))
+	}
+	b.WriteString(`</ul>`)
+	return uncheckedconversions.HTMLFromStringKnownToSatisfyTypeContract(b.String())
+}
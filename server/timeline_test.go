@@ -0,0 +1,43 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"nyiyui.ca/jts/data"
+)
+
+func TestTimeline(t *testing.T) {
+	s, do := newTestWebIn(t, "Asia/Tokyo")
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2025, 3, 12, 9, 0, 0, 0, tokyo)
+	end := start.Add(90 * time.Minute)
+	id, err := s.db.AddSession(data.Session{Description: "design <review>", Timeframes: []data.Timeframe{{Start: start, End: &end}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := readPage(t, do("GET", "/timeline?span=week&date=2025-03-12&color=session", ""))
+	for _, want := range []string{
+		`<a href="/session/` + id + `">`,
+		"design &lt;review&gt; 03-12 09:00 - 03-12 10:30",
+		"03-10 Mon",
+		"03-16 Sun",
+		"date=2025-03-05",
+		"date=2025-03-19",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("timeline does not contain %q", want)
+		}
+	}
+	// the block starts 9/24 of the way through the day
+	if !strings.Contains(page, `<rect x="406.2"`) {
+		t.Errorf("unexpected block position:\n%s", page)
+	}
+	if resp := do("GET", "/timeline?span=year", ""); resp.StatusCode != 400 {
+		t.Errorf("invalid span: status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"nyiyui.ca/jts/database"
+	"nyiyui.ca/jts/tokens"
+)
+
+// tokenTouchInterval is how often the last-used time of a token is updated at most, so that every request does not write to the database.
+const tokenTouchInterval = time.Minute
+
+// lookupToken returns the token from the tokens file, or a valid token issued by the server.
+// Issued tokens are looked up on every request, so revoking one takes effect immediately.
+func (s *Server) lookupToken(token tokens.Token) (TokenInfo, bool) {
+	if tokenInfo, ok := s.tokens[token.Hash()]; ok {
+		return tokenInfo, true
+	}
+	apiToken, err := s.db.GetAPITokenByHash(token.Hash().String())
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("get api token: %s", err)
+		}
+		return TokenInfo{}, false
+	}
+	now := time.Now()
+	if !apiToken.Valid(now) {
+		return TokenInfo{}, false
+	}
+	if apiToken.LastUsedAt == nil || now.Sub(*apiToken.LastUsedAt) >= tokenTouchInterval {
+		if err := s.db.TouchAPIToken(apiToken.ID, now); err != nil {
+			log.Printf("touch api token %s: %s", apiToken.ID, err)
+		}
+	}
+	tokenInfo := TokenInfo{Name: apiToken.Name}
+	for _, p := range apiToken.Permissions {
+		tokenInfo.Permissions = append(tokenInfo.Permissions, Permission(p))
+	}
+	return tokenInfo, true
+}
+
+// IssueToken generates a token, and stores its hash in db. The token is only returned here, and cannot be shown again.
+func IssueToken(db *database.Database, name string, permissions []Permission, expiresAt *time.Time) (tokens.Token, database.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return tokens.Token{}, database.APIToken{}, errors.New("name must not be empty")
+	}
+	if len(permissions) == 0 {
+		return tokens.Token{}, database.APIToken{}, errors.New("token must have at least one permission")
+	}
+	apiToken := database.APIToken{Name: name, CreatedAt: time.Now(), ExpiresAt: expiresAt}
+	for _, p := range permissions {
+		if !slices.Contains(Permissions, p) {
+			return tokens.Token{}, database.APIToken{}, fmt.Errorf("unknown permission %q", p)
+		}
+		if !slices.Contains(apiToken.Permissions, string(p)) {
+			apiToken.Permissions = append(apiToken.Permissions, string(p))
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(apiToken.CreatedAt) {
+		return tokens.Token{}, database.APIToken{}, errors.New("expiry must be in the future")
+	}
+	token, err := tokens.RandomToken()
+	if err != nil {
+		return tokens.Token{}, database.APIToken{}, err
+	}
+	apiToken.Hash = token.Hash().String()
+	apiToken.ID, err = db.AddAPIToken(apiToken)
+	if err != nil {
+		return tokens.Token{}, database.APIToken{}, fmt.Errorf("add token: %w", err)
+	}
+	return token, apiToken, nil
+}
+
+func (s *Server) handleGetTokens(w http.ResponseWriter, r *http.Request) {
+	s.renderTokens(w, r, nil)
+}
+
+// renderTokens renders the list of tokens. issued is the token that was just issued, if any, to show to the user once.
+func (s *Server) renderTokens(w http.ResponseWriter, r *http.Request, issued *tokens.Token) {
+	apiTokens, err := s.db.GetAPITokens()
+	if handleDBError(w, err, "tokens") {
+		return
+	}
+	var issuedString string
+	if issued != nil {
+		issuedString = issued.String()
+	}
+	s.renderTemplate("tokens.html", w, r, map[string]interface{}{
+		"Tokens":      apiTokens,
+		"Issued":      issuedString,
+		"Permissions": Permissions,
+		"Now":         time.Now(),
+	})
+}
+
+func (s *Server) handlePostNewToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "failed to parse form", 422)
+		return
+	}
+	var permissions []Permission
+	for _, p := range r.Form["permission"] {
+		permissions = append(permissions, Permission(p))
+	}
+	var expiresAt *time.Time
+	if r.FormValue("expires") != "" {
+		expires, err := time.ParseInLocation(datetimeLocalLayout, r.FormValue("expires"), getTimeLocation(r))
+		if err != nil {
+			http.Error(w, "invalid expiry", 422)
+			return
+		}
+		expiresAt = &expires
+	}
+	token, apiToken, err := IssueToken(s.db, r.FormValue("name"), permissions, expiresAt)
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+	log.Printf("issued token %s (%s)", apiToken.ID, apiToken.Name)
+	// the token is shown in the response instead of redirecting, as it is not stored
+	s.renderTokens(w, r, &token)
+}
+
+func (s *Server) handlePostRevokeToken(w http.ResponseWriter, r *http.Request) {
+	err := s.db.RevokeAPIToken(r.PathValue("id"), time.Now())
+	if handleDBError(w, err, "token") {
+		return
+	}
+	log.Printf("revoked token %s", r.PathValue("id"))
+	http.Redirect(w, r, "/admin/tokens", 303)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"html"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebTokens(t *testing.T) {
+	s, do := newTestWeb(t)
+	api := func(token string) int {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/database/changes", nil)
+		req.Header.Set("X-API-Token", token)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if resp := do("POST", "/admin/tokens/new", url.Values{"name": {"laptop"}, "permission": {"bogus"}}.Encode()); resp.StatusCode != 422 {
+		t.Errorf("expected 422 for an unknown permission, got %d", resp.StatusCode)
+	}
+	body := readPage(t, do("POST", "/admin/tokens/new", url.Values{
+		"name":       {"laptop"},
+		"permission": {string(PermissionSyncDatabase)},
+	}.Encode()))
+	token := regexp.MustCompile(`jts_server_token_[A-Za-z0-9+/=]+`).FindString(html.UnescapeString(body))
+	if token == "" {
+		t.Fatalf("expected the issued token on the page, got %s", body)
+	}
+	if code := api(token); code != 200 {
+		t.Fatalf("expected the issued token to work, got %d", code)
+	}
+	tokens, err := s.db.GetAPITokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Name != "laptop" || tokens[0].LastUsedAt == nil {
+		t.Fatalf("unexpected tokens %#v", tokens)
+	}
+	body = readPage(t, do("GET", "/admin/tokens", ""))
+	if strings.Contains(html.UnescapeString(body), token) || !strings.Contains(body, "laptop") || !strings.Contains(body, "active") {
+		t.Errorf("expected the token listed without the token itself, got %s", body)
+	}
+
+	// revoking takes effect on the next request
+	redirectedID(t, do("POST", "/admin/tokens/"+tokens[0].ID+"/revoke", ""))
+	if code := api(token); code != 403 {
+		t.Errorf("expected the revoked token to be rejected, got %d", code)
+	}
+	if resp := do("POST", "/admin/tokens/missing/revoke", ""); resp.StatusCode != 404 {
+		t.Errorf("expected 404 for an unknown token, got %d", resp.StatusCode)
+	}
+
+	expired := time.Now().Add(time.Millisecond)
+	expiredToken, _, err := IssueToken(s.db, "old", []Permission{PermissionSyncDatabase}, &expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if code := api(expiredToken.String()); code != 403 {
+		t.Errorf("expected the expired token to be rejected, got %d", code)
+	}
+}
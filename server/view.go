@@ -1,9 +1,71 @@
 package server
 
 import (
+	"errors"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"nyiyui.ca/jts/data"
 )
 
+// latestPageSize is how many sessions are shown on each page of /latest.
+const latestPageSize = 50
+
+// datetimeLocalLayout is the format of <input type="datetime-local">.
+const datetimeLocalLayout = "2006-01-02T15:04"
+
+// sessionSummary is a session with what is shown about it in lists.
+type sessionSummary struct {
+	data.Session
+	// Last is the timeframe that started last, if any.
+	Last  *data.Timeframe
+	Total time.Duration
+}
+
+func summarizeSession(session data.Session) sessionSummary {
+	summary := sessionSummary{Session: session}
+	for i, tf := range session.Timeframes {
+		summary.Total += tf.Duration()
+		if summary.Last == nil || tf.Start.After(summary.Last.Start) {
+			summary.Last = &session.Timeframes[i]
+		}
+	}
+	return summary
+}
+
+func (s *Server) handleGetLatest(w http.ResponseWriter, r *http.Request) {
+	page := 1
+	if r.URL.Query().Has("page") {
+		var err error
+		page, err = strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil || page < 1 {
+			http.Error(w, "invalid page", 400)
+			return
+		}
+	}
+	// get one more session to know if there is a next page
+	sessions, err := s.db.GetLatestSessions(latestPageSize+1, (page-1)*latestPageSize)
+	if handleDBError(w, err, "sessions") {
+		return
+	}
+	hasNext := len(sessions) > latestPageSize
+	if hasNext {
+		sessions = sessions[:latestPageSize]
+	}
+	summaries := make([]sessionSummary, len(sessions))
+	for i, session := range sessions {
+		summaries[i] = summarizeSession(session)
+	}
+	s.renderTemplate("latest.html", w, r, map[string]interface{}{
+		"Sessions": summaries,
+		"Page":     page,
+		"HasNext":  hasNext,
+	})
+}
+
 func (s *Server) handleGetSession(w http.ResponseWriter, r *http.Request) {
 	id := r.PathValue("id")
 	session, err := s.db.GetSession(id)
@@ -11,7 +73,251 @@ func (s *Server) handleGetSession(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "session not found", 404)
 		return
 	}
+	var task *data.Task
+	if session.TaskID != nil {
+		t, err := s.db.GetTask(*session.TaskID)
+		if handleDBError(w, err, "task") {
+			return
+		}
+		task = &t
+	}
+	slices.SortFunc(session.Timeframes, func(a, b data.Timeframe) int {
+		return a.Start.Compare(b.Start)
+	})
 	s.renderTemplate("session.html", w, r, map[string]interface{}{
+		"Session": summarizeSession(session),
+		"Task":    task,
+		"Running": slices.ContainsFunc(session.Timeframes, data.Timeframe.Running),
+	})
+}
+
+// renderSessionForm shows the form to create a session, or to edit it if session.ID is set.
+func (s *Server) renderSessionForm(w http.ResponseWriter, r *http.Request, session data.Session) {
+	tasks, err := s.db.GetTasks()
+	if handleDBError(w, err, "tasks") {
+		return
+	}
+	// archived tasks are only offered if the session is already linked to one
+	offered := tasks[:0]
+	for _, task := range tasks {
+		if task.Status != data.TaskStatusArchived || (session.TaskID != nil && *session.TaskID == task.ID) {
+			offered = append(offered, task)
+		}
+	}
+	taskID := ""
+	if session.TaskID != nil {
+		taskID = *session.TaskID
+	}
+	s.renderTemplate("session-form.html", w, r, map[string]interface{}{
 		"Session": session,
+		"TaskID":  taskID,
+		"Tasks":   offered,
 	})
 }
+
+// parseSessionForm returns the session properties and tags submitted by session-form.html.
+func parseSessionForm(r *http.Request) data.Session {
+	session := data.Session{
+		Description: strings.TrimSpace(r.FormValue("description")),
+		// browsers submit textarea line breaks as CRLF
+		Notes: strings.ReplaceAll(r.FormValue("notes"), "\r\n", "\n"),
+		Tags:  data.NormalizeTags(strings.Split(r.FormValue("tags"), ",")),
+	}
+	if taskID := r.FormValue("task"); taskID != "" {
+		session.TaskID = &taskID
+	}
+	return session
+}
+
+func (s *Server) handleGetNewSession(w http.ResponseWriter, r *http.Request) {
+	var session data.Session
+	if taskID := r.URL.Query().Get("task"); taskID != "" {
+		session.TaskID = &taskID
+	}
+	s.renderSessionForm(w, r, session)
+}
+
+func (s *Server) handlePostNewSession(w http.ResponseWriter, r *http.Request) {
+	session := parseSessionForm(r)
+	if !s.validateSession(w, session) {
+		return
+	}
+	id, err := s.db.AddSession(session)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	if r.FormValue("start") != "" {
+		err = s.db.StartTimer(id)
+		if handleDBError(w, err, "timer") {
+			return
+		}
+	}
+	http.Redirect(w, r, "/session/"+id, 303)
+}
+
+func (s *Server) handleGetEditSession(w http.ResponseWriter, r *http.Request) {
+	session, err := s.db.GetSession(r.PathValue("id"))
+	if handleDBError(w, err, "session") {
+		return
+	}
+	s.renderSessionForm(w, r, session)
+}
+
+func (s *Server) handlePostEditSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	_, err := s.db.GetSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	session := parseSessionForm(r)
+	session.ID = id
+	if !s.validateSession(w, session) {
+		return
+	}
+	err = s.db.EditSessionProperties(session)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	err = s.db.SetSessionTags(id, session.Tags)
+	if handleDBError(w, err, "tags") {
+		return
+	}
+	http.Redirect(w, r, "/session/"+id, 303)
+}
+
+func (s *Server) handlePostDeleteSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	_, err := s.db.GetSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	err = s.db.DeleteSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	http.Redirect(w, r, "/latest", 303)
+}
+
+func (s *Server) handlePostStartSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	_, err := s.db.GetSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	err = s.db.StartTimer(id)
+	if handleDBError(w, err, "timer") {
+		return
+	}
+	http.Redirect(w, r, "/session/"+id, 303)
+}
+
+func (s *Server) handlePostStopSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	_, err := s.db.GetSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	err = s.db.StopTimer(id)
+	if handleDBError(w, err, "timer") {
+		return
+	}
+	http.Redirect(w, r, "/session/"+id, 303)
+}
+
+// parseTimeframeForm returns the timeframe submitted by timeframe-form.html, in the user's timezone.
+// An empty end means the timeframe is running.
+func parseTimeframeForm(r *http.Request) (data.Timeframe, error) {
+	loc := getTimeLocation(r)
+	var tf data.Timeframe
+	start, err := time.ParseInLocation(datetimeLocalLayout, r.FormValue("start"), loc)
+	if err != nil {
+		return data.Timeframe{}, errors.New("invalid start")
+	}
+	tf.Start = start
+	if r.FormValue("end") != "" {
+		end, err := time.ParseInLocation(datetimeLocalLayout, r.FormValue("end"), loc)
+		if err != nil {
+			return data.Timeframe{}, errors.New("invalid end")
+		}
+		tf.End = &end
+	}
+	tf.Done = r.FormValue("done") != ""
+	return tf, nil
+}
+
+func (s *Server) handleGetNewTimeframe(w http.ResponseWriter, r *http.Request) {
+	session, err := s.db.GetSession(r.PathValue("id"))
+	if handleDBError(w, err, "session") {
+		return
+	}
+	// default to an hour ending now, which is the usual case of adding a timeframe after the fact
+	end := time.Now()
+	s.renderTemplate("timeframe-form.html", w, r, map[string]interface{}{
+		"Session":   session,
+		"Timeframe": data.Timeframe{Start: end.Add(-time.Hour), End: &end},
+	})
+}
+
+func (s *Server) handlePostNewTimeframe(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	_, err := s.db.GetSession(id)
+	if handleDBError(w, err, "session") {
+		return
+	}
+	tf, err := parseTimeframeForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+	if !validateTimeframe(w, tf) {
+		return
+	}
+	_, err = s.db.AddTimeframe(id, tf)
+	if handleDBError(w, err, "timeframe") {
+		return
+	}
+	http.Redirect(w, r, "/session/"+id, 303)
+}
+
+func (s *Server) handleGetEditTimeframe(w http.ResponseWriter, r *http.Request) {
+	session, i, ok := s.getTimeframe(w, r)
+	if !ok {
+		return
+	}
+	s.renderTemplate("timeframe-form.html", w, r, map[string]interface{}{
+		"Session":   session,
+		"Timeframe": session.Timeframes[i],
+	})
+}
+
+func (s *Server) handlePostEditTimeframe(w http.ResponseWriter, r *http.Request) {
+	session, i, ok := s.getTimeframe(w, r)
+	if !ok {
+		return
+	}
+	tf, err := parseTimeframeForm(r)
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+	if !validateTimeframe(w, tf) {
+		return
+	}
+	err = s.db.EditTimeframe(session.ID, session.Timeframes[i].ID, tf)
+	if handleDBError(w, err, "timeframe") {
+		return
+	}
+	http.Redirect(w, r, "/session/"+session.ID, 303)
+}
+
+func (s *Server) handlePostDeleteTimeframe(w http.ResponseWriter, r *http.Request) {
+	session, i, ok := s.getTimeframe(w, r)
+	if !ok {
+		return
+	}
+	err := s.db.DeleteTimeframe(session.ID, session.Timeframes[i].ID)
+	if handleDBError(w, err, "timeframe") {
+		return
+	}
+	http.Redirect(w, r, "/session/"+session.ID, 303)
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"nyiyui.ca/jts/data"
+)
+
+func readPage(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	if resp.StatusCode != 200 {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// redirectedID returns the ID at the end of the path the response redirects to.
+func redirectedID(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	if resp.StatusCode != 303 {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("status %d: %s", resp.StatusCode, body)
+	}
+	location := resp.Header.Get("Location")
+	return location[strings.LastIndex(location, "/")+1:]
+}
+
+func TestWebSessions(t *testing.T) {
+	s, do := newTestWebIn(t, "Asia/Tokyo")
+	id := redirectedID(t, do("POST", "/session/new", url.Values{
+		"description": {"write docs"},
+		"notes":       {"# Plan\r\n\r\n- *first* draft"},
+		"tags":        {"writing, docs"},
+	}.Encode()))
+
+	resp := do("POST", "/session/"+id+"/timeframes/new", url.Values{"start": {"2025-03-12T09:00"}, "end": {"2025-03-12T10:30"}}.Encode())
+	redirectedID(t, resp)
+	session, err := s.db.GetSession(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Notes != "# Plan\n\n- *first* draft" || strings.Join(session.Tags, ",") != "docs,writing" {
+		t.Fatalf("unexpected session %#v", session)
+	}
+	if len(session.Timeframes) != 1 || !session.Timeframes[0].Start.Equal(time.Date(2025, 3, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("expected a timeframe starting at 09:00 in Tokyo, got %#v", session.Timeframes)
+	}
+	page := readPage(t, do("GET", "/session/"+id, ""))
+	for _, want := range []string{"<em>first</em> draft", "2025-03-12 09:00", "2025-03-12 10:30", "1h30m0s", "docs, writing"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("session page does not contain %q", want)
+		}
+	}
+
+	tfID := session.Timeframes[0].ID
+	resp = do("POST", "/session/"+id+"/timeframes/"+tfID+"/edit", url.Values{"start": {"2025-03-12T09:00"}, "end": {"2025-03-12T08:00"}}.Encode())
+	if resp.StatusCode != 422 {
+		t.Fatalf("end before start: status %d", resp.StatusCode)
+	}
+	page = readPage(t, do("GET", "/session/"+id+"/timeframes/"+tfID+"/edit", ""))
+	if !strings.Contains(page, `value="2025-03-12T10:30"`) {
+		t.Errorf("edit form does not show the end in Tokyo:\n%s", page)
+	}
+	redirectedID(t, do("POST", "/session/"+id+"/timeframes/"+tfID+"/edit", url.Values{"start": {"2025-03-12T09:00"}, "done": {"1"}}.Encode()))
+	redirectedID(t, do("POST", "/session/"+id+"/edit", url.Values{"description": {"write the docs"}}.Encode()))
+	session, err = s.db.GetSession(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Description != "write the docs" || session.Notes != "" || len(session.Tags) != 0 {
+		t.Fatalf("unexpected edited session %#v", session)
+	}
+	if !session.Timeframes[0].Running() || !session.Timeframes[0].Done {
+		t.Fatalf("expected a running, done timeframe, got %#v", session.Timeframes[0])
+	}
+	if resp := do("POST", "/session/"+id+"/start", ""); resp.StatusCode != 409 {
+		t.Fatalf("start while running: status %d", resp.StatusCode)
+	}
+
+	redirectedID(t, do("POST", "/session/"+id+"/delete", ""))
+	if resp := do("GET", "/session/"+id, ""); resp.StatusCode != 404 {
+		t.Fatalf("deleted session: status %d", resp.StatusCode)
+	}
+}
+
+func TestWebLatest(t *testing.T) {
+	s, do := newTestWeb(t)
+	start := time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i := range latestPageSize + 1 {
+		tfStart := start.Add(time.Duration(i) * time.Hour)
+		end := tfStart.Add(30 * time.Minute)
+		_, err := s.db.AddSession(data.Session{Description: fmt.Sprintf("session %02d", i), Timeframes: []data.Timeframe{{Start: tfStart, End: &end}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	page := readPage(t, do("GET", "/latest", ""))
+	if !strings.Contains(page, "session 50") || strings.Contains(page, "session 00") || !strings.Contains(page, "?page=2") {
+		t.Fatalf("unexpected first page:\n%s", page)
+	}
+	page = readPage(t, do("GET", "/latest?page=2", ""))
+	if !strings.Contains(page, "session 00") || strings.Contains(page, "session 01") || strings.Contains(page, "?page=3") {
+		t.Fatalf("unexpected second page:\n%s", page)
+	}
+}
+
+func TestWebTasks(t *testing.T) {
+	s, do := newTestWebIn(t, "Asia/Tokyo")
+	taskID := redirectedID(t, do("POST", "/task/new", url.Values{
+		"description": {"release v2"},
+		"status":      {"open"},
+		"due":         {"2025-04-01T17:00"},
+		"estimate":    {"2h"},
+	}.Encode()))
+	task, err := s.db.GetTask(taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !task.Due.Equal(time.Date(2025, 4, 1, 8, 0, 0, 0, time.UTC)) || *task.Estimate != 2*time.Hour {
+		t.Fatalf("unexpected task %#v", task)
+	}
+	if resp := do("POST", "/task/new", url.Values{"description": {"x"}, "estimate": {"soon"}}.Encode()); resp.StatusCode != 422 {
+		t.Fatalf("invalid estimate: status %d", resp.StatusCode)
+	}
+
+	page := readPage(t, do("GET", "/session/new?task="+taskID, ""))
+	if !strings.Contains(page, `<option value="`+taskID+`" selected>`) {
+		t.Errorf("new session form does not select the task:\n%s", page)
+	}
+	redirectedID(t, do("POST", "/session/new", url.Values{"description": {"tag the release"}, "task": {taskID}}.Encode()))
+	page = readPage(t, do("GET", "/task/"+taskID, ""))
+	if !strings.Contains(page, "tag the release") || !strings.Contains(page, "2h0m0s estimated") {
+		t.Errorf("unexpected task page:\n%s", page)
+	}
+
+	redirectedID(t, do("POST", "/task/"+taskID+"/status", url.Values{"status": {"done"}}.Encode()))
+	page = readPage(t, do("GET", "/tasks", ""))
+	if !strings.Contains(page, "Done and archived") || !strings.Contains(page, "release v2") {
+		t.Errorf("unexpected tasks page:\n%s", page)
+	}
+}
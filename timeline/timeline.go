@@ -0,0 +1,317 @@
+// Package timeline lays out timeframes on day, week and month timelines, one row per day.
+package timeline
+
+import (
+	"cmp"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"slices"
+	"time"
+
+	"nyiyui.ca/jts/data"
+	"nyiyui.ca/jts/database"
+)
+
+// Span is how long a timeline is.
+type Span string
+
+const (
+	SpanDay   Span = "day"
+	SpanWeek  Span = "week" // ISO 8601 week, starting on Monday
+	SpanMonth Span = "month"
+)
+
+// Spans lists all spans, e.g. for selection in a UI.
+var Spans = []Span{SpanDay, SpanWeek, SpanMonth}
+
+// Valid reports whether s is one of the known spans.
+func (s Span) Valid() bool {
+	return slices.Contains(Spans, s)
+}
+
+// ColorBy is what blocks are colored by.
+type ColorBy string
+
+const (
+	ColorBySession ColorBy = "session"
+	// ColorByTask colors the sessions of a task the same. Sessions without a task share a color.
+	ColorByTask ColorBy = "task"
+)
+
+// ColorBys lists all colorings, e.g. for selection in a UI.
+var ColorBys = []ColorBy{ColorBySession, ColorByTask}
+
+// Valid reports whether c is one of the known colorings.
+func (c ColorBy) Valid() bool {
+	return slices.Contains(ColorBys, c)
+}
+
+// Options specifies what to lay out.
+type Options struct {
+	Span Span
+	// Date is a day in the period to show, which starts at the start of the day, week or month of Date.
+	Date time.Time
+	// Location is where days start and end. It defaults to UTC.
+	Location *time.Location
+	// ColorBy defaults to ColorBySession.
+	ColorBy ColorBy
+	// Now is used as the end of running timeframes. It defaults to the current time.
+	Now time.Time
+}
+
+// Block is the part of a timeframe on one day.
+type Block struct {
+	// Timeframe is the whole timeframe, which can continue on other days.
+	Timeframe data.Timeframe
+	// Label is the description of the session.
+	Label string
+	// Key is the session or task ID the block is colored by (see Color).
+	Key string
+	// Start and End are the part of the timeframe on the day. End is Options.Now for running timeframes.
+	Start, End time.Time
+	// ClippedStart and ClippedEnd report whether the timeframe continues on the previous or next day,
+	// i.e. Start or End is not the start or end of the timeframe.
+	ClippedStart, ClippedEnd bool
+	// Lane is the index of the row in the day the block is in, so that overlapping blocks are not drawn over each other.
+	Lane int
+}
+
+// Day is a row of a timeline.
+type Day struct {
+	// Start and End are midnight at the start and end of the day. The day is not 24 hours long on DST changes.
+	Start, End time.Time
+	// Blocks are the blocks on the day, by start time.
+	Blocks []Block
+	// Lanes is the number of lanes the blocks are in. It is at least 1.
+	Lanes int
+	Total time.Duration
+}
+
+// Offset returns where t is in the day, from 0 at the start to 1 at the end.
+func (d Day) Offset(t time.Time) float64 {
+	return float64(t.Sub(d.Start)) / float64(d.End.Sub(d.Start))
+}
+
+// At returns the time at offset (see Offset) in the day.
+func (d Day) At(offset float64) time.Time {
+	return d.Start.Add(time.Duration(offset * float64(d.End.Sub(d.Start))))
+}
+
+// LegendEntry is a session or task in the timeline.
+type LegendEntry struct {
+	Key      string
+	Label    string
+	Duration time.Duration
+}
+
+type Timeline struct {
+	Span     Span
+	ColorBy  ColorBy
+	From, To time.Time
+	Days     []Day
+	// Legend lists the keys of the blocks, the one with the most time first.
+	Legend []LegendEntry
+	Total  time.Duration
+}
+
+// Period returns the start and end of the day, week or month containing date in loc.
+func Period(span Span, date time.Time, loc *time.Location) (from, to time.Time) {
+	date = date.In(loc)
+	midnight := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
+	switch span {
+	case SpanWeek:
+		from = midnight.AddDate(0, 0, -((int(midnight.Weekday()) + 6) % 7))
+		return from, from.AddDate(0, 0, 7)
+	case SpanMonth:
+		from = midnight.AddDate(0, 0, 1-midnight.Day())
+		return from, from.AddDate(0, 1, 0)
+	default:
+		return midnight, midnight.AddDate(0, 0, 1)
+	}
+}
+
+// Step returns a day in the period n periods after the one containing date, e.g. the previous week for n = -1.
+func Step(span Span, date time.Time, n int) time.Time {
+	switch span {
+	case SpanWeek:
+		return date.AddDate(0, 0, 7*n)
+	case SpanMonth:
+		// go to the first of the month first, so that e.g. January 31st does not become March 3rd
+		return date.AddDate(0, 0, 1-date.Day()).AddDate(0, n, 0)
+	default:
+		return date.AddDate(0, 0, n)
+	}
+}
+
+// Generate lays out the timeframes in db.
+func Generate(db *database.Database, opts Options) (Timeline, error) {
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	from, to := Period(opts.Span, opts.Date, opts.Location)
+	sessions, err := db.GetSessionsBetween(from, to)
+	if err != nil {
+		return Timeline{}, fmt.Errorf("get sessions: %w", err)
+	}
+	tasks, err := db.GetTasks()
+	if err != nil {
+		return Timeline{}, fmt.Errorf("get tasks: %w", err)
+	}
+	return Layout(sessions, tasks, opts)
+}
+
+// Layout lays out the timeframes of the sessions, splitting them at midnight in opts.Location.
+func Layout(sessions []data.Session, tasks []data.Task, opts Options) (Timeline, error) {
+	if !opts.Span.Valid() {
+		return Timeline{}, fmt.Errorf("invalid span %q", opts.Span)
+	}
+	if opts.ColorBy == "" {
+		opts.ColorBy = ColorBySession
+	}
+	if !opts.ColorBy.Valid() {
+		return Timeline{}, fmt.Errorf("invalid coloring %q", opts.ColorBy)
+	}
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	if opts.Now.IsZero() {
+		opts.Now = time.Now()
+	}
+	tl := Timeline{Span: opts.Span, ColorBy: opts.ColorBy}
+	tl.From, tl.To = Period(opts.Span, opts.Date, opts.Location)
+	for start := tl.From; start.Before(tl.To); start = start.AddDate(0, 0, 1) {
+		tl.Days = append(tl.Days, Day{Start: start, End: start.AddDate(0, 0, 1), Lanes: 1})
+	}
+	taskDescriptions := map[string]string{}
+	for _, task := range tasks {
+		taskDescriptions[task.ID] = task.Description
+	}
+	legend := map[string]*LegendEntry{}
+	for _, s := range sessions {
+		key, label := s.ID, s.Description
+		if opts.ColorBy == ColorByTask {
+			key, label = "", ""
+			if s.TaskID != nil {
+				key, label = *s.TaskID, taskDescriptions[*s.TaskID]
+			}
+		}
+		for _, tf := range s.Timeframes {
+			end := opts.Now
+			if tf.End != nil {
+				end = *tf.End
+			}
+			for i := range tl.Days {
+				day := &tl.Days[i]
+				start, end := later(tf.Start, day.Start), earlier(end, day.End)
+				if !start.Before(end) {
+					continue
+				}
+				day.Blocks = append(day.Blocks, Block{
+					Timeframe:    tf,
+					Label:        s.Description,
+					Key:          key,
+					Start:        start,
+					End:          end,
+					ClippedStart: tf.Start.Before(day.Start),
+					ClippedEnd:   tf.End == nil || tf.End.After(day.End),
+				})
+				d := end.Sub(start)
+				day.Total += d
+				tl.Total += d
+				entry, ok := legend[key]
+				if !ok {
+					entry = &LegendEntry{Key: key, Label: label}
+					legend[key] = entry
+				}
+				entry.Duration += d
+			}
+		}
+	}
+	for i := range tl.Days {
+		assignLanes(&tl.Days[i])
+	}
+	for _, entry := range legend {
+		tl.Legend = append(tl.Legend, *entry)
+	}
+	slices.SortFunc(tl.Legend, func(a, b LegendEntry) int {
+		if c := cmp.Compare(b.Duration, a.Duration); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Key, b.Key)
+	})
+	return tl, nil
+}
+
+// assignLanes sorts the blocks of the day and puts each in the first lane that is free when it starts.
+func assignLanes(day *Day) {
+	slices.SortStableFunc(day.Blocks, func(a, b Block) int {
+		return a.Start.Compare(b.Start)
+	})
+	var laneEnds []time.Time
+	for i := range day.Blocks {
+		b := &day.Blocks[i]
+		b.Lane = slices.IndexFunc(laneEnds, func(end time.Time) bool {
+			return !end.After(b.Start)
+		})
+		if b.Lane == -1 {
+			b.Lane = len(laneEnds)
+			laneEnds = append(laneEnds, b.End)
+		} else {
+			laneEnds[b.Lane] = b.End
+		}
+	}
+	day.Lanes = max(len(laneEnds), 1)
+}
+
+func earlier(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// Color returns the color of blocks with the key, which is the same every time, as RGB from 0 to 1.
+// Blocks without a key (i.e. sessions without a task) are grey.
+func Color(key string) (r, g, b float64) {
+	if key == "" {
+		return 0.6, 0.6, 0.6
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return hslToRGB(float64(h.Sum32()%360), 0.55, 0.6)
+}
+
+// ColorHex returns Color as #rrggbb.
+func ColorHex(key string) string {
+	r, g, b := Color(key)
+	return fmt.Sprintf("#%02x%02x%02x", int(r*255+0.5), int(g*255+0.5), int(b*255+0.5))
+}
+
+func hslToRGB(h, s, l float64) (r, g, b float64) {
+	c := (1 - math.Abs(2*l-1)) * s
+	x := c * (1 - math.Abs(math.Mod(h/60, 2)-1))
+	m := l - c/2
+	switch {
+	case h < 60:
+		r, g, b = c, x, 0
+	case h < 120:
+		r, g, b = x, c, 0
+	case h < 180:
+		r, g, b = 0, c, x
+	case h < 240:
+		r, g, b = 0, x, c
+	case h < 300:
+		r, g, b = x, 0, c
+	default:
+		r, g, b = c, 0, x
+	}
+	return r + m, g + m, b + m
+}
@@ -0,0 +1,88 @@
+package timeline
+
+import (
+	"testing"
+	"time"
+
+	"nyiyui.ca/jts/data"
+)
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestPeriod(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+	// 2025-03-12 is a Wednesday; 20:00 UTC is already the 13th in JST
+	date := time.Date(2025, 3, 12, 20, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		span     Span
+		from, to time.Time
+	}{
+		{SpanDay, time.Date(2025, 3, 13, 0, 0, 0, 0, jst), time.Date(2025, 3, 14, 0, 0, 0, 0, jst)},
+		{SpanWeek, time.Date(2025, 3, 10, 0, 0, 0, 0, jst), time.Date(2025, 3, 17, 0, 0, 0, 0, jst)},
+		{SpanMonth, time.Date(2025, 3, 1, 0, 0, 0, 0, jst), time.Date(2025, 4, 1, 0, 0, 0, 0, jst)},
+	} {
+		from, to := Period(c.span, date, jst)
+		if !from.Equal(c.from) || !to.Equal(c.to) {
+			t.Errorf("%s: expected %s - %s, got %s - %s", c.span, c.from, c.to, from, to)
+		}
+	}
+	if got := Step(SpanMonth, time.Date(2025, 1, 31, 0, 0, 0, 0, jst), 1); got.Month() != time.February {
+		t.Errorf("month after January 31st: %s", got)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	jst := time.FixedZone("JST", 9*60*60)
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2025, 3, day, hour, min, 0, 0, jst)
+	}
+	sessions := []data.Session{
+		{ID: "a", Description: "late night", TaskID: ptr("t"), Timeframes: []data.Timeframe{
+			{ID: "a1", Start: at(10, 22, 0), End: ptr(at(11, 2, 0))},
+		}},
+		{ID: "b", Description: "meeting", Timeframes: []data.Timeframe{
+			{ID: "b1", Start: at(11, 1, 0), End: ptr(at(11, 1, 30))},
+			{ID: "b2", Start: at(11, 9, 0)},
+		}},
+	}
+	tasks := []data.Task{{ID: "t", Description: "jts"}}
+	opts := Options{Span: SpanWeek, Date: at(12, 12, 0), Location: jst, ColorBy: ColorByTask, Now: at(11, 10, 0)}
+	tl, err := Layout(sessions, tasks, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tl.Days) != 7 || !tl.Days[0].Start.Equal(at(10, 0, 0)) {
+		t.Fatalf("expected 7 days from Monday, got %d from %s", len(tl.Days), tl.Days[0].Start)
+	}
+	monday, tuesday := tl.Days[0], tl.Days[1]
+	if len(monday.Blocks) != 1 || monday.Blocks[0].ClippedStart || !monday.Blocks[0].ClippedEnd || monday.Total != 2*time.Hour {
+		t.Fatalf("unexpected Monday %#v", monday)
+	}
+	if offset := monday.Offset(monday.Blocks[0].Start); offset != 22.0/24 {
+		t.Errorf("unexpected offset %f", offset)
+	}
+	if len(tuesday.Blocks) != 3 || tuesday.Lanes != 2 {
+		t.Fatalf("expected 3 blocks in 2 lanes on Tuesday, got %#v", tuesday)
+	}
+	late, meeting, running := tuesday.Blocks[0], tuesday.Blocks[1], tuesday.Blocks[2]
+	if late.Timeframe.ID != "a1" || !late.ClippedStart || late.ClippedEnd || late.Lane != 0 || late.Key != "t" {
+		t.Errorf("unexpected block %#v", late)
+	}
+	if meeting.Timeframe.ID != "b1" || meeting.Lane != 1 || meeting.Key != "" {
+		t.Errorf("expected the overlapping meeting in the second lane, got %#v", meeting)
+	}
+	if running.Timeframe.ID != "b2" || running.Lane != 0 || !running.End.Equal(opts.Now) || !running.ClippedEnd {
+		t.Errorf("unexpected running block %#v", running)
+	}
+	if len(tl.Legend) != 2 || tl.Legend[0].Key != "t" || tl.Legend[0].Label != "jts" || tl.Legend[0].Duration != 4*time.Hour {
+		t.Errorf("unexpected legend %#v", tl.Legend)
+	}
+	if tl.Total != 5*time.Hour+30*time.Minute {
+		t.Errorf("unexpected total %s", tl.Total)
+	}
+	if ColorHex("t") != ColorHex("t") || ColorHex("") != "#999999" {
+		t.Errorf("unexpected colors %s %s", ColorHex("t"), ColorHex(""))
+	}
+}